@@ -2,7 +2,9 @@ package faker_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
+	"net/http"
 	"strings"
 	"testing"
 	"time"
@@ -387,6 +389,66 @@ func TestService_fake_Heavy(t *testing.T) {
 	}
 }
 
+func TestService_fake_CreateMulti_InvalidTask(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	// JSON にできない Body の Task があっても、他の Task は作成して index の位置の error を MultiError で返す
+	tns, err := s.CreateJsonPostTaskMulti(ctx, testQueue, []*tasksbox.JsonPostTask{
+		{RelativeURI: "/tq/hoge", Body: "hoge"},
+		{Name: "invalid", RelativeURI: "/tq/hoge", Body: make(chan int)},
+		{RelativeURI: "/tq/hoge", Body: "fuga"},
+	})
+	assertMultiError(t, err, 1, "invalid")
+	if e, g := 3, len(tns); e != g {
+		t.Fatalf("want task names length %d but got %d", e, g)
+	}
+	if len(tns[0]) < 1 || len(tns[1]) > 0 || len(tns[2]) < 1 {
+		t.Errorf("unexpected task names %v", tns)
+	}
+
+	// Method を省略した Task は作成しない
+	_, err = s.CreateTaskMulti(ctx, testQueue, []*tasksbox.Task{
+		{Name: "nomethod", RelativeURI: "/tq/hoge"},
+		{Method: http.MethodGet, RelativeURI: "/tq/hoge"},
+	})
+	assertMultiError(t, err, 0, "nomethod")
+	if _, err := s.CreateTask(ctx, testQueue, &tasksbox.Task{RelativeURI: "/tq/hoge"}); !tasksbox.ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+
+	if e, g := 3, tasksFaker.GetCreateTaskCallCount(); e != g {
+		t.Errorf("want CreateTaskCallCount is %d but got %d", e, g)
+	}
+}
+
+// assertMultiError is err が index の Task だけが失敗した MultiError であることを確認する
+func assertMultiError(t *testing.T, err error, index int, taskName string) {
+	t.Helper()
+
+	merr := &tasksbox.MultiError{}
+	if !errors.As(err, &merr) {
+		t.Fatalf("want MultiError but got %v", err)
+	}
+	if e, g := 1, len(merr.Errors); e != g {
+		t.Fatalf("want errors length %d but got %d. %v", e, g, merr)
+	}
+	if e, g := index, merr.Errors[0].KV["index"]; e != g {
+		t.Errorf("want index %d but got %v", e, g)
+	}
+	if e, g := taskName, merr.Errors[0].KV["taskName"]; e != g {
+		t.Errorf("want taskName %s but got %v", e, g)
+	}
+}
+
 func newFakeService(t *testing.T) (*tasksbox.Service, *faker.Faker) {
 	ctx := context.Background()
 
@@ -1,16 +1,43 @@
 package appengine
 
-type createTaskOptions struct {
-	ignoreAlreadyExists bool
-}
+import (
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
 
 // CreateTaskOptions is CreateTask に利用する options
-type CreateTaskOptions func(*createTaskOptions)
+type CreateTaskOptions = tasksbox.CreateTaskOptions
 
 // WithIgnoreAlreadyExists is CreateTask 時に AlreadyExists を無視する
 // TaskName を指定した状態での Retry 時にすでにAddされているものは無視すればよい場合に使う
 func WithIgnoreAlreadyExists() CreateTaskOptions {
-	return func(ops *createTaskOptions) {
-		ops.ignoreAlreadyExists = true
-	}
+	return tasksbox.WithIgnoreAlreadyExists()
+}
+
+// WithMaxInFlight is cloudtasks.WithMaxInFlight
+func WithMaxInFlight(max int) CreateTaskOptions {
+	return tasksbox.WithMaxInFlight(max)
+}
+
+// WithRateLimit is cloudtasks.WithRateLimit
+func WithRateLimit(tasksPerSecond float64) CreateTaskOptions {
+	return tasksbox.WithRateLimit(tasksPerSecond)
+}
+
+// WithRetry is cloudtasks.WithRetry
+func WithRetry(maxAttempts int, backoff gax.Backoff) CreateTaskOptions {
+	return tasksbox.WithRetry(maxAttempts, backoff)
+}
+
+// WithContentTaskName is cloudtasks.WithContentTaskName
+// App Engine Task の場合は RelativeURI と Body から {TASK_ID} を生成する
+func WithContentTaskName(window time.Duration) CreateTaskOptions {
+	return tasksbox.WithContentTaskName(window)
+}
+
+// ContentTaskName is cloudtasks.ContentTaskName
+func ContentTaskName(relativeURI string, body []byte, window time.Duration, now time.Time) string {
+	return tasksbox.ContentTaskName(relativeURI, body, window, now)
 }
@@ -3,43 +3,36 @@ package appengine
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
-	"sync"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
 
 // Service is App Engine Task Service
+// cloudtasks.Enqueuer に cloudtasks.AppEngineTarget を指定して Task を作成する
 type Service struct {
-	taskClient *cloudtasks.Client
+	enqueuer *tasksbox.TaskEnqueuer
 }
 
 // NewService is return serviceImple
 func NewService(ctx context.Context, taskClient *cloudtasks.Client) (*Service, error) {
+	enqueuer, err := tasksbox.NewEnqueuer(ctx, taskClient, &tasksbox.AppEngineTarget{})
+	if err != nil {
+		return nil, err
+	}
 	return &Service{
-		taskClient: taskClient,
+		enqueuer: enqueuer,
 	}, nil
 }
 
 // Queue is Cloud Tasks Queue
-type Queue struct {
-	ProjectID string
-	Region    string
-	Name      string
-}
-
-// Parent is return Cloud Tasks Parent format value
-func (q *Queue) Parent() string {
-	return fmt.Sprintf("projects/%s/locations/%s/queues/%s", q.ProjectID, q.Region, q.Name)
-}
+type Queue = tasksbox.Queue
 
 // Routing is Push 先の App EngineのServiceとVersionを指定するのに使う
 type Routing struct {
@@ -60,18 +53,7 @@ func AppEngineRoutingProtoToRouting(routing *taskspb.AppEngineRouting) (*Routing
 
 // HttpMethodProtoToHttpMethod is HttpMethodProto から HttpMethod に変換する
 func HttpMethodProtoToHttpMethod(method taskspb.HttpMethod) (string, error) {
-	switch method {
-	case taskspb.HttpMethod_POST:
-		return http.MethodPost, nil
-	case taskspb.HttpMethod_GET:
-		return http.MethodGet, nil
-	case taskspb.HttpMethod_PUT:
-		return http.MethodPut, nil
-	case taskspb.HttpMethod_DELETE:
-		return http.MethodDelete, nil
-	default:
-		return "", fmt.Errorf("unsupported method %s", method.String())
-	}
+	return tasksbox.HttpMethodProtoToHttpMethod(method)
 }
 
 // Task is Task
@@ -111,46 +93,51 @@ type Task struct {
 	DispatchDeadline time.Duration
 }
 
+// ToJob is Task を cloudtasks.Job に変換する
+// Routing は Job.Target に cloudtasks.AppEngineTarget として設定する
+func (task *Task) ToJob() *tasksbox.Job {
+	target := &tasksbox.AppEngineTarget{}
+	if task.Routing != nil {
+		target.Service = task.Routing.Service
+		target.Version = task.Routing.Version
+	}
+	return &tasksbox.Job{
+		Name:         task.Name,
+		Path:         task.RelativeURI,
+		Method:       task.Method,
+		Headers:      task.Headers,
+		Body:         task.Body,
+		ScheduleTime: task.ScheduleTime,
+		Deadline:     task.DispatchDeadline,
+		Target:       target,
+	}
+}
+
+// validate is Task を作成できるかを確認する
+// cloudtasks.Job と違い Method は省略できない
+func (task *Task) validate() error {
+	if len(task.Method) < 1 {
+		return NewErrInvalidArgument(fmt.Sprintf("unsupported HttpMethod : %v", task.Method), map[string]interface{}{"method": task.Method}, nil)
+	}
+	return nil
+}
+
 // ToCreateTaskRequestProto is CreateTaskRequest に変換する
 func (task *Task) ToCreateTaskRequestProto(queue *Queue) (*taskspb.CreateTaskRequest, error) {
 	if queue == nil {
 		return nil, NewErrInvalidArgument("queue is required", map[string]interface{}{}, nil)
 	}
-
-	var method taskspb.HttpMethod
-	switch task.Method {
-	case http.MethodPost:
-		method = taskspb.HttpMethod_POST
-	case http.MethodPut:
-		method = taskspb.HttpMethod_PUT
-	case http.MethodGet:
-		method = taskspb.HttpMethod_GET
-	case http.MethodDelete:
-		method = taskspb.HttpMethod_DELETE
-	default:
-		return nil, fmt.Errorf("unsupported HttpMethod : %v", task.Method)
+	if err := task.validate(); err != nil {
+		return nil, err
 	}
 
-	appEngineRequest := &taskspb.AppEngineHttpRequest{
-		HttpMethod:  method,
-		RelativeUri: task.RelativeURI,
-		Headers:     task.Headers,
-		Body:        task.Body,
-	}
-	if task.Routing != nil {
-		appEngineRequest.AppEngineRouting = &taskspb.AppEngineRouting{
-			Service: task.Routing.Service,
-			Version: task.Routing.Version,
-		}
-	}
-
-	pbTask := &taskspb.Task{
-		MessageType: &taskspb.Task_AppEngineHttpRequest{
-			AppEngineHttpRequest: appEngineRequest,
-		},
+	job := task.ToJob()
+	pbTask, err := job.Target.Task(queue, job)
+	if err != nil {
+		return nil, err
 	}
 	if len(task.Name) > 0 {
-		pbTask.Name = fmt.Sprintf("projects/%s/locations/%s/queues/%s/tasks/%s", queue.ProjectID, queue.Region, queue.Name, task.Name)
+		pbTask.Name = fmt.Sprintf("%s/tasks/%s", queue.Parent(), task.Name)
 	}
 	if !task.ScheduleTime.IsZero() {
 		pbTask.ScheduleTime = timestamppb.New(task.ScheduleTime)
@@ -167,58 +154,60 @@ func (task *Task) ToCreateTaskRequestProto(queue *Queue) (*taskspb.CreateTaskReq
 
 // CreateTask is QueueにTaskを作成する
 func (s *Service) CreateTask(ctx context.Context, queue *Queue, task *Task, ops ...CreateTaskOptions) (string, error) {
-	opt := createTaskOptions{}
-	for _, o := range ops {
-		o(&opt)
+	if task == nil {
+		return "", fmt.Errorf("failed CreateTask. task is nil")
 	}
-
-	taskReq, err := task.ToCreateTaskRequestProto(queue)
-	if err != nil {
+	if err := task.validate(); err != nil {
 		return "", err
 	}
+	return s.enqueuer.Enqueue(ctx, queue, task.ToJob(), ops...)
+}
+
+var _ tasksbox.Target = &invalidTarget{}
+
+// invalidTarget is Job に変換できなかった Task の error を返す Target
+// EnqueueMulti に渡すと、他の Task は作成して、 error は index を KV に入れて MultiError で返る
+type invalidTarget struct {
+	err error
+}
 
-	t, err := s.taskClient.CreateTask(ctx, taskReq)
+// Task is Target interface
+func (t *invalidTarget) Task(queue *Queue, job *tasksbox.Job) (*taskspb.Task, error) {
+	return nil, t.err
+}
+
+// multiJob is *Multi で EnqueueMulti に渡す Job を返す
+// task を作れなかった場合や task が invalid な場合は、その error を返す Job にする
+func multiJob(task *Task, name string, relativeURI string, err error) *tasksbox.Job {
+	if err == nil && task == nil {
+		err = fmt.Errorf("task is nil")
+	}
+	if err == nil {
+		err = task.validate()
+	}
 	if err != nil {
-		sts, ok := status.FromError(err)
-		if ok {
-			if sts.Code() == codes.AlreadyExists {
-				if opt.ignoreAlreadyExists {
-					return taskReq.GetTask().Name, nil
-				}
-				return "", NewErrAlreadyExists(fmt.Sprintf("%s is already exists.", task.Name), map[string]interface{}{"taskName": task.Name}, err)
-			}
+		return &tasksbox.Job{
+			Name:   name,
+			Path:   relativeURI,
+			Target: &invalidTarget{err: err},
 		}
-		return "", err
 	}
-	return t.Name, nil
+	return task.ToJob()
 }
 
-// CreateTask is QueueにTaskを作成する
+// CreateTaskMulti is Queue に複数の Task を作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
 func (s *Service) CreateTaskMulti(ctx context.Context, queue *Queue, tasks []*Task, ops ...CreateTaskOptions) ([]string, error) {
-	results := make([]string, len(tasks))
-	merr := MultiError{}
-	wg := &sync.WaitGroup{}
+	jobs := make([]*tasksbox.Job, len(tasks))
 	for i, task := range tasks {
-		wg.Add(1)
-		go func(i int, task *Task) {
-			defer wg.Done()
-			tn, err := s.CreateTask(ctx, queue, task, ops...)
-			if err != nil {
-				appErr := &Error{}
-				if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
-					appErr.KV["index"] = i
-					merr.Append(appErr)
-					return
-				}
-
-				merr.Append(NewErrCreateMultiTask("failed CreateTask", map[string]interface{}{"index": i, "taskName": task.Name}, err))
-				return
-			}
-			results[i] = tn
-		}(i, task)
+		if task == nil {
+			jobs[i] = multiJob(nil, "", "", nil)
+			continue
+		}
+		jobs[i] = multiJob(task, task.Name, task.RelativeURI, nil)
 	}
-	wg.Wait()
-	return results, merr.ErrorOrNil()
+	return s.enqueuer.EnqueueMulti(ctx, queue, jobs, ops...)
 }
 
 // JsonPostTask is JsonをBodyに入れるTask
@@ -295,31 +284,19 @@ func (s *Service) CreateJsonPostTask(ctx context.Context, queue *Queue, task *Js
 }
 
 // CreateJsonPostTaskMulti is Queue に 複数の JsonPostTask を作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
 func (s *Service) CreateJsonPostTaskMulti(ctx context.Context, queue *Queue, tasks []*JsonPostTask, ops ...CreateTaskOptions) ([]string, error) {
-	results := make([]string, len(tasks))
-	merr := MultiError{}
-	wg := &sync.WaitGroup{}
+	jobs := make([]*tasksbox.Job, len(tasks))
 	for i, task := range tasks {
-		wg.Add(1)
-		go func(i int, task *JsonPostTask) {
-			defer wg.Done()
-			tn, err := s.CreateJsonPostTask(ctx, queue, task, ops...)
-			if err != nil {
-				appErr := &Error{}
-				if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
-					appErr.KV["index"] = i
-					merr.Append(appErr)
-					return
-				}
-
-				merr.Append(NewErrCreateMultiTask("failed CreateJsonPostTask", map[string]interface{}{"index": i, "taskName": task.Name}, err))
-				return
-			}
-			results[i] = tn
-		}(i, task)
+		if task == nil {
+			jobs[i] = multiJob(nil, "", "", nil)
+			continue
+		}
+		t, err := task.ToTask()
+		jobs[i] = multiJob(t, task.Name, task.RelativeURI, err)
 	}
-	wg.Wait()
-	return results, merr.ErrorOrNil()
+	return s.enqueuer.EnqueueMulti(ctx, queue, jobs, ops...)
 }
 
 // GetTask is Get Request 用の Task
@@ -382,29 +359,17 @@ func (s *Service) CreateGetTask(ctx context.Context, queue *Queue, task *GetTask
 }
 
 // CreateGetTaskMulti is Queue に複数の GetTask を作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
 func (s *Service) CreateGetTaskMulti(ctx context.Context, queue *Queue, tasks []*GetTask, ops ...CreateTaskOptions) ([]string, error) {
-	results := make([]string, len(tasks))
-	merr := MultiError{}
-	wg := &sync.WaitGroup{}
+	jobs := make([]*tasksbox.Job, len(tasks))
 	for i, task := range tasks {
-		wg.Add(1)
-		go func(i int, task *GetTask) {
-			defer wg.Done()
-			tn, err := s.CreateGetTask(ctx, queue, task, ops...)
-			if err != nil {
-				appErr := &Error{}
-				if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
-					appErr.KV["index"] = i
-					merr.Append(appErr)
-					return
-				}
-
-				merr.Append(NewErrCreateMultiTask("failed CreateGetTask", map[string]interface{}{"index": i, "taskName": task.Name}, err))
-				return
-			}
-			results[i] = tn
-		}(i, task)
+		if task == nil {
+			jobs[i] = multiJob(nil, "", "", nil)
+			continue
+		}
+		t, err := task.ToTask()
+		jobs[i] = multiJob(t, task.Name, task.RelativeURI, err)
 	}
-	wg.Wait()
-	return results, merr.ErrorOrNil()
+	return s.enqueuer.EnqueueMulti(ctx, queue, jobs, ops...)
 }
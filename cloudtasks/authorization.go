@@ -0,0 +1,74 @@
+package cloudtasks
+
+import (
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+// AuthorizationType is Task が Handler に Request する時に付与する Authorization Header の種類
+type AuthorizationType int
+
+const (
+	// AuthorizationOIDC is OIDC Token を付与する
+	// Cloud Run, IAP などに Request する時に使う
+	// zero value なので、指定しない場合はこれになる
+	AuthorizationOIDC AuthorizationType = iota
+
+	// AuthorizationOAuth is OAuth Access Token を付与する
+	// *.googleapis.com の API を直接呼ぶ時に使う
+	AuthorizationOAuth
+
+	// AuthorizationNone is Authorization Header を付与しない
+	AuthorizationNone
+)
+
+// String is fmt.Stringer
+func (t AuthorizationType) String() string {
+	switch t {
+	case AuthorizationOIDC:
+		return "OIDC"
+	case AuthorizationOAuth:
+		return "OAuth"
+	case AuthorizationNone:
+		return "None"
+	default:
+		return "Unknown"
+	}
+}
+
+// setAuthorizationHeader is authorizationType に合わせて req に serviceAccountEmail の Authorization Header の設定を入れる
+// audience は OIDC の時だけ、 scope は OAuth の時だけ利用する
+func setAuthorizationHeader(req *taskspb.HttpRequest, serviceAccountEmail string, authorizationType AuthorizationType, audience string, scope string) error {
+	switch authorizationType {
+	case AuthorizationOIDC:
+		req.AuthorizationHeader = &taskspb.HttpRequest_OidcToken{
+			OidcToken: &taskspb.OidcToken{
+				ServiceAccountEmail: serviceAccountEmail,
+				Audience:            audience,
+			},
+		}
+	case AuthorizationOAuth:
+		req.AuthorizationHeader = &taskspb.HttpRequest_OauthToken{
+			OauthToken: &taskspb.OAuthToken{
+				ServiceAccountEmail: serviceAccountEmail,
+				Scope:               scope,
+			},
+		}
+	case AuthorizationNone:
+		req.AuthorizationHeader = nil
+	default:
+		return NewErrInvalidArgument("unsupported AuthorizationType", map[string]interface{}{"AuthorizationType": authorizationType}, nil)
+	}
+	return nil
+}
+
+// authorizationFromHttpRequest is req の Authorization Header の設定から AuthorizationType, audience, scope を返す
+func authorizationFromHttpRequest(req *taskspb.HttpRequest) (authorizationType AuthorizationType, audience string, scope string) {
+	switch {
+	case req.GetOidcToken() != nil:
+		return AuthorizationOIDC, req.GetOidcToken().GetAudience(), ""
+	case req.GetOauthToken() != nil:
+		return AuthorizationOAuth, "", req.GetOauthToken().GetScope()
+	default:
+		return AuthorizationNone, "", ""
+	}
+}
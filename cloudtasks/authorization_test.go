@@ -0,0 +1,65 @@
+package cloudtasks
+
+import (
+	"testing"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+func TestSetAuthorizationHeader(t *testing.T) {
+	const serviceAccountEmail = "hoge@unittest.iam.gserviceaccount.com"
+
+	cases := []struct {
+		name              string
+		authorizationType AuthorizationType
+		audience          string
+		scope             string
+		wantAudience      string
+		wantScope         string
+	}{
+		{"OIDC", AuthorizationOIDC, "https://example.com", "", "https://example.com", ""},
+		{"OAuth", AuthorizationOAuth, "https://example.com", "https://www.googleapis.com/auth/cloud-platform", "", "https://www.googleapis.com/auth/cloud-platform"},
+		{"None", AuthorizationNone, "https://example.com", "", "", ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req := &taskspb.HttpRequest{}
+			if err := setAuthorizationHeader(req, serviceAccountEmail, tt.authorizationType, tt.audience, tt.scope); err != nil {
+				t.Fatal(err)
+			}
+			switch tt.authorizationType {
+			case AuthorizationOIDC:
+				if e, g := serviceAccountEmail, req.GetOidcToken().GetServiceAccountEmail(); e != g {
+					t.Errorf("want ServiceAccountEmail %s but got %s", e, g)
+				}
+			case AuthorizationOAuth:
+				if e, g := serviceAccountEmail, req.GetOauthToken().GetServiceAccountEmail(); e != g {
+					t.Errorf("want ServiceAccountEmail %s but got %s", e, g)
+				}
+			case AuthorizationNone:
+				if req.GetAuthorizationHeader() != nil {
+					t.Errorf("want no AuthorizationHeader but got %v", req.GetAuthorizationHeader())
+				}
+			}
+
+			authorizationType, audience, scope := authorizationFromHttpRequest(req)
+			if e, g := tt.authorizationType, authorizationType; e != g {
+				t.Errorf("want AuthorizationType %s but got %s", e, g)
+			}
+			if e, g := tt.wantAudience, audience; e != g {
+				t.Errorf("want Audience %s but got %s", e, g)
+			}
+			if e, g := tt.wantScope, scope; e != g {
+				t.Errorf("want Scope %s but got %s", e, g)
+			}
+		})
+	}
+}
+
+func TestSetAuthorizationHeader_Unsupported(t *testing.T) {
+	err := setAuthorizationHeader(&taskspb.HttpRequest{}, "", AuthorizationType(100), "", "")
+	if !ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
@@ -0,0 +1,152 @@
+// Package barrier is Fan-out した Task が全て完了したら、完了用の Task を 1 度だけ作成する Barrier
+//
+// FanOut で子の Task Name を Store に記録してから子の Task を作成し、子の Handler は Done か Middleware で完了を記録する。
+// 最後の子が完了すると Completion を Body に入れた Task を作成する。
+// Completion の Task Name は BarrierID から決まるので、 Done が何度呼ばれても Completion の Task は 1 つしか作成されない。
+package barrier
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+// BarrierID is 子の Task の BarrierID を入れる Header Key
+const BarrierID = "X-Gcpbox-BarrierID"
+
+// Completion is 全ての子が完了した時に作成する Task の Body
+type Completion struct {
+	BarrierID string
+}
+
+// CompletionTaskName is barrierID の Completion の Task Name ({TASK_ID}) を返す
+func CompletionTaskName(barrierID string) string {
+	return fmt.Sprintf("%s-completion", barrierID)
+}
+
+// ChildTaskName is Name を指定していない i 番目の子の Task Name ({TASK_ID}) を返す
+// 連番の Name は Cloud Tasks の Latency が悪化するので、 cloudtasks.HashPrefixTaskName で Hash を先頭に付ける
+func ChildTaskName(barrierID string, i int) string {
+	return tasksbox.HashPrefixTaskName(fmt.Sprintf("%s-%d", barrierID, i))
+}
+
+// Barrier is Fan-out した Task の完了を待って Completion の Task を作成する
+type Barrier struct {
+	store          Store
+	enqueuer       tasksbox.Enqueuer
+	queue          *tasksbox.Queue
+	completionPath string
+}
+
+// NewBarrier is Barrier を返す
+// Completion の Task は enqueuer で queue の completionPath に JSON で POST される
+// FanOut する側と子の Handler で同じ store, queue, completionPath を指定する
+func NewBarrier(store Store, enqueuer tasksbox.Enqueuer, queue *tasksbox.Queue, completionPath string) *Barrier {
+	return &Barrier{
+		store:          store,
+		enqueuer:       enqueuer,
+		queue:          queue,
+		completionPath: completionPath,
+	}
+}
+
+// FanOut is jobs を子の Task として作成して、 jobs と同じ順番で Task Name を返す
+// Name を指定していない Job は ChildTaskName を Name にする. 子の Task の Header には BarrierID が入る
+// 子の Task Name は Task を作成する前に Store に記録する. 同じ barrierID で FanOut を再実行すると、作成できていなかった子だけを作成する
+// jobs が空の場合はすぐに Completion の Task を作成する
+func (b *Barrier) FanOut(ctx context.Context, barrierID string, jobs []*tasksbox.Job, ops ...tasksbox.CreateTaskOptions) ([]string, error) {
+	if len(barrierID) < 1 {
+		return nil, tasksbox.NewErrInvalidArgument("barrierID is required", map[string]interface{}{}, nil)
+	}
+
+	children := make([]*tasksbox.Job, len(jobs))
+	names := make([]string, len(jobs))
+	for i, job := range jobs {
+		if job == nil {
+			return nil, tasksbox.NewErrInvalidArgument("job is required", map[string]interface{}{"index": i}, nil)
+		}
+		child := *job
+		if len(child.Name) < 1 {
+			child.Name = ChildTaskName(barrierID, i)
+		}
+		child.Headers = map[string]string{}
+		for k, v := range job.Headers {
+			child.Headers[k] = v
+		}
+		child.Headers[BarrierID] = barrierID
+		children[i] = &child
+		names[i] = child.Name
+	}
+
+	if err := b.store.Create(ctx, barrierID, names); err != nil && !errors.Is(err, tasksbox.ErrAlreadyExists) {
+		return nil, fmt.Errorf("failed create barrier. barrierID=%s : %w", barrierID, err)
+	}
+	if len(children) < 1 {
+		if err := b.complete(ctx, barrierID); err != nil {
+			return nil, err
+		}
+		return []string{}, nil
+	}
+	return b.enqueuer.EnqueueMulti(ctx, b.queue, children, append(ops, tasksbox.WithIgnoreAlreadyExists())...)
+}
+
+// Done is barrierID の子の taskName ({TASK_ID}) を完了にして、完了していない子の数を返す
+// 全ての子が完了した場合は Completion の Task を作成する
+// 子の Handler が Retry されて同じ taskName で何度呼んでも、 1 回だけ完了として数える
+func (b *Barrier) Done(ctx context.Context, barrierID string, taskName string) (int, error) {
+	remaining, err := b.store.Done(ctx, barrierID, taskName)
+	if err != nil {
+		return 0, fmt.Errorf("failed done barrier. barrierID=%s, taskName=%s : %w", barrierID, taskName, err)
+	}
+	if remaining > 0 {
+		return remaining, nil
+	}
+	// 前回 Completion の作成に失敗していても、 Retry で作成される
+	if err := b.complete(ctx, barrierID); err != nil {
+		return 0, err
+	}
+	return 0, nil
+}
+
+// Middleware is next が 2xx を返した時に、 Request の Header の BarrierID と Task Name で Done を呼ぶ
+// Done に失敗した場合は 500 を返して Task を Retry させる. そのため next は Retry されても問題ないようにしておく
+// Header に BarrierID が入っていない Request はそのまま next に渡す
+func (b *Barrier) Middleware(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		barrierID := r.Header.Get(BarrierID)
+		if len(barrierID) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+		taskName := taskNameFromRequest(r)
+		if len(taskName) == 0 {
+			http.Error(w, "TaskName not found", http.StatusBadRequest)
+			return
+		}
+
+		bw := &bufferedResponseWriter{header: http.Header{}}
+		next.ServeHTTP(bw, r)
+		if bw.statusCode() >= 200 && bw.statusCode() < 300 {
+			if _, err := b.Done(r.Context(), barrierID, taskName); err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+		}
+		bw.writeTo(w)
+	})
+}
+
+func (b *Barrier) complete(ctx context.Context, barrierID string) error {
+	job, err := tasksbox.NewJsonJob(b.completionPath, &Completion{BarrierID: barrierID})
+	if err != nil {
+		return err
+	}
+	job.Name = CompletionTaskName(barrierID)
+	if _, err := b.enqueuer.Enqueue(ctx, b.queue, job, tasksbox.WithIgnoreAlreadyExists()); err != nil {
+		return fmt.Errorf("failed enqueue completion. barrierID=%s : %w", barrierID, err)
+	}
+	return nil
+}
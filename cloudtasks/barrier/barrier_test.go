@@ -0,0 +1,218 @@
+package barrier_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/barrier"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/dispatcher"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var testQueue = &tasksbox.Queue{
+	ProjectID: "unittest",
+	Region:    "asia-northeast1",
+	Name:      "testqueue",
+}
+
+var testRetryConfig = &taskspb.RetryConfig{
+	MaxAttempts: 5,
+	MinBackoff:  durationpb.New(10 * time.Millisecond),
+	MaxBackoff:  durationpb.New(100 * time.Millisecond),
+}
+
+type Body struct {
+	Content string
+}
+
+func TestBarrier_FanOut(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var children []string
+	var completions []*barrier.Completion
+	failed := map[string]bool{}
+	var b *barrier.Barrier
+
+	mux := http.NewServeMux()
+	mux.Handle("/tq/child", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		th, err := tasksbox.GetHeader(r)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		if th.TaskName == barrier.ChildTaskName("nightly", 1) && !failed[th.TaskName] {
+			// 1 回失敗して Retry される子
+			failed[th.TaskName] = true
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		children = append(children, th.TaskName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	mux.Handle("/tq/completion", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		var c barrier.Completion
+		if err := json.NewDecoder(r.Body).Decode(&c); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		// Completion は全ての子が完了した後に届く
+		if e, g := 3, len(children); e != g {
+			t.Errorf("want completed children %d but got %d", e, g)
+		}
+		completions = append(completions, &c)
+		w.WriteHeader(http.StatusOK)
+	}))
+
+	d, err := dispatcher.NewDispatcher(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		b.Middleware(mux).ServeHTTP(w, r)
+	}), dispatcher.WithDefaultRetryConfig(testRetryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	b = newBarrier(t, d)
+
+	var jobs []*tasksbox.Job
+	for _, content := range []string{"a", "b", "c"} {
+		job, err := tasksbox.NewJsonJob("/tq/child", &Body{Content: content})
+		if err != nil {
+			t.Fatal(err)
+		}
+		jobs = append(jobs, job)
+	}
+	tns, err := b.FanOut(ctx, "nightly", jobs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 3, len(tns); e != g {
+		t.Errorf("want task names %d but got %d", e, g)
+	}
+	for _, job := range jobs {
+		if len(job.Name) > 0 {
+			t.Errorf("FanOut should not modify jobs. Name=%s", job.Name)
+		}
+	}
+	d.Wait()
+
+	want := []string{barrier.ChildTaskName("nightly", 0), barrier.ChildTaskName("nightly", 1), barrier.ChildTaskName("nightly", 2)}
+	sort.Strings(want)
+	sort.Strings(children)
+	if diff := cmp.Diff(want, children); diff != "" {
+		t.Errorf("children diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]*barrier.Completion{{BarrierID: "nightly"}}, completions); diff != "" {
+		t.Errorf("completions diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBarrier_FanOut_Empty(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var taskNames []string
+	d, err := dispatcher.NewDispatcher(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		th, err := tasksbox.GetHeader(r)
+		if err != nil {
+			t.Error(err)
+		}
+		taskNames = append(taskNames, th.TaskName)
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	b := newBarrier(t, d)
+
+	if _, err := b.FanOut(ctx, "empty", nil); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if diff := cmp.Diff([]string{barrier.CompletionTaskName("empty")}, taskNames); diff != "" {
+		t.Errorf("taskNames diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestBarrier_Done(t *testing.T) {
+	ctx := context.Background()
+
+	d, err := dispatcher.NewDispatcher(ctx, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	b := newBarrier(t, d)
+
+	job, err := tasksbox.NewJsonJob("/tq/child", &Body{Content: "a"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	job.Name = "child-a"
+	if _, err := b.FanOut(ctx, "done", []*tasksbox.Job{job, {Path: "/tq/child"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		taskName      string
+		wantRemaining int
+	}{
+		{"child-a", 1},
+		{"child-a", 1}, // Retry されても 1 回として数える
+		{barrier.ChildTaskName("done", 1), 0},
+		{barrier.ChildTaskName("done", 1), 0},
+	}
+	for _, tt := range cases {
+		remaining, err := b.Done(ctx, "done", tt.taskName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, g := tt.wantRemaining, remaining; e != g {
+			t.Errorf("%s: want remaining %d but got %d", tt.taskName, e, g)
+		}
+	}
+
+	if _, err := b.Done(ctx, "done", "unknown"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+	if _, err := b.Done(ctx, "unknown", "child-a"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+}
+
+func newBarrier(t *testing.T, d *dispatcher.Dispatcher) *barrier.Barrier {
+	ctx := context.Background()
+
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueuer, err := tasksbox.NewEnqueuer(ctx, taskClient, &tasksbox.HTTPTarget{BaseURL: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return barrier.NewBarrier(barrier.NewMemoryStore(), enqueuer, testQueue, "/tq/completion")
+}
@@ -0,0 +1,56 @@
+package barrier
+
+import (
+	"bytes"
+	"net/http"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/appengine"
+)
+
+// taskNameFromRequest is HTTP Target または App Engine の Task Name Header の値を返す
+func taskNameFromRequest(r *http.Request) string {
+	if v := r.Header.Get(tasksbox.TaskName); len(v) > 0 {
+		return v
+	}
+	return r.Header.Get(appengine.AppEngineTaskName)
+}
+
+// bufferedResponseWriter is next の Response を Done が終わるまで溜めておく http.ResponseWriter
+type bufferedResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func (w *bufferedResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *bufferedResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+}
+
+func (w *bufferedResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.body.Write(b)
+}
+
+func (w *bufferedResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
+
+func (w *bufferedResponseWriter) writeTo(rw http.ResponseWriter) {
+	for k, v := range w.header {
+		rw.Header()[k] = v
+	}
+	rw.WriteHeader(w.statusCode())
+	_, _ = rw.Write(w.body.Bytes())
+}
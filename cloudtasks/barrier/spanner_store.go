@@ -0,0 +1,131 @@
+package barrier
+
+import (
+	"context"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"google.golang.org/grpc/codes"
+)
+
+// DefaultSpannerTable is SpannerStore の Table Name の default
+// 子の Table は {Table}Children になる
+const DefaultSpannerTable = "CloudTasksBarriers"
+
+// CreateSpannerTableStatements is SpannerStore の DDL を返す
+// Remaining は完了していない子の数で、 Done の Transaction で子と一緒に更新する
+func CreateSpannerTableStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`
+CREATE TABLE %s (
+    BarrierID STRING(500) NOT NULL,
+    Remaining INT64 NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+) PRIMARY KEY (BarrierID)`, table),
+		fmt.Sprintf(`
+CREATE TABLE %sChildren (
+    BarrierID STRING(500) NOT NULL,
+    TaskName STRING(500) NOT NULL,
+    DoneAt TIMESTAMP OPTIONS (allow_commit_timestamp=true),
+) PRIMARY KEY (BarrierID, TaskName),
+  INTERLEAVE IN PARENT %s ON DELETE CASCADE`, table, table),
+	}
+}
+
+// MaxSpannerStoreChildren is SpannerStore.Create で 1 つの Barrier に作成できる子の最大数
+//
+// Barrier と全ての子を 1 つの Commit で Insert するので、 Spanner の 1 Commit あたりの Mutation の上限 (80,000) に収まる数にしている
+// 子の Row は 2 Column なので 1 子あたり 2 Mutation になり、 Index を追加した場合の余裕を見て上限の半分にしている
+// これを超える場合は Barrier を分けて、 Barrier の完了で次の Barrier の Task を作成する
+const MaxSpannerStoreChildren = 20000
+
+var _ Store = &SpannerStore{}
+
+// SpannerStore is Spanner に保存する Store
+//
+// 子の Done は全て Barrier の Row の Remaining を更新するので、同じ Barrier の子が並行に完了すると Remaining の Row が Write の Hotspot になり、
+// Transaction が Abort されて Retry されることが増える. 1 つの Barrier で同時に完了する子の数が多い場合は Barrier を分けるとよい
+type SpannerStore struct {
+	spannerClient *spanner.Client
+	table         string
+}
+
+// NewSpannerStore is SpannerStore を返す
+// table は CreateSpannerTableStatements で作成した Table の Name
+func NewSpannerStore(spannerClient *spanner.Client, table string) *SpannerStore {
+	return &SpannerStore{
+		spannerClient: spannerClient,
+		table:         table,
+	}
+}
+
+// Create is Store interface
+// children が MaxSpannerStoreChildren を超える場合は cloudtasks.ErrInvalidArgument を返す
+func (s *SpannerStore) Create(ctx context.Context, barrierID string, children []string) error {
+	if len(children) > MaxSpannerStoreChildren {
+		return tasksbox.NewErrInvalidArgument(fmt.Sprintf("barrier %s has too many children. max=%d", barrierID, MaxSpannerStoreChildren), map[string]interface{}{"barrierID": barrierID, "children": len(children)}, nil)
+	}
+
+	mus := []*spanner.Mutation{
+		spanner.Insert(s.table, []string{"BarrierID", "Remaining", "CreatedAt"}, []interface{}{barrierID, int64(len(children)), spanner.CommitTimestamp}),
+	}
+	for _, child := range children {
+		mus = append(mus, spanner.Insert(s.childrenTable(), []string{"BarrierID", "TaskName"}, []interface{}{barrierID, child}))
+	}
+	if _, err := s.spannerClient.Apply(ctx, mus); err != nil {
+		if spanner.ErrCode(err) == codes.AlreadyExists {
+			return tasksbox.NewErrAlreadyExists(fmt.Sprintf("barrier %s is already exists.", barrierID), map[string]interface{}{"barrierID": barrierID}, err)
+		}
+		return err
+	}
+	return nil
+}
+
+// Done is Store interface
+func (s *SpannerStore) Done(ctx context.Context, barrierID string, taskName string) (int, error) {
+	kv := map[string]interface{}{"barrierID": barrierID, "taskName": taskName}
+
+	var remaining int64
+	_, err := s.spannerClient.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		row, err := tx.ReadRow(ctx, s.table, spanner.Key{barrierID}, []string{"Remaining"})
+		if err != nil {
+			if spanner.ErrCode(err) == codes.NotFound {
+				return tasksbox.NewErrNotFound(fmt.Sprintf("barrier %s is not found.", barrierID), kv, err)
+			}
+			return err
+		}
+		if err := row.Columns(&remaining); err != nil {
+			return err
+		}
+
+		row, err = tx.ReadRow(ctx, s.childrenTable(), spanner.Key{barrierID, taskName}, []string{"DoneAt"})
+		if err != nil {
+			if spanner.ErrCode(err) == codes.NotFound {
+				return tasksbox.NewErrNotFound(fmt.Sprintf("%s is not found in barrier %s.", taskName, barrierID), kv, err)
+			}
+			return err
+		}
+		var doneAt spanner.NullTime
+		if err := row.Columns(&doneAt); err != nil {
+			return err
+		}
+		if doneAt.Valid {
+			return nil
+		}
+
+		remaining--
+		return tx.BufferWrite([]*spanner.Mutation{
+			spanner.Update(s.table, []string{"BarrierID", "Remaining"}, []interface{}{barrierID, remaining}),
+			spanner.Update(s.childrenTable(), []string{"BarrierID", "TaskName", "DoneAt"}, []interface{}{barrierID, taskName, spanner.CommitTimestamp}),
+		})
+	})
+	if err != nil {
+		return 0, err
+	}
+	return int(remaining), nil
+}
+
+func (s *SpannerStore) childrenTable() string {
+	return fmt.Sprintf("%sChildren", s.table)
+}
@@ -0,0 +1,140 @@
+package barrier_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+
+	"cloud.google.com/go/spanner"
+	sadDatabase "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sadInstance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/barrier"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestSpannerStore_TooManyChildren(t *testing.T) {
+	// 上限の確認は Spanner に Request する前に行うので Client は nil でよい
+	store := barrier.NewSpannerStore(nil, barrier.DefaultSpannerTable)
+
+	children := make([]string, barrier.MaxSpannerStoreChildren+1)
+	for i := range children {
+		children[i] = fmt.Sprintf("nightly-%d", i)
+	}
+	if err := store.Create(context.Background(), "nightly", children); !tasksbox.ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
+
+func TestSpannerStore(t *testing.T) {
+	ctx := context.Background()
+
+	sc := newSpannerClient(t)
+	defer sc.Close()
+	store := barrier.NewSpannerStore(sc, barrier.DefaultSpannerTable)
+
+	if err := store.Create(ctx, "nightly", []string{"nightly-0", "nightly-1"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := store.Create(ctx, "nightly", []string{"nightly-0", "nightly-1"}); !tasksbox.ErrAlreadyExists.Is(err) {
+		t.Errorf("want ErrAlreadyExists but got %v", err)
+	}
+
+	cases := []struct {
+		taskName      string
+		wantRemaining int
+	}{
+		{"nightly-0", 1},
+		{"nightly-0", 1},
+		{"nightly-1", 0},
+		{"nightly-1", 0},
+	}
+	for _, tt := range cases {
+		remaining, err := store.Done(ctx, "nightly", tt.taskName)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if e, g := tt.wantRemaining, remaining; e != g {
+			t.Errorf("%s: want remaining %d but got %d", tt.taskName, e, g)
+		}
+	}
+
+	if _, err := store.Done(ctx, "nightly", "unknown"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+	if _, err := store.Done(ctx, "unknown", "nightly-0"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+}
+
+// newSpannerClient is Spanner Emulator に SpannerStore の Table を持つ Database を作成して Client を返す
+func newSpannerClient(t *testing.T) *spanner.Client {
+	seh := os.Getenv("SPANNER_EMULATOR_HOST")
+	if len(seh) < 1 {
+		t.Fatal("Required $SPANNER_EMULATOR_HOST")
+	}
+
+	ctx := context.Background()
+	const projectID = "unittest"
+	const instance = "barrier"
+
+	spannerInstanceAdminClient, err := sadInstance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerInstanceAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	spannerDatabaseAdminClient, err := sadDatabase.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerDatabaseAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err = spannerInstanceAdminClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     fmt.Sprintf("projects/%s", projectID),
+		InstanceId: instance,
+		Instance: &instancepb.Instance{
+			Name:      fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+			NodeCount: 1,
+		},
+	})
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			// noop
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	database := fmt.Sprintf("barrier%d", rand.Int31())
+	op, err := spannerDatabaseAdminClient.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+		CreateStatement: fmt.Sprintf("CREATE DATABASE %s", database),
+		ExtraStatements: barrier.CreateSpannerTableStatements(barrier.DefaultSpannerTable),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instance, database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
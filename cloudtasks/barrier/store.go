@@ -0,0 +1,84 @@
+package barrier
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+// Store is Barrier の子の完了状態を保存する
+type Store interface {
+	// Create is barrierID の Barrier を children ({TASK_ID}) が全て未完了の状態で作成する
+	// すでに存在する場合は cloudtasks.ErrAlreadyExists を返す
+	Create(ctx context.Context, barrierID string, children []string) error
+
+	// Done is barrierID の子の taskName を完了にして、完了していない子の数を返す
+	// すでに完了している子の場合は何もせずに、完了していない子の数を返す
+	// Barrier や子が存在しない場合は cloudtasks.ErrNotFound を返す
+	Done(ctx context.Context, barrierID string, taskName string) (int, error)
+}
+
+var _ Store = &MemoryStore{}
+
+// MemoryStore is Memory 上に保存する Store
+// 1 つの Process の中で完結する場合や UnitTest で使う
+type MemoryStore struct {
+	mutex    sync.Mutex
+	barriers map[string]*memoryBarrier
+}
+
+// memoryBarrier is MemoryStore の 1 つの Barrier
+// Done の度に全ての子を数えないように、完了していない子の数を持っておく
+type memoryBarrier struct {
+	done      map[string]bool
+	remaining int
+}
+
+// NewMemoryStore is MemoryStore を返す
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{
+		barriers: map[string]*memoryBarrier{},
+	}
+}
+
+// Create is Store interface
+func (s *MemoryStore) Create(ctx context.Context, barrierID string, children []string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.barriers[barrierID]; ok {
+		return tasksbox.NewErrAlreadyExists(fmt.Sprintf("barrier %s is already exists.", barrierID), map[string]interface{}{"barrierID": barrierID}, nil)
+	}
+	done := make(map[string]bool, len(children))
+	for _, child := range children {
+		done[child] = false
+	}
+	s.barriers[barrierID] = &memoryBarrier{
+		done:      done,
+		remaining: len(done),
+	}
+	return nil
+}
+
+// Done is Store interface
+func (s *MemoryStore) Done(ctx context.Context, barrierID string, taskName string) (int, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	kv := map[string]interface{}{"barrierID": barrierID, "taskName": taskName}
+	b, ok := s.barriers[barrierID]
+	if !ok {
+		return 0, tasksbox.NewErrNotFound(fmt.Sprintf("barrier %s is not found.", barrierID), kv, nil)
+	}
+	done, ok := b.done[taskName]
+	if !ok {
+		return 0, tasksbox.NewErrNotFound(fmt.Sprintf("%s is not found in barrier %s.", taskName, barrierID), kv, nil)
+	}
+	if !done {
+		b.done[taskName] = true
+		b.remaining--
+	}
+	return b.remaining, nil
+}
@@ -0,0 +1,152 @@
+// Package continuation is Handler が自分自身の次の Step を Task として作成して、長い処理を分割して続けるための Helper
+//
+// Cursor を使って Batch を少しずつ処理する Handler が、 Cursor を State に入れて次の Step を作成する時に使う。
+// 次の Step の Task Name は ChainID と Generation から決まるので、 Handler が Retry されても次の Step は 1 つしか作成されない。
+package continuation
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/google/uuid"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+)
+
+// ErrMaxDepth is Generation が MaxDepth に達したので、次の Step を作成しなかった
+var ErrMaxDepth = errors.New("max depth exceeded")
+
+// Step is Chain の 1 つの Step の Task の Body
+type Step[S any] struct {
+	// ChainID is Chain の ID. Chain の全ての Step で同じ値
+	ChainID string
+
+	// Generation is Chain の何番目の Step なのか. 最初の Step は 0
+	Generation int
+
+	// Idle is Backoff で連続して作成された回数. Next で作成すると 0 に戻る
+	Idle int
+
+	// State is Step の間で引き継ぐ値. Cursor などを入れる
+	State S
+}
+
+// TaskName is Step の Task Name ({TASK_ID})
+func (s *Step[S]) TaskName() string {
+	return TaskName(s.ChainID, s.Generation)
+}
+
+// TaskName is chainID の generation 番目の Step の Task Name ({TASK_ID}) を返す
+func TaskName(chainID string, generation int) string {
+	return fmt.Sprintf("%s-%d", chainID, generation)
+}
+
+// Continuation is Step を Task として作成する
+type Continuation[S any] struct {
+	enqueuer tasksbox.Enqueuer
+	queue    *tasksbox.Queue
+	path     string
+
+	maxDepth          int
+	delay             time.Duration
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	backoffMultiplier float64
+	createTaskOptions []tasksbox.CreateTaskOptions
+}
+
+// NewContinuation is Continuation を返す
+// Step は enqueuer で queue の path に JSON で POST される
+// Handler は handler.NewHandler[continuation.Step[S]] で作成する
+func NewContinuation[S any](enqueuer tasksbox.Enqueuer, queue *tasksbox.Queue, path string, ops ...Options) *Continuation[S] {
+	opt := options{
+		backoffInitial:    time.Second,
+		backoffMax:        time.Hour,
+		backoffMultiplier: 2,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	return &Continuation[S]{
+		enqueuer:          enqueuer,
+		queue:             queue,
+		path:              path,
+		maxDepth:          opt.maxDepth,
+		delay:             opt.delay,
+		backoffInitial:    opt.backoffInitial,
+		backoffMax:        opt.backoffMax,
+		backoffMultiplier: opt.backoffMultiplier,
+		createTaskOptions: append(opt.createTaskOptions, tasksbox.WithIgnoreAlreadyExists()),
+	}
+}
+
+// Start is 新しい Chain の最初の Step を作成して、 Task Name を返す
+// chainID を省略した場合は UUID を設定する. 同じ chainID で Start しても Step は 1 つしか作成されない
+func (c *Continuation[S]) Start(ctx context.Context, chainID string, state S) (string, error) {
+	if len(chainID) < 1 {
+		chainID = uuid.New().String()
+	}
+	return c.enqueue(ctx, &Step[S]{ChainID: chainID, State: state}, time.Now().Add(c.delay))
+}
+
+// Next is step の次の Step を state で作成して、 Task Name を返す
+// Handler の中で Batch を処理した後に呼ぶ. 次の Step は WithDelay で指定した時間の後に実行される
+// Generation が WithMaxDepth に達した場合は作成せずに ErrMaxDepth を返す
+func (c *Continuation[S]) Next(ctx context.Context, step *Step[S], state S) (string, error) {
+	return c.enqueue(ctx, &Step[S]{
+		ChainID:    step.ChainID,
+		Generation: step.Generation + 1,
+		State:      state,
+	}, time.Now().Add(c.delay))
+}
+
+// Backoff is 処理するものが無かった時に、 step の次の Step を間隔を空けて作成して、 Task Name を返す
+// 間隔は Backoff が連続するたびに WithBackoff の multiplier 倍になり、 Next で元に戻る
+// Generation が WithMaxDepth に達した場合は作成せずに ErrMaxDepth を返す
+func (c *Continuation[S]) Backoff(ctx context.Context, step *Step[S], state S) (string, error) {
+	next := &Step[S]{
+		ChainID:    step.ChainID,
+		Generation: step.Generation + 1,
+		Idle:       step.Idle + 1,
+		State:      state,
+	}
+	return c.enqueue(ctx, next, time.Now().Add(c.BackoffDelay(next.Idle)))
+}
+
+// BackoffDelay is Backoff が idle 回連続した時の Step の間隔を返す
+func (c *Continuation[S]) BackoffDelay(idle int) time.Duration {
+	d := float64(c.backoffInitial)
+	for i := 1; i < idle && d < float64(c.backoffMax); i++ {
+		d *= c.backoffMultiplier
+	}
+	if d > float64(c.backoffMax) {
+		return c.backoffMax
+	}
+	return time.Duration(d)
+}
+
+func (c *Continuation[S]) enqueue(ctx context.Context, step *Step[S], scheduleTime time.Time) (string, error) {
+	kv := map[string]interface{}{"chainID": step.ChainID, "generation": step.Generation}
+	if header, ok := handler.HeaderFromContext(ctx); ok {
+		kv["taskName"] = header.TaskName
+	}
+
+	if c.maxDepth > 0 && step.Generation >= c.maxDepth {
+		return "", fmt.Errorf("%w. maxDepth=%d, %+v", ErrMaxDepth, c.maxDepth, kv)
+	}
+
+	job, err := tasksbox.NewJsonJob(c.path, step)
+	if err != nil {
+		return "", err
+	}
+	job.Name = step.TaskName()
+	job.ScheduleTime = scheduleTime
+	tn, err := c.enqueuer.Enqueue(ctx, c.queue, job, c.createTaskOptions...)
+	if err != nil {
+		return "", fmt.Errorf("failed enqueue step. %+v : %w", kv, err)
+	}
+	return tn, nil
+}
@@ -0,0 +1,187 @@
+package continuation_test
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/continuation"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/dispatcher"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+var testQueue = &tasksbox.Queue{
+	ProjectID: "unittest",
+	Region:    "asia-northeast1",
+	Name:      "testqueue",
+}
+
+var testRetryConfig = &taskspb.RetryConfig{
+	MaxAttempts: 5,
+	MinBackoff:  durationpb.New(10 * time.Millisecond),
+	MaxBackoff:  durationpb.New(100 * time.Millisecond),
+}
+
+type Cursor struct {
+	Offset int
+}
+
+func TestContinuation_Next(t *testing.T) {
+	ctx := context.Background()
+
+	const itemCount = 10
+	const batchSize = 3
+
+	var mutex sync.Mutex
+	var processed []int
+	var taskNames []string
+	var failed bool
+	var cont *continuation.Continuation[Cursor]
+	h := handler.NewHandler(func(ctx context.Context, step *continuation.Step[Cursor]) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		th, _ := handler.HeaderFromContext(ctx)
+		taskNames = append(taskNames, th.TaskName)
+
+		end := min(step.State.Offset+batchSize, itemCount)
+		for i := step.State.Offset; i < end; i++ {
+			processed = append(processed, i)
+		}
+		if end >= itemCount {
+			return nil
+		}
+		if _, err := cont.Next(ctx, step, Cursor{Offset: end}); err != nil {
+			return err
+		}
+		if step.Generation == 1 && !failed {
+			// 次の Step を作成した後に失敗して Retry される
+			failed = true
+			processed = processed[:len(processed)-(end-step.State.Offset)]
+			return errors.New("retry")
+		}
+		return nil
+	})
+
+	d, err := dispatcher.NewDispatcher(ctx, h, dispatcher.WithDefaultRetryConfig(testRetryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueuer, err := tasksbox.NewEnqueuer(ctx, taskClient, &tasksbox.HTTPTarget{BaseURL: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cont = continuation.NewContinuation[Cursor](enqueuer, testQueue, "/tq/cursor")
+
+	if _, err := cont.Start(ctx, "chain", Cursor{}); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	// Retry された Step は次の Step より後に実行されることがある
+	sort.Ints(processed)
+	sort.Strings(taskNames)
+	if diff := cmp.Diff([]int{0, 1, 2, 3, 4, 5, 6, 7, 8, 9}, processed); diff != "" {
+		t.Errorf("processed diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"chain-0", "chain-1", "chain-1", "chain-2", "chain-3"}, taskNames); diff != "" {
+		t.Errorf("taskNames diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestContinuation_MaxDepth(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var generations []int
+	var deadLetter *handler.DeadLetter
+	var cont *continuation.Continuation[Cursor]
+	h := handler.NewHandler(func(ctx context.Context, step *continuation.Step[Cursor]) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		generations = append(generations, step.Generation)
+		if _, err := cont.Next(ctx, step, Cursor{Offset: step.State.Offset + 1}); err != nil {
+			if errors.Is(err, continuation.ErrMaxDepth) {
+				return handler.Permanent(err)
+			}
+			return err
+		}
+		return nil
+	}, handler.WithDeadLetter(func(ctx context.Context, dl *handler.DeadLetter) {
+		deadLetter = dl
+	}))
+
+	d, err := dispatcher.NewDispatcher(ctx, h, dispatcher.WithDefaultRetryConfig(testRetryConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	enqueuer, err := tasksbox.NewEnqueuer(ctx, taskClient, &tasksbox.HTTPTarget{BaseURL: "http://localhost"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	cont = continuation.NewContinuation[Cursor](enqueuer, testQueue, "/tq/cursor", continuation.WithMaxDepth(3))
+
+	if _, err := cont.Start(ctx, "", Cursor{}); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if diff := cmp.Diff([]int{0, 1, 2}, generations); diff != "" {
+		t.Errorf("generations diff (-want +got):\n%s", diff)
+	}
+	if deadLetter == nil {
+		t.Fatal("dead letter is not called")
+	}
+	if !errors.Is(deadLetter.Err, continuation.ErrMaxDepth) {
+		t.Errorf("want ErrMaxDepth but got %v", deadLetter.Err)
+	}
+}
+
+func TestContinuation_BackoffDelay(t *testing.T) {
+	cont := continuation.NewContinuation[Cursor](nil, testQueue, "/tq/cursor", continuation.WithBackoff(time.Second, 5*time.Second, 2))
+
+	cases := []struct {
+		idle int
+		want time.Duration
+	}{
+		{1, time.Second},
+		{2, 2 * time.Second},
+		{3, 4 * time.Second},
+		{4, 5 * time.Second},
+		{10, 5 * time.Second},
+	}
+	for _, tt := range cases {
+		if e, g := tt.want, cont.BackoffDelay(tt.idle); e != g {
+			t.Errorf("idle=%d: want %s but got %s", tt.idle, e, g)
+		}
+	}
+}
+
+func TestTaskName(t *testing.T) {
+	step := &continuation.Step[Cursor]{ChainID: "chain", Generation: 3}
+	if e, g := continuation.TaskName("chain", 3), step.TaskName(); e != g {
+		t.Errorf("want %s but got %s", e, g)
+	}
+	if e, g := "chain-3", step.TaskName(); e != g {
+		t.Errorf("want %s but got %s", e, g)
+	}
+}
@@ -0,0 +1,54 @@
+package continuation
+
+import (
+	"time"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+type options struct {
+	maxDepth          int
+	delay             time.Duration
+	backoffInitial    time.Duration
+	backoffMax        time.Duration
+	backoffMultiplier float64
+	createTaskOptions []tasksbox.CreateTaskOptions
+}
+
+// Options is Continuation の Options
+type Options func(*options)
+
+// WithMaxDepth is Chain の Step の最大数を指定する
+// Generation が max になる Step は作成されずに ErrMaxDepth になる. 省略した場合は無制限
+func WithMaxDepth(max int) Options {
+	return func(ops *options) {
+		ops.maxDepth = max
+	}
+}
+
+// WithDelay is Start, Next で作成する Step を実行するまでの時間を指定する
+// 省略した場合は即時実行
+func WithDelay(delay time.Duration) Options {
+	return func(ops *options) {
+		ops.delay = delay
+	}
+}
+
+// WithBackoff is Backoff で作成する Step の間隔を指定する
+// 最初は initial で、連続するたびに multiplier 倍にして max で止める
+// 省略した場合は 1s から 2 倍ずつで 1h まで
+func WithBackoff(initial time.Duration, max time.Duration, multiplier float64) Options {
+	return func(ops *options) {
+		ops.backoffInitial = initial
+		ops.backoffMax = max
+		ops.backoffMultiplier = multiplier
+	}
+}
+
+// WithCreateTaskOptions is Step を作成する時の CreateTaskOptions を指定する
+// cloudtasks.WithIgnoreAlreadyExists は常に指定される
+func WithCreateTaskOptions(ops ...tasksbox.CreateTaskOptions) Options {
+	return func(o *options) {
+		o.createTaskOptions = append(o.createTaskOptions, ops...)
+	}
+}
@@ -0,0 +1,377 @@
+package dispatcher
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/appengine"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+const (
+	// defaultDispatchDeadline is DispatchDeadline が指定されていない時の Deadline
+	defaultDispatchDeadline = 10 * time.Minute
+
+	// deadlineExceededStatusCode is Handler が DispatchDeadline までに Response を返さなかった時に Attempt に記録する StatusCode
+	deadlineExceededStatusCode = http.StatusGatewayTimeout
+)
+
+// Attempt is Task を Handler に届けた 1 回分の記録
+type Attempt struct {
+	// QueueName is projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID} 形式の Queue Name
+	QueueName string
+
+	// TaskName is projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式の Task Name
+	TaskName string
+
+	// RetryCount is Handler に渡した RetryCount
+	RetryCount int
+
+	// ExecutionCount is Handler に渡した ExecutionCount
+	ExecutionCount int
+
+	// ETA is Handler に渡した ETA
+	ETA time.Time
+
+	// StatusCode is Handler が返した StatusCode
+	// DispatchDeadline を超えた場合は 504 になる
+	StatusCode int
+
+	// DeadlineExceeded is Handler が DispatchDeadline までに Response を返さなかった
+	DeadlineExceeded bool
+
+	// DispatchedAt is Handler を呼び出した時刻
+	DispatchedAt time.Time
+}
+
+// Dispatcher is Local で Cloud Tasks の代わりに http.Handler に Task を届ける
+//
+// ClientOption() を cloudtasks.Client に設定すると、 CreateTask された Task を ScheduleTime になった時に handler に渡す
+// Handler が 2xx 以外を返した場合は Queue の RetryConfig に従って Retry する
+// OIDC Token などの Authorization Header は付与しない
+// DispatchDeadline を超えた Handler も Stop() で終わるのを待つので、 Handler は Request の Context の Done を見て終了すること
+type Dispatcher struct {
+	handler            http.Handler
+	defaultRetryConfig *taskspb.RetryConfig
+	retryConfigs       map[string]*taskspb.RetryConfig
+
+	serv *grpc.Server
+	conn *grpc.ClientConn
+
+	ctx    context.Context
+	cancel context.CancelFunc
+	wg     sync.WaitGroup
+
+	// handlers is DispatchDeadline を超えて Attempt の記録が終わった後も動いている Handler を含めた、実行中の Handler
+	handlers sync.WaitGroup
+
+	mutex     sync.Mutex
+	taskNames map[string]bool
+	attempts  []*Attempt
+}
+
+// NewDispatcher is Dispatcher を返す
+// 使い終わったら Stop() を呼ぶ
+func NewDispatcher(ctx context.Context, handler http.Handler, ops ...Options) (*Dispatcher, error) {
+	opt := options{
+		retryConfigs: map[string]*taskspb.RetryConfig{},
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return nil, fmt.Errorf("failed net.Listen : %w", err)
+	}
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		lis.Close()
+		return nil, fmt.Errorf("failed grpc.NewClient : %w", err)
+	}
+
+	dctx, cancel := context.WithCancel(context.Background())
+	d := &Dispatcher{
+		handler:            handler,
+		defaultRetryConfig: opt.defaultRetryConfig,
+		retryConfigs:       opt.retryConfigs,
+		serv:               grpc.NewServer(),
+		conn:               conn,
+		ctx:                dctx,
+		cancel:             cancel,
+		taskNames:          map[string]bool{},
+	}
+	taskspb.RegisterCloudTasksServer(d.serv, &server{d: d})
+	go d.serv.Serve(lis)
+
+	return d, nil
+}
+
+// ClientOption is cloudtasks.Client に 設定する ClientOption
+func (d *Dispatcher) ClientOption() option.ClientOption {
+	return option.WithGRPCConn(d.conn)
+}
+
+// Wait is 作成された全ての Task が成功するか、Retry の上限に達するまで待つ
+func (d *Dispatcher) Wait() {
+	d.wg.Wait()
+}
+
+// Stop is 配送待ちの Task を破棄して Dispatcher を停止する
+// 実行中の Handler は Request の Context を Cancel して、終わるのを待つ
+func (d *Dispatcher) Stop() {
+	d.cancel()
+	d.wg.Wait()
+	d.handlers.Wait()
+	d.conn.Close()
+	d.serv.Stop()
+}
+
+// Attempts is これまでに Handler を呼び出した記録を呼び出した順に返す
+func (d *Dispatcher) Attempts() []*Attempt {
+	d.mutex.Lock()
+	defer d.mutex.Unlock()
+
+	ret := make([]*Attempt, len(d.attempts))
+	copy(ret, d.attempts)
+	return ret
+}
+
+// CreateTask is Task を受け付けて、ScheduleTime になったら Handler に届ける
+func (d *Dispatcher) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	if req.GetTask() == nil {
+		return nil, status.Error(codes.InvalidArgument, "task is required")
+	}
+	if req.GetTask().GetHttpRequest() == nil && req.GetTask().GetAppEngineHttpRequest() == nil {
+		return nil, status.Error(codes.InvalidArgument, "HttpRequest or AppEngineHttpRequest is required")
+	}
+	if _, err := queueID(req.GetParent()); err != nil {
+		return nil, status.Error(codes.InvalidArgument, err.Error())
+	}
+	if err := d.ctx.Err(); err != nil {
+		return nil, status.Error(codes.Unavailable, "dispatcher is stopped")
+	}
+
+	task := proto.Clone(req.GetTask()).(*taskspb.Task)
+	now := time.Now()
+	if task.GetName() == "" {
+		task.Name = fmt.Sprintf("%s/tasks/%d", req.GetParent(), rand.Uint64())
+	} else if !strings.HasPrefix(task.GetName(), req.GetParent()+"/tasks/") {
+		return nil, status.Errorf(codes.InvalidArgument, "task name %s does not belong to %s", task.GetName(), req.GetParent())
+	}
+	if task.GetScheduleTime() == nil {
+		task.ScheduleTime = timestamppb.New(now)
+	}
+	if task.GetDispatchDeadline() == nil {
+		task.DispatchDeadline = durationpb.New(defaultDispatchDeadline)
+	}
+	task.CreateTime = timestamppb.New(now)
+
+	d.mutex.Lock()
+	if d.taskNames[task.GetName()] {
+		d.mutex.Unlock()
+		return nil, status.Errorf(codes.AlreadyExists, "%s is already exists", task.GetName())
+	}
+	d.taskNames[task.GetName()] = true
+	d.mutex.Unlock()
+
+	d.wg.Add(1)
+	go d.run(req.GetParent(), proto.Clone(task).(*taskspb.Task))
+
+	return task, nil
+}
+
+func (d *Dispatcher) retryConfig(queue string) *retryConfig {
+	if cfg, ok := d.retryConfigs[queue]; ok {
+		return newRetryConfig(cfg)
+	}
+	return newRetryConfig(d.defaultRetryConfig)
+}
+
+func (d *Dispatcher) run(queue string, task *taskspb.Task) {
+	defer d.wg.Done()
+
+	cfg := d.retryConfig(queue)
+	eta := task.GetScheduleTime().AsTime()
+	var firstAttemptAt time.Time
+	var executionCount int
+	var previousResponse int
+	for retryCount := 0; ; retryCount++ {
+		if !d.sleepUntil(eta) {
+			return
+		}
+		if firstAttemptAt.IsZero() {
+			firstAttemptAt = time.Now()
+		}
+
+		attempt := d.dispatch(queue, task, retryCount, executionCount, eta, previousResponse)
+		d.mutex.Lock()
+		d.attempts = append(d.attempts, attempt)
+		d.mutex.Unlock()
+
+		if attempt.StatusCode >= 200 && attempt.StatusCode < 300 {
+			return
+		}
+		if !attempt.DeadlineExceeded {
+			executionCount++
+		}
+		previousResponse = attempt.StatusCode
+		if !cfg.canRetry(retryCount+1, time.Since(firstAttemptAt)) {
+			return
+		}
+		eta = time.Now().Add(cfg.backoff(retryCount))
+	}
+}
+
+func (d *Dispatcher) sleepUntil(t time.Time) bool {
+	timer := time.NewTimer(time.Until(t))
+	defer timer.Stop()
+
+	select {
+	case <-d.ctx.Done():
+		return false
+	case <-timer.C:
+		return true
+	}
+}
+
+func (d *Dispatcher) dispatch(queue string, task *taskspb.Task, retryCount int, executionCount int, eta time.Time, previousResponse int) *Attempt {
+	attempt := &Attempt{
+		QueueName:      queue,
+		TaskName:       task.GetName(),
+		RetryCount:     retryCount,
+		ExecutionCount: executionCount,
+		ETA:            eta,
+		DispatchedAt:   time.Now(),
+	}
+
+	ctx, cancel := context.WithTimeout(d.ctx, task.GetDispatchDeadline().AsDuration())
+	defer cancel()
+
+	r, err := newRequest(ctx, queue, task, retryCount, executionCount, eta, previousResponse)
+	if err != nil {
+		// Cloud Tasks 上では作成時に弾かれる Task なので、Handler には届けずに失敗扱いにする
+		attempt.StatusCode = http.StatusBadRequest
+		return attempt
+	}
+
+	w := httptest.NewRecorder()
+	done := make(chan struct{})
+	d.handlers.Add(1)
+	go func() {
+		defer d.handlers.Done()
+		defer close(done)
+		defer func() {
+			if rec := recover(); rec != nil {
+				w.Code = http.StatusInternalServerError
+			}
+		}()
+		d.handler.ServeHTTP(w, r)
+	}()
+
+	select {
+	case <-done:
+		attempt.StatusCode = w.Code
+	case <-ctx.Done():
+		attempt.StatusCode = deadlineExceededStatusCode
+		attempt.DeadlineExceeded = true
+	}
+	return attempt
+}
+
+func newRequest(ctx context.Context, queue string, task *taskspb.Task, retryCount int, executionCount int, eta time.Time, previousResponse int) (*http.Request, error) {
+	qid, err := queueID(queue)
+	if err != nil {
+		return nil, err
+	}
+	tid := task.GetName()[strings.LastIndex(task.GetName(), "/")+1:]
+
+	if req := task.GetHttpRequest(); req != nil {
+		method, err := tasksbox.HttpMethodProtoToHttpMethod(req.GetHttpMethod())
+		if err != nil {
+			return nil, err
+		}
+		r, err := http.NewRequestWithContext(ctx, method, req.GetUrl(), bytes.NewReader(req.GetBody()))
+		if err != nil {
+			return nil, err
+		}
+		for k, v := range req.GetHeaders() {
+			r.Header.Set(k, v)
+		}
+		r.Header.Set("User-Agent", "Google-Cloud-Tasks")
+		r.Header.Set(tasksbox.QueueName, qid)
+		r.Header.Set(tasksbox.TaskName, tid)
+		r.Header.Set(tasksbox.RetryCount, strconv.Itoa(retryCount))
+		r.Header.Set(tasksbox.ExecutionCount, strconv.Itoa(executionCount))
+		r.Header.Set(tasksbox.ETA, formatETA(eta))
+		r.Header.Set(tasksbox.PreviousResponse, strconv.Itoa(previousResponse))
+		return r, nil
+	}
+
+	req := task.GetAppEngineHttpRequest()
+	method, err := appengine.HttpMethodProtoToHttpMethod(req.GetHttpMethod())
+	if err != nil {
+		return nil, err
+	}
+	r, err := http.NewRequestWithContext(ctx, method, req.GetRelativeUri(), bytes.NewReader(req.GetBody()))
+	if err != nil {
+		return nil, err
+	}
+	for k, v := range req.GetHeaders() {
+		r.Header.Set(k, v)
+	}
+	r.Header.Set("User-Agent", "AppEngine-Google; (+http://code.google.com/appengine)")
+	r.Header.Set(appengine.GoogleInternalSkipAdminCheck, "true")
+	r.Header.Set(appengine.AppEngineQueueName, qid)
+	r.Header.Set(appengine.AppEngineTaskName, tid)
+	r.Header.Set(appengine.AppEngineTaskRetryCount, strconv.Itoa(retryCount))
+	r.Header.Set(appengine.AppEngineTaskExecutionCount, strconv.Itoa(executionCount))
+	r.Header.Set(appengine.AppEngineTaskETA, formatETA(eta))
+	if retryCount > 0 {
+		r.Header.Set(appengine.AppEngineTaskPreviousResponse, strconv.Itoa(previousResponse))
+	}
+	return r, nil
+}
+
+// formatETA is Cloud Tasks が ETA Header に入れる {UNIX秒}.{マイクロ秒} 形式にする
+func formatETA(t time.Time) string {
+	return fmt.Sprintf("%d.%06d", t.Unix(), t.Nanosecond()/1000)
+}
+
+// queueID is projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID} 形式の文字列から {QUEUE_ID} を返す
+func queueID(queue string) (string, error) {
+	l := strings.Split(queue, "/")
+	if len(l) != 6 || l[0] != "projects" || l[2] != "locations" || l[4] != "queues" {
+		return "", fmt.Errorf("invalid queue name %s. The expected format is projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}", queue)
+	}
+	return l[5], nil
+}
+
+type server struct {
+	// Embed for forward compatibility.
+	taskspb.UnimplementedCloudTasksServer
+
+	d *Dispatcher
+}
+
+func (s *server) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	return s.d.CreateTask(ctx, req)
+}
@@ -0,0 +1,555 @@
+package dispatcher_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/url"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/appengine"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/dispatcher"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+var testQueue = &tasksbox.Queue{
+	ProjectID: "unittest",
+	Region:    "asia-northeast1",
+	Name:      "testqueue",
+}
+
+var testRetryConfig = &taskspb.RetryConfig{
+	MaxAttempts: 5,
+	MinBackoff:  durationpb.New(10 * time.Millisecond),
+	MaxBackoff:  durationpb.New(100 * time.Millisecond),
+}
+
+type Body struct {
+	Content string
+}
+
+func TestDispatcher_CreateJsonPostTask(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var headers []*tasksbox.Header
+	var bodies []*Body
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		th, err := tasksbox.GetHeader(r)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		var body Body
+		if err := json.NewDecoder(r.Body).Decode(&body); err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		headers = append(headers, th)
+		bodies = append(bodies, &body)
+		if len(headers) < 3 {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s, d := newDispatcherService(t, handler, dispatcher.WithRetryConfig(testQueue.Parent(), testRetryConfig))
+	defer d.Stop()
+
+	_, err := s.CreateJsonPostTask(ctx, testQueue, &tasksbox.JsonPostTask{
+		Name:        "hellotask",
+		RelativeURI: "http://localhost/tq/hoge",
+		Body:        &Body{Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if e, g := 3, len(headers); e != g {
+		t.Fatalf("want handler call count %d but got %d", e, g)
+	}
+	for i, th := range headers {
+		if e, g := testQueue.Name, th.QueueName; e != g {
+			t.Errorf("%d : want QueueName %s but got %s", i, e, g)
+		}
+		if e, g := "hellotask", th.TaskName; e != g {
+			t.Errorf("%d : want TaskName %s but got %s", i, e, g)
+		}
+		if e, g := i, th.RetryCount; e != g {
+			t.Errorf("%d : want RetryCount %d but got %d", i, e, g)
+		}
+		if e, g := i, th.ExecutionCount; e != g {
+			t.Errorf("%d : want ExecutionCount %d but got %d", i, e, g)
+		}
+		if i > 0 {
+			if e, g := "500", th.PreviousResponse; e != g {
+				t.Errorf("%d : want PreviousResponse %s but got %s", i, e, g)
+			}
+		}
+		if e, g := "Hello", bodies[i].Content; e != g {
+			t.Errorf("%d : want Body %s but got %s", i, e, g)
+		}
+	}
+
+	attempts := d.Attempts()
+	if e, g := 3, len(attempts); e != g {
+		t.Fatalf("want attempts %d but got %d", e, g)
+	}
+	if e, g := http.StatusOK, attempts[2].StatusCode; e != g {
+		t.Errorf("want last StatusCode %d but got %d", e, g)
+	}
+}
+
+func TestDispatcher_MaxAttempts(t *testing.T) {
+	ctx := context.Background()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+	})
+
+	s, d := newDispatcherService(t, handler, dispatcher.WithDefaultRetryConfig(testRetryConfig))
+	defer d.Stop()
+
+	_, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{
+		RelativeURI: "http://localhost/tq/hoge",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if e, g := int(testRetryConfig.MaxAttempts), len(d.Attempts()); e != g {
+		t.Errorf("want attempts %d but got %d", e, g)
+	}
+}
+
+func TestDispatcher_HandlerMaxAge(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var deadLetters []*handler.DeadLetter
+	h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+		return errors.New("retry")
+	}, handler.WithMaxAge(100*time.Millisecond), handler.WithDeadLetter(func(ctx context.Context, dl *handler.DeadLetter) {
+		mutex.Lock()
+		defer mutex.Unlock()
+		deadLetters = append(deadLetters, dl)
+	}))
+
+	// 各試行は ETA 通りに始まるので、 ETA からの経過時間では MaxAge に達しない
+	retryConfig := &taskspb.RetryConfig{
+		MaxAttempts: 100,
+		MinBackoff:  durationpb.New(20 * time.Millisecond),
+		MaxBackoff:  durationpb.New(20 * time.Millisecond),
+	}
+	s, d := newDispatcherService(t, h, dispatcher.WithDefaultRetryConfig(retryConfig))
+	defer d.Stop()
+
+	_, err := s.CreateJsonPostTask(ctx, testQueue, &tasksbox.JsonPostTask{
+		RelativeURI: "http://localhost/tq/hoge",
+		Body:        &Body{Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if e, g := 1, len(deadLetters); e != g {
+		t.Fatalf("want dead letter %d but got %d", e, g)
+	}
+	if e, g := handler.DeadLetterReasonMaxAge, deadLetters[0].Reason; e != g {
+		t.Errorf("want Reason %s but got %s", e, g)
+	}
+	if deadLetters[0].Header.RetryCount < 1 {
+		t.Errorf("want retried task but got RetryCount %d", deadLetters[0].Header.RetryCount)
+	}
+	attempts := d.Attempts()
+	if g := len(attempts); g >= int(retryConfig.MaxAttempts) {
+		t.Errorf("want attempts less than %d but got %d", retryConfig.MaxAttempts, g)
+	}
+	if e, g := http.StatusOK, attempts[len(attempts)-1].StatusCode; e != g {
+		t.Errorf("want last StatusCode %d but got %d", e, g)
+	}
+}
+
+func TestDispatcher_ScheduleTime(t *testing.T) {
+	ctx := context.Background()
+
+	var dispatchedAt time.Time
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dispatchedAt = time.Now()
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s, d := newDispatcherService(t, handler)
+	defer d.Stop()
+
+	scheduleTime := time.Now().Add(200 * time.Millisecond)
+	_, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{
+		RelativeURI:  "http://localhost/tq/hoge",
+		ScheduleTime: scheduleTime,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if dispatchedAt.Before(scheduleTime) {
+		t.Errorf("dispatched before ScheduleTime. ScheduleTime=%v, dispatchedAt=%v", scheduleTime, dispatchedAt)
+	}
+}
+
+func TestDispatcher_DispatchDeadline(t *testing.T) {
+	ctx := context.Background()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-r.Context().Done():
+		case <-time.After(time.Second):
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s, d := newDispatcherService(t, handler, dispatcher.WithDefaultRetryConfig(&taskspb.RetryConfig{
+		MaxAttempts: 2,
+		MinBackoff:  durationpb.New(10 * time.Millisecond),
+	}))
+	defer d.Stop()
+
+	_, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{
+		RelativeURI: "http://localhost/tq/hoge",
+		Deadline:    50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	attempts := d.Attempts()
+	if e, g := 2, len(attempts); e != g {
+		t.Fatalf("want attempts %d but got %d", e, g)
+	}
+	for i, attempt := range attempts {
+		if !attempt.DeadlineExceeded {
+			t.Errorf("%d : want DeadlineExceeded", i)
+		}
+		// Handler から Response を受け取っていないので、ExecutionCount は増えない
+		if e, g := 0, attempt.ExecutionCount; e != g {
+			t.Errorf("%d : want ExecutionCount %d but got %d", i, e, g)
+		}
+	}
+}
+
+func TestDispatcher_StopWaitsHandlers(t *testing.T) {
+	ctx := context.Background()
+
+	var finished int32
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+		// DispatchDeadline を超えた後も少し動き続ける Handler
+		time.Sleep(100 * time.Millisecond)
+		atomic.StoreInt32(&finished, 1)
+	})
+
+	s, d := newDispatcherService(t, handler, dispatcher.WithDefaultRetryConfig(&taskspb.RetryConfig{
+		MaxAttempts: 1,
+	}))
+
+	_, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{
+		RelativeURI: "http://localhost/tq/hoge",
+		Deadline:    50 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+	d.Stop()
+
+	if atomic.LoadInt32(&finished) != 1 {
+		t.Error("want Stop waits handler but returned before handler finished")
+	}
+}
+
+func TestDispatcher_AlreadyExists(t *testing.T) {
+	ctx := context.Background()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s, d := newDispatcherService(t, handler)
+	defer d.Stop()
+
+	task := &tasksbox.GetTask{
+		Name:        "dup",
+		RelativeURI: "http://localhost/tq/hoge",
+	}
+	if _, err := s.CreateGetTask(ctx, testQueue, task); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.CreateGetTask(ctx, testQueue, task)
+	if !tasksbox.ErrAlreadyExists.Is(err) {
+		t.Errorf("want ErrAlreadyExists but got %v", err)
+	}
+	if _, err := s.CreateGetTask(ctx, testQueue, task, tasksbox.WithIgnoreAlreadyExists()); err != nil {
+		t.Errorf("want ignore AlreadyExists but got %v", err)
+	}
+	d.Wait()
+}
+
+func TestDispatcher_ContentTaskName(t *testing.T) {
+	ctx := context.Background()
+
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	s, d := newDispatcherService(t, handler)
+	defer d.Stop()
+
+	ops := []tasksbox.CreateTaskOptions{tasksbox.WithContentTaskName(time.Hour), tasksbox.WithIgnoreAlreadyExists()}
+	var names []string
+	for _, content := range []string{"Hello", "Hello", "World"} {
+		name, err := s.CreateJsonPostTask(ctx, testQueue, &tasksbox.JsonPostTask{
+			RelativeURI: "http://localhost/tq/hoge",
+			Body:        &Body{Content: content},
+		}, ops...)
+		if err != nil {
+			t.Fatal(err)
+		}
+		names = append(names, name)
+	}
+	d.Wait()
+
+	if names[0] != names[1] {
+		t.Errorf("want same task name but got %s, %s", names[0], names[1])
+	}
+	if names[0] == names[2] {
+		t.Errorf("want other task name but got %s", names[2])
+	}
+	if e, g := 2, len(d.Attempts()); e != g {
+		t.Errorf("want attempts %d but got %d", e, g)
+	}
+}
+
+func TestDispatcher_AppEngine(t *testing.T) {
+	ctx := context.Background()
+
+	var header *appengine.Header
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		th, err := appengine.GetHeader(r)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		header = th
+		w.WriteHeader(http.StatusOK)
+	})
+
+	d, err := dispatcher.NewDispatcher(ctx, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := appengine.NewService(ctx, taskClient)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.CreateJsonPostTask(ctx, &appengine.Queue{
+		ProjectID: testQueue.ProjectID,
+		Region:    testQueue.Region,
+		Name:      testQueue.Name,
+	}, &appengine.JsonPostTask{
+		Name:        "appenginetask",
+		RelativeURI: "/tq/hoge",
+		Body:        &Body{Content: "Hello"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	if header == nil {
+		t.Fatal("handler is not called")
+	}
+	if e, g := "appenginetask", header.TaskName; e != g {
+		t.Errorf("want TaskName %s but got %s", e, g)
+	}
+	if e, g := testQueue.Name, header.QueueName; e != g {
+		t.Errorf("want QueueName %s but got %s", e, g)
+	}
+}
+
+func TestDispatcher_Enqueuer(t *testing.T) {
+	ctx := context.Background()
+
+	var mutex sync.Mutex
+	var httpTaskNames, appEngineTaskNames []string
+	handler := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		if th, err := appengine.GetHeader(r); err == nil {
+			appEngineTaskNames = append(appEngineTaskNames, th.TaskName)
+		} else if th, err := tasksbox.GetHeader(r); err == nil {
+			httpTaskNames = append(httpTaskNames, th.TaskName)
+		}
+		w.WriteHeader(http.StatusOK)
+	})
+
+	d, err := dispatcher.NewDispatcher(ctx, handler)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 同じ Job を Target だけ変えて作成する
+	targets := map[string]tasksbox.Target{
+		"http-target":      &tasksbox.HTTPTarget{BaseURL: "http://localhost"},
+		"appengine-target": &tasksbox.AppEngineTarget{},
+	}
+	for name, target := range targets {
+		enqueuer, err := tasksbox.NewEnqueuer(ctx, taskClient, target)
+		if err != nil {
+			t.Fatal(err)
+		}
+		job, err := tasksbox.NewJsonJob("/tq/hoge", &Body{Content: "Hello"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		job.Name = name
+		if _, err := enqueuer.EnqueueMulti(ctx, testQueue, []*tasksbox.Job{job}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	d.Wait()
+
+	if diff := cmp.Diff([]string{"http-target"}, httpTaskNames); diff != "" {
+		t.Errorf("HTTP Target diff (-want +got):\n%s", diff)
+	}
+	if diff := cmp.Diff([]string{"appengine-target"}, appEngineTaskNames); diff != "" {
+		t.Errorf("App Engine Target diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDispatcher_BodyTaskTypes(t *testing.T) {
+	ctx := context.Background()
+
+	type request struct {
+		Method      string
+		ContentType string
+		Body        string
+	}
+	var mutex sync.Mutex
+	got := map[string]*request{}
+	s, d := newDispatcherService(t, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		th, err := tasksbox.GetHeader(r)
+		if err != nil {
+			t.Error(err)
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		req := &request{Method: r.Method, ContentType: r.Header.Get("Content-Type")}
+		switch req.ContentType {
+		case tasksbox.ContentTypeProtobuf:
+			var body wrapperspb.StringValue
+			if err := handler.DecodeProto(r, &body); err != nil {
+				t.Error(err)
+			}
+			req.Body = body.GetValue()
+		case tasksbox.ContentTypeForm:
+			values, err := handler.DecodeForm(r)
+			if err != nil {
+				t.Error(err)
+			}
+			req.Body = values.Get("content")
+		}
+		got[th.TaskName] = req
+		w.WriteHeader(http.StatusOK)
+	}))
+	defer d.Stop()
+
+	if _, err := s.CreateProtoTask(ctx, testQueue, &tasksbox.ProtoTask{
+		Name:        "proto",
+		RelativeURI: "http://localhost/tq/proto",
+		Body:        wrapperspb.String("Hello Proto"),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateFormTaskMulti(ctx, testQueue, []*tasksbox.FormTask{
+		{
+			Name:        "form",
+			RelativeURI: "http://localhost/tq/form",
+			Method:      http.MethodPut,
+			Headers:     map[string]string{"content-type": "text/plain"},
+			Body:        url.Values{"content": {"Hello Form"}},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.CreateHttpTask(ctx, testQueue, &tasksbox.Task{
+		Name:        "delete",
+		RelativeURI: "http://localhost/tq/hoge/1",
+		Method:      http.MethodDelete,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	d.Wait()
+
+	want := map[string]*request{
+		"proto":  {Method: http.MethodPost, ContentType: tasksbox.ContentTypeProtobuf, Body: "Hello Proto"},
+		"form":   {Method: http.MethodPut, ContentType: tasksbox.ContentTypeForm, Body: "Hello Form"},
+		"delete": {Method: http.MethodDelete},
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func newDispatcherService(t *testing.T, handler http.Handler, ops ...dispatcher.Options) (*tasksbox.Service, *dispatcher.Dispatcher) {
+	ctx := context.Background()
+
+	d, err := dispatcher.NewDispatcher(ctx, handler, ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tasksbox.NewService(ctx, taskClient, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, d
+}
@@ -0,0 +1,29 @@
+package dispatcher
+
+import (
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+type options struct {
+	defaultRetryConfig *taskspb.RetryConfig
+	retryConfigs       map[string]*taskspb.RetryConfig
+}
+
+// Options is NewDispatcher に利用する options
+type Options func(*options)
+
+// WithDefaultRetryConfig is Queue 個別の RetryConfig が指定されていない時に利用する RetryConfig を指定する
+// 指定しない場合は Cloud Tasks の default と同じ値を利用する
+func WithDefaultRetryConfig(cfg *taskspb.RetryConfig) Options {
+	return func(ops *options) {
+		ops.defaultRetryConfig = cfg
+	}
+}
+
+// WithRetryConfig is Queue ごとの RetryConfig を指定する
+// queue には projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID} 形式の値を指定する
+func WithRetryConfig(queue string, cfg *taskspb.RetryConfig) Options {
+	return func(ops *options) {
+		ops.retryConfigs[queue] = cfg
+	}
+}
@@ -0,0 +1,80 @@
+package dispatcher
+
+import (
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+// Cloud Tasks の RetryConfig の default 値
+// https://cloud.google.com/tasks/docs/reference/rpc/google.cloud.tasks.v2#retryconfig
+const (
+	defaultMaxAttempts  = 100
+	defaultMinBackoff   = 100 * time.Millisecond
+	defaultMaxBackoff   = 3600 * time.Second
+	defaultMaxDoublings = 16
+)
+
+type retryConfig struct {
+	maxAttempts      int
+	maxRetryDuration time.Duration
+	minBackoff       time.Duration
+	maxBackoff       time.Duration
+	maxDoublings     int
+}
+
+func newRetryConfig(cfg *taskspb.RetryConfig) *retryConfig {
+	ret := &retryConfig{
+		maxAttempts:  defaultMaxAttempts,
+		minBackoff:   defaultMinBackoff,
+		maxBackoff:   defaultMaxBackoff,
+		maxDoublings: defaultMaxDoublings,
+	}
+	if cfg == nil {
+		return ret
+	}
+	if cfg.GetMaxAttempts() != 0 {
+		ret.maxAttempts = int(cfg.GetMaxAttempts())
+	}
+	if cfg.GetMaxRetryDuration() != nil {
+		ret.maxRetryDuration = cfg.GetMaxRetryDuration().AsDuration()
+	}
+	if cfg.GetMinBackoff() != nil {
+		ret.minBackoff = cfg.GetMinBackoff().AsDuration()
+	}
+	if cfg.GetMaxBackoff() != nil {
+		ret.maxBackoff = cfg.GetMaxBackoff().AsDuration()
+	}
+	if cfg.GetMaxDoublings() != 0 {
+		ret.maxDoublings = int(cfg.GetMaxDoublings())
+	}
+	return ret
+}
+
+// canRetry is attempts 回実行して elapsed 経過した Task をさらに Retry するかを返す
+// MaxAttempts と MaxRetryDuration の両方が指定されている場合は、両方の上限に達するまで Retry する
+func (c *retryConfig) canRetry(attempts int, elapsed time.Duration) bool {
+	attemptsExceeded := c.maxAttempts >= 0 && attempts >= c.maxAttempts
+	if c.maxRetryDuration == 0 {
+		return !attemptsExceeded
+	}
+	durationExceeded := elapsed >= c.maxRetryDuration
+	return !(attemptsExceeded && durationExceeded)
+}
+
+// backoff is retryCount 回目の失敗の後、次に実行するまでの間隔を返す
+//
+// MinBackoff から MaxDoublings 回倍になり、その後は線形に増え、MaxBackoff で頭打ちになる
+// MinBackoff=10s, MaxBackoff=300s, MaxDoublings=3 の場合は 10s, 20s, 40s, 80s, 160s, 240s, 300s, 300s ... になる
+func (c *retryConfig) backoff(retryCount int) time.Duration {
+	var d time.Duration
+	if retryCount <= c.maxDoublings {
+		d = c.minBackoff << retryCount
+	} else {
+		d = (c.minBackoff << c.maxDoublings) * time.Duration(retryCount-c.maxDoublings+1)
+	}
+	if d <= 0 || d > c.maxBackoff {
+		return c.maxBackoff
+	}
+	return d
+}
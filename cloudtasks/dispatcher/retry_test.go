@@ -0,0 +1,49 @@
+package dispatcher
+
+import (
+	"testing"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestRetryConfig_Backoff(t *testing.T) {
+	cfg := newRetryConfig(&taskspb.RetryConfig{
+		MinBackoff:   durationpb.New(10 * time.Second),
+		MaxBackoff:   durationpb.New(300 * time.Second),
+		MaxDoublings: 3,
+	})
+
+	want := []time.Duration{10, 20, 40, 80, 160, 240, 300, 300}
+	for i, w := range want {
+		if e, g := w*time.Second, cfg.backoff(i); e != g {
+			t.Errorf("retryCount %d : want %v but got %v", i, e, g)
+		}
+	}
+}
+
+func TestRetryConfig_CanRetry(t *testing.T) {
+	cases := []struct {
+		name     string
+		cfg      *taskspb.RetryConfig
+		attempts int
+		elapsed  time.Duration
+		want     bool
+	}{
+		{"default", nil, 1, 0, true},
+		{"default max attempts", nil, 100, 0, false},
+		{"max attempts", &taskspb.RetryConfig{MaxAttempts: 3}, 3, 0, false},
+		{"unlimited", &taskspb.RetryConfig{MaxAttempts: -1}, 1000, 0, true},
+		{"max retry duration not reached", &taskspb.RetryConfig{MaxAttempts: 3, MaxRetryDuration: durationpb.New(time.Minute)}, 3, time.Second, true},
+		{"both reached", &taskspb.RetryConfig{MaxAttempts: 3, MaxRetryDuration: durationpb.New(time.Minute)}, 3, time.Hour, false},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if e, g := tt.want, newRetryConfig(tt.cfg).canRetry(tt.attempts, tt.elapsed); e != g {
+				t.Errorf("want %v but got %v", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,382 @@
+package cloudtasks
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+// Job is 届け先に依存しない Task の内容
+// Target が Job を HTTP Target や App Engine Target の Task に変換する
+type Job struct {
+	// Name is Task Name
+	// optional
+	// Task の重複を抑制するために指定するTaskのName
+	// 中で projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式にしているので指定するのは {TASK_ID} の部分だけ
+	Name string
+
+	// Path is Task が到達する Handler の Path
+	// "/" で始まる値を指定する
+	Path string
+
+	// Method is HTTP Method
+	// optional 省略した場合は POST になる
+	Method string
+
+	// Headers is Task Request の Header
+	// optional
+	Headers map[string]string
+
+	// Body is Task Body
+	// optional
+	Body []byte
+
+	// ScheduleTime is estimated time of arrival
+	// optional 省略した場合は即時実行
+	ScheduleTime time.Time
+
+	// Deadline is Handler の Deadline
+	// optional 省略した場合は Target の default に従う
+	Deadline time.Duration
+
+	// Target is この Job だけ Enqueuer の Target の代わりに使う Target
+	// optional
+	Target Target
+}
+
+// NewJsonJob is body を JSON にして Body に入れた POST の Job を返す
+func NewJsonJob(path string, body interface{}) (*Job, error) {
+	b, err := json.Marshal(body)
+	if err != nil {
+		return nil, fmt.Errorf("failed json.Marshal(). body=%+v : %w", body, err)
+	}
+	return &Job{
+		Path:    path,
+		Method:  http.MethodPost,
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    b,
+	}, nil
+}
+
+// Target is Job をどこに届けるかを決めて、 taskspb.Task に変換する
+type Target interface {
+	// Task is queue に作成する taskspb.Task に job を変換する
+	// Name, ScheduleTime, DispatchDeadline は Enqueuer が設定するので、 MessageType だけを設定すればよい
+	Task(queue *Queue, job *Job) (*taskspb.Task, error)
+}
+
+var _ Target = &HTTPTarget{}
+
+// HTTPTarget is Cloud Run などの HTTP Endpoint に Task を届ける Target
+type HTTPTarget struct {
+	// BaseURL is Job.Path の前に付ける URL
+	// e.g. https://example-xxxxxxxxxx-an.a.run.app
+	// Job.Path が http:// か https:// で始まる場合は付けない
+	BaseURL string
+
+	// ServiceAccountEmail is Authorization Header の Token を発行する Service Account
+	ServiceAccountEmail string
+
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// Audience is OIDC の Audience
+	// optional 省略した場合は Task の URL になる
+	Audience string
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+}
+
+// Task is Job を HttpRequest の Task に変換する
+func (t *HTTPTarget) Task(queue *Queue, job *Job) (*taskspb.Task, error) {
+	method, err := httpMethodToProto(job.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	url := job.Path
+	if !strings.HasPrefix(url, "http://") && !strings.HasPrefix(url, "https://") {
+		url = strings.TrimSuffix(t.BaseURL, "/") + job.Path
+	}
+	req := &taskspb.HttpRequest{
+		Url:        url,
+		HttpMethod: method,
+		Headers:    job.Headers,
+		Body:       job.Body,
+	}
+	if err := setAuthorizationHeader(req, t.ServiceAccountEmail, t.AuthorizationType, t.Audience, t.OAuthScope); err != nil {
+		return nil, err
+	}
+	return &taskspb.Task{
+		MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: req,
+		},
+	}, nil
+}
+
+var _ Target = &AppEngineTarget{}
+
+// AppEngineTarget is App Engine に Task を届ける Target
+type AppEngineTarget struct {
+	// Service is Task を到達させる App Engine の Service
+	// optional 省略した場合は Queue の設定に従う
+	Service string
+
+	// Version is Task を到達させる App Engine の Version
+	// optional 省略した場合は Queue の設定に従う
+	Version string
+}
+
+// Task is Job を AppEngineHttpRequest の Task に変換する
+func (t *AppEngineTarget) Task(queue *Queue, job *Job) (*taskspb.Task, error) {
+	method, err := httpMethodToProto(job.Method)
+	if err != nil {
+		return nil, err
+	}
+
+	req := &taskspb.AppEngineHttpRequest{
+		HttpMethod:  method,
+		RelativeUri: job.Path,
+		Headers:     job.Headers,
+		Body:        job.Body,
+	}
+	if len(t.Service) > 0 || len(t.Version) > 0 {
+		req.AppEngineRouting = &taskspb.AppEngineRouting{
+			Service: t.Service,
+			Version: t.Version,
+		}
+	}
+	return &taskspb.Task{
+		MessageType: &taskspb.Task_AppEngineHttpRequest{
+			AppEngineHttpRequest: req,
+		},
+	}, nil
+}
+
+// Enqueuer is Job を Queue に Task として作成する
+// Target を差し替えると、 Job を作る側を変更せずに届け先を App Engine から Cloud Run などに変更できる
+type Enqueuer interface {
+	// Enqueue is job を queue に作成して、 Task Name を返す
+	Enqueue(ctx context.Context, queue *Queue, job *Job, ops ...CreateTaskOptions) (string, error)
+
+	// EnqueueMulti is jobs を queue に並行に作成して、 jobs と同じ順番で Task Name を返す
+	// 失敗した Task は index を KV に入れて MultiError で返す
+	EnqueueMulti(ctx context.Context, queue *Queue, jobs []*Job, ops ...CreateTaskOptions) ([]string, error)
+}
+
+var _ Enqueuer = &TaskEnqueuer{}
+
+// TaskEnqueuer is Cloud Tasks に Task を作成する Enqueuer
+type TaskEnqueuer struct {
+	taskClient *cloudtasks.Client
+	target     Target
+}
+
+// NewEnqueuer is TaskEnqueuer を返す
+// target は Job.Target を指定していない Job に利用する
+func NewEnqueuer(ctx context.Context, taskClient *cloudtasks.Client, target Target) (*TaskEnqueuer, error) {
+	if target == nil {
+		return nil, NewErrInvalidArgument("target is required", map[string]interface{}{}, nil)
+	}
+	return &TaskEnqueuer{
+		taskClient: taskClient,
+		target:     target,
+	}, nil
+}
+
+// Enqueue is job を queue に作成して、 Task Name を返す
+func (e *TaskEnqueuer) Enqueue(ctx context.Context, queue *Queue, job *Job, ops ...CreateTaskOptions) (string, error) {
+	if queue == nil {
+		return "", NewErrInvalidArgument("queue is required", map[string]interface{}{}, nil)
+	}
+	if job == nil {
+		return "", NewErrInvalidArgument("job is required", map[string]interface{}{}, nil)
+	}
+
+	target := e.target
+	if job.Target != nil {
+		target = job.Target
+	}
+	task, err := target.Task(queue, job)
+	if err != nil {
+		return "", err
+	}
+	if len(job.Name) > 0 {
+		task.Name = queue.taskName(job.Name)
+	}
+	if !job.ScheduleTime.IsZero() {
+		task.ScheduleTime = timestamppb.New(job.ScheduleTime)
+	}
+	if job.Deadline != 0 {
+		task.DispatchDeadline = durationpb.New(job.Deadline)
+	}
+
+	got, err := createTask(ctx, e.taskClient, queue, task, ops...)
+	if err != nil {
+		return "", err
+	}
+	return got.GetName(), nil
+}
+
+// EnqueueMulti is jobs を queue に並行に作成して、 jobs と同じ順番で Task Name を返す
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
+func (e *TaskEnqueuer) EnqueueMulti(ctx context.Context, queue *Queue, jobs []*Job, ops ...CreateTaskOptions) ([]string, error) {
+	return createMulti(len(jobs), ops, func(i int) (string, error) {
+		return e.Enqueue(ctx, queue, jobs[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed Enqueue", map[string]interface{}{"index": i, "taskName": jobs[i].Name, "URI": jobs[i].Path}, err)
+	})
+}
+
+// createTask is options に従って task を queue に作成する
+// task.Name には projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式の値を入れておく
+// ctx の Span を子にした Span の Context を Task の Header に入れて、 Handler の Span と繋げる
+func createTask(ctx context.Context, taskClient *cloudtasks.Client, queue *Queue, task *taskspb.Task, ops ...CreateTaskOptions) (_ *taskspb.Task, err error) {
+	ctx = trace.StartSpan(ctx, "cloudtasks.CreateTask")
+	defer func() {
+		trace.EndSpan(ctx, err)
+	}()
+
+	opt := createTaskOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+	injectTraceContext(ctx, task)
+	createdAt := opt.createdAt
+	if createdAt.IsZero() {
+		createdAt = time.Now()
+	}
+	injectCreatedAt(task, createdAt)
+
+	if len(task.GetName()) == 0 && opt.contentTaskName {
+		url, body := taskURLAndBody(task)
+		task.Name = queue.taskName(ContentTaskName(url, body, opt.contentTaskWindow, time.Now()))
+	}
+
+	taskReq := &taskspb.CreateTaskRequest{
+		Parent: queue.Parent(),
+		Task:   task,
+	}
+	var payloadRef string
+	if opt.payloadStore != nil {
+		var err error
+		taskReq, payloadRef, err = offloadPayload(ctx, opt.payloadStore, opt.payloadThreshold, taskReq)
+		if err != nil {
+			return nil, err
+		}
+	}
+	var got *taskspb.Task
+	err = opt.invoke(ctx, func(ctx context.Context) error {
+		var err error
+		got, err = taskClient.CreateTask(ctx, taskReq)
+		return err
+	})
+	if err != nil {
+		if len(payloadRef) > 0 {
+			// Task が作成されなかったので、保存した Body は使われない
+			_ = opt.payloadStore.Delete(ctx, payloadRef)
+		}
+		sts, ok := status.FromError(err)
+		if ok {
+			if sts.Code() == codes.AlreadyExists {
+				if opt.ignoreAlreadyExists {
+					return taskReq.GetTask(), nil
+				}
+				name := taskReq.GetTask().GetName()
+				// KV の taskName は Job.Name と同じ {TASK_ID} にする
+				return nil, NewErrAlreadyExists(fmt.Sprintf("%s is already exists.", name), map[string]interface{}{"taskName": name[strings.LastIndex(name, "/")+1:]}, err)
+			}
+		}
+		return nil, err
+	}
+	return got, nil
+}
+
+// createMulti is create を n 回並行に実行して、結果を index の順番で返す
+// WithMaxInFlight が指定されている場合は、その数の worker で順番に実行して goroutine を task の数だけ作らないようにする
+// 失敗したものは AlreadyExists であれば index を KV に入れて、それ以外は wrap で包んで MultiError にまとめる
+func createMulti(n int, ops []CreateTaskOptions, create func(i int) (string, error), wrap func(i int, err error) *Error) ([]string, error) {
+	opt := createTaskOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+	workers := n
+	if opt.inFlight != nil && cap(opt.inFlight) < workers {
+		workers = cap(opt.inFlight)
+	}
+
+	results := make([]string, n)
+	merr := MultiError{}
+	indexes := make(chan int)
+	wg := &sync.WaitGroup{}
+	for w := 0; w < workers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range indexes {
+				tn, err := create(i)
+				if err != nil {
+					appErr := &Error{}
+					if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
+						appErr.KV["index"] = i
+						merr.Append(appErr)
+						continue
+					}
+					merr.Append(wrap(i, err))
+					continue
+				}
+				results[i] = tn
+			}
+		}()
+	}
+	for i := 0; i < n; i++ {
+		indexes <- i
+	}
+	close(indexes)
+	wg.Wait()
+	return results, merr.ErrorOrNil()
+}
+
+// taskURLAndBody is task の届け先の URL と Body を返す
+// App Engine Task の場合は RelativeUri を返す
+func taskURLAndBody(task *taskspb.Task) (string, []byte) {
+	if req := task.GetHttpRequest(); req != nil {
+		return req.GetUrl(), req.GetBody()
+	}
+	req := task.GetAppEngineHttpRequest()
+	return req.GetRelativeUri(), req.GetBody()
+}
+
+// httpMethodToProto is HTTP Method から HttpMethodProto に変換する
+// 空の場合は POST にする
+func httpMethodToProto(method string) (taskspb.HttpMethod, error) {
+	switch method {
+	case "", http.MethodPost:
+		return taskspb.HttpMethod_POST, nil
+	case http.MethodGet:
+		return taskspb.HttpMethod_GET, nil
+	case http.MethodPut:
+		return taskspb.HttpMethod_PUT, nil
+	case http.MethodDelete:
+		return taskspb.HttpMethod_DELETE, nil
+	default:
+		return taskspb.HttpMethod_HTTP_METHOD_UNSPECIFIED, NewErrInvalidArgument(fmt.Sprintf("unsupported HttpMethod : %v", method), map[string]interface{}{"method": method}, nil)
+	}
+}
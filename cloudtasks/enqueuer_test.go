@@ -0,0 +1,131 @@
+package cloudtasks
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+)
+
+func TestHTTPTarget_Task(t *testing.T) {
+	queue := &Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+	target := &HTTPTarget{
+		BaseURL:             "https://example.com/",
+		ServiceAccountEmail: "hoge@unittest.iam.gserviceaccount.com",
+		Audience:            "https://example.com",
+	}
+
+	cases := []struct {
+		name    string
+		path    string
+		wantURL string
+	}{
+		{"path", "/tq/hoge", "https://example.com/tq/hoge"},
+		{"absolute url", "https://other.example.com/tq/hoge", "https://other.example.com/tq/hoge"},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := target.Task(queue, &Job{Path: tt.path, Method: http.MethodPut, Body: []byte("hello")})
+			if err != nil {
+				t.Fatal(err)
+			}
+			req := got.GetHttpRequest()
+			if e, g := tt.wantURL, req.GetUrl(); e != g {
+				t.Errorf("want Url %s but got %s", e, g)
+			}
+			if e, g := taskspb.HttpMethod_PUT, req.GetHttpMethod(); e != g {
+				t.Errorf("want HttpMethod %v but got %v", e, g)
+			}
+			if e, g := target.Audience, req.GetOidcToken().GetAudience(); e != g {
+				t.Errorf("want Audience %s but got %s", e, g)
+			}
+			if e, g := "hello", string(req.GetBody()); e != g {
+				t.Errorf("want Body %s but got %s", e, g)
+			}
+		})
+	}
+}
+
+func TestAppEngineTarget_Task(t *testing.T) {
+	queue := &Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+
+	got, err := (&AppEngineTarget{Service: "worker"}).Task(queue, &Job{Path: "/tq/hoge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	req := got.GetAppEngineHttpRequest()
+	if e, g := "/tq/hoge", req.GetRelativeUri(); e != g {
+		t.Errorf("want RelativeUri %s but got %s", e, g)
+	}
+	if e, g := taskspb.HttpMethod_POST, req.GetHttpMethod(); e != g {
+		t.Errorf("want HttpMethod %v but got %v", e, g)
+	}
+	if e, g := "worker", req.GetAppEngineRouting().GetService(); e != g {
+		t.Errorf("want Service %s but got %s", e, g)
+	}
+
+	got, err = (&AppEngineTarget{}).Task(queue, &Job{Path: "/tq/hoge"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got.GetAppEngineHttpRequest().GetAppEngineRouting() != nil {
+		t.Errorf("want Queue routing but got %v", got.GetAppEngineHttpRequest().GetAppEngineRouting())
+	}
+
+	_, err = (&AppEngineTarget{}).Task(queue, &Job{Path: "/tq/hoge", Method: http.MethodPatch})
+	if !ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
+
+func TestCreateMulti_MaxInFlight(t *testing.T) {
+	const max = 3
+	const n = 50
+
+	var running, peak int32
+	got, err := createMulti(n, []CreateTaskOptions{WithMaxInFlight(max)}, func(i int) (string, error) {
+		r := atomic.AddInt32(&running, 1)
+		defer atomic.AddInt32(&running, -1)
+		for {
+			p := atomic.LoadInt32(&peak)
+			if r <= p || atomic.CompareAndSwapInt32(&peak, p, r) {
+				break
+			}
+		}
+		time.Sleep(time.Millisecond)
+		if i == 7 {
+			return "", errors.New("failed")
+		}
+		return fmt.Sprintf("task%d", i), nil
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed", map[string]interface{}{"index": i}, err)
+	})
+
+	// create を実行している goroutine は worker の数までになる
+	if peak > max {
+		t.Errorf("want running <= %d but got %d", max, peak)
+	}
+	for i, v := range got {
+		want := fmt.Sprintf("task%d", i)
+		if i == 7 {
+			want = ""
+		}
+		if e, g := want, v; e != g {
+			t.Errorf("index %d want %s but got %s", i, e, g)
+		}
+	}
+	merr := &MultiError{}
+	if !errors.As(err, &merr) {
+		t.Fatalf("want MultiError but got %v", err)
+	}
+	if e, g := 1, len(merr.Errors); e != g {
+		t.Fatalf("want errors %d but got %d", e, g)
+	}
+	if e, g := 7, merr.Errors[0].KV["index"]; e != g {
+		t.Errorf("want index %d but got %v", e, g)
+	}
+}
@@ -38,6 +38,13 @@ var ErrAlreadyExists = &Error{
 	KV:      map[string]interface{}{},
 }
 
+// ErrNotFound is 対象が存在しない場合の Error
+var ErrNotFound = &Error{
+	Code:    "NotFound",
+	Message: "NotFound",
+	KV:      map[string]interface{}{},
+}
+
 // Error is Error情報を保持する struct
 type Error struct {
 	Code    string
@@ -139,6 +146,16 @@ func NewErrAlreadyExists(message string, kv map[string]interface{}, err error) *
 	}
 }
 
+// NewErrNotFound is return ErrNotFound
+func NewErrNotFound(message string, kv map[string]interface{}, err error) *Error {
+	return &Error{
+		Code:    ErrNotFound.Code,
+		Message: message,
+		KV:      kv,
+		err:     err,
+	}
+}
+
 // NewErrCreateMultiTask is return ErrCreateMultiTask
 func NewErrCreateMultiTask(message string, kv map[string]interface{}, err error) *Error {
 	return &Error{
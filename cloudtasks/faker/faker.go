@@ -1,74 +1,304 @@
 package faker
 
 import (
+	"context"
 	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"sync"
 	"testing"
 
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/uuid"
 	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
-	tasksfaker "github.com/sinmetalcraft/gcpfaker/cloudtasks"
 	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
 	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/emptypb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+var (
+	// ErrAlreadyExists is AddErrorWithIndex, AddErrorWithTaskName に指定すると CreateTask が AlreadyExists で失敗する
+	ErrAlreadyExists = status.Error(codes.AlreadyExists, "task already exists")
+
+	// ErrResourceExhausted is AddErrorWithIndex, AddErrorWithTaskName に指定すると CreateTask が Quota 超過の ResourceExhausted で失敗する
+	ErrResourceExhausted = status.Error(codes.ResourceExhausted, "quota exceeded")
+
+	// ErrUnavailable is AddErrorWithIndex, AddErrorWithTaskName に指定すると CreateTask が Unavailable で失敗する
+	ErrUnavailable = status.Error(codes.Unavailable, "service unavailable")
 )
 
 // Faker is UnitTestのために Fake 実装
+//
+// CreateTask された Task を Memory 上に保持し、 GetTask, ListTasks, DeleteTask に応答する
+// 同じ Name の Task を CreateTask すると、本物と同じように AlreadyExists を返す
 type Faker struct {
-	org *tasksfaker.Faker
+	serv *grpc.Server
+	conn *grpc.ClientConn
+
+	mutex sync.Mutex
+
+	// CreateTask が呼ばれた時の Request が順番に入っている
+	createTaskReqs []*taskspb.CreateTaskRequest
+
+	// 作成に成功した Task. key は projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID}
+	tasks map[string]*taskspb.Task
+
+	// 作成に成功した Task の Name を CreateTask した順に入れている
+	taskNames []string
+
+	// CreateTask の呼び出し回数 (1 始まり) を指定して返す error
+	errorsForIndex map[int]error
+
+	// {TASK_ID} を指定して返す error. 呼び出されるたびに先頭から 1 つずつ使う
+	errorsForTaskName map[string][]error
 }
 
 // NewFaker is Faker を返す
 func NewFaker(t *testing.T) *Faker {
-	return &Faker{tasksfaker.NewFaker(t)}
+	t.Helper()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	conn, err := grpc.NewClient(lis.Addr().String(), grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		lis.Close()
+		t.Fatal(err)
+	}
+
+	f := &Faker{
+		serv:              grpc.NewServer(),
+		conn:              conn,
+		tasks:             map[string]*taskspb.Task{},
+		errorsForIndex:    map[int]error{},
+		errorsForTaskName: map[string][]error{},
+	}
+	taskspb.RegisterCloudTasksServer(f.serv, &server{f: f})
+	go f.serv.Serve(lis)
+
+	return f
 }
 
 // Stop is Stop
 func (f *Faker) Stop() {
-	f.org.Stop()
+	f.conn.Close()
+	f.serv.Stop()
 }
 
 // ClientOption is cloudtasks.Client に 設定する ClientOption
 func (f *Faker) ClientOption() option.ClientOption {
-	return f.org.ClientOpt
+	return option.WithGRPCConn(f.conn)
+}
+
+// AddErrorWithIndex is CreateTask が呼ばれた回数が callCount (1 始まり) になった時に err を返す
+// err には ErrAlreadyExists, ErrResourceExhausted などを指定する. gRPC の status ではない error は Unknown になる
+func (f *Faker) AddErrorWithIndex(callCount int, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.errorsForIndex[callCount] = err
+}
+
+// AddErrorWithTaskName is taskName ({TASK_ID}) の Task が CreateTask された時に err を返す
+// 複数回 Add した場合は、呼ばれるたびに Add した順に 1 つずつ返す
+func (f *Faker) AddErrorWithTaskName(taskName string, err error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.errorsForTaskName[taskName] = append(f.errorsForTaskName[taskName], err)
 }
 
 // GetCreateTaskCallCount is CreateTask が実行された回数を返す
-func (s *Faker) GetCreateTaskCallCount() int {
-	return s.org.GetCreateTaskCallCount()
+// error を返した呼び出しも含む
+func (f *Faker) GetCreateTaskCallCount() int {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	return len(f.createTaskReqs)
 }
 
-// GetTask is 作成された Task を取得する
-func (s *Faker) GetTask(i int) (*tasksbox.Task, error) {
-	req, err := s.org.GetCreateTaskRequest(i)
-	if err != nil {
-		return nil, err
+// GetTask is i 番目の CreateTask に渡された Task を取得する
+// error を返した呼び出しも含む
+func (f *Faker) GetTask(i int) (*tasksbox.Task, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if i < 0 || i > len(f.createTaskReqs)-1 {
+		return nil, fmt.Errorf("GetTask out of range. arg=%d,len=%d", i, len(f.createTaskReqs))
 	}
-	buf, err := proto.Marshal(req)
+	t := f.createTaskReqs[i].GetTask()
+	task, err := tasksbox.TaskProtoToTask(t)
 	if err != nil {
 		return nil, err
 	}
-	var tr taskspb.CreateTaskRequest
-	if err := proto.Unmarshal(buf, &tr); err != nil {
-		return nil, err
+	// CreateTaskRequest の Name, ScheduleTime は指定した値をそのまま返す
+	task.Name = t.GetName()
+	task.ScheduleTime = t.GetScheduleTime().AsTime()
+	return task, nil
+}
+
+// GetTasksByQueue is queue に作成された Task を CreateTask した順に返す
+// Name には {TASK_ID} の部分が入る
+func (f *Faker) GetTasksByQueue(queue *tasksbox.Queue) ([]*tasksbox.Task, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	ret := []*tasksbox.Task{}
+	for _, t := range f.queueTasks(queue.Parent()) {
+		task, err := tasksbox.TaskProtoToTask(t)
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, task)
 	}
-	t := tr.GetTask()
-	httpReq := t.GetHttpRequest()
-	if httpReq == nil {
-		return nil, fmt.Errorf("http request is required")
+	return ret, nil
+}
+
+// GetTaskByName is queue に作成された taskName ({TASK_ID}) の Task を返す
+// 存在しない場合は cloudtasks.ErrNotFound を返す
+func (f *Faker) GetTaskByName(queue *tasksbox.Queue, taskName string) (*tasksbox.Task, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	name := fmt.Sprintf("%s/tasks/%s", queue.Parent(), taskName)
+	t, ok := f.tasks[name]
+	if !ok {
+		return nil, tasksbox.NewErrNotFound(fmt.Sprintf("%s is not found.", name), map[string]interface{}{"queue": queue.Parent(), "taskName": taskName}, nil)
 	}
+	return tasksbox.TaskProtoToTask(t)
+}
 
-	method, err := tasksbox.HttpMethodProtoToHttpMethod(httpReq.GetHttpMethod())
-	if err != nil {
+// queueTasks is parent の Queue の Task を CreateTask した順に返す
+// mutex を取得してから呼ぶ
+func (f *Faker) queueTasks(parent string) []*taskspb.Task {
+	var tasks []*taskspb.Task
+	for _, name := range f.taskNames {
+		if strings.HasPrefix(name, parent+"/tasks/") {
+			tasks = append(tasks, f.tasks[name])
+		}
+	}
+	return tasks
+}
+
+func (f *Faker) createTask(req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	f.createTaskReqs = append(f.createTaskReqs, req)
+
+	if req.GetTask() == nil {
+		return nil, status.Error(codes.InvalidArgument, "task is required")
+	}
+	t := proto.Clone(req.GetTask()).(*taskspb.Task)
+	taskID := t.GetName()[strings.LastIndex(t.GetName(), "/")+1:]
+	if errs := f.errorsForTaskName[taskID]; len(t.GetName()) > 0 && len(errs) > 0 {
+		f.errorsForTaskName[taskID] = errs[1:]
+		return nil, errs[0]
+	}
+	if err, ok := f.errorsForIndex[len(f.createTaskReqs)]; ok {
 		return nil, err
 	}
 
-	return &tasksbox.Task{
-		Audience:     "", // TODO AuthorizationHeader
-		RelativeURI:  httpReq.Url,
-		Headers:      httpReq.Headers,
-		Method:       method,
-		ScheduleTime: t.GetScheduleTime().AsTime(),
-		Deadline:     t.GetDispatchDeadline().AsDuration(),
-		Body:         httpReq.GetBody(),
-		Name:         t.GetName(),
-	}, nil
+	if len(t.GetName()) < 1 {
+		t.Name = fmt.Sprintf("%s/tasks/%s", req.GetParent(), uuid.New().String())
+	}
+	if _, ok := f.tasks[t.GetName()]; ok {
+		return nil, status.Errorf(codes.AlreadyExists, "%s is already exists", t.GetName())
+	}
+	now := timestamppb.Now()
+	t.CreateTime = now
+	if t.GetScheduleTime() == nil {
+		t.ScheduleTime = now
+	}
+	f.tasks[t.GetName()] = t
+	f.taskNames = append(f.taskNames, t.GetName())
+	return t, nil
+}
+
+func (f *Faker) getTask(name string) (*taskspb.Task, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	t, ok := f.tasks[name]
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "%s is not found", name)
+	}
+	return t, nil
+}
+
+func (f *Faker) listTasks(req *taskspb.ListTasksRequest) (*taskspb.ListTasksResponse, error) {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	tasks := f.queueTasks(req.GetParent())
+	var offset int
+	if len(req.GetPageToken()) > 0 {
+		v, err := strconv.Atoi(req.GetPageToken())
+		if err != nil || v < 0 || v > len(tasks) {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid page token %s", req.GetPageToken())
+		}
+		offset = v
+	}
+	end := len(tasks)
+	if req.GetPageSize() > 0 && offset+int(req.GetPageSize()) < end {
+		end = offset + int(req.GetPageSize())
+	}
+
+	resp := &taskspb.ListTasksResponse{
+		Tasks: tasks[offset:end],
+	}
+	if end < len(tasks) {
+		resp.NextPageToken = strconv.Itoa(end)
+	}
+	return resp, nil
+}
+
+func (f *Faker) deleteTask(name string) error {
+	f.mutex.Lock()
+	defer f.mutex.Unlock()
+
+	if _, ok := f.tasks[name]; !ok {
+		return status.Errorf(codes.NotFound, "%s is not found", name)
+	}
+	delete(f.tasks, name)
+	for i, v := range f.taskNames {
+		if v == name {
+			f.taskNames = append(f.taskNames[:i], f.taskNames[i+1:]...)
+			break
+		}
+	}
+	return nil
+}
+
+// server is Faker を Cloud Tasks の gRPC Server として振る舞わせる
+type server struct {
+	taskspb.UnimplementedCloudTasksServer
+
+	f *Faker
+}
+
+func (s *server) CreateTask(ctx context.Context, req *taskspb.CreateTaskRequest) (*taskspb.Task, error) {
+	return s.f.createTask(req)
+}
+
+func (s *server) GetTask(ctx context.Context, req *taskspb.GetTaskRequest) (*taskspb.Task, error) {
+	return s.f.getTask(req.GetName())
+}
+
+func (s *server) ListTasks(ctx context.Context, req *taskspb.ListTasksRequest) (*taskspb.ListTasksResponse, error) {
+	return s.f.listTasks(req)
+}
+
+func (s *server) DeleteTask(ctx context.Context, req *taskspb.DeleteTaskRequest) (*emptypb.Empty, error) {
+	if err := s.f.deleteTask(req.GetName()); err != nil {
+		return nil, err
+	}
+	return &emptypb.Empty{}, nil
 }
@@ -0,0 +1,68 @@
+package faker
+
+import (
+	"context"
+	"fmt"
+	"sync"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+var _ tasksbox.PayloadStore = &PayloadStore{}
+
+// PayloadStore is UnitTest のための Memory 上に Body を保存する PayloadStore
+type PayloadStore struct {
+	mutex    sync.Mutex
+	seq      int
+	payloads map[string][]byte
+}
+
+// NewPayloadStore is PayloadStore を返す
+func NewPayloadStore() *PayloadStore {
+	return &PayloadStore{
+		payloads: map[string][]byte{},
+	}
+}
+
+// Put is body を保存して memory://{連番} 形式の参照を返す
+func (s *PayloadStore) Put(ctx context.Context, body []byte) (string, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.seq++
+	ref := fmt.Sprintf("memory://%d", s.seq)
+	s.payloads[ref] = append([]byte{}, body...)
+	return ref, nil
+}
+
+// Get is 参照から body を取り出す
+func (s *PayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	body, ok := s.payloads[ref]
+	if !ok {
+		return nil, tasksbox.NewErrNotFound(fmt.Sprintf("%s is not found.", ref), map[string]interface{}{"ref": ref}, nil)
+	}
+	return body, nil
+}
+
+// Delete is 参照の body を削除する
+func (s *PayloadStore) Delete(ctx context.Context, ref string) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if _, ok := s.payloads[ref]; !ok {
+		return tasksbox.NewErrNotFound(fmt.Sprintf("%s is not found.", ref), map[string]interface{}{"ref": ref}, nil)
+	}
+	delete(s.payloads, ref)
+	return nil
+}
+
+// Len is 保存されている body の数を返す
+func (s *PayloadStore) Len() int {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return len(s.payloads)
+}
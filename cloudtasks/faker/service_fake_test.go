@@ -2,6 +2,7 @@ package faker_test
 
 import (
 	"context"
+	"errors"
 	"fmt"
 	"strings"
 	"testing"
@@ -9,8 +10,11 @@ import (
 
 	"cloud.google.com/go/cloudtasks/apiv2"
 	"github.com/google/go-cmp/cmp"
+	"github.com/googleapis/gax-go/v2"
 	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
 	"github.com/sinmetalcraft/gcpbox/cloudtasks/faker"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
 )
 
 func TestService_fake_CreateGetTask(t *testing.T) {
@@ -303,6 +307,50 @@ func TestService_fake_CreateJsonPostTaskMulti(t *testing.T) {
 	}
 }
 
+func TestService_fake_AuthorizationType(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	cases := []struct {
+		name    string
+		getTask *tasksbox.GetTask
+	}{
+		{"OIDC", &tasksbox.GetTask{RelativeURI: "https://example.com/tq/hoge", Audience: "https://example.com"}},
+		{"OAuth", &tasksbox.GetTask{RelativeURI: "https://example.googleapis.com/v1/hoge", AuthorizationType: tasksbox.AuthorizationOAuth, OAuthScope: "https://www.googleapis.com/auth/cloud-platform"}},
+		{"None", &tasksbox.GetTask{RelativeURI: "https://example.com/tq/hoge", AuthorizationType: tasksbox.AuthorizationNone}},
+	}
+
+	for _, tt := range cases {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			s, tasksFaker := newFakeService(t)
+			defer tasksFaker.Stop()
+
+			if _, err := s.CreateGetTask(ctx, testQueue, tt.getTask); err != nil {
+				t.Fatal(err)
+			}
+			got, err := tasksFaker.GetTask(0)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e, g := tt.getTask.AuthorizationType, got.AuthorizationType; e != g {
+				t.Errorf("want AuthorizationType %s but got %s", e, g)
+			}
+			if e, g := tt.getTask.Audience, got.Audience; e != g {
+				t.Errorf("want Audience %s but got %s", e, g)
+			}
+			if e, g := tt.getTask.OAuthScope, got.OAuthScope; e != g {
+				t.Errorf("want OAuthScope %s but got %s", e, g)
+			}
+		})
+	}
+}
+
 func TestService_fake_Heavy(t *testing.T) {
 	ctx := context.Background()
 
@@ -365,6 +413,239 @@ func TestService_fake_Heavy(t *testing.T) {
 	}
 }
 
+func TestService_fake_AlreadyExists(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	task := &tasksbox.GetTask{Name: "hellotask", RelativeURI: "/tq/hoge"}
+	if _, err := s.CreateGetTask(ctx, testQueue, task); err != nil {
+		t.Fatal(err)
+	}
+	_, err := s.CreateGetTask(ctx, testQueue, task)
+	if !tasksbox.ErrAlreadyExists.Is(err) {
+		t.Errorf("want ErrAlreadyExists but got %v", err)
+	}
+	appErr := &tasksbox.Error{}
+	if errors.As(err, &appErr) {
+		if e, g := "hellotask", appErr.KV["taskName"]; e != g {
+			t.Errorf("want taskName %s but got %v", e, g)
+		}
+	}
+	if _, err := s.CreateGetTask(ctx, testQueue, task, tasksbox.WithIgnoreAlreadyExists()); err != nil {
+		t.Errorf("want ignore AlreadyExists but got %v", err)
+	}
+
+	// Inject した AlreadyExists も同じように扱われる
+	tasksFaker.AddErrorWithTaskName("injected", faker.ErrAlreadyExists)
+	if _, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{Name: "injected", RelativeURI: "/tq/hoge"}, tasksbox.WithIgnoreAlreadyExists()); err != nil {
+		t.Errorf("want ignore AlreadyExists but got %v", err)
+	}
+
+	tasks, err := tasksFaker.GetTasksByQueue(testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(tasks); e != g {
+		t.Errorf("want tasks length %d but got %d", e, g)
+	}
+}
+
+func TestService_fake_CreateGetTaskMulti_Error(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	tasksFaker.AddErrorWithTaskName("exists", faker.ErrAlreadyExists)
+	tasksFaker.AddErrorWithTaskName("exhausted", faker.ErrResourceExhausted)
+	tasks := []*tasksbox.GetTask{
+		{Name: "ok", RelativeURI: "/tq/hoge"},
+		{Name: "exists", RelativeURI: "/tq/hoge"},
+		{Name: "exhausted", RelativeURI: "/tq/hoge"},
+	}
+	tns, err := s.CreateGetTaskMulti(ctx, testQueue, tasks)
+	merr := &tasksbox.MultiError{}
+	if !errors.As(err, &merr) {
+		t.Fatalf("want MultiError but got %v", err)
+	}
+	if e, g := 2, len(merr.Errors); e != g {
+		t.Fatalf("want errors length %d but got %d", e, g)
+	}
+	for _, v := range merr.Errors {
+		switch v.KV["index"] {
+		case 1:
+			if !tasksbox.ErrAlreadyExists.Is(v) {
+				t.Errorf("index 1 : want ErrAlreadyExists but got %v", v)
+			}
+		case 2:
+			if !tasksbox.ErrCreateMultiTask.Is(v) {
+				t.Errorf("index 2 : want ErrCreateMultiTask but got %v", v)
+			}
+			if e, g := codes.ResourceExhausted, status.Code(errors.Unwrap(v)); e != g {
+				t.Errorf("index 2 : want code %v but got %v", e, g)
+			}
+		default:
+			t.Errorf("unexpected error %v", v)
+		}
+	}
+	if e, g := fmt.Sprintf("%s/tasks/ok", testQueue.Parent()), tns[0]; e != g {
+		t.Errorf("want TaskName %s but got %s", e, g)
+	}
+
+	// Retry を指定すると ResourceExhausted は再実行される
+	tasksFaker.AddErrorWithTaskName("retry", faker.ErrResourceExhausted)
+	_, err = s.CreateGetTaskMulti(ctx, testQueue, []*tasksbox.GetTask{{Name: "retry", RelativeURI: "/tq/hoge"}}, tasksbox.WithRetry(2, gax.Backoff{Initial: time.Millisecond}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := tasksFaker.GetTaskByName(testQueue, "retry"); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestService_fake_AddErrorWithIndex(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	myErr := errors.New("my error")
+	tasksFaker.AddErrorWithIndex(2, myErr)
+	for i := 0; i < 3; i++ {
+		_, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{RelativeURI: "/tq/hoge"})
+		if i == 1 {
+			if err == nil {
+				t.Errorf("%d : want error", i)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("%d : %v", i, err)
+		}
+	}
+	if e, g := 3, tasksFaker.GetCreateTaskCallCount(); e != g {
+		t.Errorf("want CreateTaskCallCount %d but got %d", e, g)
+	}
+}
+
+func TestService_fake_ReadTasks(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+	otherQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "otherqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	for i := 0; i < 3; i++ {
+		if _, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{Name: fmt.Sprintf("task%d", i), RelativeURI: "/tq/hoge"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := s.CreateGetTask(ctx, otherQueue, &tasksbox.GetTask{Name: "other", RelativeURI: "/tq/hoge"}); err != nil {
+		t.Fatal(err)
+	}
+
+	tasks, nextPageToken, err := s.ListTasks(ctx, testQueue, tasksbox.WithPageSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 2, len(tasks); e != g {
+		t.Fatalf("want tasks length %d but got %d", e, g)
+	}
+	tasks, nextPageToken, err = s.ListTasks(ctx, testQueue, tasksbox.WithPageSize(2), tasksbox.WithPageToken(nextPageToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(tasks); e != g {
+		t.Fatalf("want tasks length %d but got %d", e, g)
+	}
+	if e, g := "task2", tasks[0].Name; e != g {
+		t.Errorf("want Name %s but got %s", e, g)
+	}
+	if nextPageToken != "" {
+		t.Errorf("want empty nextPageToken but got %s", nextPageToken)
+	}
+
+	if err := s.DeleteTask(ctx, testQueue, "task0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.GetTask(ctx, testQueue, "task0"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+	if _, err := tasksFaker.GetTaskByName(testQueue, "task0"); !tasksbox.ErrNotFound.Is(err) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+	got, err := s.GetTask(ctx, testQueue, "task1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "task1", got.Name; e != g {
+		t.Errorf("want Name %s but got %s", e, g)
+	}
+
+	otherTasks, err := tasksFaker.GetTasksByQueue(otherQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(otherTasks); e != g {
+		t.Errorf("want tasks length %d but got %d", e, g)
+	}
+}
+
+func TestService_fake_WithCreatedAt(t *testing.T) {
+	ctx := context.Background()
+
+	testQueue := &tasksbox.Queue{
+		ProjectID: "unittest",
+		Region:    "asia-northeast1",
+		Name:      "testqueue",
+	}
+
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	createdAt := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	if _, err := s.CreateGetTask(ctx, testQueue, &tasksbox.GetTask{Name: "hellotask", RelativeURI: "/tq/hoge"}, tasksbox.WithCreatedAt(createdAt)); err != nil {
+		t.Fatal(err)
+	}
+	got, err := tasksFaker.GetTaskByName(testQueue, "hellotask")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := createdAt.Format(time.RFC3339Nano), got.Headers[tasksbox.CreatedAt]; e != g {
+		t.Errorf("want CreatedAt %s but got %s", e, g)
+	}
+}
+
 func newFakeService(t *testing.T) (*tasksbox.Service, *faker.Faker) {
 	ctx := context.Background()
 
@@ -0,0 +1,72 @@
+package handler
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+
+	"google.golang.org/protobuf/proto"
+)
+
+// Decoder is Task の Body を v に Decode する
+// Handler では v に *T が渡される
+type Decoder func(body []byte, v interface{}) error
+
+// JSONDecoder is JsonPostTask の Body を Decode する Decoder
+func JSONDecoder(body []byte, v interface{}) error {
+	return json.Unmarshal(body, v)
+}
+
+// ProtoDecoder is ProtoTask の Body を Decode する Decoder
+// v は proto.Message でなければならない
+func ProtoDecoder(body []byte, v interface{}) error {
+	m, ok := v.(proto.Message)
+	if !ok {
+		return fmt.Errorf("%T is not proto.Message", v)
+	}
+	return proto.Unmarshal(body, m)
+}
+
+// FormDecoder is FormTask の Body を Decode する Decoder
+// v は *url.Values でなければならない
+func FormDecoder(body []byte, v interface{}) error {
+	values, ok := v.(*url.Values)
+	if !ok {
+		return fmt.Errorf("%T is not *url.Values", v)
+	}
+	got, err := url.ParseQuery(string(body))
+	if err != nil {
+		return err
+	}
+	*values = got
+	return nil
+}
+
+// DecodeJSON is JsonPostTask の Request の Body を v に Decode する
+func DecodeJSON(r *http.Request, v interface{}) error {
+	return decodeRequest(r, JSONDecoder, v)
+}
+
+// DecodeProto is ProtoTask の Request の Body を m に Decode する
+func DecodeProto(r *http.Request, m proto.Message) error {
+	return decodeRequest(r, ProtoDecoder, m)
+}
+
+// DecodeForm is FormTask の Request の Body を url.Values にして返す
+func DecodeForm(r *http.Request) (url.Values, error) {
+	var values url.Values
+	if err := decodeRequest(r, FormDecoder, &values); err != nil {
+		return nil, err
+	}
+	return values, nil
+}
+
+func decodeRequest(r *http.Request, decoder Decoder, v interface{}) error {
+	body, err := io.ReadAll(r.Body)
+	if err != nil {
+		return fmt.Errorf("failed read body : %w", err)
+	}
+	return decoder(body, v)
+}
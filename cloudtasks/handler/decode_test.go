@@ -0,0 +1,80 @@
+package handler_test
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/known/wrapperspb"
+)
+
+func TestDecodeProto(t *testing.T) {
+	body, err := proto.Marshal(wrapperspb.String("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(string(body)))
+
+	var got wrapperspb.StringValue
+	if err := handler.DecodeProto(r, &got); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Hello", got.GetValue(); e != g {
+		t.Errorf("want %s but got %s", e, g)
+	}
+}
+
+func TestDecodeForm(t *testing.T) {
+	values := url.Values{"content": {"Hello", "World"}}
+	r := httptest.NewRequest(http.MethodPut, "/tq/hoge", strings.NewReader(values.Encode()))
+
+	got, err := handler.DecodeForm(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff(values, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestDecoder_InvalidType(t *testing.T) {
+	var body Body
+	if err := handler.ProtoDecoder([]byte{}, &body); err == nil {
+		t.Error("want error but got nil")
+	}
+	if err := handler.FormDecoder([]byte{}, &body); err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestHandler_WithDecoder(t *testing.T) {
+	body, err := proto.Marshal(wrapperspb.String("Hello"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got string
+	h := handler.NewHandler(func(ctx context.Context, body *wrapperspb.StringValue) error {
+		got = body.GetValue()
+		return nil
+	}, handler.WithDecoder(handler.ProtoDecoder))
+
+	r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(string(body)))
+	setCloudTasksHeader(r, 0, time.Now())
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if e, g := http.StatusOK, w.Code; e != g {
+		t.Errorf("want StatusCode %d but got %d", e, g)
+	}
+	if e, g := "Hello", got; e != g {
+		t.Errorf("want %s but got %s", e, g)
+	}
+}
@@ -0,0 +1,15 @@
+package handler
+
+import (
+	"errors"
+	"fmt"
+)
+
+// ErrPermanent is Retry しても成功しない失敗を表す
+// Handler の関数が errors.Is(err, ErrPermanent) を満たす error を返すと、Task は Retry されずに DeadLetter に送られる
+var ErrPermanent = errors.New("permanent failure")
+
+// Permanent is err を Retry しない失敗として wrap する
+func Permanent(err error) error {
+	return fmt.Errorf("%w: %w", ErrPermanent, err)
+}
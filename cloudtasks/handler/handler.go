@@ -0,0 +1,138 @@
+package handler
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"time"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+)
+
+// DeadLetterReason is Task を DeadLetter に送った理由
+type DeadLetterReason string
+
+const (
+	// DeadLetterReasonPermanent is Handler の関数が Permanent な error を返した
+	DeadLetterReasonPermanent DeadLetterReason = "Permanent"
+
+	// DeadLetterReasonInvalidBody is Body を Decode できなかった
+	DeadLetterReasonInvalidBody DeadLetterReason = "InvalidBody"
+
+	// DeadLetterReasonMaxRetryCount is RetryCount が上限に達した
+	DeadLetterReasonMaxRetryCount DeadLetterReason = "MaxRetryCount"
+
+	// DeadLetterReasonMaxAge is Task の作成からの経過時間が上限に達した
+	DeadLetterReasonMaxAge DeadLetterReason = "MaxAge"
+)
+
+// DeadLetter is Retry せずに諦めた Task の情報
+type DeadLetter struct {
+	Reason DeadLetterReason
+	Header *Header
+	Body   []byte
+	Err    error
+}
+
+// DeadLetterFunc is DeadLetter を受け取る関数
+// 呼び出された後、Task は成功として Cloud Tasks に返される
+type DeadLetterFunc func(ctx context.Context, deadLetter *DeadLetter)
+
+// Handler is Cloud Tasks から来た Request の Body を T に Decode して関数を呼び出す http.Handler
+// Body は WithDecoder で指定した Decoder で Decode する. 指定しない場合は JSON として Decode する
+//
+// 関数の戻り値によって Response の Status Code を決める
+//
+//	nil : 200 を返して Task を完了させる
+//	Permanent な error : DeadLetter に送って 200 を返す
+//	それ以外の error : 500 を返して Retry させる。 RetryCount や Task の作成からの経過時間が上限に達していれば DeadLetter に送って 200 を返す
+type Handler[T any] struct {
+	fn            func(ctx context.Context, body *T) error
+	headerParser  HeaderParser
+	maxRetryCount int
+	maxAge        time.Duration
+	deadLetter    DeadLetterFunc
+	decoder       Decoder
+}
+
+// NewHandler is Handler を返す
+// fn に渡す context には Header が入っているので HeaderFromContext で取得できる
+func NewHandler[T any](fn func(ctx context.Context, body *T) error, ops ...Options) *Handler[T] {
+	opt := options{
+		headerParser: HTTPTargetHeader,
+		decoder:      JSONDecoder,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	return &Handler[T]{
+		fn:            fn,
+		headerParser:  opt.headerParser,
+		maxRetryCount: opt.maxRetryCount,
+		maxAge:        opt.maxAge,
+		deadLetter:    opt.deadLetter,
+		decoder:       opt.decoder,
+	}
+}
+
+// ServeHTTP is http.Handler interface
+// Task を作成した時の Span を親にした Span の中で実行する
+func (h *Handler[T]) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	ctx, _ := tasksbox.StartSpanFromRequest(r, "cloudtasks.handler.ServeHTTP")
+	var err error
+	defer func() {
+		trace.EndSpan(ctx, err)
+	}()
+
+	header, err := h.headerParser(r)
+	if err != nil {
+		// Cloud Tasks から来た Request ではない
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	ctx = WithHeader(ctx, header)
+
+	buf, err := io.ReadAll(r.Body)
+	if err != nil {
+		// 読み込みの失敗は一時的なものとして Retry させる
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	var body T
+	if len(buf) > 0 {
+		if err = h.decoder(buf, &body); err != nil {
+			h.sendDeadLetter(ctx, w, &DeadLetter{Reason: DeadLetterReasonInvalidBody, Header: header, Body: buf, Err: err})
+			return
+		}
+	}
+
+	err = h.fn(ctx, &body)
+	if err == nil {
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+	if errors.Is(err, ErrPermanent) {
+		h.sendDeadLetter(ctx, w, &DeadLetter{Reason: DeadLetterReasonPermanent, Header: header, Body: buf, Err: err})
+		return
+	}
+	if h.maxRetryCount > 0 && header.RetryCount >= h.maxRetryCount {
+		h.sendDeadLetter(ctx, w, &DeadLetter{Reason: DeadLetterReasonMaxRetryCount, Header: header, Body: buf, Err: err})
+		return
+	}
+	if h.maxAge > 0 && time.Since(header.ageFrom()) >= h.maxAge {
+		h.sendDeadLetter(ctx, w, &DeadLetter{Reason: DeadLetterReasonMaxAge, Header: header, Body: buf, Err: err})
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
+}
+
+func (h *Handler[T]) sendDeadLetter(ctx context.Context, w http.ResponseWriter, deadLetter *DeadLetter) {
+	if h.deadLetter != nil {
+		h.deadLetter(ctx, deadLetter)
+	}
+	w.WriteHeader(http.StatusOK)
+}
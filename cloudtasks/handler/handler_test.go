@@ -0,0 +1,224 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/appengine"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	octrace "go.opencensus.io/trace"
+)
+
+type Body struct {
+	Content string
+}
+
+func TestHandler_ServeHTTP(t *testing.T) {
+	errRetry := errors.New("retry")
+
+	cases := []struct {
+		name           string
+		body           string
+		retryCount     int
+		eta            time.Time
+		ops            []handler.Options
+		fnErr          error
+		wantStatusCode int
+		wantReason     handler.DeadLetterReason
+	}{
+		{"success", `{"Content":"Hello"}`, 0, time.Now(), nil, nil, http.StatusOK, ""},
+		{"retry", `{"Content":"Hello"}`, 0, time.Now(), nil, errRetry, http.StatusInternalServerError, ""},
+		{"permanent", `{"Content":"Hello"}`, 0, time.Now(), nil, handler.Permanent(errRetry), http.StatusOK, handler.DeadLetterReasonPermanent},
+		{"invalid body", `{"Content":`, 0, time.Now(), nil, nil, http.StatusOK, handler.DeadLetterReasonInvalidBody},
+		{"under max retry count", `{"Content":"Hello"}`, 2, time.Now(), []handler.Options{handler.WithMaxRetryCount(3)}, errRetry, http.StatusInternalServerError, ""},
+		{"max retry count", `{"Content":"Hello"}`, 3, time.Now(), []handler.Options{handler.WithMaxRetryCount(3)}, errRetry, http.StatusOK, handler.DeadLetterReasonMaxRetryCount},
+		{"under max age", `{"Content":"Hello"}`, 0, time.Now(), []handler.Options{handler.WithMaxAge(time.Hour)}, errRetry, http.StatusInternalServerError, ""},
+		{"max age", `{"Content":"Hello"}`, 0, time.Now().Add(-2 * time.Hour), []handler.Options{handler.WithMaxAge(time.Hour)}, errRetry, http.StatusOK, handler.DeadLetterReasonMaxAge},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var gotBody *Body
+			var gotHeader *handler.Header
+			var deadLetter *handler.DeadLetter
+			ops := append(tt.ops, handler.WithDeadLetter(func(ctx context.Context, dl *handler.DeadLetter) {
+				deadLetter = dl
+			}))
+			h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+				gotBody = body
+				th, ok := handler.HeaderFromContext(ctx)
+				if !ok {
+					t.Error("header not found in context")
+				}
+				gotHeader = th
+				return tt.fnErr
+			}, ops...)
+
+			r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(tt.body))
+			setCloudTasksHeader(r, tt.retryCount, tt.eta)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if e, g := tt.wantStatusCode, w.Code; e != g {
+				t.Errorf("want StatusCode %d but got %d", e, g)
+			}
+			if tt.wantReason == "" {
+				if deadLetter != nil {
+					t.Errorf("unexpected dead letter %+v", deadLetter)
+				}
+			} else {
+				if deadLetter == nil {
+					t.Fatal("dead letter is not called")
+				}
+				if e, g := tt.wantReason, deadLetter.Reason; e != g {
+					t.Errorf("want Reason %s but got %s", e, g)
+				}
+				if e, g := tt.body, string(deadLetter.Body); e != g {
+					t.Errorf("want Body %s but got %s", e, g)
+				}
+			}
+			if tt.wantReason == handler.DeadLetterReasonInvalidBody {
+				return
+			}
+			if e, g := "Hello", gotBody.Content; e != g {
+				t.Errorf("want Content %s but got %s", e, g)
+			}
+			if e, g := tt.retryCount, gotHeader.RetryCount; e != g {
+				t.Errorf("want RetryCount %d but got %d", e, g)
+			}
+		})
+	}
+}
+
+func TestHandler_MaxAge_CreatedAt(t *testing.T) {
+	errRetry := errors.New("retry")
+
+	cases := []struct {
+		name       string
+		createdAt  time.Time
+		wantReason handler.DeadLetterReason
+	}{
+		{"under max age", time.Now().Add(-30 * time.Minute), ""},
+		// Retry で ETA が変わっても作成からの経過時間で判定する
+		{"max age", time.Now().Add(-2 * time.Hour), handler.DeadLetterReasonMaxAge},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var deadLetter *handler.DeadLetter
+			h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+				return errRetry
+			}, handler.WithMaxAge(time.Hour), handler.WithDeadLetter(func(ctx context.Context, dl *handler.DeadLetter) {
+				deadLetter = dl
+			}))
+
+			r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(`{"Content":"Hello"}`))
+			setCloudTasksHeader(r, 5, time.Now())
+			r.Header.Set(tasksbox.CreatedAt, tt.createdAt.Format(time.RFC3339Nano))
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if tt.wantReason == "" {
+				if deadLetter != nil {
+					t.Errorf("unexpected dead letter %+v", deadLetter)
+				}
+				return
+			}
+			if deadLetter == nil {
+				t.Fatal("dead letter is not called")
+			}
+			if e, g := tt.wantReason, deadLetter.Reason; e != g {
+				t.Errorf("want Reason %s but got %s", e, g)
+			}
+			if !tt.createdAt.Equal(deadLetter.Header.CreatedAt) {
+				t.Errorf("want CreatedAt %s but got %s", tt.createdAt, deadLetter.Header.CreatedAt)
+			}
+		})
+	}
+}
+
+func TestHandler_InvalidHeader(t *testing.T) {
+	h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+		t.Error("unexpected call")
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(`{"Content":"Hello"}`))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if e, g := http.StatusBadRequest, w.Code; e != g {
+		t.Errorf("want StatusCode %d but got %d", e, g)
+	}
+}
+
+func TestHandler_AppEngineHeader(t *testing.T) {
+	var gotHeader *handler.Header
+	h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+		gotHeader, _ = handler.HeaderFromContext(ctx)
+		return nil
+	}, handler.WithHeaderParser(handler.AppEngineHeader))
+
+	r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(`{"Content":"Hello"}`))
+	r.Header.Set(appengine.GoogleInternalSkipAdminCheck, "true")
+	r.Header.Set(appengine.AppEngineQueueName, "testqueue")
+	r.Header.Set(appengine.AppEngineTaskName, "hellotask")
+	r.Header.Set(appengine.AppEngineTaskRetryCount, "2")
+	r.Header.Set(appengine.AppEngineTaskExecutionCount, "1")
+	r.Header.Set(appengine.AppEngineTaskETA, fmt.Sprintf("%d.000000", time.Now().Unix()))
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if e, g := http.StatusOK, w.Code; e != g {
+		t.Fatalf("want StatusCode %d but got %d", e, g)
+	}
+	if e, g := "hellotask", gotHeader.TaskName; e != g {
+		t.Errorf("want TaskName %s but got %s", e, g)
+	}
+	if e, g := 2, gotHeader.RetryCount; e != g {
+		t.Errorf("want RetryCount %d but got %d", e, g)
+	}
+}
+
+func TestHandler_Trace(t *testing.T) {
+	_, parent := octrace.StartSpan(context.Background(), "enqueue", octrace.WithSampler(octrace.AlwaysSample()))
+	parent.End()
+
+	var got octrace.SpanContext
+	h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+		got = octrace.FromContext(ctx).SpanContext()
+		return nil
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/tq/hoge", strings.NewReader(`{"Content":"Hello"}`))
+	setCloudTasksHeader(r, 0, time.Now())
+	(&tracecontext.HTTPFormat{}).SpanContextToRequest(parent.SpanContext(), r)
+	w := httptest.NewRecorder()
+	h.ServeHTTP(w, r)
+
+	if e, g := http.StatusOK, w.Code; e != g {
+		t.Errorf("want StatusCode %d but got %d", e, g)
+	}
+	if e, g := parent.SpanContext().TraceID, got.TraceID; e != g {
+		t.Errorf("want TraceID %s but got %s", e, g)
+	}
+	if got.SpanID == parent.SpanContext().SpanID {
+		t.Errorf("want child span but got parent span")
+	}
+}
+
+func setCloudTasksHeader(r *http.Request, retryCount int, eta time.Time) {
+	r.Header.Set(tasksbox.QueueName, "testqueue")
+	r.Header.Set(tasksbox.TaskName, "hellotask")
+	r.Header.Set(tasksbox.RetryCount, fmt.Sprintf("%d", retryCount))
+	r.Header.Set(tasksbox.ExecutionCount, fmt.Sprintf("%d", retryCount))
+	r.Header.Set(tasksbox.ETA, fmt.Sprintf("%d.%06d", eta.Unix(), eta.Nanosecond()/1000))
+}
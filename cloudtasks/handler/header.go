@@ -0,0 +1,104 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"time"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/appengine"
+)
+
+// Header is cloudtasks.Header と appengine.Header を共通の形にしたもの
+type Header struct {
+	// QueueName is Queue Name
+	QueueName string
+
+	// TaskName is Task の Short Name
+	TaskName string
+
+	// RetryCount is このタスクが再試行された回数
+	// 最初の試行の場合は、この値は 0
+	RetryCount int
+
+	// ExecutionCount is タスクがハンドラからレスポンスを受け取った合計回数
+	ExecutionCount int
+
+	// ETA is タスクのスケジュール時間
+	// Retry の度に次の試行の時刻に変わる
+	ETA time.Time
+
+	// PreviousResponse is 前回の再試行の HTTP レスポンス コード
+	// optional
+	PreviousResponse string
+
+	// RetryReason is タスクを再試行する理由
+	// optional
+	RetryReason string
+
+	// CreatedAt is Task を作成した時刻. cloudtasks.CreatedAt Header から取得する
+	// optional gcpbox 以外で作成した Task の場合は Zero
+	CreatedAt time.Time
+}
+
+// HeaderParser is Request から Header を取得する
+type HeaderParser func(r *http.Request) (*Header, error)
+
+// HTTPTargetHeader is HTTP Target Task の Header を cloudtasks.GetHeader で取得する
+func HTTPTargetHeader(r *http.Request) (*Header, error) {
+	h, err := tasksbox.GetHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	return &Header{
+		QueueName:        h.QueueName,
+		TaskName:         h.TaskName,
+		RetryCount:       h.RetryCount,
+		ExecutionCount:   h.ExecutionCount,
+		ETA:              h.ETA,
+		PreviousResponse: h.PreviousResponse,
+		RetryReason:      h.RetryReason,
+		CreatedAt:        h.CreatedAt,
+	}, nil
+}
+
+// AppEngineHeader is App Engine Task の Header を appengine.GetHeader で取得する
+func AppEngineHeader(r *http.Request) (*Header, error) {
+	h, err := appengine.GetHeader(r)
+	if err != nil {
+		return nil, err
+	}
+	createdAt, _ := tasksbox.GetCreatedAt(r)
+	return &Header{
+		QueueName:        h.QueueName,
+		TaskName:         h.TaskName,
+		RetryCount:       int(h.TaskRetryCount),
+		ExecutionCount:   int(h.TaskExecutionCount),
+		ETA:              h.TaskETA,
+		PreviousResponse: h.TaskPreviousResponse,
+		RetryReason:      h.TaskRetryReason,
+		CreatedAt:        createdAt,
+	}, nil
+}
+
+type headerContextKey struct{}
+
+// WithHeader is Header を入れた context を返す
+func WithHeader(ctx context.Context, header *Header) context.Context {
+	return context.WithValue(ctx, headerContextKey{}, header)
+}
+
+// HeaderFromContext is Handler が context に入れた Header を返す
+func HeaderFromContext(ctx context.Context) (*Header, bool) {
+	h, ok := ctx.Value(headerContextKey{}).(*Header)
+	return h, ok
+}
+
+// age is 経過時間を計る起点の時刻を返す
+// CreatedAt が無い場合は ETA を使う
+func (h *Header) ageFrom() time.Time {
+	if !h.CreatedAt.IsZero() {
+		return h.CreatedAt
+	}
+	return h.ETA
+}
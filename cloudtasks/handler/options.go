@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"time"
+)
+
+type options struct {
+	headerParser  HeaderParser
+	maxRetryCount int
+	maxAge        time.Duration
+	deadLetter    DeadLetterFunc
+	decoder       Decoder
+}
+
+// Options is NewHandler に利用する options
+type Options func(*options)
+
+// WithHeaderParser is Header の取得方法を指定する
+// 指定しない場合は HTTPTargetHeader を利用する
+// App Engine Task を受ける場合は AppEngineHeader を指定する
+func WithHeaderParser(parser HeaderParser) Options {
+	return func(ops *options) {
+		ops.headerParser = parser
+	}
+}
+
+// WithMaxRetryCount is RetryCount が maxRetryCount 以上の Task が失敗した時に Retry せずに DeadLetter に送る
+func WithMaxRetryCount(maxRetryCount int) Options {
+	return func(ops *options) {
+		ops.maxRetryCount = maxRetryCount
+	}
+}
+
+// WithMaxAge is 作成から maxAge 以上経過した Task が失敗した時に Retry せずに DeadLetter に送る
+// 経過時間は gcpbox の CreateTask で設定した Header.CreatedAt から計る
+// CreatedAt が無い Task の場合は ETA から計るが、 ETA は Retry の度に変わるので、その試行の開始の遅れしか分からない
+func WithMaxAge(maxAge time.Duration) Options {
+	return func(ops *options) {
+		ops.maxAge = maxAge
+	}
+}
+
+// WithDeadLetter is Retry せずに諦めた Task を受け取る関数を指定する
+func WithDeadLetter(fn DeadLetterFunc) Options {
+	return func(ops *options) {
+		ops.deadLetter = fn
+	}
+}
+
+// WithDecoder is Body の Decode 方法を指定する
+// 指定しない場合は JSONDecoder を利用する
+// ProtoTask を受ける場合は ProtoDecoder, FormTask を受ける場合は FormDecoder を指定する
+func WithDecoder(decoder Decoder) Options {
+	return func(ops *options) {
+		ops.decoder = decoder
+	}
+}
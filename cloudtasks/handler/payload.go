@@ -0,0 +1,66 @@
+package handler
+
+import (
+	"bytes"
+	"io"
+	"net/http"
+	"strconv"
+
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+// PayloadMiddleware is cloudtasks.WithPayloadStore で store に逃がした Body を取り出して next に渡す
+// next が 2xx を返した場合は、もう使わないので store から削除する
+// Header に参照が入っていない Request はそのまま next に渡す
+func PayloadMiddleware(store tasksbox.PayloadStore, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		ref := r.Header.Get(tasksbox.PayloadRef)
+		if len(ref) == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		body, err := store.Get(r.Context(), ref)
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		r.Body = io.NopCloser(bytes.NewReader(body))
+		r.ContentLength = int64(len(body))
+		r.Header.Set("Content-Length", strconv.Itoa(len(body)))
+
+		sw := &statusResponseWriter{ResponseWriter: w}
+		next.ServeHTTP(sw, r)
+		if sw.statusCode() >= 200 && sw.statusCode() < 300 {
+			// 削除に失敗しても Task は成功しているので、 Retry はさせない
+			_ = store.Delete(r.Context(), ref)
+		}
+	})
+}
+
+// statusResponseWriter is next が返した Status Code を記録する http.ResponseWriter
+type statusResponseWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusResponseWriter) WriteHeader(statusCode int) {
+	if w.status == 0 {
+		w.status = statusCode
+	}
+	w.ResponseWriter.WriteHeader(statusCode)
+}
+
+func (w *statusResponseWriter) Write(b []byte) (int, error) {
+	if w.status == 0 {
+		w.status = http.StatusOK
+	}
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *statusResponseWriter) statusCode() int {
+	if w.status == 0 {
+		return http.StatusOK
+	}
+	return w.status
+}
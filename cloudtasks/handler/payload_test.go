@@ -0,0 +1,123 @@
+package handler_test
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/dispatcher"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/faker"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/handler"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestPayloadMiddleware(t *testing.T) {
+	ctx := context.Background()
+
+	store := faker.NewPayloadStore()
+
+	var mutex sync.Mutex
+	var contents []string
+	h := handler.NewHandler(func(ctx context.Context, body *Body) error {
+		mutex.Lock()
+		defer mutex.Unlock()
+
+		contents = append(contents, body.Content)
+		if th, _ := handler.HeaderFromContext(ctx); th.RetryCount == 0 {
+			return errors.New("retry")
+		}
+		return nil
+	})
+
+	d, err := dispatcher.NewDispatcher(ctx, handler.PayloadMiddleware(store, h), dispatcher.WithDefaultRetryConfig(&taskspb.RetryConfig{
+		MaxAttempts: 5,
+		MinBackoff:  durationpb.New(10 * time.Millisecond),
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer d.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, d.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tasksbox.NewService(ctx, taskClient, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	queue := &tasksbox.Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+	large := strings.Repeat("a", 1024)
+	for _, content := range []string{"small", large} {
+		_, err = s.CreateJsonPostTask(ctx, queue, &tasksbox.JsonPostTask{
+			RelativeURI: "http://localhost/tq/hoge",
+			Body:        &Body{Content: content},
+		}, tasksbox.WithPayloadStore(store, 100))
+		if err != nil {
+			t.Fatal(err)
+		}
+		d.Wait()
+	}
+
+	if e, g := 4, len(contents); e != g {
+		t.Fatalf("want handler call count %d but got %d", e, g)
+	}
+	for i, want := range []string{"small", "small", large, large} {
+		if e, g := want, contents[i]; e != g {
+			t.Errorf("%d : want Content length %d but got %d", i, len(e), len(g))
+		}
+	}
+	if e, g := 0, store.Len(); e != g {
+		t.Errorf("want store len %d but got %d", e, g)
+	}
+}
+
+func TestPayloadMiddleware_KeepOnFailure(t *testing.T) {
+	ctx := context.Background()
+
+	store := faker.NewPayloadStore()
+	ref, err := store.Put(ctx, []byte(`{"Content":"Hello"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, tt := range []struct {
+		statusCode int
+		wantLen    int
+	}{
+		{http.StatusInternalServerError, 1},
+		{http.StatusOK, 0},
+	} {
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			buf, err := io.ReadAll(r.Body)
+			if err != nil {
+				t.Error(err)
+			}
+			if e, g := `{"Content":"Hello"}`, string(buf); e != g {
+				t.Errorf("want body %s but got %s", e, g)
+			}
+			w.WriteHeader(tt.statusCode)
+		})
+
+		r := httptest.NewRequest(http.MethodPost, "/tq/hoge", nil)
+		r.Header.Set(tasksbox.PayloadRef, ref)
+		w := httptest.NewRecorder()
+		handler.PayloadMiddleware(store, next).ServeHTTP(w, r)
+
+		if e, g := tt.statusCode, w.Code; e != g {
+			t.Errorf("want StatusCode %d but got %d", e, g)
+		}
+		if e, g := tt.wantLen, store.Len(); e != g {
+			t.Errorf("status %d : want store len %d but got %d", tt.statusCode, e, g)
+		}
+	}
+}
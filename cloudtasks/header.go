@@ -1,10 +1,13 @@
 package cloudtasks
 
 import (
+	"maps"
 	"net/http"
 	"strconv"
 	"strings"
 	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
 )
 
 const (
@@ -29,6 +32,10 @@ const (
 
 	// RetryReason is X-CloudTasks-TaskRetryReason Header Key
 	RetryReason = "X-CloudTasks-TaskRetryReason"
+
+	// CreatedAt is Task を作成した時刻を入れる Header Key
+	// Cloud Tasks は Retry の度に ETA を変えるので、最初の試行からの経過時間を知るために CreateTask の時に設定する
+	CreatedAt = "X-Gcpbox-TaskCreatedAt"
 )
 
 // Header is Cloud Tasks から来た Request の Header
@@ -63,6 +70,10 @@ type Header struct {
 	// RetryReason is タスクを再試行する理由。
 	// optional
 	RetryReason string
+
+	// CreatedAt is Task を作成した時刻
+	// optional gcpbox 以外で作成した Task の場合は Zero
+	CreatedAt time.Time
 }
 
 // GetHeader is return Cloud Task Header
@@ -127,5 +138,53 @@ func GetHeader(r *http.Request) (*Header, error) {
 
 	ret.PreviousResponse = r.Header.Get(PreviousResponse)
 	ret.RetryReason = r.Header.Get(RetryReason)
+	ret.CreatedAt, _ = GetCreatedAt(r)
 	return &ret, nil
 }
+
+// GetCreatedAt is CreatedAt Header から Task を作成した時刻を取得する
+// Header が無い場合と形式が正しくない場合は false を返す
+func GetCreatedAt(r *http.Request) (time.Time, bool) {
+	v := r.Header.Get(CreatedAt)
+	if len(v) < 1 {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, v)
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// injectCreatedAt is Task の Header に CreatedAt を設定する
+// 既に Header が指定されている場合は何もしない. Outbox の Relay などで作り直した Task でも最初の時刻を使う
+// Task の Headers は呼び出し元の map をそのまま参照していることがあるので、 Copy した map に設定して Task に入れ直す
+func injectCreatedAt(task *taskspb.Task, now time.Time) {
+	var src map[string]string
+	switch {
+	case task.GetHttpRequest() != nil:
+		src = task.GetHttpRequest().Headers
+	case task.GetAppEngineHttpRequest() != nil:
+		src = task.GetAppEngineHttpRequest().Headers
+	default:
+		return
+	}
+	for k := range src {
+		if http.CanonicalHeaderKey(k) == http.CanonicalHeaderKey(CreatedAt) {
+			return
+		}
+	}
+
+	headers := maps.Clone(src)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[CreatedAt] = now.UTC().Format(time.RFC3339Nano)
+
+	switch {
+	case task.GetHttpRequest() != nil:
+		task.GetHttpRequest().Headers = headers
+	case task.GetAppEngineHttpRequest() != nil:
+		task.GetAppEngineHttpRequest().Headers = headers
+	}
+}
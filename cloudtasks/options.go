@@ -1,7 +1,26 @@
 package cloudtasks
 
+import (
+	"context"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"golang.org/x/time/rate"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
 type createTaskOptions struct {
 	ignoreAlreadyExists bool
+	inFlight            chan struct{}
+	limiter             *rate.Limiter
+	maxAttempts         int
+	backoff             gax.Backoff
+	contentTaskName     bool
+	contentTaskWindow   time.Duration
+	payloadStore        PayloadStore
+	payloadThreshold    int
+	createdAt           time.Time
 }
 
 // CreateTaskOptions is CreateTask に利用する options
@@ -14,3 +33,107 @@ func WithIgnoreAlreadyExists() CreateTaskOptions {
 		ops.ignoreAlreadyExists = true
 	}
 }
+
+// WithMaxInFlight is 同時に実行する CreateTask Request の数を max までにする
+// 同じ CreateTaskOptions を渡した呼び出しの間で共有されるので、 CreateJsonPostTaskMulti などで Quota を超えないようにするために使う
+// max が 0 以下の場合は制限しない
+func WithMaxInFlight(max int) CreateTaskOptions {
+	var inFlight chan struct{}
+	if max > 0 {
+		inFlight = make(chan struct{}, max)
+	}
+	return func(ops *createTaskOptions) {
+		ops.inFlight = inFlight
+	}
+}
+
+// WithRateLimit is 1秒間に実行する CreateTask Request の数を tasksPerSecond までにする
+// 同じ CreateTaskOptions を渡した呼び出しの間で共有されるので、 CreateJsonPostTaskMulti などで Quota を超えないようにするために使う
+// tasksPerSecond が 0 以下の場合は制限しない
+func WithRateLimit(tasksPerSecond float64) CreateTaskOptions {
+	var limiter *rate.Limiter
+	if tasksPerSecond > 0 {
+		limiter = rate.NewLimiter(rate.Limit(tasksPerSecond), 1)
+	}
+	return func(ops *createTaskOptions) {
+		ops.limiter = limiter
+	}
+}
+
+// WithRetry is CreateTask が ResourceExhausted, Unavailable で失敗した時に backoff を空けて maxAttempts 回まで実行する
+// backoff が zero value の場合は gax の default (初回 1s, 最大 30s, 2倍ずつ) になる
+func WithRetry(maxAttempts int, backoff gax.Backoff) CreateTaskOptions {
+	return func(ops *createTaskOptions) {
+		ops.maxAttempts = maxAttempts
+		ops.backoff = backoff
+	}
+}
+
+// WithCreatedAt is Task の CreatedAt Header に CreateTask の時刻の代わりに createdAt を設定する
+// Outbox の Relay などで、 Task を作成する前に記録した時刻から Handler の WithMaxAge を数えたい場合に使う
+func WithCreatedAt(createdAt time.Time) CreateTaskOptions {
+	return func(ops *createTaskOptions) {
+		ops.createdAt = createdAt
+	}
+}
+
+// WithContentTaskName is Name が指定されていない Task の {TASK_ID} を ContentTaskName で生成する
+// window には同じ内容の Task を 1 つにまとめる時間帯の長さを指定する。 0 の場合は時間帯で区切らない
+// 重複した Task を無視するためには WithIgnoreAlreadyExists も合わせて指定する
+func WithContentTaskName(window time.Duration) CreateTaskOptions {
+	return func(ops *createTaskOptions) {
+		ops.contentTaskName = true
+		ops.contentTaskWindow = window
+	}
+}
+
+// WithPayloadStore is Header などを含めた CreateTaskRequest の Size が threshold byte を超える Task の Body を store に保存して、 Task には参照だけを入れる
+// threshold が 0 以下の場合は MaxTaskBodySize を利用する
+// Handler 側は handler.PayloadMiddleware で Body を取り出す
+func WithPayloadStore(store PayloadStore, threshold int) CreateTaskOptions {
+	if threshold <= 0 {
+		threshold = MaxTaskBodySize
+	}
+	return func(ops *createTaskOptions) {
+		ops.payloadStore = store
+		ops.payloadThreshold = threshold
+	}
+}
+
+// invoke is options に従って同時実行数, Rate を制限し、 Retry しながら call を実行する
+func (opt *createTaskOptions) invoke(ctx context.Context, call func(ctx context.Context) error) error {
+	backoff := opt.backoff // Pause() が状態を持つので呼び出しごとに copy する
+	for attempt := 1; ; attempt++ {
+		if opt.limiter != nil {
+			if err := opt.limiter.Wait(ctx); err != nil {
+				return err
+			}
+		}
+		if opt.inFlight != nil {
+			select {
+			case opt.inFlight <- struct{}{}:
+			case <-ctx.Done():
+				return ctx.Err()
+			}
+		}
+		err := call(ctx)
+		if opt.inFlight != nil {
+			<-opt.inFlight
+		}
+		if err == nil || attempt >= opt.maxAttempts || !isRetryableCreateTaskError(err) {
+			return err
+		}
+		if err := gax.Sleep(ctx, backoff.Pause()); err != nil {
+			return err
+		}
+	}
+}
+
+func isRetryableCreateTaskError(err error) bool {
+	switch status.Code(err) {
+	case codes.ResourceExhausted, codes.Unavailable:
+		return true
+	default:
+		return false
+	}
+}
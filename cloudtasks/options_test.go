@@ -0,0 +1,150 @@
+package cloudtasks
+
+import (
+	"context"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/googleapis/gax-go/v2"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+func TestCreateTaskOptions_invokeRetry(t *testing.T) {
+	ctx := context.Background()
+
+	backoff := gax.Backoff{Initial: time.Millisecond, Max: 10 * time.Millisecond}
+	cases := []struct {
+		name      string
+		ops       []CreateTaskOptions
+		errs      []error
+		wantCalls int
+		wantCode  codes.Code
+	}{
+		{"no retry", nil, []error{status.Error(codes.ResourceExhausted, "")}, 1, codes.ResourceExhausted},
+		{"retry until success", []CreateTaskOptions{WithRetry(5, backoff)}, []error{status.Error(codes.ResourceExhausted, ""), status.Error(codes.Unavailable, "")}, 3, codes.OK},
+		{"max attempts", []CreateTaskOptions{WithRetry(2, backoff)}, []error{status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, ""), status.Error(codes.Unavailable, "")}, 2, codes.Unavailable},
+		{"not retryable", []CreateTaskOptions{WithRetry(5, backoff)}, []error{status.Error(codes.InvalidArgument, "")}, 1, codes.InvalidArgument},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := createTaskOptions{}
+			for _, o := range tt.ops {
+				o(&opt)
+			}
+
+			var calls int
+			err := opt.invoke(ctx, func(ctx context.Context) error {
+				calls++
+				if calls <= len(tt.errs) {
+					return tt.errs[calls-1]
+				}
+				return nil
+			})
+			if e, g := tt.wantCalls, calls; e != g {
+				t.Errorf("want calls %d but got %d", e, g)
+			}
+			if e, g := tt.wantCode, status.Code(err); e != g {
+				t.Errorf("want code %v but got %v", e, g)
+			}
+		})
+	}
+}
+
+func TestCreateTaskOptions_invokeMaxInFlight(t *testing.T) {
+	ctx := context.Background()
+
+	const max = 3
+	ops := []CreateTaskOptions{WithMaxInFlight(max)}
+
+	var inFlight, peak int32
+	wg := &sync.WaitGroup{}
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			opt := createTaskOptions{}
+			for _, o := range ops {
+				o(&opt)
+			}
+			err := opt.invoke(ctx, func(ctx context.Context) error {
+				n := atomic.AddInt32(&inFlight, 1)
+				defer atomic.AddInt32(&inFlight, -1)
+				for {
+					p := atomic.LoadInt32(&peak)
+					if n <= p || atomic.CompareAndSwapInt32(&peak, p, n) {
+						break
+					}
+				}
+				time.Sleep(5 * time.Millisecond)
+				return nil
+			})
+			if err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	if peak > max {
+		t.Errorf("want in flight <= %d but got %d", max, peak)
+	}
+}
+
+func TestCreateTaskOptions_invokeRateLimit(t *testing.T) {
+	ctx := context.Background()
+
+	opt := createTaskOptions{}
+	WithRateLimit(100)(&opt)
+
+	start := time.Now()
+	for i := 0; i < 11; i++ {
+		if err := opt.invoke(ctx, func(ctx context.Context) error { return nil }); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// burst は 1 なので 2 回目以降は 10ms ずつ待つ
+	if elapsed := time.Since(start); elapsed < 90*time.Millisecond {
+		t.Errorf("want rate limited but elapsed %v", elapsed)
+	}
+}
+
+func TestCreateTaskOptions_invokeUnlimited(t *testing.T) {
+	cases := []struct {
+		name string
+		ops  []CreateTaskOptions
+	}{
+		{"max in flight 0", []CreateTaskOptions{WithMaxInFlight(0)}},
+		{"max in flight negative", []CreateTaskOptions{WithMaxInFlight(-1)}},
+		{"rate limit 0", []CreateTaskOptions{WithRateLimit(0)}},
+		{"rate limit negative", []CreateTaskOptions{WithRateLimit(-1)}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+			defer cancel()
+
+			opt := createTaskOptions{}
+			for _, o := range tt.ops {
+				o(&opt)
+			}
+
+			var calls int
+			for i := 0; i < 3; i++ {
+				if err := opt.invoke(ctx, func(ctx context.Context) error {
+					calls++
+					return nil
+				}); err != nil {
+					t.Fatal(err)
+				}
+			}
+			if e, g := 3, calls; e != g {
+				t.Errorf("want calls %d but got %d", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,47 @@
+package outbox
+
+import (
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+type relayOptions struct {
+	table             string
+	batchSize         int
+	keepPublished     bool
+	createTaskOptions []tasksbox.CreateTaskOptions
+}
+
+// RelayOptions is Relay の Options
+type RelayOptions func(*relayOptions)
+
+// WithTable is Outbox Table の Name を指定する
+// 省略した場合は DefaultTable
+func WithTable(table string) RelayOptions {
+	return func(ops *relayOptions) {
+		ops.table = table
+	}
+}
+
+// WithBatchSize is 1 回の RelayOnce で Task を作成する最大数を指定する
+// 省略した場合は 100
+func WithBatchSize(size int) RelayOptions {
+	return func(ops *relayOptions) {
+		ops.batchSize = size
+	}
+}
+
+// WithKeepPublished is Task を作成した Row を Delete せずに PublishedAt を設定して残す
+// 残した Row は Relay の対象にはならないので、不要になったら自分で Delete する
+func WithKeepPublished() RelayOptions {
+	return func(ops *relayOptions) {
+		ops.keepPublished = true
+	}
+}
+
+// WithCreateTaskOptions is Task を作成する時の CreateTaskOptions を指定する
+// cloudtasks.WithIgnoreAlreadyExists は常に指定される
+func WithCreateTaskOptions(ops ...tasksbox.CreateTaskOptions) RelayOptions {
+	return func(o *relayOptions) {
+		o.createTaskOptions = append(o.createTaskOptions, ops...)
+	}
+}
@@ -0,0 +1,197 @@
+// Package outbox is Spanner の Transaction と一緒に Cloud Tasks の Task を作成するための Transactional Outbox
+//
+// Put で Task を Outbox Table への Mutation として呼び出し元の ReadWriteTransaction に入れ、
+// Relay が Outbox Table を読んで Cloud Tasks に Task を作成する。
+// Transaction が Commit された Task だけが作成され、 Commit 後に Process が落ちても Task は失われない。
+package outbox
+
+import (
+	"encoding/json"
+	"fmt"
+	"hash/fnv"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"github.com/google/uuid"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+)
+
+// DefaultTable is Outbox Table の Table Name の default
+const DefaultTable = "CloudTasksOutbox"
+
+// ShardCount is Outbox Table の ShardID の数
+// Relay が使う Index の先頭を ShardID にして、 CreatedAt の Commit Timestamp で Index の末尾に書き込みが集中しないようにする
+const ShardCount = 16
+
+// ShardID is taskName の Row の ShardID を返す
+func ShardID(taskName string) int64 {
+	h := fnv.New32a()
+	_, _ = h.Write([]byte(taskName))
+	return int64(h.Sum32() % ShardCount)
+}
+
+// CreateTableStatements is Outbox Table と Relay が使う Index の DDL を返す
+func CreateTableStatements(table string) []string {
+	return []string{
+		CreateTableStatement(table),
+		CreateIndexStatement(table),
+	}
+}
+
+// IndexName is Relay が未作成の Task を探すのに使う Index の Name を返す
+func IndexName(table string) string {
+	return table + "ByShardIDPublishedAtCreatedAt"
+}
+
+// CreateIndexStatement is Relay が未作成の Task を探すのに使う Index の DDL を返す
+//
+// Relay は PublishedAt IS NULL の Row を CreatedAt の古い順に読むので、この Index が無いと Poll の度に Table 全体を Scan する
+// 昇順の Index では NULL が先頭に来るので、 WithKeepPublished で作成済みの Row が残っていても未作成の Row だけを読む
+// PublishedAt, CreatedAt は Commit Timestamp なので、先頭に TaskName の Hash から決めた ShardID を置いて書き込みを ShardCount 個に分散する
+// CreateTableStatement だけで Table を作成している場合は、この DDL を追加で実行する
+func CreateIndexStatement(table string) string {
+	return fmt.Sprintf(`CREATE INDEX %s ON %s (ShardID, PublishedAt, CreatedAt)`, IndexName(table), table)
+}
+
+// CreateTableStatement is Outbox Table の DDL を返す
+// Relay が使う Index は含まないので、 CreateTableStatements を使うか CreateIndexStatement も実行する
+//
+// TaskName は Cloud Tasks の {TASK_ID} で、 Relay が何度 Task を作成しても同じ Task になるように Put の時に決める
+// ShardID は ShardID(TaskName) で、 Relay が使う Index の書き込みを分散するために使う
+// Deadline は time.Duration を nanoseconds で入れている
+func CreateTableStatement(table string) string {
+	return fmt.Sprintf(`
+CREATE TABLE %s (
+    TaskName STRING(500) NOT NULL,
+    ShardID INT64 NOT NULL,
+    ProjectID STRING(MAX) NOT NULL,
+    Region STRING(MAX) NOT NULL,
+    QueueName STRING(MAX) NOT NULL,
+    RelativeURI STRING(MAX) NOT NULL,
+    Audience STRING(MAX) NOT NULL,
+    AuthorizationType INT64 NOT NULL,
+    OAuthScope STRING(MAX) NOT NULL,
+    Body BYTES(MAX),
+    ScheduleTime TIMESTAMP,
+    Deadline INT64 NOT NULL,
+    CreatedAt TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+    PublishedAt TIMESTAMP OPTIONS (allow_commit_timestamp=true),
+) PRIMARY KEY (TaskName)`, table)
+}
+
+// Row is Outbox Table の Row
+type Row struct {
+	TaskName          string
+	ShardID           int64
+	ProjectID         string
+	Region            string
+	QueueName         string
+	RelativeURI       string
+	Audience          string
+	AuthorizationType int64
+	OAuthScope        string
+	Body              []byte
+	ScheduleTime      spanner.NullTime
+	Deadline          int64
+	CreatedAt         time.Time
+	PublishedAt       spanner.NullTime
+}
+
+// Queue is Task を作成する Queue
+func (r *Row) Queue() *tasksbox.Queue {
+	return &tasksbox.Queue{
+		ProjectID: r.ProjectID,
+		Region:    r.Region,
+		Name:      r.QueueName,
+	}
+}
+
+// JsonPostTask is Row を cloudtasks.JsonPostTask に戻す
+func (r *Row) JsonPostTask() *tasksbox.JsonPostTask {
+	task := &tasksbox.JsonPostTask{
+		Name:              r.TaskName,
+		RelativeURI:       r.RelativeURI,
+		Audience:          r.Audience,
+		AuthorizationType: tasksbox.AuthorizationType(r.AuthorizationType),
+		OAuthScope:        r.OAuthScope,
+		Body:              json.RawMessage(r.Body),
+		Deadline:          time.Duration(r.Deadline),
+	}
+	if r.ScheduleTime.Valid {
+		task.ScheduleTime = r.ScheduleTime.Time
+	}
+	return task
+}
+
+// Outbox is Outbox Table に Task を書き込む
+type Outbox struct {
+	table string
+}
+
+// NewOutbox is Outbox を返す
+// table は CreateTableStatement で作成した Table の Name
+func NewOutbox(table string) *Outbox {
+	return &Outbox{
+		table: table,
+	}
+}
+
+// Put is task を Outbox Table に Insert する Mutation を tx に入れて、 Task Name ({TASK_ID}) を返す
+// tx が Commit されると、 Relay が Task を作成する
+// task.Name を省略した場合は UUID を設定する. 同じ Name の Task がすでに Outbox にある場合は Commit が AlreadyExists で失敗する
+func (o *Outbox) Put(tx *spanner.ReadWriteTransaction, queue *tasksbox.Queue, task *tasksbox.JsonPostTask) (string, error) {
+	m, taskName, err := o.Mutation(queue, task)
+	if err != nil {
+		return "", err
+	}
+	if err := tx.BufferWrite([]*spanner.Mutation{m}); err != nil {
+		return "", fmt.Errorf("failed BufferWrite. taskName=%s : %w", taskName, err)
+	}
+	return taskName, nil
+}
+
+// Mutation is task を Outbox Table に Insert する Mutation と Task Name ({TASK_ID}) を返す
+// Put を使わずに spanner.Client.Apply などで書き込みたい時に使う
+func (o *Outbox) Mutation(queue *tasksbox.Queue, task *tasksbox.JsonPostTask) (*spanner.Mutation, string, error) {
+	if queue == nil {
+		return nil, "", tasksbox.NewErrInvalidArgument("queue is required", map[string]interface{}{}, nil)
+	}
+	if task == nil {
+		return nil, "", tasksbox.NewErrInvalidArgument("task is required", map[string]interface{}{}, nil)
+	}
+
+	body, err := json.Marshal(task.Body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed json.Marshal(). body=%+v : %w", task.Body, err)
+	}
+	taskName := task.Name
+	if len(taskName) < 1 {
+		taskName = uuid.New().String()
+	}
+	// ScheduledTimeを使っている古いものへの対応
+	scheduleTime := task.ScheduleTime
+	if scheduleTime.IsZero() && !task.ScheduledTime.IsZero() {
+		scheduleTime = task.ScheduledTime
+	}
+
+	row := &Row{
+		TaskName:          taskName,
+		ShardID:           ShardID(taskName),
+		ProjectID:         queue.ProjectID,
+		Region:            queue.Region,
+		QueueName:         queue.Name,
+		RelativeURI:       task.RelativeURI,
+		Audience:          task.Audience,
+		AuthorizationType: int64(task.AuthorizationType),
+		OAuthScope:        task.OAuthScope,
+		Body:              body,
+		ScheduleTime:      spanner.NullTime{Time: scheduleTime, Valid: !scheduleTime.IsZero()},
+		Deadline:          int64(task.Deadline),
+		CreatedAt:         spanner.CommitTimestamp,
+	}
+	m, err := spanner.InsertStruct(o.table, row)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed spanner.InsertStruct. taskName=%s : %w", taskName, err)
+	}
+	return m, taskName, nil
+}
@@ -0,0 +1,340 @@
+package outbox_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"testing"
+	"time"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	"cloud.google.com/go/spanner"
+	sadDatabase "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sadInstance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/faker"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/outbox"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	projectID = "unittest"
+	instance  = "outbox"
+)
+
+var testQueue = &tasksbox.Queue{
+	ProjectID: "unittest",
+	Region:    "asia-northeast1",
+	Name:      "testqueue",
+}
+
+type Body struct {
+	Content string
+}
+
+func TestShardID(t *testing.T) {
+	shards := map[int64]bool{}
+	for i := 0; i < 1000; i++ {
+		taskName := fmt.Sprintf("task-%d", i)
+		shardID := outbox.ShardID(taskName)
+		if shardID < 0 || shardID >= outbox.ShardCount {
+			t.Fatalf("%s: invalid ShardID %d", taskName, shardID)
+		}
+		if e, g := shardID, outbox.ShardID(taskName); e != g {
+			t.Errorf("%s: want same ShardID %d but got %d", taskName, e, g)
+		}
+		shards[shardID] = true
+	}
+	if e, g := outbox.ShardCount, len(shards); e != g {
+		t.Errorf("want shards %d but got %d", e, g)
+	}
+}
+
+func TestRelay_RelayOnce(t *testing.T) {
+	ctx := context.Background()
+
+	sc := newSpannerClient(t)
+	defer sc.Close()
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	ob := outbox.NewOutbox(outbox.DefaultTable)
+	var committed []string
+	commitTimestamp, err := sc.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		committed = nil
+		for _, content := range []string{"Hello", "World"} {
+			tn, err := ob.Put(tx, testQueue, &tasksbox.JsonPostTask{
+				RelativeURI: "https://example.com/tq/hoge",
+				Body:        &Body{Content: content},
+			})
+			if err != nil {
+				return err
+			}
+			committed = append(committed, tn)
+		}
+		return nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Rollback した Transaction の Task は作成されない
+	errRollback := errors.New("rollback")
+	_, err = sc.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		if _, err := ob.Put(tx, testQueue, &tasksbox.JsonPostTask{RelativeURI: "https://example.com/tq/hoge", Body: &Body{Content: "Rollback"}}); err != nil {
+			return err
+		}
+		return errRollback
+	})
+	if !errors.Is(err, errRollback) {
+		t.Fatalf("want errRollback but got %v", err)
+	}
+
+	relay := outbox.NewRelay(sc, s)
+	n, err := relay.RelayOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 2, n; e != g {
+		t.Errorf("want relay count %d but got %d", e, g)
+	}
+	for _, tn := range committed {
+		task, err := tasksFaker.GetTaskByName(testQueue, tn)
+		if err != nil {
+			t.Errorf("%s: %v", tn, err)
+			continue
+		}
+		// CreatedAt Header は Relay した時刻ではなく Put した Transaction の Commit Timestamp
+		if e, g := commitTimestamp.UTC().Format(time.RFC3339Nano), task.Headers[tasksbox.CreatedAt]; e != g {
+			t.Errorf("%s: want CreatedAt %s but got %s", tn, e, g)
+		}
+	}
+	if e, g := 2, tasksFaker.GetCreateTaskCallCount(); e != g {
+		t.Errorf("want CreateTask call count %d but got %d", e, g)
+	}
+	if e, g := 0, countRows(t, sc); e != g {
+		t.Errorf("want outbox rows %d but got %d", e, g)
+	}
+
+	n, err = relay.RelayOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, n; e != g {
+		t.Errorf("want relay count %d but got %d", e, g)
+	}
+}
+
+func TestRelay_RelayOnce_KeepPublished(t *testing.T) {
+	ctx := context.Background()
+
+	sc := newSpannerClient(t)
+	defer sc.Close()
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	task := &tasksbox.JsonPostTask{
+		Name:        "hellotask",
+		RelativeURI: "https://example.com/tq/hoge",
+		Body:        &Body{Content: "Hello"},
+	}
+	m, _, err := outbox.NewOutbox(outbox.DefaultTable).Mutation(testQueue, task)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := sc.Apply(ctx, []*spanner.Mutation{m}); err != nil {
+		t.Fatal(err)
+	}
+	// 前回の Relay で Task を作成した後に Row の更新に失敗したケース
+	if _, err := s.CreateJsonPostTask(ctx, testQueue, task); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := outbox.NewRelay(sc, s, outbox.WithKeepPublished()).RelayOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, n; e != g {
+		t.Errorf("want relay count %d but got %d", e, g)
+	}
+	if e, g := 1, len(mustTasksByQueue(t, tasksFaker)); e != g {
+		t.Errorf("want tasks %d but got %d", e, g)
+	}
+
+	row, err := sc.Single().ReadRow(ctx, outbox.DefaultTable, spanner.Key{"hellotask"}, []string{"PublishedAt"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var publishedAt spanner.NullTime
+	if err := row.Columns(&publishedAt); err != nil {
+		t.Fatal(err)
+	}
+	if !publishedAt.Valid {
+		t.Error("want PublishedAt but got NULL")
+	}
+}
+
+func TestRelay_RelayOnce_Error(t *testing.T) {
+	ctx := context.Background()
+
+	sc := newSpannerClient(t)
+	defer sc.Close()
+	s, tasksFaker := newFakeService(t)
+	defer tasksFaker.Stop()
+
+	ob := outbox.NewOutbox(outbox.DefaultTable)
+	var mus []*spanner.Mutation
+	for _, name := range []string{"task1", "task2"} {
+		m, _, err := ob.Mutation(testQueue, &tasksbox.JsonPostTask{Name: name, RelativeURI: "https://example.com/tq/hoge"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		mus = append(mus, m)
+	}
+	if _, err := sc.Apply(ctx, mus); err != nil {
+		t.Fatal(err)
+	}
+	tasksFaker.AddErrorWithTaskName("task2", faker.ErrUnavailable)
+
+	relay := outbox.NewRelay(sc, s)
+	n, err := relay.RelayOnce(ctx)
+	if !tasksbox.ErrCreateMultiTask.Is(firstError(t, err)) {
+		t.Fatalf("want ErrCreateMultiTask but got %v", err)
+	}
+	if e, g := 1, n; e != g {
+		t.Errorf("want relay count %d but got %d", e, g)
+	}
+	if e, g := 1, countRows(t, sc); e != g {
+		t.Errorf("want outbox rows %d but got %d", e, g)
+	}
+
+	// 失敗した Row は次の RelayOnce で作成される
+	n, err = relay.RelayOnce(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, n; e != g {
+		t.Errorf("want relay count %d but got %d", e, g)
+	}
+	if _, err := tasksFaker.GetTaskByName(testQueue, "task2"); err != nil {
+		t.Error(err)
+	}
+}
+
+func firstError(t *testing.T, err error) error {
+	t.Helper()
+
+	var merr *tasksbox.MultiError
+	if !errors.As(err, &merr) || len(merr.Errors) < 1 {
+		t.Fatalf("want MultiError but got %v", err)
+	}
+	return merr.Errors[0]
+}
+
+func mustTasksByQueue(t *testing.T, tasksFaker *faker.Faker) []*tasksbox.Task {
+	t.Helper()
+
+	tasks, err := tasksFaker.GetTasksByQueue(testQueue)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tasks
+}
+
+func countRows(t *testing.T, sc *spanner.Client) int {
+	t.Helper()
+
+	var count int64
+	err := sc.Single().Query(context.Background(), spanner.NewStatement(fmt.Sprintf("SELECT COUNT(*) FROM %s", outbox.DefaultTable))).Do(func(r *spanner.Row) error {
+		return r.Columns(&count)
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return int(count)
+}
+
+func newFakeService(t *testing.T) (*tasksbox.Service, *faker.Faker) {
+	ctx := context.Background()
+
+	tasksFaker := faker.NewFaker(t)
+	taskClient, err := cloudtasks.NewClient(ctx, tasksFaker.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tasksbox.NewService(ctx, taskClient, "hoge@unittest.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s, tasksFaker
+}
+
+// newSpannerClient is Spanner Emulator に Outbox Table と Index を持つ Database を作成して Client を返す
+func newSpannerClient(t *testing.T) *spanner.Client {
+	seh := os.Getenv("SPANNER_EMULATOR_HOST")
+	if len(seh) < 1 {
+		t.Fatal("Required $SPANNER_EMULATOR_HOST")
+	}
+
+	ctx := context.Background()
+
+	spannerInstanceAdminClient, err := sadInstance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerInstanceAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	spannerDatabaseAdminClient, err := sadDatabase.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerDatabaseAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err = spannerInstanceAdminClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     fmt.Sprintf("projects/%s", projectID),
+		InstanceId: instance,
+		Instance: &instancepb.Instance{
+			Name:      fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+			NodeCount: 1,
+		},
+	})
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			// noop
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	database := fmt.Sprintf("outbox%d", rand.Int31())
+	op, err := spannerDatabaseAdminClient.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+		CreateStatement: fmt.Sprintf("CREATE DATABASE %s", database),
+		ExtraStatements: outbox.CreateTableStatements(outbox.DefaultTable),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instance, database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return sc
+}
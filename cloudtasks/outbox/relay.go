@@ -0,0 +1,134 @@
+package outbox
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"google.golang.org/api/iterator"
+)
+
+// Relay is Outbox Table の Task を Cloud Tasks に作成する
+//
+// Task Name は Put の時に決めているので、複数の Relay が同じ Row を処理したり、
+// Task を作成した後に Row の更新に失敗して再度処理しても、 Task は 1 つしか作成されない
+type Relay struct {
+	spannerClient *spanner.Client
+	tasksService  *tasksbox.Service
+
+	table             string
+	batchSize         int
+	keepPublished     bool
+	createTaskOptions []tasksbox.CreateTaskOptions
+}
+
+// NewRelay is Relay を返す
+// Outbox Table には CreateIndexStatement の Index が必要
+func NewRelay(spannerClient *spanner.Client, tasksService *tasksbox.Service, ops ...RelayOptions) *Relay {
+	opt := relayOptions{
+		table:     DefaultTable,
+		batchSize: 100,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	return &Relay{
+		spannerClient:     spannerClient,
+		tasksService:      tasksService,
+		table:             opt.table,
+		batchSize:         opt.batchSize,
+		keepPublished:     opt.keepPublished,
+		createTaskOptions: append(opt.createTaskOptions, tasksbox.WithIgnoreAlreadyExists()),
+	}
+}
+
+// RelayOnce is Outbox Table の未作成の Task を CreatedAt の古い順に batchSize 件まで作成して、作成した件数を返す
+// 作成した Row は Delete する. WithKeepPublished を指定した場合は PublishedAt を設定する
+// 作成に失敗した Row はそのまま残して次回に作成する. 失敗した Task は taskName を KV に入れて MultiError で返す
+func (r *Relay) RelayOnce(ctx context.Context) (int, error) {
+	rows, err := r.pendingRows(ctx)
+	if err != nil {
+		return 0, err
+	}
+
+	merr := &tasksbox.MultiError{}
+	var mus []*spanner.Mutation
+	for _, row := range rows {
+		// Handler の WithMaxAge が Put した時刻から数えるように、 Outbox に書き込んだ時刻を CreatedAt Header にする
+		ops := append([]tasksbox.CreateTaskOptions{tasksbox.WithCreatedAt(row.CreatedAt)}, r.createTaskOptions...)
+		if _, err := r.tasksService.CreateJsonPostTask(ctx, row.Queue(), row.JsonPostTask(), ops...); err != nil {
+			merr.Append(tasksbox.NewErrCreateMultiTask("failed CreateJsonPostTask", map[string]interface{}{"taskName": row.TaskName, "URI": row.RelativeURI}, err))
+			continue
+		}
+		if r.keepPublished {
+			mus = append(mus, spanner.Update(r.table, []string{"TaskName", "PublishedAt"}, []interface{}{row.TaskName, spanner.CommitTimestamp}))
+		} else {
+			mus = append(mus, spanner.Delete(r.table, spanner.Key{row.TaskName}))
+		}
+	}
+	if len(mus) > 0 {
+		if _, err := r.spannerClient.Apply(ctx, mus); err != nil {
+			// Task は作成済みなので、次回同じ Task Name で作成しても AlreadyExists になるだけ
+			return 0, fmt.Errorf("failed mark published. table=%s : %w", r.table, err)
+		}
+	}
+	return len(mus), merr.ErrorOrNil()
+}
+
+// Run is ctx が Done になるまで interval ごとに RelayOnce を実行する
+// RelayOnce の error は onError に渡して処理を続ける. onError は nil でもよい
+// batchSize 件作成した場合は、まだ残っている可能性があるので interval を待たずに次を実行する
+func (r *Relay) Run(ctx context.Context, interval time.Duration, onError func(err error)) error {
+	for {
+		n, err := r.RelayOnce(ctx)
+		if err != nil && onError != nil {
+			onError(err)
+		}
+		if n >= r.batchSize && err == nil {
+			continue
+		}
+
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-time.After(interval):
+		}
+	}
+}
+
+// pendingRows is 全ての ShardID の未作成の Row を CreatedAt の古い順に batchSize 件まで読む
+func (r *Relay) pendingRows(ctx context.Context) ([]*Row, error) {
+	shardIDs := make([]int64, ShardCount)
+	for i := range shardIDs {
+		shardIDs[i] = int64(i)
+	}
+	stmt := spanner.Statement{
+		SQL: fmt.Sprintf(`SELECT * FROM %s@{FORCE_INDEX=%s} WHERE ShardID IN UNNEST(@ShardIDs) AND PublishedAt IS NULL ORDER BY CreatedAt LIMIT @Limit`, r.table, IndexName(r.table)),
+		Params: map[string]interface{}{
+			"ShardIDs": shardIDs,
+			"Limit":    r.batchSize,
+		},
+	}
+	iter := r.spannerClient.Single().Query(ctx, stmt)
+	defer iter.Stop()
+
+	var rows []*Row
+	for {
+		sr, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed read outbox. table=%s : %w", r.table, err)
+		}
+		var row Row
+		if err := sr.ToStruct(&row); err != nil {
+			return nil, fmt.Errorf("failed read outbox. table=%s : %w", r.table, err)
+		}
+		rows = append(rows, &row)
+	}
+	return rows, nil
+}
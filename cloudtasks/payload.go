@@ -0,0 +1,162 @@
+package cloudtasks
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	gcs "cloud.google.com/go/storage"
+	"github.com/google/uuid"
+	"google.golang.org/protobuf/proto"
+)
+
+// PayloadRef is Body を PayloadStore に逃がした時に、参照先を入れる Header Key
+const PayloadRef = "X-Gcpbox-PayloadRef"
+
+// MaxTaskBodySize is Cloud Tasks が受け付ける Task の最大サイズ
+// Body だけでなく Header などを含めた CreateTaskRequest 全体の Size がこれを超えないようにする
+// https://cloud.google.com/tasks/docs/quotas
+const MaxTaskBodySize = 100 * 1024
+
+// PayloadStore is Task の Body を Cloud Tasks の外に保存する Store
+// WithPayloadStore で CreateTask に指定し、 Handler 側では handler.PayloadMiddleware で取り出す
+type PayloadStore interface {
+	// Put is body を保存して、取り出すための参照を返す
+	Put(ctx context.Context, body []byte) (ref string, err error)
+
+	// Get is Put で返した参照から body を取り出す
+	// 存在しない場合は ErrNotFound を返す
+	Get(ctx context.Context, ref string) ([]byte, error)
+
+	// Delete is Put で返した参照の body を削除する
+	Delete(ctx context.Context, ref string) error
+}
+
+// GCSPayloadStore is Cloud Storage に Body を保存する PayloadStore
+// Handler が成功しなかった Task の Object は残るので、 Bucket に Lifecycle を設定しておくとよい
+type GCSPayloadStore struct {
+	gcs    *gcs.Client
+	bucket string
+	prefix string
+}
+
+// NewGCSPayloadStore is GCSPayloadStore を返す
+// Object は gs://{bucket}/{prefix}{UUID} に作成する
+func NewGCSPayloadStore(ctx context.Context, gcs *gcs.Client, bucket string, prefix string) (*GCSPayloadStore, error) {
+	return &GCSPayloadStore{
+		gcs:    gcs,
+		bucket: bucket,
+		prefix: prefix,
+	}, nil
+}
+
+// Put is body を Object として保存して gs://{bucket}/{object} 形式の参照を返す
+func (s *GCSPayloadStore) Put(ctx context.Context, body []byte) (string, error) {
+	object := s.prefix + uuid.New().String()
+	w := s.gcs.Bucket(s.bucket).Object(object).NewWriter(ctx)
+	if _, err := w.Write(body); err != nil {
+		_ = w.Close()
+		return "", fmt.Errorf("failed write payload. bucket=%s, object=%s : %w", s.bucket, object, err)
+	}
+	if err := w.Close(); err != nil {
+		return "", fmt.Errorf("failed write payload. bucket=%s, object=%s : %w", s.bucket, object, err)
+	}
+	return fmt.Sprintf("gs://%s/%s", s.bucket, object), nil
+}
+
+// Get is gs://{bucket}/{object} 形式の参照から body を取り出す
+// 参照は Request Header から来るので、 Store の bucket, prefix 以外の Object を指している場合は ErrInvalidArgument を返す
+func (s *GCSPayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	bucket, object, err := s.parseRef(ref)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.gcs.Bucket(bucket).Object(object).NewReader(ctx)
+	if errors.Is(err, gcs.ErrObjectNotExist) {
+		return nil, NewErrNotFound(fmt.Sprintf("%s is not found.", ref), map[string]interface{}{"ref": ref}, err)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed read payload. ref=%s : %w", ref, err)
+	}
+	defer r.Close()
+
+	body, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("failed read payload. ref=%s : %w", ref, err)
+	}
+	return body, nil
+}
+
+// Delete is gs://{bucket}/{object} 形式の参照の Object を削除する
+// 参照は Request Header から来るので、 Store の bucket, prefix 以外の Object を指している場合は ErrInvalidArgument を返す
+func (s *GCSPayloadStore) Delete(ctx context.Context, ref string) error {
+	bucket, object, err := s.parseRef(ref)
+	if err != nil {
+		return err
+	}
+	if err := s.gcs.Bucket(bucket).Object(object).Delete(ctx); err != nil {
+		if errors.Is(err, gcs.ErrObjectNotExist) {
+			return NewErrNotFound(fmt.Sprintf("%s is not found.", ref), map[string]interface{}{"ref": ref}, err)
+		}
+		return fmt.Errorf("failed delete payload. ref=%s : %w", ref, err)
+	}
+	return nil
+}
+
+// parseRef is ref を bucket と object に分けて、 Put で作成する Object を指しているかを確認する
+func (s *GCSPayloadStore) parseRef(ref string) (bucket string, object string, err error) {
+	bucket, object, err = parseGCSPayloadRef(ref)
+	if err != nil {
+		return "", "", err
+	}
+	if bucket != s.bucket || !strings.HasPrefix(object, s.prefix) {
+		return "", "", NewErrInvalidArgument("payload ref is not in the store", map[string]interface{}{"ref": ref, "bucket": s.bucket, "prefix": s.prefix}, nil)
+	}
+	return bucket, object, nil
+}
+
+func parseGCSPayloadRef(ref string) (bucket string, object string, err error) {
+	l := strings.SplitN(strings.TrimPrefix(ref, "gs://"), "/", 2)
+	if !strings.HasPrefix(ref, "gs://") || len(l) < 2 || len(l[0]) < 1 || len(l[1]) < 1 {
+		return "", "", NewErrInvalidArgument("invalid payload ref", map[string]interface{}{"ref": ref}, nil)
+	}
+	return l[0], l[1], nil
+}
+
+// offloadPayload is taskReq の Size が threshold を超えていたら Task の Body を store に保存して、 Body の代わりに Header に参照を入れた Request を返す
+// Body だけでなく Header や URL も Cloud Tasks の Size の上限に含まれるので、 CreateTaskRequest 全体の Size で判定する
+// 保存しなかった場合は ref は空になる
+func offloadPayload(ctx context.Context, store PayloadStore, threshold int, taskReq *taskspb.CreateTaskRequest) (ret *taskspb.CreateTaskRequest, ref string, err error) {
+	url, body := taskURLAndBody(taskReq.GetTask())
+	if len(body) < 1 || proto.Size(taskReq) <= threshold {
+		return taskReq, "", nil
+	}
+
+	ref, err = store.Put(ctx, body)
+	if err != nil {
+		return nil, "", fmt.Errorf("failed offload payload. url=%s : %w", url, err)
+	}
+	ret = proto.Clone(taskReq).(*taskspb.CreateTaskRequest)
+	switch task := ret.GetTask(); {
+	case task.GetHttpRequest() != nil:
+		req := task.GetHttpRequest()
+		req.Headers = withPayloadRef(req.Headers, ref)
+		req.Body = nil
+	case task.GetAppEngineHttpRequest() != nil:
+		req := task.GetAppEngineHttpRequest()
+		req.Headers = withPayloadRef(req.Headers, ref)
+		req.Body = nil
+	}
+	return ret, ref, nil
+}
+
+func withPayloadRef(headers map[string]string, ref string) map[string]string {
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	headers[PayloadRef] = ref
+	return headers
+}
@@ -0,0 +1,133 @@
+package cloudtasks
+
+import (
+	"context"
+	"testing"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"google.golang.org/protobuf/proto"
+)
+
+type memoryPayloadStore map[string][]byte
+
+func (s memoryPayloadStore) Put(ctx context.Context, body []byte) (string, error) {
+	s["ref"] = body
+	return "ref", nil
+}
+
+func (s memoryPayloadStore) Get(ctx context.Context, ref string) ([]byte, error) {
+	return s[ref], nil
+}
+
+func (s memoryPayloadStore) Delete(ctx context.Context, ref string) error {
+	delete(s, ref)
+	return nil
+}
+
+func TestOffloadPayload(t *testing.T) {
+	ctx := context.Background()
+
+	store := memoryPayloadStore{}
+	req := &taskspb.HttpRequest{
+		Url:     "https://example.com/tq/hoge",
+		Headers: map[string]string{"Content-Type": "application/json"},
+		Body:    []byte(`{"Content":"Hello"}`),
+	}
+
+	taskReq := &taskspb.CreateTaskRequest{
+		Parent: "projects/unittest/locations/asia-northeast1/queues/testqueue",
+		Task:   &taskspb.Task{MessageType: &taskspb.Task_HttpRequest{HttpRequest: req}},
+	}
+
+	got, ref, err := offloadPayload(ctx, store, proto.Size(taskReq), taskReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got != taskReq || len(ref) > 0 {
+		t.Errorf("want not offload but got ref=%s", ref)
+	}
+
+	// Body は threshold より小さくても、 Header などを含めた Request の Size で判定する
+	got, ref, err = offloadPayload(ctx, store, len(req.GetBody())+1, taskReq)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "ref", ref; e != g {
+		t.Errorf("want ref %s but got %s", e, g)
+	}
+	if e, g := ref, got.GetTask().GetHttpRequest().GetHeaders()[PayloadRef]; e != g {
+		t.Errorf("want header %s but got %s", e, g)
+	}
+	if e, g := "application/json", got.GetTask().GetHttpRequest().GetHeaders()["Content-Type"]; e != g {
+		t.Errorf("want Content-Type %s but got %s", e, g)
+	}
+	if len(got.GetTask().GetHttpRequest().GetBody()) > 0 {
+		t.Errorf("want empty body but got %s", got.GetTask().GetHttpRequest().GetBody())
+	}
+	if e, g := string(req.GetBody()), string(store[ref]); e != g {
+		t.Errorf("want stored body %s but got %s", e, g)
+	}
+	// 元の Request は変更しない
+	if _, ok := req.GetHeaders()[PayloadRef]; ok {
+		t.Error("original request headers is modified")
+	}
+}
+
+func TestParseGCSPayloadRef(t *testing.T) {
+	cases := []struct {
+		ref        string
+		wantBucket string
+		wantObject string
+		wantErr    bool
+	}{
+		{"gs://hoge/payload/fuga", "hoge", "payload/fuga", false},
+		{"gs://hoge/", "", "", true},
+		{"gs://hoge", "", "", true},
+		{"hoge/fuga", "", "", true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.ref, func(t *testing.T) {
+			bucket, object, err := parseGCSPayloadRef(tt.ref)
+			if tt.wantErr {
+				if !ErrInvalidArgument.Is(err) {
+					t.Errorf("want ErrInvalidArgument but got %v", err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if e, g := tt.wantBucket, bucket; e != g {
+				t.Errorf("want bucket %s but got %s", e, g)
+			}
+			if e, g := tt.wantObject, object; e != g {
+				t.Errorf("want object %s but got %s", e, g)
+			}
+		})
+	}
+}
+
+func TestGCSPayloadStore_ForeignRef(t *testing.T) {
+	ctx := context.Background()
+
+	// 参照の確認は GCS に Request する前に行うので Client は nil でよい
+	store, err := NewGCSPayloadStore(ctx, nil, "payload-bucket", "payload/")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, ref := range []string{
+		"gs://other-bucket/payload/fuga",
+		"gs://payload-bucket/secret/fuga",
+	} {
+		t.Run(ref, func(t *testing.T) {
+			if _, err := store.Get(ctx, ref); !ErrInvalidArgument.Is(err) {
+				t.Errorf("Get want ErrInvalidArgument but got %v", err)
+			}
+			if err := store.Delete(ctx, ref); !ErrInvalidArgument.Is(err) {
+				t.Errorf("Delete want ErrInvalidArgument but got %v", err)
+			}
+		})
+	}
+}
@@ -0,0 +1,303 @@
+package cloudtasks
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/fieldmaskpb"
+)
+
+// QueueConfig is Queue の設定を宣言的に表す
+// nil や zero value の項目は管理対象外として扱い、現在の設定を変更しない
+type QueueConfig struct {
+	// RateLimits is Task の dispatch の速度
+	// optional
+	RateLimits *RateLimits
+
+	// RetryConfig is Task が失敗した時の Retry の設定
+	// optional
+	RetryConfig *RetryConfig
+}
+
+// RateLimits is Queue の Rate Limit
+// https://cloud.google.com/tasks/docs/reference/rpc/google.cloud.tasks.v2#ratelimits
+type RateLimits struct {
+	// MaxDispatchesPerSecond is 1秒あたりに dispatch する Task の最大数
+	MaxDispatchesPerSecond float64
+
+	// MaxConcurrentDispatches is 同時に dispatch する Task の最大数
+	MaxConcurrentDispatches int32
+}
+
+// RetryConfig is Queue の Retry の設定
+// https://cloud.google.com/tasks/docs/reference/rpc/google.cloud.tasks.v2#retryconfig
+type RetryConfig struct {
+	// MaxAttempts is Task を実行する最大回数
+	// -1 を指定すると無制限になる
+	MaxAttempts int32
+
+	// MaxRetryDuration is 最初の実行から Retry を続ける時間
+	MaxRetryDuration time.Duration
+
+	// MinBackoff is Retry までの最短の間隔
+	MinBackoff time.Duration
+
+	// MaxBackoff is Retry までの最長の間隔
+	MaxBackoff time.Duration
+
+	// MaxDoublings is Retry の間隔を倍にしていく回数
+	MaxDoublings int32
+}
+
+// QueueConfigDiff is QueueConfig と現在の Queue の設定の差分
+type QueueConfigDiff struct {
+	// Field is 差分がある項目の FieldMask の Path
+	// ex. rate_limits.max_dispatches_per_second
+	Field string
+
+	// Current is 現在の値
+	Current interface{}
+
+	// Desired is QueueConfig で指定された値
+	Desired interface{}
+}
+
+// String is 差分を "field: current -> desired" 形式で返す
+func (d *QueueConfigDiff) String() string {
+	return fmt.Sprintf("%s: %v -> %v", d.Field, d.Current, d.Desired)
+}
+
+// EnsureQueueResult is EnsureQueue の結果
+type EnsureQueueResult struct {
+	// Created is Queue を新しく作成した
+	Created bool
+
+	// Diffs is 既存の Queue に適用した差分
+	Diffs []*QueueConfigDiff
+}
+
+// locationName is projects/{PROJECT_ID}/locations/{LOCATION} 形式の値を返す
+func (q *Queue) locationName() string {
+	return fmt.Sprintf("projects/%s/locations/%s", q.ProjectID, q.Region)
+}
+
+// CreateQueue is Queue を作成する
+// すでに存在する場合は ErrAlreadyExists を返す
+func (s *Service) CreateQueue(ctx context.Context, queue *Queue, config *QueueConfig) error {
+	_, err := s.taskClient.CreateQueue(ctx, &taskspb.CreateQueueRequest{
+		Parent: queue.locationName(),
+		Queue:  config.toQueueProto(queue),
+	})
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			return NewErrAlreadyExists(fmt.Sprintf("%s is already exists.", queue.Parent()), map[string]interface{}{"queue": queue.Parent()}, err)
+		}
+		return fmt.Errorf("failed CreateQueue(). queue=%+v : %w", queue, err)
+	}
+	return nil
+}
+
+// GetQueueConfig is 現在の Queue の設定を返す
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) GetQueueConfig(ctx context.Context, queue *Queue) (*QueueConfig, error) {
+	q, err := s.getQueue(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+	return queueProtoToQueueConfig(q), nil
+}
+
+// DiffQueueConfig is 現在の Queue の設定と config の差分を返す
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) DiffQueueConfig(ctx context.Context, queue *Queue, config *QueueConfig) ([]*QueueConfigDiff, error) {
+	q, err := s.getQueue(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+	return diffQueueConfig(q, config), nil
+}
+
+// UpdateQueueConfig is 現在の Queue の設定と config に差分がある項目だけを更新し、適用した差分を返す
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) UpdateQueueConfig(ctx context.Context, queue *Queue, config *QueueConfig) ([]*QueueConfigDiff, error) {
+	q, err := s.getQueue(ctx, queue)
+	if err != nil {
+		return nil, err
+	}
+	diffs := diffQueueConfig(q, config)
+	if len(diffs) < 1 {
+		return diffs, nil
+	}
+
+	var paths []string
+	for _, diff := range diffs {
+		paths = append(paths, diff.Field)
+	}
+	_, err = s.taskClient.UpdateQueue(ctx, &taskspb.UpdateQueueRequest{
+		Queue:      config.toQueueProto(queue),
+		UpdateMask: &fieldmaskpb.FieldMask{Paths: paths},
+	})
+	if err != nil {
+		return nil, fmt.Errorf("failed UpdateQueue(). queue=%+v, diffs=%v : %w", queue, diffs, err)
+	}
+	return diffs, nil
+}
+
+// EnsureQueue is Queue が存在しなければ作成し、存在すれば config との差分を適用する
+// 何度実行しても同じ結果になるので、Deploy 時に Queue の設定を揃えるのに使う
+func (s *Service) EnsureQueue(ctx context.Context, queue *Queue, config *QueueConfig) (*EnsureQueueResult, error) {
+	diffs, err := s.UpdateQueueConfig(ctx, queue, config)
+	if err == nil {
+		return &EnsureQueueResult{Diffs: diffs}, nil
+	}
+	if !ErrNotFound.Is(err) {
+		return nil, err
+	}
+
+	if err := s.CreateQueue(ctx, queue, config); err != nil {
+		if ErrAlreadyExists.Is(err) {
+			// 同時に作成された場合は、もう一度差分を適用する
+			diffs, err := s.UpdateQueueConfig(ctx, queue, config)
+			if err != nil {
+				return nil, err
+			}
+			return &EnsureQueueResult{Diffs: diffs}, nil
+		}
+		return nil, err
+	}
+	return &EnsureQueueResult{Created: true}, nil
+}
+
+// PauseQueue is Queue を一時停止する
+// 一時停止中も Task は作成できるが、dispatch されない
+func (s *Service) PauseQueue(ctx context.Context, queue *Queue) error {
+	_, err := s.taskClient.PauseQueue(ctx, &taskspb.PauseQueueRequest{Name: queue.Parent()})
+	if err != nil {
+		return s.queueError("PauseQueue", queue, err)
+	}
+	return nil
+}
+
+// ResumeQueue is 一時停止している Queue を再開する
+func (s *Service) ResumeQueue(ctx context.Context, queue *Queue) error {
+	_, err := s.taskClient.ResumeQueue(ctx, &taskspb.ResumeQueueRequest{Name: queue.Parent()})
+	if err != nil {
+		return s.queueError("ResumeQueue", queue, err)
+	}
+	return nil
+}
+
+// PurgeQueue is Queue の全ての Task を削除する
+// 削除が完了するまでには最大で 1 分程度かかる
+func (s *Service) PurgeQueue(ctx context.Context, queue *Queue) error {
+	_, err := s.taskClient.PurgeQueue(ctx, &taskspb.PurgeQueueRequest{Name: queue.Parent()})
+	if err != nil {
+		return s.queueError("PurgeQueue", queue, err)
+	}
+	return nil
+}
+
+// DeleteQueue is Queue を削除する
+// 削除した Queue と同じ名前の Queue は 7 日間作成できない
+func (s *Service) DeleteQueue(ctx context.Context, queue *Queue) error {
+	err := s.taskClient.DeleteQueue(ctx, &taskspb.DeleteQueueRequest{Name: queue.Parent()})
+	if err != nil {
+		return s.queueError("DeleteQueue", queue, err)
+	}
+	return nil
+}
+
+func (s *Service) getQueue(ctx context.Context, queue *Queue) (*taskspb.Queue, error) {
+	q, err := s.taskClient.GetQueue(ctx, &taskspb.GetQueueRequest{Name: queue.Parent()})
+	if err != nil {
+		return nil, s.queueError("GetQueue", queue, err)
+	}
+	return q, nil
+}
+
+func (s *Service) queueError(method string, queue *Queue, err error) error {
+	if status.Code(err) == codes.NotFound {
+		return NewErrNotFound(fmt.Sprintf("%s is not found.", queue.Parent()), map[string]interface{}{"queue": queue.Parent()}, err)
+	}
+	return fmt.Errorf("failed %s(). queue=%+v : %w", method, queue, err)
+}
+
+func (config *QueueConfig) toQueueProto(queue *Queue) *taskspb.Queue {
+	q := &taskspb.Queue{
+		Name: queue.Parent(),
+	}
+	if config == nil {
+		return q
+	}
+	if v := config.RateLimits; v != nil {
+		q.RateLimits = &taskspb.RateLimits{
+			MaxDispatchesPerSecond:  v.MaxDispatchesPerSecond,
+			MaxConcurrentDispatches: v.MaxConcurrentDispatches,
+		}
+	}
+	if v := config.RetryConfig; v != nil {
+		q.RetryConfig = &taskspb.RetryConfig{
+			MaxAttempts:  v.MaxAttempts,
+			MaxDoublings: v.MaxDoublings,
+		}
+		if v.MaxRetryDuration != 0 {
+			q.RetryConfig.MaxRetryDuration = durationpb.New(v.MaxRetryDuration)
+		}
+		if v.MinBackoff != 0 {
+			q.RetryConfig.MinBackoff = durationpb.New(v.MinBackoff)
+		}
+		if v.MaxBackoff != 0 {
+			q.RetryConfig.MaxBackoff = durationpb.New(v.MaxBackoff)
+		}
+	}
+	return q
+}
+
+func queueProtoToQueueConfig(q *taskspb.Queue) *QueueConfig {
+	return &QueueConfig{
+		RateLimits: &RateLimits{
+			MaxDispatchesPerSecond:  q.GetRateLimits().GetMaxDispatchesPerSecond(),
+			MaxConcurrentDispatches: q.GetRateLimits().GetMaxConcurrentDispatches(),
+		},
+		RetryConfig: &RetryConfig{
+			MaxAttempts:      q.GetRetryConfig().GetMaxAttempts(),
+			MaxRetryDuration: q.GetRetryConfig().GetMaxRetryDuration().AsDuration(),
+			MinBackoff:       q.GetRetryConfig().GetMinBackoff().AsDuration(),
+			MaxBackoff:       q.GetRetryConfig().GetMaxBackoff().AsDuration(),
+			MaxDoublings:     q.GetRetryConfig().GetMaxDoublings(),
+		},
+	}
+}
+
+// diffQueueConfig is 現在の Queue と desired で指定されている項目の差分を返す
+func diffQueueConfig(current *taskspb.Queue, desired *QueueConfig) []*QueueConfigDiff {
+	var diffs []*QueueConfigDiff
+	if desired == nil {
+		return diffs
+	}
+	c := queueProtoToQueueConfig(current)
+
+	appendDiff := func(field string, current interface{}, desired interface{}, managed bool) {
+		if !managed || current == desired {
+			return
+		}
+		diffs = append(diffs, &QueueConfigDiff{Field: field, Current: current, Desired: desired})
+	}
+	if v := desired.RateLimits; v != nil {
+		appendDiff("rate_limits.max_dispatches_per_second", c.RateLimits.MaxDispatchesPerSecond, v.MaxDispatchesPerSecond, v.MaxDispatchesPerSecond != 0)
+		appendDiff("rate_limits.max_concurrent_dispatches", c.RateLimits.MaxConcurrentDispatches, v.MaxConcurrentDispatches, v.MaxConcurrentDispatches != 0)
+	}
+	if v := desired.RetryConfig; v != nil {
+		appendDiff("retry_config.max_attempts", c.RetryConfig.MaxAttempts, v.MaxAttempts, v.MaxAttempts != 0)
+		appendDiff("retry_config.max_retry_duration", c.RetryConfig.MaxRetryDuration, v.MaxRetryDuration, v.MaxRetryDuration != 0)
+		appendDiff("retry_config.min_backoff", c.RetryConfig.MinBackoff, v.MinBackoff, v.MinBackoff != 0)
+		appendDiff("retry_config.max_backoff", c.RetryConfig.MaxBackoff, v.MaxBackoff, v.MaxBackoff != 0)
+		appendDiff("retry_config.max_doublings", c.RetryConfig.MaxDoublings, v.MaxDoublings, v.MaxDoublings != 0)
+	}
+	return diffs
+}
@@ -0,0 +1,84 @@
+package cloudtasks
+
+import (
+	"testing"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+)
+
+func TestDiffQueueConfig(t *testing.T) {
+	current := &taskspb.Queue{
+		Name: "projects/unittest/locations/asia-northeast1/queues/testqueue",
+		RateLimits: &taskspb.RateLimits{
+			MaxDispatchesPerSecond:  500,
+			MaxConcurrentDispatches: 1000,
+		},
+		RetryConfig: &taskspb.RetryConfig{
+			MaxAttempts:  100,
+			MinBackoff:   durationpb.New(100 * time.Millisecond),
+			MaxBackoff:   durationpb.New(3600 * time.Second),
+			MaxDoublings: 16,
+		},
+	}
+
+	cases := []struct {
+		name    string
+		desired *QueueConfig
+		want    []*QueueConfigDiff
+	}{
+		{"nil", nil, nil},
+		{"no diff", &QueueConfig{
+			RateLimits:  &RateLimits{MaxDispatchesPerSecond: 500},
+			RetryConfig: &RetryConfig{MaxAttempts: 100, MinBackoff: 100 * time.Millisecond},
+		}, nil},
+		{"rate limits", &QueueConfig{
+			RateLimits: &RateLimits{MaxDispatchesPerSecond: 10, MaxConcurrentDispatches: 1000},
+		}, []*QueueConfigDiff{
+			{Field: "rate_limits.max_dispatches_per_second", Current: float64(500), Desired: float64(10)},
+		}},
+		{"retry config", &QueueConfig{
+			RetryConfig: &RetryConfig{MaxAttempts: -1, MaxRetryDuration: time.Hour, MaxBackoff: 60 * time.Second},
+		}, []*QueueConfigDiff{
+			{Field: "retry_config.max_attempts", Current: int32(100), Desired: int32(-1)},
+			{Field: "retry_config.max_retry_duration", Current: time.Duration(0), Desired: time.Hour},
+			{Field: "retry_config.max_backoff", Current: 3600 * time.Second, Desired: 60 * time.Second},
+		}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := diffQueueConfig(current, tt.desired)
+			if diff := cmp.Diff(tt.want, got); diff != "" {
+				t.Errorf("diff (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestQueueConfig_toQueueProto(t *testing.T) {
+	queue := &Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+	config := &QueueConfig{
+		RateLimits:  &RateLimits{MaxDispatchesPerSecond: 10},
+		RetryConfig: &RetryConfig{MaxAttempts: 5, MinBackoff: time.Second},
+	}
+
+	got := config.toQueueProto(queue)
+	if e, g := queue.Parent(), got.GetName(); e != g {
+		t.Errorf("want Name %s but got %s", e, g)
+	}
+	if e, g := float64(10), got.GetRateLimits().GetMaxDispatchesPerSecond(); e != g {
+		t.Errorf("want MaxDispatchesPerSecond %v but got %v", e, g)
+	}
+	if e, g := int32(5), got.GetRetryConfig().GetMaxAttempts(); e != g {
+		t.Errorf("want MaxAttempts %v but got %v", e, g)
+	}
+	if e, g := time.Second, got.GetRetryConfig().GetMinBackoff().AsDuration(); e != g {
+		t.Errorf("want MinBackoff %v but got %v", e, g)
+	}
+	if got.GetRetryConfig().GetMaxBackoff() != nil {
+		t.Errorf("want MaxBackoff is nil but got %v", got.GetRetryConfig().GetMaxBackoff())
+	}
+}
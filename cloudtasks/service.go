@@ -3,16 +3,14 @@ package cloudtasks
 import (
 	"context"
 	"encoding/json"
-	"errors"
 	"fmt"
 	"net/http"
-	"sync"
+	"net/url"
 	"time"
 
 	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
 	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
-	"google.golang.org/grpc/codes"
-	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
 	"google.golang.org/protobuf/types/known/durationpb"
 	"google.golang.org/protobuf/types/known/timestamppb"
 )
@@ -47,42 +45,21 @@ func (q *Queue) Parent() string {
 // 一番 Primitive なやつ
 // taskName は中で projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式にしているので指定するのは {TASK_ID} の部分だけ
 func (s *Service) CreateTask(ctx context.Context, queue *Queue, taskName string, req *taskspb.HttpRequest, scheduleTime time.Time, deadline time.Duration, ops ...CreateTaskOptions) (*taskspb.Task, error) {
-	opt := createTaskOptions{}
-	for _, o := range ops {
-		o(&opt)
-	}
-
-	taskReq := &taskspb.CreateTaskRequest{
-		Parent: queue.Parent(),
-		Task: &taskspb.Task{
-			MessageType: &taskspb.Task_HttpRequest{
-				HttpRequest: req,
-			},
+	task := &taskspb.Task{
+		MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: req,
 		},
 	}
 	if len(taskName) > 0 {
-		taskReq.GetTask().Name = fmt.Sprintf("projects/%s/locations/%s/queues/%s/tasks/%s", queue.ProjectID, queue.Region, queue.Name, taskName)
+		task.Name = queue.taskName(taskName)
 	}
 	if !scheduleTime.IsZero() {
-		taskReq.Task.ScheduleTime = timestamppb.New(scheduleTime)
+		task.ScheduleTime = timestamppb.New(scheduleTime)
 	}
 	if deadline != 0 {
-		taskReq.Task.DispatchDeadline = durationpb.New(deadline)
-	}
-	task, err := s.taskClient.CreateTask(ctx, taskReq)
-	if err != nil {
-		sts, ok := status.FromError(err)
-		if ok {
-			if sts.Code() == codes.AlreadyExists {
-				if opt.ignoreAlreadyExists {
-					return taskReq.GetTask(), nil
-				}
-				return nil, NewErrAlreadyExists(fmt.Sprintf("%s is already exists.", taskReq.GetTask().Name), map[string]interface{}{"taskName": taskReq.GetTask().Name}, err)
-			}
-		}
-		return nil, err
+		task.DispatchDeadline = durationpb.New(deadline)
 	}
-	return task, nil
+	return createTask(ctx, s.taskClient, queue, task, ops...)
 }
 
 // Task is Response Task
@@ -95,6 +72,14 @@ type Task struct {
 	// Cloud Run.Invokerに投げる場合は RelativeURI と同じものを指定する
 	Audience string
 
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+
 	// Task Request の Header
 	Headers map[string]string
 
@@ -121,6 +106,18 @@ type Task struct {
 	// 中で projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式にしているので指定するのは {TASK_ID} の部分だけ
 	// 未指定の場合は自動的に設定される
 	Name string
+
+	// CreateTime is Task が作成された時刻
+	// GetTask, ListTasks で取得した時のみ設定される
+	CreateTime time.Time
+
+	// DispatchCount is Task が dispatch された回数
+	// GetTask, ListTasks で取得した時のみ設定される
+	DispatchCount int32
+
+	// ResponseCount is Task が Handler から Response を受け取った回数
+	// GetTask, ListTasks で取得した時のみ設定される
+	ResponseCount int32
 }
 
 // HttpMethodProtoToHttpMethod is HttpMethodProto から HttpMethod に変換する
@@ -149,6 +146,14 @@ type JsonPostTask struct {
 	// Cloud Run.Invokerに投げる場合は RelativeURI と同じものを指定する
 	Audience string
 
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+
 	// Task が到達する Handler の URL
 	RelativeURI string
 
@@ -186,13 +191,15 @@ func (jpTask *JsonPostTask) ToTask() (*Task, error) {
 		body = b
 	}
 	return &Task{
-		Audience:     jpTask.Audience,
-		RelativeURI:  jpTask.RelativeURI,
-		Method:       http.MethodPost,
-		ScheduleTime: jpTask.ScheduleTime,
-		Deadline:     jpTask.Deadline,
-		Body:         body,
-		Name:         jpTask.Name,
+		Audience:          jpTask.Audience,
+		AuthorizationType: jpTask.AuthorizationType,
+		OAuthScope:        jpTask.OAuthScope,
+		RelativeURI:       jpTask.RelativeURI,
+		Method:            http.MethodPost,
+		ScheduleTime:      jpTask.ScheduleTime,
+		Deadline:          jpTask.Deadline,
+		Body:              body,
+		Name:              jpTask.Name,
 	}, nil
 }
 
@@ -207,18 +214,16 @@ func (s *Service) CreateJsonPostTask(ctx context.Context, queue *Queue, task *Js
 	if err != nil {
 		return "", fmt.Errorf("failed json.Marshal(). body=%+v : %w", task.Body, err)
 	}
-	got, err := s.CreateTask(ctx, queue, task.Name, &taskspb.HttpRequest{
+	req := &taskspb.HttpRequest{
 		Url:        task.RelativeURI,
-		Headers:    map[string]string{"Content-Type": "application/json"},
+		Headers:    map[string]string{"Content-Type": ContentTypeJSON},
 		HttpMethod: taskspb.HttpMethod_POST,
 		Body:       body,
-		AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
-			OidcToken: &taskspb.OidcToken{
-				ServiceAccountEmail: s.serviceAccountEmail,
-				Audience:            task.Audience,
-			},
-		},
-	}, task.ScheduleTime, task.Deadline, ops...)
+	}
+	if err := setAuthorizationHeader(req, s.serviceAccountEmail, task.AuthorizationType, task.Audience, task.OAuthScope); err != nil {
+		return "", err
+	}
+	got, err := s.CreateTask(ctx, queue, task.Name, req, task.ScheduleTime, task.Deadline, ops...)
 	if err != nil {
 		return "", fmt.Errorf("failed CreateJsonPostTask(). queue=%+v, body=%+v : %w", queue, task.Body, err)
 	}
@@ -226,29 +231,14 @@ func (s *Service) CreateJsonPostTask(ctx context.Context, queue *Queue, task *Js
 }
 
 // CreateJsonPostTaskMulti is Queue に JsonPostTask を複数作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
 func (s *Service) CreateJsonPostTaskMulti(ctx context.Context, queue *Queue, tasks []*JsonPostTask, ops ...CreateTaskOptions) ([]string, error) {
-	results := make([]string, len(tasks))
-	merr := MultiError{}
-	wg := &sync.WaitGroup{}
-	for i, task := range tasks {
-		wg.Add(1)
-		go func(i int, task *JsonPostTask) {
-			defer wg.Done()
-			tn, err := s.CreateJsonPostTask(ctx, queue, task, ops...)
-			if err != nil {
-				appErr := &Error{}
-				if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
-					appErr.KV["index"] = i
-					merr.Append(appErr)
-					return
-				}
-				merr.Append(NewErrCreateMultiTask("failed CreateJsonPostTask", map[string]interface{}{"index": i, "taskName": task.Name, "URI": task.RelativeURI}, err))
-			}
-			results[i] = tn
-		}(i, task)
-	}
-	wg.Wait()
-	return results, merr.ErrorOrNil()
+	return createMulti(len(tasks), ops, func(i int) (string, error) {
+		return s.CreateJsonPostTask(ctx, queue, tasks[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed CreateJsonPostTask", map[string]interface{}{"index": i, "taskName": tasks[i].Name, "URI": tasks[i].RelativeURI}, err)
+	})
 }
 
 // GetTask is Get Request 用の Task
@@ -261,6 +251,14 @@ type GetTask struct {
 	// Cloud Run.Invokerに投げる場合は RelativeURI と同じものを指定する
 	Audience string
 
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+
 	// Task Request の Header
 	Headers map[string]string
 
@@ -289,14 +287,16 @@ type GetTask struct {
 // ToTask is GetTask convert to Task
 func (gTask *GetTask) ToTask() (*Task, error) {
 	return &Task{
-		Audience:     gTask.Audience,
-		RelativeURI:  gTask.RelativeURI,
-		Headers:      gTask.Headers,
-		Method:       http.MethodGet,
-		ScheduleTime: gTask.ScheduleTime,
-		Deadline:     gTask.Deadline,
-		Body:         nil,
-		Name:         gTask.Name,
+		Audience:          gTask.Audience,
+		AuthorizationType: gTask.AuthorizationType,
+		OAuthScope:        gTask.OAuthScope,
+		RelativeURI:       gTask.RelativeURI,
+		Headers:           gTask.Headers,
+		Method:            http.MethodGet,
+		ScheduleTime:      gTask.ScheduleTime,
+		Deadline:          gTask.Deadline,
+		Body:              nil,
+		Name:              gTask.Name,
 	}, nil
 }
 
@@ -307,17 +307,15 @@ func (s *Service) CreateGetTask(ctx context.Context, queue *Queue, task *GetTask
 		task.ScheduleTime = task.ScheduledTime
 	}
 
-	got, err := s.CreateTask(ctx, queue, task.Name, &taskspb.HttpRequest{
+	req := &taskspb.HttpRequest{
 		Url:        task.RelativeURI,
 		Headers:    task.Headers,
 		HttpMethod: taskspb.HttpMethod_GET,
-		AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
-			OidcToken: &taskspb.OidcToken{
-				ServiceAccountEmail: s.serviceAccountEmail,
-				Audience:            task.Audience,
-			},
-		},
-	}, task.ScheduleTime, task.Deadline, ops...)
+	}
+	if err := setAuthorizationHeader(req, s.serviceAccountEmail, task.AuthorizationType, task.Audience, task.OAuthScope); err != nil {
+		return "", err
+	}
+	got, err := s.CreateTask(ctx, queue, task.Name, req, task.ScheduleTime, task.Deadline, ops...)
 	if err != nil {
 		return "", fmt.Errorf("failed CreateJsonPostTask(). queue=%+v, url=%s : %w", queue, task.RelativeURI, err)
 	}
@@ -325,27 +323,246 @@ func (s *Service) CreateGetTask(ctx context.Context, queue *Queue, task *GetTask
 }
 
 // CreateGetTaskMulti is Queue に GetTask を作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
 func (s *Service) CreateGetTaskMulti(ctx context.Context, queue *Queue, tasks []*GetTask, ops ...CreateTaskOptions) ([]string, error) {
-	results := make([]string, len(tasks))
-	merr := MultiError{}
-	wg := &sync.WaitGroup{}
-	for i, task := range tasks {
-		wg.Add(1)
-		go func(i int, task *GetTask) {
-			defer wg.Done()
-			tn, err := s.CreateGetTask(ctx, queue, task, ops...)
-			if err != nil {
-				appErr := &Error{}
-				if errors.As(err, &appErr) && appErr.Code == ErrAlreadyExists.Code {
-					appErr.KV["index"] = i
-					merr.Append(appErr)
-					return
-				}
-				merr.Append(NewErrCreateMultiTask("failed CreateGetTask", map[string]interface{}{"index": i, "taskName": task.Name, "URI": task.RelativeURI}, err))
-			}
-			results[i] = tn
-		}(i, task)
+	return createMulti(len(tasks), ops, func(i int) (string, error) {
+		return s.CreateGetTask(ctx, queue, tasks[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed CreateGetTask", map[string]interface{}{"index": i, "taskName": tasks[i].Name, "URI": tasks[i].RelativeURI}, err)
+	})
+}
+
+const (
+	// ContentTypeJSON is JsonPostTask の Content-Type
+	ContentTypeJSON = "application/json"
+
+	// ContentTypeProtobuf is ProtoTask の Content-Type
+	ContentTypeProtobuf = "application/x-protobuf"
+
+	// ContentTypeForm is FormTask の Content-Type
+	ContentTypeForm = "application/x-www-form-urlencoded"
+)
+
+// CreateHttpTask is Task の Method, Headers, Body をそのまま使って Task を作る
+// PUT, DELETE など JsonPostTask, GetTask 以外の Method の Task を作る時に使う
+func (s *Service) CreateHttpTask(ctx context.Context, queue *Queue, task *Task, ops ...CreateTaskOptions) (string, error) {
+	method, err := httpMethodToProto(task.Method)
+	if err != nil {
+		return "", err
+	}
+	req := &taskspb.HttpRequest{
+		Url:        task.RelativeURI,
+		Headers:    task.Headers,
+		HttpMethod: method,
+		Body:       task.Body,
+	}
+	if err := setAuthorizationHeader(req, s.serviceAccountEmail, task.AuthorizationType, task.Audience, task.OAuthScope); err != nil {
+		return "", err
+	}
+	got, err := s.CreateTask(ctx, queue, task.Name, req, task.ScheduleTime, task.Deadline, ops...)
+	if err != nil {
+		return "", fmt.Errorf("failed CreateHttpTask(). queue=%+v, method=%s, url=%s : %w", queue, task.Method, task.RelativeURI, err)
+	}
+	return got.Name, nil
+}
+
+// CreateHttpTaskMulti is Queue に Task を複数作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
+func (s *Service) CreateHttpTaskMulti(ctx context.Context, queue *Queue, tasks []*Task, ops ...CreateTaskOptions) ([]string, error) {
+	return createMulti(len(tasks), ops, func(i int) (string, error) {
+		return s.CreateHttpTask(ctx, queue, tasks[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed CreateHttpTask", map[string]interface{}{"index": i, "taskName": tasks[i].Name, "URI": tasks[i].RelativeURI}, err)
+	})
+}
+
+// ProtoTask is proto.Message を Body に入れる Task
+type ProtoTask struct {
+	// OIDC の Audience
+	//
+	// IAPに向けて投げる時は、IAPのClient IDを指定する
+	// https://cloud.google.com/iap/docs/authentication-howto#authenticating_from_a_service_account
+	//
+	// Cloud Run.Invokerに投げる場合は RelativeURI と同じものを指定する
+	Audience string
+
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+
+	// Task Request の Header
+	// Content-Type は application/x-protobuf になる
+	Headers map[string]string
+
+	// Task が到達する Handler の URL
+	RelativeURI string
+
+	// HTTP Method
+	// optional 省略した場合は POST になる
+	Method string
+
+	// ScheduleTime is estimated time of arrival
+	ScheduleTime time.Time
+
+	// HandlerのDeadline
+	// default は 10min 最長は 30min
+	Deadline time.Duration
+
+	// Task Body
+	// 中で proto.Marshal する
+	Body proto.Message
+
+	// Name is Task Name
+	// optional
+	// Task の重複を抑制するために指定するTaskのName
+	// 中で projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式にしているので指定するのは {TASK_ID} の部分だけ
+	// 未指定の場合は自動的に設定される
+	Name string
+}
+
+// ToTask is ProtoTask convert to Task
+func (pTask *ProtoTask) ToTask() (*Task, error) {
+	var body []byte
+	if pTask.Body != nil {
+		b, err := proto.Marshal(pTask.Body)
+		if err != nil {
+			return nil, fmt.Errorf("failed ProtoTask convert to Task :%w", err)
+		}
+		body = b
+	}
+	return &Task{
+		Audience:          pTask.Audience,
+		AuthorizationType: pTask.AuthorizationType,
+		OAuthScope:        pTask.OAuthScope,
+		Headers:           headersWithContentType(pTask.Headers, ContentTypeProtobuf),
+		RelativeURI:       pTask.RelativeURI,
+		Method:            pTask.Method,
+		ScheduleTime:      pTask.ScheduleTime,
+		Deadline:          pTask.Deadline,
+		Body:              body,
+		Name:              pTask.Name,
+	}, nil
+}
+
+// CreateProtoTask is Body に proto.Message を入れる Task を作る
+func (s *Service) CreateProtoTask(ctx context.Context, queue *Queue, task *ProtoTask, ops ...CreateTaskOptions) (string, error) {
+	t, err := task.ToTask()
+	if err != nil {
+		return "", err
+	}
+	return s.CreateHttpTask(ctx, queue, t, ops...)
+}
+
+// CreateProtoTaskMulti is Queue に ProtoTask を複数作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
+func (s *Service) CreateProtoTaskMulti(ctx context.Context, queue *Queue, tasks []*ProtoTask, ops ...CreateTaskOptions) ([]string, error) {
+	return createMulti(len(tasks), ops, func(i int) (string, error) {
+		return s.CreateProtoTask(ctx, queue, tasks[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed CreateProtoTask", map[string]interface{}{"index": i, "taskName": tasks[i].Name, "URI": tasks[i].RelativeURI}, err)
+	})
+}
+
+// FormTask is url.Values を form-urlencoded にして Body に入れる Task
+type FormTask struct {
+	// OIDC の Audience
+	//
+	// IAPに向けて投げる時は、IAPのClient IDを指定する
+	// https://cloud.google.com/iap/docs/authentication-howto#authenticating_from_a_service_account
+	//
+	// Cloud Run.Invokerに投げる場合は RelativeURI と同じものを指定する
+	Audience string
+
+	// AuthorizationType is Handler に Request する時に付与する Authorization Header の種類
+	// optional 省略した場合は AuthorizationOIDC になる
+	AuthorizationType AuthorizationType
+
+	// OAuthScope is AuthorizationOAuth の時の OAuth Scope
+	// optional 省略した場合は https://www.googleapis.com/auth/cloud-platform になる
+	OAuthScope string
+
+	// Task Request の Header
+	// Content-Type は application/x-www-form-urlencoded になる
+	Headers map[string]string
+
+	// Task が到達する Handler の URL
+	RelativeURI string
+
+	// HTTP Method
+	// optional 省略した場合は POST になる
+	Method string
+
+	// ScheduleTime is estimated time of arrival
+	ScheduleTime time.Time
+
+	// HandlerのDeadline
+	// default は 10min 最長は 30min
+	Deadline time.Duration
+
+	// Task Body
+	// 中で url.Values.Encode する
+	Body url.Values
+
+	// Name is Task Name
+	// optional
+	// Task の重複を抑制するために指定するTaskのName
+	// 中で projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式にしているので指定するのは {TASK_ID} の部分だけ
+	// 未指定の場合は自動的に設定される
+	Name string
+}
+
+// ToTask is FormTask convert to Task
+func (fTask *FormTask) ToTask() (*Task, error) {
+	return &Task{
+		Audience:          fTask.Audience,
+		AuthorizationType: fTask.AuthorizationType,
+		OAuthScope:        fTask.OAuthScope,
+		Headers:           headersWithContentType(fTask.Headers, ContentTypeForm),
+		RelativeURI:       fTask.RelativeURI,
+		Method:            fTask.Method,
+		ScheduleTime:      fTask.ScheduleTime,
+		Deadline:          fTask.Deadline,
+		Body:              []byte(fTask.Body.Encode()),
+		Name:              fTask.Name,
+	}, nil
+}
+
+// CreateFormTask is Body に form-urlencoded な値を入れる Task を作る
+func (s *Service) CreateFormTask(ctx context.Context, queue *Queue, task *FormTask, ops ...CreateTaskOptions) (string, error) {
+	t, err := task.ToTask()
+	if err != nil {
+		return "", err
+	}
+	return s.CreateHttpTask(ctx, queue, t, ops...)
+}
+
+// CreateFormTaskMulti is Queue に FormTask を複数作成する
+// WithMaxInFlight, WithRateLimit, WithRetry を指定すると CreateTask の Quota を超えないように実行する
+// 失敗した Task は index を KV に入れて MultiError で返す
+func (s *Service) CreateFormTaskMulti(ctx context.Context, queue *Queue, tasks []*FormTask, ops ...CreateTaskOptions) ([]string, error) {
+	return createMulti(len(tasks), ops, func(i int) (string, error) {
+		return s.CreateFormTask(ctx, queue, tasks[i], ops...)
+	}, func(i int, err error) *Error {
+		return NewErrCreateMultiTask("failed CreateFormTask", map[string]interface{}{"index": i, "taskName": tasks[i].Name, "URI": tasks[i].RelativeURI}, err)
+	})
+}
+
+// headersWithContentType is headers をコピーして Content-Type を設定する
+func headersWithContentType(headers map[string]string, contentType string) map[string]string {
+	ret := make(map[string]string, len(headers)+1)
+	for k, v := range headers {
+		if http.CanonicalHeaderKey(k) == "Content-Type" {
+			continue
+		}
+		ret[k] = v
 	}
-	wg.Wait()
-	return results, merr.ErrorOrNil()
+	ret["Content-Type"] = contentType
+	return ret
 }
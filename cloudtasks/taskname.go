@@ -0,0 +1,39 @@
+package cloudtasks
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/dgryski/go-farm"
+)
+
+// ContentTaskName is uri と body の内容から {TASK_ID} を生成する
+//
+// 同じ内容であれば同じ Name になるので、 WithIgnoreAlreadyExists と組み合わせると同じ Task を重複して作成しないようにできる
+// window を指定すると now を window 単位で区切った時間帯ごとに別の Name になるので、「5分に1回まで」のような制御ができる
+// window が 0 の場合は時間帯で区切らない
+//
+// Cloud Tasks は連続した Prefix を持つ Name だと Latency が悪化するので、 Hash を先頭に置いている
+// https://cloud.google.com/tasks/docs/reference/rest/v2/projects.locations.queues.tasks/create#body.request_body.FIELDS.task
+func ContentTaskName(uri string, body []byte, window time.Duration, now time.Time) string {
+	var bucket int64
+	if window > 0 {
+		bucket = now.UnixNano() / int64(window)
+	}
+
+	buf := make([]byte, 0, len(uri)+len(body)+32)
+	buf = append(buf, uri...)
+	buf = append(buf, 0)
+	buf = append(buf, body...)
+	buf = append(buf, 0)
+	buf = append(buf, fmt.Sprintf("%d-%d", int64(window), bucket)...)
+	return fmt.Sprintf("%016x-%d", farm.Fingerprint64(buf), bucket)
+}
+
+// HashPrefixTaskName is name の Hash を先頭に付けた {TASK_ID} を返す
+//
+// 連番などの連続した Name を分散させるために使う. 同じ name であれば同じ {TASK_ID} になる
+// https://cloud.google.com/tasks/docs/reference/rest/v2/projects.locations.queues.tasks/create#body.request_body.FIELDS.task
+func HashPrefixTaskName(name string) string {
+	return fmt.Sprintf("%016x-%s", farm.Fingerprint64([]byte(name)), name)
+}
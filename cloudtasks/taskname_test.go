@@ -0,0 +1,54 @@
+package cloudtasks
+
+import (
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestContentTaskName(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+	const uri = "https://example.com/tq/hoge"
+	body := []byte(`{"Content":"Hello"}`)
+
+	name := ContentTaskName(uri, body, 5*time.Minute, base)
+	if !regexp.MustCompile(`^[0-9a-f]{16}-[0-9]+$`).MatchString(name) {
+		t.Errorf("invalid task name format %s", name)
+	}
+
+	cases := []struct {
+		name  string
+		other string
+		same  bool
+	}{
+		{"same window", ContentTaskName(uri, body, 5*time.Minute, base.Add(4*time.Minute)), true},
+		{"next window", ContentTaskName(uri, body, 5*time.Minute, base.Add(5*time.Minute)), false},
+		{"other body", ContentTaskName(uri, []byte(`{"Content":"World"}`), 5*time.Minute, base), false},
+		{"other uri", ContentTaskName("https://example.com/tq/fuga", body, 5*time.Minute, base), false},
+		{"other window", ContentTaskName(uri, body, 10*time.Minute, base), false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if e, g := tt.same, name == tt.other; e != g {
+				t.Errorf("want same %v but got %s, %s", e, name, tt.other)
+			}
+		})
+	}
+
+	if e, g := ContentTaskName(uri, body, 0, base), ContentTaskName(uri, body, 0, base.Add(24*time.Hour)); e != g {
+		t.Errorf("want same name without window but got %s, %s", e, g)
+	}
+}
+
+func TestHashPrefixTaskName(t *testing.T) {
+	name := HashPrefixTaskName("nightly-0")
+	if !regexp.MustCompile(`^[0-9a-f]{16}-nightly-0$`).MatchString(name) {
+		t.Errorf("invalid task name format %s", name)
+	}
+	if e, g := name, HashPrefixTaskName("nightly-0"); e != g {
+		t.Errorf("want same name %s but got %s", e, g)
+	}
+	if name[:16] == HashPrefixTaskName("nightly-1")[:16] {
+		t.Errorf("want different prefix but got %s", name)
+	}
+}
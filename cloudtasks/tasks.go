@@ -0,0 +1,245 @@
+package cloudtasks
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const defaultListTasksPageSize = 100
+
+const defaultListTasksMaxPages = 10
+
+type taskReadOptions struct {
+	pageSize         int
+	maxPages         int
+	pageToken        string
+	scheduleTimeFrom time.Time
+	scheduleTimeTo   time.Time
+	namePrefix       string
+	fullView         bool
+}
+
+// TaskReadOptions is ListTasks, GetTask, RunTask に利用する options
+type TaskReadOptions func(*taskReadOptions)
+
+// WithPageSize is ListTasks で 1 回に取得する Task の数を指定する
+// Filter を指定した場合は pageSize 件以上 Match するか最後の Page になるまで次の Page を取得する
+// 取得した Page の Task は全て返すので、返ってくる Task の数はこれより多くなることがある
+func WithPageSize(pageSize int) TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.pageSize = pageSize
+	}
+}
+
+// WithMaxPages is Filter を指定した ListTasks で 1 回に取得する Page の数の上限を指定する
+// 上限に達した場合は Match した Task が pageSize 件未満でも nextPageToken を返すので、 WithPageToken に指定して続きを取得する
+// 指定しない場合は 10 Page まで取得する
+func WithMaxPages(maxPages int) TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.maxPages = maxPages
+	}
+}
+
+// WithPageToken is ListTasks で前回返ってきた nextPageToken を指定して続きを取得する
+func WithPageToken(pageToken string) TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.pageToken = pageToken
+	}
+}
+
+// WithScheduleTimeRange is ListTasks で ScheduleTime が from 以上 to 未満の Task だけを返す
+// zero value を指定した側は制限しない
+func WithScheduleTimeRange(from time.Time, to time.Time) TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.scheduleTimeFrom = from
+		ops.scheduleTimeTo = to
+	}
+}
+
+// WithTaskNamePrefix is ListTasks で {TASK_ID} が prefix で始まる Task だけを返す
+func WithTaskNamePrefix(prefix string) TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.namePrefix = prefix
+	}
+}
+
+// WithFullView is Body を含めた全ての情報を取得する
+// cloudtasks.tasks.fullView の権限が必要
+func WithFullView() TaskReadOptions {
+	return func(ops *taskReadOptions) {
+		ops.fullView = true
+	}
+}
+
+func (opt *taskReadOptions) view() taskspb.Task_View {
+	if opt.fullView {
+		return taskspb.Task_FULL
+	}
+	return taskspb.Task_BASIC
+}
+
+func (opt *taskReadOptions) match(task *Task) bool {
+	if !opt.scheduleTimeFrom.IsZero() && task.ScheduleTime.Before(opt.scheduleTimeFrom) {
+		return false
+	}
+	if !opt.scheduleTimeTo.IsZero() && !task.ScheduleTime.Before(opt.scheduleTimeTo) {
+		return false
+	}
+	if len(opt.namePrefix) > 0 && !strings.HasPrefix(task.Name, opt.namePrefix) {
+		return false
+	}
+	return true
+}
+
+func (opt *taskReadOptions) filtered() bool {
+	return !opt.scheduleTimeFrom.IsZero() || !opt.scheduleTimeTo.IsZero() || len(opt.namePrefix) > 0
+}
+
+// ListTasks is Queue にある Task を取得する
+// 続きがある場合は nextPageToken を返すので、 WithPageToken に指定して次の Page を取得する
+// Filter を指定した場合は Match した Task が pageSize 件以上になるか、最後の Page か WithMaxPages の上限になるまで続けて取得する
+// Task は HTTP Task だけを返す。 App Engine Task など Task に変換できない Task は error にせずに読み飛ばすので、返ってくる Task の数は pageSize より少なくなることがある
+func (s *Service) ListTasks(ctx context.Context, queue *Queue, ops ...TaskReadOptions) (tasks []*Task, nextPageToken string, err error) {
+	opt := taskReadOptions{
+		pageSize: defaultListTasksPageSize,
+		maxPages: defaultListTasksMaxPages,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	tasks = []*Task{}
+	nextPageToken = opt.pageToken
+	for page := 1; ; page++ {
+		it := s.taskClient.ListTasks(ctx, &taskspb.ListTasksRequest{
+			Parent:       queue.Parent(),
+			ResponseView: opt.view(),
+		})
+		var pbTasks []*taskspb.Task
+		nextPageToken, err = iterator.NewPager(it, opt.pageSize, nextPageToken).NextPage(&pbTasks)
+		if err != nil {
+			return nil, "", s.queueError("ListTasks", queue, err)
+		}
+
+		for _, pbTask := range pbTasks {
+			task, err := TaskProtoToTask(pbTask)
+			if err != nil {
+				continue
+			}
+			if !opt.match(task) {
+				continue
+			}
+			tasks = append(tasks, task)
+		}
+		// Page の途中で止めると nextPageToken で続きを取得できないので、 Page 単位で判定する
+		if !opt.filtered() || len(tasks) >= opt.pageSize || len(nextPageToken) < 1 || page >= opt.maxPages {
+			return tasks, nextPageToken, nil
+		}
+	}
+}
+
+// GetTask is Task を取得する
+// taskName には {TASK_ID} の部分を指定する
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) GetTask(ctx context.Context, queue *Queue, taskName string, ops ...TaskReadOptions) (*Task, error) {
+	opt := taskReadOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	task, err := s.taskClient.GetTask(ctx, &taskspb.GetTaskRequest{
+		Name:         queue.taskName(taskName),
+		ResponseView: opt.view(),
+	})
+	if err != nil {
+		return nil, s.taskError("GetTask", queue, taskName, err)
+	}
+	return TaskProtoToTask(task)
+}
+
+// DeleteTask is Task を削除する
+// taskName には {TASK_ID} の部分を指定する
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) DeleteTask(ctx context.Context, queue *Queue, taskName string) error {
+	err := s.taskClient.DeleteTask(ctx, &taskspb.DeleteTaskRequest{
+		Name: queue.taskName(taskName),
+	})
+	if err != nil {
+		return s.taskError("DeleteTask", queue, taskName, err)
+	}
+	return nil
+}
+
+// RunTask is ScheduleTime や RateLimits, Queue の一時停止を無視して、Task をすぐに dispatch する
+// taskName には {TASK_ID} の部分を指定する
+// 存在しない場合は ErrNotFound を返す
+func (s *Service) RunTask(ctx context.Context, queue *Queue, taskName string, ops ...TaskReadOptions) (*Task, error) {
+	opt := taskReadOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	task, err := s.taskClient.RunTask(ctx, &taskspb.RunTaskRequest{
+		Name:         queue.taskName(taskName),
+		ResponseView: opt.view(),
+	})
+	if err != nil {
+		return nil, s.taskError("RunTask", queue, taskName, err)
+	}
+	return TaskProtoToTask(task)
+}
+
+// TaskProtoToTask is taskspb.Task から Task に変換する
+// Name には {TASK_ID} の部分を設定する
+func TaskProtoToTask(task *taskspb.Task) (*Task, error) {
+	httpReq := task.GetHttpRequest()
+	if httpReq == nil {
+		return nil, NewErrInvalidArgument("http request is required", map[string]interface{}{"taskName": task.GetName()}, nil)
+	}
+
+	method, err := HttpMethodProtoToHttpMethod(httpReq.GetHttpMethod())
+	if err != nil {
+		return nil, err
+	}
+
+	authorizationType, audience, scope := authorizationFromHttpRequest(httpReq)
+	ret := &Task{
+		Audience:          audience,
+		AuthorizationType: authorizationType,
+		OAuthScope:        scope,
+		Headers:           httpReq.GetHeaders(),
+		RelativeURI:       httpReq.GetUrl(),
+		Method:            method,
+		Deadline:          task.GetDispatchDeadline().AsDuration(),
+		Body:              httpReq.GetBody(),
+		Name:              task.GetName()[strings.LastIndex(task.GetName(), "/")+1:],
+		DispatchCount:     task.GetDispatchCount(),
+		ResponseCount:     task.GetResponseCount(),
+	}
+	if task.GetScheduleTime() != nil {
+		ret.ScheduleTime = task.GetScheduleTime().AsTime()
+	}
+	if task.GetCreateTime() != nil {
+		ret.CreateTime = task.GetCreateTime().AsTime()
+	}
+	return ret, nil
+}
+
+// taskName is projects/{PROJECT_ID}/locations/{LOCATION}/queues/{QUEUE_ID}/tasks/{TASK_ID} 形式の値を返す
+func (q *Queue) taskName(taskID string) string {
+	return fmt.Sprintf("%s/tasks/%s", q.Parent(), taskID)
+}
+
+func (s *Service) taskError(method string, queue *Queue, taskName string, err error) error {
+	if status.Code(err) == codes.NotFound {
+		return NewErrNotFound(fmt.Sprintf("%s is not found.", queue.taskName(taskName)), map[string]interface{}{"queue": queue.Parent(), "taskName": taskName}, err)
+	}
+	return fmt.Errorf("failed %s(). queue=%+v, taskName=%s : %w", method, queue, taskName, err)
+}
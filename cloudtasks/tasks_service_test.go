@@ -0,0 +1,136 @@
+package cloudtasks_test
+
+import (
+	"context"
+	"fmt"
+	"testing"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/faker"
+)
+
+func TestService_ListTasks_Filter(t *testing.T) {
+	ctx := context.Background()
+
+	tasksFaker := faker.NewFaker(t)
+	defer tasksFaker.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, tasksFaker.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskClient.Close()
+	s, err := tasksbox.NewService(ctx, taskClient, "hoge@unittest.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := &tasksbox.Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+
+	// HTTP Task に変換できない App Engine Task を混ぜておく
+	if _, err := taskClient.CreateTask(ctx, &taskspb.CreateTaskRequest{
+		Parent: queue.Parent(),
+		Task: &taskspb.Task{
+			Name: fmt.Sprintf("%s/tasks/appengine", queue.Parent()),
+			MessageType: &taskspb.Task_AppEngineHttpRequest{
+				AppEngineHttpRequest: &taskspb.AppEngineHttpRequest{RelativeUri: "/tq/hoge"},
+			},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"a0", "b0", "b1", "a1", "a2", "b2"} {
+		if _, err := s.CreateGetTask(ctx, queue, &tasksbox.GetTask{Name: name, RelativeURI: "/tq/hoge"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	taskNames := func(tasks []*tasksbox.Task) []string {
+		var names []string
+		for _, task := range tasks {
+			names = append(names, task.Name)
+		}
+		return names
+	}
+
+	tasks, nextPageToken, err := s.ListTasks(ctx, queue, tasksbox.WithPageSize(2), tasksbox.WithTaskNamePrefix("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a0", "a1", "a2"}, taskNames(tasks)); diff != "" {
+		t.Errorf("ListTasks (-want +got):\n%s", diff)
+	}
+	if nextPageToken == "" {
+		t.Fatal("want nextPageToken but got empty")
+	}
+
+	tasks, nextPageToken, err = s.ListTasks(ctx, queue, tasksbox.WithPageSize(2), tasksbox.WithTaskNamePrefix("a"), tasksbox.WithPageToken(nextPageToken))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, len(tasks); e != g {
+		t.Errorf("want tasks length %d but got %d", e, g)
+	}
+	if nextPageToken != "" {
+		t.Errorf("want empty nextPageToken but got %s", nextPageToken)
+	}
+
+	// Filter が無い場合は 1 Page だけ取得して、変換できない Task は読み飛ばす
+	tasks, _, err = s.ListTasks(ctx, queue, tasksbox.WithPageSize(2))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if diff := cmp.Diff([]string{"a0"}, taskNames(tasks)); diff != "" {
+		t.Errorf("ListTasks (-want +got):\n%s", diff)
+	}
+}
+
+func TestService_ListTasks_MaxPages(t *testing.T) {
+	ctx := context.Background()
+
+	tasksFaker := faker.NewFaker(t)
+	defer tasksFaker.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, tasksFaker.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer taskClient.Close()
+	s, err := tasksbox.NewService(ctx, taskClient, "hoge@unittest.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := &tasksbox.Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+
+	for _, name := range []string{"b0", "b1", "b2", "b3", "a0"} {
+		if _, err := s.CreateGetTask(ctx, queue, &tasksbox.GetTask{Name: name, RelativeURI: "/tq/hoge"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// 2 Page 読んだところで止めて、続きの nextPageToken を返す
+	tasks, nextPageToken, err := s.ListTasks(ctx, queue, tasksbox.WithPageSize(1), tasksbox.WithMaxPages(2), tasksbox.WithTaskNamePrefix("a"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, len(tasks); e != g {
+		t.Errorf("want tasks length %d but got %d", e, g)
+	}
+	if nextPageToken == "" {
+		t.Fatal("want nextPageToken but got empty")
+	}
+
+	var names []string
+	for len(nextPageToken) > 0 {
+		tasks, nextPageToken, err = s.ListTasks(ctx, queue, tasksbox.WithPageSize(1), tasksbox.WithMaxPages(2), tasksbox.WithTaskNamePrefix("a"), tasksbox.WithPageToken(nextPageToken))
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, task := range tasks {
+			names = append(names, task.Name)
+		}
+	}
+	if diff := cmp.Diff([]string{"a0"}, names); diff != "" {
+		t.Errorf("ListTasks (-want +got):\n%s", diff)
+	}
+}
@@ -0,0 +1,103 @@
+package cloudtasks
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/protobuf/types/known/durationpb"
+	"google.golang.org/protobuf/types/known/timestamppb"
+)
+
+func TestTaskProtoToTask(t *testing.T) {
+	scheduleTime := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+	createTime := time.Date(2024, 1, 2, 3, 0, 0, 0, time.UTC)
+
+	got, err := TaskProtoToTask(&taskspb.Task{
+		Name: "projects/unittest/locations/asia-northeast1/queues/testqueue/tasks/hellotask",
+		MessageType: &taskspb.Task_HttpRequest{
+			HttpRequest: &taskspb.HttpRequest{
+				Url:        "https://example.com/tq/hoge",
+				HttpMethod: taskspb.HttpMethod_POST,
+				Headers:    map[string]string{"Content-Type": "application/json"},
+				Body:       []byte(`{"Content":"Hello"}`),
+				AuthorizationHeader: &taskspb.HttpRequest_OidcToken{
+					OidcToken: &taskspb.OidcToken{
+						ServiceAccountEmail: "hoge@unittest.iam.gserviceaccount.com",
+						Audience:            "https://example.com",
+					},
+				},
+			},
+		},
+		ScheduleTime:     timestamppb.New(scheduleTime),
+		CreateTime:       timestamppb.New(createTime),
+		DispatchDeadline: durationpb.New(30 * time.Second),
+		DispatchCount:    3,
+		ResponseCount:    2,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := &Task{
+		Audience:      "https://example.com",
+		Headers:       map[string]string{"Content-Type": "application/json"},
+		RelativeURI:   "https://example.com/tq/hoge",
+		Method:        http.MethodPost,
+		ScheduleTime:  scheduleTime,
+		Deadline:      30 * time.Second,
+		Body:          []byte(`{"Content":"Hello"}`),
+		Name:          "hellotask",
+		CreateTime:    createTime,
+		DispatchCount: 3,
+		ResponseCount: 2,
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("diff (-want +got):\n%s", diff)
+	}
+}
+
+func TestTaskProtoToTask_AppEngine(t *testing.T) {
+	_, err := TaskProtoToTask(&taskspb.Task{
+		Name: "projects/unittest/locations/asia-northeast1/queues/testqueue/tasks/hellotask",
+		MessageType: &taskspb.Task_AppEngineHttpRequest{
+			AppEngineHttpRequest: &taskspb.AppEngineHttpRequest{RelativeUri: "/tq/hoge"},
+		},
+	})
+	if !ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
+
+func TestTaskReadOptions_match(t *testing.T) {
+	base := time.Date(2024, 1, 2, 3, 4, 5, 0, time.UTC)
+
+	cases := []struct {
+		name string
+		ops  []TaskReadOptions
+		task *Task
+		want bool
+	}{
+		{"no filter", nil, &Task{Name: "hoge", ScheduleTime: base}, true},
+		{"prefix match", []TaskReadOptions{WithTaskNamePrefix("ho")}, &Task{Name: "hoge"}, true},
+		{"prefix unmatch", []TaskReadOptions{WithTaskNamePrefix("fu")}, &Task{Name: "hoge"}, false},
+		{"in range", []TaskReadOptions{WithScheduleTimeRange(base, base.Add(time.Minute))}, &Task{ScheduleTime: base}, true},
+		{"before from", []TaskReadOptions{WithScheduleTimeRange(base, base.Add(time.Minute))}, &Task{ScheduleTime: base.Add(-time.Second)}, false},
+		{"equal to", []TaskReadOptions{WithScheduleTimeRange(base, base.Add(time.Minute))}, &Task{ScheduleTime: base.Add(time.Minute)}, false},
+		{"open from", []TaskReadOptions{WithScheduleTimeRange(time.Time{}, base)}, &Task{ScheduleTime: base.Add(-time.Hour)}, true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			opt := taskReadOptions{}
+			for _, o := range tt.ops {
+				o(&opt)
+			}
+			if e, g := tt.want, opt.match(tt.task); e != g {
+				t.Errorf("want %v but got %v", e, g)
+			}
+		})
+	}
+}
@@ -0,0 +1,140 @@
+package cloudtasks
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"maps"
+	"net/http"
+	"strconv"
+	"strings"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	"go.opencensus.io/plugin/ochttp/propagation/tracecontext"
+	octrace "go.opencensus.io/trace"
+)
+
+const (
+
+	// TraceParent is W3C Trace Context の traceparent Header Key
+	TraceParent = "traceparent"
+
+	// TraceState is W3C Trace Context の tracestate Header Key
+	TraceState = "tracestate"
+
+	// CloudTraceContext is X-Cloud-Trace-Context Header Key
+	// https://cloud.google.com/trace/docs/trace-context#legacy-http-header
+	CloudTraceContext = "X-Cloud-Trace-Context"
+)
+
+var traceContextFormat = &tracecontext.HTTPFormat{}
+
+// injectTraceContext is ctx の Span を Task の Header に traceparent, X-Cloud-Trace-Context として設定する
+// Span が無い場合と、既に Header が指定されている場合は何もしない
+// Task の Headers は呼び出し元の map をそのまま参照していることがあるので、 Copy した map に設定して Task に入れ直す
+func injectTraceContext(ctx context.Context, task *taskspb.Task) {
+	span := octrace.FromContext(ctx)
+	if span == nil {
+		return
+	}
+	sc := span.SpanContext()
+
+	var src map[string]string
+	switch {
+	case task.GetHttpRequest() != nil:
+		src = task.GetHttpRequest().Headers
+	case task.GetAppEngineHttpRequest() != nil:
+		src = task.GetAppEngineHttpRequest().Headers
+	default:
+		return
+	}
+	for k := range src {
+		switch http.CanonicalHeaderKey(k) {
+		case http.CanonicalHeaderKey(TraceParent), http.CanonicalHeaderKey(CloudTraceContext):
+			return
+		}
+	}
+
+	headers := maps.Clone(src)
+	if headers == nil {
+		headers = map[string]string{}
+	}
+	tp, ts := traceContextFormat.SpanContextToHeaders(sc)
+	headers[TraceParent] = tp
+	if len(ts) > 0 {
+		headers[TraceState] = ts
+	}
+	headers[CloudTraceContext] = formatCloudTraceContext(sc)
+
+	switch {
+	case task.GetHttpRequest() != nil:
+		task.GetHttpRequest().Headers = headers
+	case task.GetAppEngineHttpRequest() != nil:
+		task.GetAppEngineHttpRequest().Headers = headers
+	}
+}
+
+// SpanContextFromRequest is Task を作成した時の Span Context を Request Header から取得する
+// traceparent を優先し、無い場合は X-Cloud-Trace-Context を利用する
+func SpanContextFromRequest(r *http.Request) (octrace.SpanContext, bool) {
+	if sc, ok := traceContextFormat.SpanContextFromRequest(r); ok {
+		return sc, true
+	}
+	return parseCloudTraceContext(r.Header.Get(CloudTraceContext))
+}
+
+// StartSpanFromRequest is Task を作成した時の Span を親にした Span を開始する
+// Task を作成した Request から Handler までを 1 つの Trace として Cloud Trace で追えるようにする
+// Header に Span Context が無い場合は、新しい Trace として開始する
+// 返した Span は Handler の処理が終わったら End() する
+func StartSpanFromRequest(r *http.Request, name string) (context.Context, *octrace.Span) {
+	ctx := r.Context()
+	if sc, ok := SpanContextFromRequest(r); ok {
+		ctx = trace.StartSpanWithRemoteParent(ctx, name, sc)
+	} else {
+		ctx = trace.StartSpan(ctx, name)
+	}
+	return ctx, octrace.FromContext(ctx)
+}
+
+// formatCloudTraceContext is X-Cloud-Trace-Context の TRACE_ID/SPAN_ID;o=OPTIONS 形式にする
+// SPAN_ID は 10 進数
+func formatCloudTraceContext(sc octrace.SpanContext) string {
+	var o int
+	if sc.IsSampled() {
+		o = 1
+	}
+	return fmt.Sprintf("%s/%d;o=%d", hex.EncodeToString(sc.TraceID[:]), binary.BigEndian.Uint64(sc.SpanID[:]), o)
+}
+
+// parseCloudTraceContext is X-Cloud-Trace-Context の値から Span Context を取得する
+func parseCloudTraceContext(v string) (octrace.SpanContext, bool) {
+	var sc octrace.SpanContext
+	if len(v) < 1 {
+		return sc, false
+	}
+
+	traceID, rest, ok := strings.Cut(v, "/")
+	if !ok {
+		return sc, false
+	}
+	b, err := hex.DecodeString(traceID)
+	if err != nil || len(b) != len(sc.TraceID) {
+		return sc, false
+	}
+	copy(sc.TraceID[:], b)
+
+	spanID, options, _ := strings.Cut(rest, ";")
+	sid, err := strconv.ParseUint(spanID, 10, 64)
+	if err != nil {
+		return octrace.SpanContext{}, false
+	}
+	binary.BigEndian.PutUint64(sc.SpanID[:], sid)
+
+	if options == "o=1" {
+		sc.TraceOptions = octrace.TraceOptions(1)
+	}
+	return sc, true
+}
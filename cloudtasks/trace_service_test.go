@@ -0,0 +1,66 @@
+package cloudtasks_test
+
+import (
+	"context"
+	"net/http"
+	"testing"
+
+	cloudtasks "cloud.google.com/go/cloudtasks/apiv2"
+	tasksbox "github.com/sinmetalcraft/gcpbox/cloudtasks"
+	"github.com/sinmetalcraft/gcpbox/cloudtasks/faker"
+	octrace "go.opencensus.io/trace"
+)
+
+func TestService_CreateHttpTask_TraceContextNotModifyHeaders(t *testing.T) {
+	ctx, span := octrace.StartSpan(context.Background(), "test", octrace.WithSampler(octrace.AlwaysSample()))
+	defer span.End()
+
+	tasksFaker := faker.NewFaker(t)
+	defer tasksFaker.Stop()
+	taskClient, err := cloudtasks.NewClient(ctx, tasksFaker.ClientOption())
+	if err != nil {
+		t.Fatal(err)
+	}
+	s, err := tasksbox.NewService(ctx, taskClient, "hoge@unittest.iam.gserviceaccount.com")
+	if err != nil {
+		t.Fatal(err)
+	}
+	queue := &tasksbox.Queue{ProjectID: "unittest", Region: "asia-northeast1", Name: "testqueue"}
+
+	// 全ての Task で同じ map を使い回す
+	headers := map[string]string{"X-Hoge": "hoge"}
+	if _, err := s.CreateHttpTask(ctx, queue, &tasksbox.Task{
+		Method:      http.MethodPut,
+		RelativeURI: "https://example.com/tq/hoge",
+		Headers:     headers,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	var tasks []*tasksbox.Task
+	for i := 0; i < 10; i++ {
+		tasks = append(tasks, &tasksbox.Task{
+			Method:      http.MethodPut,
+			RelativeURI: "https://example.com/tq/hoge",
+			Headers:     headers,
+		})
+	}
+	if _, err := s.CreateHttpTaskMulti(ctx, queue, tasks, tasksbox.WithMaxInFlight(5)); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, g := 1, len(headers); e != g {
+		t.Errorf("want caller headers len %d but got %d. headers=%v", e, g, headers)
+	}
+	for i := 0; i < tasksFaker.GetCreateTaskCallCount(); i++ {
+		task, err := tasksFaker.GetTask(i)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(task.Headers[tasksbox.TraceParent]) < 1 {
+			t.Errorf("%d: want traceparent but got %v", i, task.Headers)
+		}
+		if e, g := "hoge", task.Headers["X-Hoge"]; e != g {
+			t.Errorf("%d: want X-Hoge %s but got %s", i, e, g)
+		}
+	}
+}
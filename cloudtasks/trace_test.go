@@ -0,0 +1,99 @@
+package cloudtasks
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	taskspb "cloud.google.com/go/cloudtasks/apiv2/cloudtaskspb"
+	octrace "go.opencensus.io/trace"
+)
+
+func TestInjectTraceContext(t *testing.T) {
+	ctx, span := octrace.StartSpan(context.Background(), "test", octrace.WithSampler(octrace.AlwaysSample()))
+	defer span.End()
+
+	cases := []struct {
+		name string
+		task *taskspb.Task
+	}{
+		{"http", &taskspb.Task{MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{}}}},
+		{"appengine", &taskspb.Task{MessageType: &taskspb.Task_AppEngineHttpRequest{AppEngineHttpRequest: &taskspb.AppEngineHttpRequest{}}}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			injectTraceContext(ctx, tt.task)
+
+			headers := tt.task.GetHttpRequest().GetHeaders()
+			if tt.task.GetAppEngineHttpRequest() != nil {
+				headers = tt.task.GetAppEngineHttpRequest().GetHeaders()
+			}
+			for _, key := range []string{TraceParent, CloudTraceContext} {
+				r := httptest.NewRequest(http.MethodPost, "/", nil)
+				r.Header.Set(key, headers[key])
+				sc, ok := SpanContextFromRequest(r)
+				if !ok {
+					t.Fatalf("%s: span context not found. headers=%v", key, headers)
+				}
+				if e, g := span.SpanContext(), sc; e.TraceID != g.TraceID || e.SpanID != g.SpanID || e.IsSampled() != g.IsSampled() {
+					t.Errorf("%s: want %+v but got %+v", key, e, g)
+				}
+			}
+		})
+	}
+}
+
+func TestInjectTraceContext_Specified(t *testing.T) {
+	ctx, span := octrace.StartSpan(context.Background(), "test")
+	defer span.End()
+
+	task := &taskspb.Task{MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{
+		Headers: map[string]string{"Traceparent": "00-8776346c8342bf2965086545fc998243-d62f64b9d0de713b-01"},
+	}}}
+	injectTraceContext(ctx, task)
+	if e, g := 1, len(task.GetHttpRequest().GetHeaders()); e != g {
+		t.Errorf("want headers len %d but got %d. headers=%v", e, g, task.GetHttpRequest().GetHeaders())
+	}
+}
+
+func TestInjectTraceContext_NoSpan(t *testing.T) {
+	task := &taskspb.Task{MessageType: &taskspb.Task_HttpRequest{HttpRequest: &taskspb.HttpRequest{}}}
+	injectTraceContext(context.Background(), task)
+	if e, g := 0, len(task.GetHttpRequest().GetHeaders()); e != g {
+		t.Errorf("want headers len %d but got %d", e, g)
+	}
+}
+
+func TestParseCloudTraceContext(t *testing.T) {
+	cases := []struct {
+		name        string
+		v           string
+		wantOK      bool
+		wantSampled bool
+	}{
+		{"sampled", "105445aa7843bc8bf206b12000100000/1;o=1", true, true},
+		{"not sampled", "105445aa7843bc8bf206b12000100000/1;o=0", true, false},
+		{"no options", "105445aa7843bc8bf206b12000100000/1", true, false},
+		{"empty", "", false, false},
+		{"no span id", "105445aa7843bc8bf206b12000100000", false, false},
+		{"invalid trace id", "hoge/1;o=1", false, false},
+		{"invalid span id", "105445aa7843bc8bf206b12000100000/hoge;o=1", false, false},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sc, ok := parseCloudTraceContext(tt.v)
+			if e, g := tt.wantOK, ok; e != g {
+				t.Fatalf("want ok %t but got %t", e, g)
+			}
+			if e, g := tt.wantSampled, sc.IsSampled(); e != g {
+				t.Errorf("want sampled %t but got %t", e, g)
+			}
+			if tt.wantSampled {
+				if e, g := tt.v, formatCloudTraceContext(sc); e != g {
+					t.Errorf("want %s but got %s", e, g)
+				}
+			}
+		})
+	}
+}
@@ -23,6 +23,7 @@ require (
 	github.com/k0kubun/pp v3.0.1+incompatible
 	github.com/sinmetalcraft/gcpfaker v0.4.0
 	go.opencensus.io v0.24.0
+	golang.org/x/time v0.11.0
 	google.golang.org/api v0.232.0
 	google.golang.org/genproto/googleapis/rpc v0.0.0-20250505200425-f936aa4a68b2
 	google.golang.org/grpc v1.72.0
@@ -83,7 +84,6 @@ require (
 	golang.org/x/sync v0.14.0 // indirect
 	golang.org/x/sys v0.32.0 // indirect
 	golang.org/x/text v0.24.0 // indirect
-	golang.org/x/time v0.11.0 // indirect
 	golang.org/x/tools v0.30.0 // indirect
 	golang.org/x/xerrors v0.0.0-20240903120638-7835f813f4da // indirect
 	google.golang.org/genproto v0.0.0-20250303144028-a0af3efb3deb // indirect
@@ -16,6 +16,13 @@ func StartSpan(ctx context.Context, name string) context.Context {
 	return ctx
 }
 
+// StartSpanWithRemoteParent adds a span to the trace with the given name as a child of parent.
+// parent is a span context propagated from another process, such as an enqueuing request.
+func StartSpanWithRemoteParent(ctx context.Context, name string, parent trace.SpanContext) context.Context {
+	ctx, _ = trace.StartSpanWithRemoteParent(ctx, fmt.Sprintf("github.com/sinmetalcraft/gcpbox/%s", name), parent)
+	return ctx
+}
+
 // EndSpan ends a span with the given error.
 func EndSpan(ctx context.Context, err error) {
 	span := trace.FromContext(ctx)
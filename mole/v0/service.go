@@ -9,8 +9,12 @@ import (
 var (
 	// ErrCacheMiss is Cacheに存在しない時に返す
 	ErrCacheMiss = errors.New("mole: cache miss")
+
+	// ErrInvalidArgument is 引数に問題がある時に返す
+	ErrInvalidArgument = errors.New("mole: invalid argument")
 )
 
+// Item is Cache する値
 type Item struct {
 	// Key is 主となるKey
 	Key string
@@ -23,9 +27,11 @@ type Item struct {
 	Value []byte
 
 	// ExpiredAt is 有効期限
+	// Zero の場合は有効期限なし
 	ExpiredAt time.Time
 }
 
+// Service is Key と SurrogateKey で Item を扱う Cache
 type Service interface {
 	Get(ctx context.Context, key string) (*Item, error)
 	GetBySurrogateKey(ctx context.Context, surrogateKey string) ([]*Item, error)
@@ -3,51 +3,379 @@ package mole
 import (
 	"context"
 	"fmt"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/iterator"
 )
 
+// DefaultTable is Item を入れる Table の Table Name の default
+const DefaultTable = "MoleItems"
+
+// SurrogateKeyTable is table の SurrogateKey の Index Table の Table Name を返す
+func SurrogateKeyTable(table string) string {
+	return table + "SurrogateKeys"
+}
+
+// CreateTableStatements is Item の Table と SurrogateKey の Index Table の DDL を返す
+//
+// ExpiredAt を過ぎた Row は ROW DELETION POLICY で削除される. 削除は Background で行われるので、 Read の時も ExpiredAt を確認している
+// ExpiredAt が NULL の Row は有効期限なしとして扱い、削除されない
+func CreateTableStatements(table string) []string {
+	return []string{
+		fmt.Sprintf(`
+CREATE TABLE %s (
+    CacheKey STRING(MAX) NOT NULL,
+    Value BYTES(MAX),
+    SurrogateKeys ARRAY<STRING(MAX)>,
+    ExpiredAt TIMESTAMP,
+    UpdatedAt TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+) PRIMARY KEY (CacheKey),
+  ROW DELETION POLICY (OLDER_THAN(ExpiredAt, INTERVAL 0 DAY))`, table),
+		fmt.Sprintf(`
+CREATE TABLE %s (
+    SurrogateKey STRING(MAX) NOT NULL,
+    CacheKey STRING(MAX) NOT NULL,
+    ExpiredAt TIMESTAMP,
+) PRIMARY KEY (SurrogateKey, CacheKey),
+  ROW DELETION POLICY (OLDER_THAN(ExpiredAt, INTERVAL 0 DAY))`, SurrogateKeyTable(table)),
+	}
+}
+
+var itemColumns = []string{"CacheKey", "Value", "SurrogateKeys", "ExpiredAt"}
+
+// itemRow is Item の Table の Row
+type itemRow struct {
+	CacheKey      string
+	Value         []byte
+	SurrogateKeys []string
+	ExpiredAt     spanner.NullTime
+}
+
+func (r *itemRow) expired(now time.Time) bool {
+	return r.ExpiredAt.Valid && !now.Before(r.ExpiredAt.Time)
+}
+
+func (r *itemRow) item() *Item {
+	item := &Item{
+		Key:           r.CacheKey,
+		SurrogateKeys: r.SurrogateKeys,
+		Value:         r.Value,
+	}
+	if r.ExpiredAt.Valid {
+		item.ExpiredAt = r.ExpiredAt.Time
+	}
+	return item
+}
+
+type spannerServiceOptions struct {
+	table string
+}
+
+// SpannerServiceOptions is NewService の Options
+type SpannerServiceOptions func(*spannerServiceOptions)
+
+// WithTable is Item の Table の Name を指定する
+// SurrogateKey の Index Table は SurrogateKeyTable(table) になる
+// 省略した場合は DefaultTable
+func WithTable(table string) SpannerServiceOptions {
+	return func(ops *spannerServiceOptions) {
+		ops.table = table
+	}
+}
+
+// SpannerService is Cloud Spanner を使った Service
+//
+// Instance の再起動で消えず、複数の Cloud Run の Instance で共有できる Cache として使う
+// Table は CreateTableStatements で作成する
 type SpannerService struct {
+	spanner        *spanner.Client
+	table          string
+	surrogateTable string
+	now            func() time.Time
 }
 
-func NewService() (Service, error) {
-	return &SpannerService{}, nil
+// NewService is SpannerService を返す
+func NewService(spannerClient *spanner.Client, ops ...SpannerServiceOptions) (Service, error) {
+	if spannerClient == nil {
+		return nil, fmt.Errorf("spannerClient is required : %w", ErrInvalidArgument)
+	}
+	opt := spannerServiceOptions{
+		table: DefaultTable,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+	return &SpannerService{
+		spanner:        spannerClient,
+		table:          opt.table,
+		surrogateTable: SurrogateKeyTable(opt.table),
+		now:            time.Now,
+	}, nil
 }
 
+// Get is key の Item を返す
+// 存在しない場合と ExpiredAt を過ぎている場合は ErrCacheMiss を返す
 func (s *SpannerService) Get(ctx context.Context, key string) (*Item, error) {
-	return nil, fmt.Errorf("unimplemented")
+	items, err := s.GetMulti(ctx, []string{key})
+	if err != nil {
+		return nil, err
+	}
+	item, ok := items[key]
+	if !ok {
+		return nil, ErrCacheMiss
+	}
+	return item, nil
 }
 
+// GetBySurrogateKey is surrogateKey を持つ Item を全て返す
+// 1 つも無い場合は空の slice を返す
 func (s *SpannerService) GetBySurrogateKey(ctx context.Context, surrogateKey string) ([]*Item, error) {
-	return nil, fmt.Errorf("unimplemented")
+	tx := s.spanner.ReadOnlyTransaction()
+	defer tx.Close()
+
+	keys, err := s.readKeysBySurrogateKeys(ctx, tx, []string{surrogateKey})
+	if err != nil {
+		return nil, err
+	}
+	rows, err := s.readItemRows(ctx, tx, keys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	items := []*Item{}
+	for _, key := range keys {
+		row, ok := rows[key]
+		if !ok || row.expired(now) {
+			continue
+		}
+		items = append(items, row.item())
+	}
+	return items, nil
 }
 
+// GetMulti is keys の Item を 1 回の Read で取得する
+// 存在しない Key と ExpiredAt を過ぎている Key は map に含めない
 func (s *SpannerService) GetMulti(ctx context.Context, keys []string) (map[string]*Item, error) {
-	return nil, fmt.Errorf("unimplemented")
+	rows, err := s.readItemRows(ctx, s.spanner.Single(), keys)
+	if err != nil {
+		return nil, err
+	}
+
+	now := s.now()
+	items := map[string]*Item{}
+	for key, row := range rows {
+		if row.expired(now) {
+			continue
+		}
+		items[key] = row.item()
+	}
+	return items, nil
 }
 
+// Set is item を保存する. すでに同じ Key の Item がある場合は上書きする
 func (s *SpannerService) Set(ctx context.Context, item *Item) error {
-	return fmt.Errorf("unimplemented")
+	return s.SetMulti(ctx, []*Item{item})
 }
 
+// SetMulti is items を 1 つの Transaction で保存する
+// 同じ Key の Item が複数ある場合は後ろのものを保存する
 func (s *SpannerService) SetMulti(ctx context.Context, items []*Item) error {
-	return fmt.Errorf("unimplemented")
+	var keys []string
+	itemsByKey := map[string]*Item{}
+	for i, item := range items {
+		if item == nil || len(item.Key) < 1 {
+			return fmt.Errorf("item key is required. index=%d : %w", i, ErrInvalidArgument)
+		}
+		if _, ok := itemsByKey[item.Key]; !ok {
+			keys = append(keys, item.Key)
+		}
+		itemsByKey[item.Key] = item
+	}
+	if len(keys) < 1 {
+		return nil
+	}
+
+	_, err := s.spanner.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		current, err := s.readItemRows(ctx, tx, keys)
+		if err != nil {
+			return err
+		}
+
+		var mus []*spanner.Mutation
+		for _, key := range keys {
+			item := itemsByKey[key]
+			expiredAt := spanner.NullTime{Time: item.ExpiredAt, Valid: !item.ExpiredAt.IsZero()}
+
+			// 新しい Item に無い SurrogateKey の Index を削除する
+			newSurrogateKeys := map[string]bool{}
+			for _, sk := range item.SurrogateKeys {
+				newSurrogateKeys[sk] = true
+			}
+			if row, ok := current[key]; ok {
+				for _, sk := range row.SurrogateKeys {
+					if !newSurrogateKeys[sk] {
+						mus = append(mus, spanner.Delete(s.surrogateTable, spanner.Key{sk, key}))
+					}
+				}
+			}
+
+			mus = append(mus, spanner.InsertOrUpdate(s.table,
+				[]string{"CacheKey", "Value", "SurrogateKeys", "ExpiredAt", "UpdatedAt"},
+				[]interface{}{key, item.Value, item.SurrogateKeys, expiredAt, spanner.CommitTimestamp}))
+			for sk := range newSurrogateKeys {
+				mus = append(mus, spanner.InsertOrUpdate(s.surrogateTable,
+					[]string{"SurrogateKey", "CacheKey", "ExpiredAt"},
+					[]interface{}{sk, key, expiredAt}))
+			}
+		}
+		return tx.BufferWrite(mus)
+	})
+	if err != nil {
+		return fmt.Errorf("failed SetMulti. keys=%v : %w", keys, err)
+	}
+	return nil
 }
 
+// Delete is key の Item を削除する. 存在しない場合も error にはしない
 func (s *SpannerService) Delete(ctx context.Context, key string) error {
-	return fmt.Errorf("unimplemented")
+	return s.DeleteMulti(ctx, []string{key})
 }
 
+// DeleteBySurrogateKey is surrogateKey を持つ Item を全て削除する
 func (s *SpannerService) DeleteBySurrogateKey(ctx context.Context, surrogateKey string) error {
-	return fmt.Errorf("unimplemented")
+	return s.DeleteMultiBySurrogateKey(ctx, []string{surrogateKey})
 }
 
+// DeleteMulti is keys の Item を 1 つの Transaction で削除する
 func (s *SpannerService) DeleteMulti(ctx context.Context, keys []string) error {
-	return fmt.Errorf("unimplemented")
+	if len(keys) < 1 {
+		return nil
+	}
+	_, err := s.spanner.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		return s.deleteItems(ctx, tx, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("failed DeleteMulti. keys=%v : %w", keys, err)
+	}
+	return nil
 }
 
+// DeleteMultiBySurrogateKey is surrogateKeys のいずれかを持つ Item を 1 つの Transaction で全て削除する
 func (s *SpannerService) DeleteMultiBySurrogateKey(ctx context.Context, surrogateKeys []string) error {
-	return fmt.Errorf("unimplemented")
+	if len(surrogateKeys) < 1 {
+		return nil
+	}
+	_, err := s.spanner.ReadWriteTransaction(ctx, func(ctx context.Context, tx *spanner.ReadWriteTransaction) error {
+		keys, err := s.readKeysBySurrogateKeys(ctx, tx, surrogateKeys)
+		if err != nil {
+			return err
+		}
+		return s.deleteItems(ctx, tx, keys)
+	})
+	if err != nil {
+		return fmt.Errorf("failed DeleteMultiBySurrogateKey. surrogateKeys=%v : %w", surrogateKeys, err)
+	}
+	return nil
 }
 
+// FlushAll is 全ての Item を削除する
+// Partitioned DML で削除するので、 Table 全体で 1 つの Transaction にはならない
 func (s *SpannerService) FlushAll(ctx context.Context) error {
-	return fmt.Errorf("unimplemented")
+	for _, table := range []string{s.table, s.surrogateTable} {
+		if _, err := s.spanner.PartitionedUpdate(ctx, spanner.NewStatement(fmt.Sprintf("DELETE FROM %s WHERE true", table))); err != nil {
+			return fmt.Errorf("failed FlushAll. table=%s : %w", table, err)
+		}
+	}
+	return nil
+}
+
+// deleteItems is keys の Item と、その Item の SurrogateKey の Index を削除する Mutation を tx に入れる
+func (s *SpannerService) deleteItems(ctx context.Context, tx *spanner.ReadWriteTransaction, keys []string) error {
+	if len(keys) < 1 {
+		return nil
+	}
+	current, err := s.readItemRows(ctx, tx, keys)
+	if err != nil {
+		return err
+	}
+
+	var mus []*spanner.Mutation
+	for _, key := range keys {
+		mus = append(mus, spanner.Delete(s.table, spanner.Key{key}))
+		if row, ok := current[key]; ok {
+			for _, sk := range row.SurrogateKeys {
+				mus = append(mus, spanner.Delete(s.surrogateTable, spanner.Key{sk, key}))
+			}
+		}
+	}
+	return tx.BufferWrite(mus)
+}
+
+// reader is spanner.ReadOnlyTransaction と spanner.ReadWriteTransaction の Read
+type reader interface {
+	Read(ctx context.Context, table string, keys spanner.KeySet, columns []string) *spanner.RowIterator
+}
+
+// readItemRows is keys の Row を Read する. ExpiredAt は確認しない
+func (s *SpannerService) readItemRows(ctx context.Context, tx reader, keys []string) (map[string]*itemRow, error) {
+	rows := map[string]*itemRow{}
+	if len(keys) < 1 {
+		return rows, nil
+	}
+
+	ks := make([]spanner.KeySet, len(keys))
+	for i, key := range keys {
+		ks[i] = spanner.Key{key}
+	}
+	iter := tx.Read(ctx, s.table, spanner.KeySets(ks...), itemColumns)
+	defer iter.Stop()
+	for {
+		r, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed read items. table=%s : %w", s.table, err)
+		}
+		var row itemRow
+		if err := r.ToStruct(&row); err != nil {
+			return nil, fmt.Errorf("failed read items. table=%s : %w", s.table, err)
+		}
+		rows[row.CacheKey] = &row
+	}
+	return rows, nil
+}
+
+// readKeysBySurrogateKeys is surrogateKeys のいずれかを持つ Item の Key を Index Table から Read する
+func (s *SpannerService) readKeysBySurrogateKeys(ctx context.Context, tx reader, surrogateKeys []string) ([]string, error) {
+	ks := make([]spanner.KeySet, len(surrogateKeys))
+	for i, sk := range surrogateKeys {
+		ks[i] = spanner.Key{sk}.AsPrefix()
+	}
+	iter := tx.Read(ctx, s.surrogateTable, spanner.KeySets(ks...), []string{"CacheKey"})
+	defer iter.Stop()
+
+	var keys []string
+	exists := map[string]bool{}
+	for {
+		r, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed read surrogate keys. table=%s : %w", s.surrogateTable, err)
+		}
+		var key string
+		if err := r.Columns(&key); err != nil {
+			return nil, fmt.Errorf("failed read surrogate keys. table=%s : %w", s.surrogateTable, err)
+		}
+		if exists[key] {
+			continue
+		}
+		exists[key] = true
+		keys = append(keys, key)
+	}
+	return keys, nil
 }
@@ -0,0 +1,292 @@
+package mole_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"os"
+	"sort"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	sadDatabase "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sadInstance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"github.com/google/go-cmp/cmp"
+	mole "github.com/sinmetalcraft/gcpbox/mole/v0"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	projectID = "unittest"
+	instance  = "mole"
+)
+
+func TestSpannerService_GetSet(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSpannerService(t)
+
+	item := &mole.Item{
+		Key:           "hello",
+		SurrogateKeys: []string{"greeting"},
+		Value:         []byte("world"),
+		ExpiredAt:     time.Now().Add(time.Hour).UTC().Truncate(time.Microsecond),
+	}
+	if err := s.Set(ctx, item); err != nil {
+		t.Fatal(err)
+	}
+	got, err := s.Get(ctx, "hello")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(item, got) {
+		t.Errorf("diff %s", cmp.Diff(item, got))
+	}
+
+	// 有効期限なし
+	noExpire := &mole.Item{Key: "forever", Value: []byte("value")}
+	if err := s.Set(ctx, noExpire); err != nil {
+		t.Fatal(err)
+	}
+	got, err = s.Get(ctx, "forever")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !cmp.Equal(noExpire, got) {
+		t.Errorf("diff %s", cmp.Diff(noExpire, got))
+	}
+
+	if _, err := s.Get(ctx, "notfound"); !errors.Is(err, mole.ErrCacheMiss) {
+		t.Errorf("want ErrCacheMiss but got %v", err)
+	}
+}
+
+func TestSpannerService_Get_Expired(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSpannerService(t)
+
+	if err := s.Set(ctx, &mole.Item{
+		Key:           "expired",
+		SurrogateKeys: []string{"tag"},
+		Value:         []byte("value"),
+		ExpiredAt:     time.Now().Add(-time.Minute),
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := s.Get(ctx, "expired"); !errors.Is(err, mole.ErrCacheMiss) {
+		t.Errorf("want ErrCacheMiss but got %v", err)
+	}
+	items, err := s.GetBySurrogateKey(ctx, "tag")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, len(items); e != g {
+		t.Errorf("want items %d but got %d", e, g)
+	}
+}
+
+func TestSpannerService_Multi(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSpannerService(t)
+
+	if err := s.SetMulti(ctx, []*mole.Item{
+		{Key: "a", Value: []byte("a")},
+		{Key: "b", Value: []byte("b")},
+		{Key: "c", Value: []byte("c")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	items, err := s.GetMulti(ctx, []string{"a", "b", "notfound"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := []string{"a", "b"}, itemKeys(items); !cmp.Equal(e, g) {
+		t.Errorf("want %v but got %v", e, g)
+	}
+
+	if err := s.DeleteMulti(ctx, []string{"a", "c", "notfound"}); err != nil {
+		t.Fatal(err)
+	}
+	items, err = s.GetMulti(ctx, []string{"a", "b", "c"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := []string{"b"}, itemKeys(items); !cmp.Equal(e, g) {
+		t.Errorf("want %v but got %v", e, g)
+	}
+
+	if err := s.SetMulti(ctx, []*mole.Item{{Key: ""}}); !errors.Is(err, mole.ErrInvalidArgument) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
+
+func TestSpannerService_SurrogateKey(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSpannerService(t)
+
+	if err := s.SetMulti(ctx, []*mole.Item{
+		{Key: "user1", SurrogateKeys: []string{"users", "team1"}, Value: []byte("1")},
+		{Key: "user2", SurrogateKeys: []string{"users", "team2"}, Value: []byte("2")},
+		{Key: "user3", SurrogateKeys: []string{"users", "team1"}, Value: []byte("3")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if e, g := []string{"user1", "user3"}, surrogateKeyItems(t, s, "team1"); !cmp.Equal(e, g) {
+		t.Errorf("want %v but got %v", e, g)
+	}
+
+	// SurrogateKey を変更すると古い SurrogateKey では取得できない
+	if err := s.Set(ctx, &mole.Item{Key: "user3", SurrogateKeys: []string{"users", "team2"}, Value: []byte("3")}); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := []string{"user1"}, surrogateKeyItems(t, s, "team1"); !cmp.Equal(e, g) {
+		t.Errorf("want %v but got %v", e, g)
+	}
+
+	if err := s.DeleteBySurrogateKey(ctx, "team2"); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := []string{"user1"}, surrogateKeyItems(t, s, "users"); !cmp.Equal(e, g) {
+		t.Errorf("want %v but got %v", e, g)
+	}
+	if _, err := s.Get(ctx, "user2"); !errors.Is(err, mole.ErrCacheMiss) {
+		t.Errorf("want ErrCacheMiss but got %v", err)
+	}
+
+	if err := s.DeleteMultiBySurrogateKey(ctx, []string{"team1", "notfound"}); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, len(surrogateKeyItems(t, s, "users")); e != g {
+		t.Errorf("want items %d but got %d", e, g)
+	}
+}
+
+func TestSpannerService_FlushAll(t *testing.T) {
+	ctx := context.Background()
+
+	s := newSpannerService(t)
+
+	if err := s.SetMulti(ctx, []*mole.Item{
+		{Key: "a", SurrogateKeys: []string{"tag"}, Value: []byte("a")},
+		{Key: "b", SurrogateKeys: []string{"tag"}, Value: []byte("b")},
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.FlushAll(ctx); err != nil {
+		t.Fatal(err)
+	}
+	items, err := s.GetMulti(ctx, []string{"a", "b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 0, len(items); e != g {
+		t.Errorf("want items %d but got %d", e, g)
+	}
+	if e, g := 0, len(surrogateKeyItems(t, s, "tag")); e != g {
+		t.Errorf("want items %d but got %d", e, g)
+	}
+}
+
+func itemKeys(items map[string]*mole.Item) []string {
+	var keys []string
+	for k := range items {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func surrogateKeyItems(t *testing.T, s mole.Service, surrogateKey string) []string {
+	t.Helper()
+
+	items, err := s.GetBySurrogateKey(context.Background(), surrogateKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	for _, item := range items {
+		keys = append(keys, item.Key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// newSpannerService is Spanner Emulator に mole の Table を持つ Database を作成して Service を返す
+func newSpannerService(t *testing.T) mole.Service {
+	seh := os.Getenv("SPANNER_EMULATOR_HOST")
+	if len(seh) < 1 {
+		t.Fatal("Required $SPANNER_EMULATOR_HOST")
+	}
+
+	ctx := context.Background()
+
+	spannerInstanceAdminClient, err := sadInstance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerInstanceAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	spannerDatabaseAdminClient, err := sadDatabase.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer func() {
+		if err := spannerDatabaseAdminClient.Close(); err != nil {
+			t.Fatal(err)
+		}
+	}()
+
+	_, err = spannerInstanceAdminClient.CreateInstance(ctx, &instancepb.CreateInstanceRequest{
+		Parent:     fmt.Sprintf("projects/%s", projectID),
+		InstanceId: instance,
+		Instance: &instancepb.Instance{
+			Name:      fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+			NodeCount: 1,
+		},
+	})
+	if err != nil {
+		if status.Code(err) == codes.AlreadyExists {
+			// noop
+		} else {
+			t.Fatal(err)
+		}
+	}
+
+	database := fmt.Sprintf("mole%d", rand.Int31())
+	op, err := spannerDatabaseAdminClient.CreateDatabase(ctx, &databasepb.CreateDatabaseRequest{
+		Parent:          fmt.Sprintf("projects/%s/instances/%s", projectID, instance),
+		CreateStatement: fmt.Sprintf("CREATE DATABASE %s", database),
+		ExtraStatements: mole.CreateTableStatements(mole.DefaultTable),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := op.Wait(ctx); err != nil {
+		t.Fatal(err)
+	}
+
+	sc, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", projectID, instance, database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(sc.Close)
+
+	s, err := mole.NewService(sc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
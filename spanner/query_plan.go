@@ -0,0 +1,329 @@
+package spanner
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+
+	"cloud.google.com/go/spanner"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/api/iterator"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+// QueryPlanNode is Query Plan の 1 つの Relational Operator
+type QueryPlanNode struct {
+	// Index is QueryPlan.PlanNodes の index
+	Index int32 `json:"index"`
+
+	// DisplayName is Operator の名前. "Distributed Union", "Table Scan" など
+	DisplayName string `json:"displayName"`
+
+	// LinkType is 親の Operator から見たこの Operator の種類. "Input", "Map" など. Root は空
+	LinkType string `json:"linkType,omitempty"`
+
+	// ScanType is Scan の Operator の scan_type. "TableScan", "IndexScan" など
+	ScanType string `json:"scanType,omitempty"`
+
+	// ScanTarget is Scan の Operator の対象の Table, Index
+	ScanTarget string `json:"scanTarget,omitempty"`
+
+	// Scalars is 子の Scalar の LinkType ごとの式. "Condition", "Seek Condition" など
+	Scalars map[string]string `json:"scalars,omitempty"`
+
+	// Metadata is PlanNode の metadata
+	Metadata map[string]interface{} `json:"metadata,omitempty"`
+
+	// Latency is Operator の実行にかかった時間の合計. PROFILE mode の時だけ入る. "1.23 msecs" 形式
+	Latency string `json:"latency,omitempty"`
+
+	// CPUTime is Operator の CPU 時間の合計. PROFILE mode の時だけ入る
+	CPUTime string `json:"cpuTime,omitempty"`
+
+	// Rows is Operator が返した Row の数の合計. PROFILE mode の時だけ入る
+	Rows int64 `json:"rows"`
+
+	// Executions is Operator が実行された回数. PROFILE mode の時だけ入る
+	Executions int64 `json:"executions"`
+
+	// ExecutionStats is PlanNode の execution_stats. PROFILE mode の時だけ入る
+	ExecutionStats map[string]interface{} `json:"executionStats,omitempty"`
+
+	Children []*QueryPlanNode `json:"children,omitempty"`
+}
+
+// QueryPlan is Spanner の QueryPlan を Relational Operator の木にしたもの
+type QueryPlan struct {
+	Root *QueryPlanNode `json:"root"`
+}
+
+// QueryPlanWithStats is statement を mode で実行して、 QueryWithStats と QueryPlan を返す
+//
+// mode が PROFILE の場合は Query を実行して Row は捨てる
+// mode が PLAN の場合は Query を実行せずに Plan だけを返すので、 QueryWithStats は nil になり、 QueryPlanNode の実行時の情報は入らない
+func QueryPlanWithStats(ctx context.Context, spannerClient *spanner.Client, statement spanner.Statement, mode sppb.ExecuteSqlRequest_QueryMode) (*QueryWithStats, *QueryPlan, error) {
+	switch mode {
+	case sppb.ExecuteSqlRequest_PLAN:
+		pb, err := spannerClient.Single().AnalyzeQuery(ctx, statement)
+		if err != nil {
+			return nil, nil, err
+		}
+		plan, err := NewQueryPlan(pb)
+		if err != nil {
+			return nil, nil, err
+		}
+		return nil, plan, nil
+	case sppb.ExecuteSqlRequest_PROFILE:
+		iter := spannerClient.Single().QueryWithStats(ctx, statement)
+		defer iter.Stop()
+		for {
+			_, err := iter.Next()
+			if err == iterator.Done {
+				break
+			}
+			if err != nil {
+				return nil, nil, err
+			}
+		}
+		stats, err := ConvertQueryWithStats(iter.QueryStats)
+		if err != nil {
+			return nil, nil, err
+		}
+		plan, err := NewQueryPlan(iter.QueryPlan)
+		if err != nil {
+			return nil, nil, err
+		}
+		return stats, plan, nil
+	default:
+		return nil, nil, NewErrInvalidArgument(fmt.Sprintf("unsupported query mode %s", mode), map[string]interface{}{"mode": mode.String()}, nil)
+	}
+}
+
+// NewQueryPlan is sppb.QueryPlan から QueryPlan を作成する
+// Scalar の PlanNode は親の Relational Operator の Scalars に入れる
+func NewQueryPlan(pb *sppb.QueryPlan) (*QueryPlan, error) {
+	nodes := pb.GetPlanNodes()
+	if len(nodes) < 1 {
+		return nil, NewErrInvalidArgument("query plan has no plan nodes", nil, nil)
+	}
+	root, err := newQueryPlanNode(nodes, 0, "", map[int32]bool{})
+	if err != nil {
+		return nil, err
+	}
+	return &QueryPlan{Root: root}, nil
+}
+
+func newQueryPlanNode(nodes []*sppb.PlanNode, index int32, linkType string, visited map[int32]bool) (*QueryPlanNode, error) {
+	if index < 0 || int(index) >= len(nodes) {
+		return nil, NewErrInvalidArgument(fmt.Sprintf("plan node index %d is out of range", index), map[string]interface{}{"index": index}, nil)
+	}
+	if visited[index] {
+		return nil, NewErrInvalidArgument(fmt.Sprintf("plan node %d is cyclic", index), map[string]interface{}{"index": index}, nil)
+	}
+	visited[index] = true
+
+	pn := nodes[index]
+	node := &QueryPlanNode{
+		Index:       pn.GetIndex(),
+		DisplayName: pn.GetDisplayName(),
+		LinkType:    linkType,
+		Metadata:    pn.GetMetadata().AsMap(),
+	}
+	node.ScanType = structString(pn.GetMetadata(), "scan_type")
+	node.ScanTarget = structString(pn.GetMetadata(), "scan_target")
+
+	if es := pn.GetExecutionStats(); es != nil {
+		node.ExecutionStats = es.AsMap()
+		node.Latency = executionStatsTotal(es, "latency")
+		node.CPUTime = executionStatsTotal(es, "cpu_time")
+		node.Rows = parseInt64(executionStatsValue(es, "rows", "total"))
+		node.Executions = parseInt64(executionStatsValue(es, "execution_summary", "num_executions"))
+	}
+
+	for _, link := range pn.GetChildLinks() {
+		ci := link.GetChildIndex()
+		if ci < 0 || int(ci) >= len(nodes) {
+			return nil, NewErrInvalidArgument(fmt.Sprintf("plan node index %d is out of range", ci), map[string]interface{}{"index": ci}, nil)
+		}
+		child := nodes[ci]
+		if child.GetKind() == sppb.PlanNode_SCALAR {
+			desc := child.GetShortRepresentation().GetDescription()
+			if len(link.GetType()) < 1 || len(desc) < 1 {
+				continue
+			}
+			if node.Scalars == nil {
+				node.Scalars = map[string]string{}
+			}
+			if v, ok := node.Scalars[link.GetType()]; ok {
+				desc = v + ", " + desc
+			}
+			node.Scalars[link.GetType()] = desc
+			continue
+		}
+		c, err := newQueryPlanNode(nodes, ci, link.GetType(), visited)
+		if err != nil {
+			return nil, err
+		}
+		node.Children = append(node.Children, c)
+	}
+	return node, nil
+}
+
+// Walk is Root から深さ優先で全ての QueryPlanNode に f を実行する
+// depth は Root が 0
+func (p *QueryPlan) Walk(f func(node *QueryPlanNode, depth int)) {
+	var walk func(node *QueryPlanNode, depth int)
+	walk = func(node *QueryPlanNode, depth int) {
+		f(node, depth)
+		for _, c := range node.Children {
+			walk(c, depth+1)
+		}
+	}
+	walk(p.Root, 0)
+}
+
+// Label is Operator の名前と Scan の対象を返す
+func (n *QueryPlanNode) Label() string {
+	var b strings.Builder
+	b.WriteString(n.DisplayName)
+	if len(n.ScanTarget) > 0 {
+		fmt.Fprintf(&b, " (%s", n.ScanTarget)
+		if len(n.ScanType) > 0 {
+			fmt.Fprintf(&b, ", %s", n.ScanType)
+		}
+		b.WriteString(")")
+	}
+	return b.String()
+}
+
+// statsLabel is PROFILE mode の実行時の情報を返す. PLAN mode の場合は空
+func (n *QueryPlanNode) statsLabel() string {
+	if n.ExecutionStats == nil {
+		return ""
+	}
+	var l []string
+	l = append(l, fmt.Sprintf("rows=%d", n.Rows))
+	if len(n.Latency) > 0 {
+		l = append(l, fmt.Sprintf("latency=%s", n.Latency))
+	}
+	if len(n.CPUTime) > 0 {
+		l = append(l, fmt.Sprintf("cpu=%s", n.CPUTime))
+	}
+	l = append(l, fmt.Sprintf("executions=%d", n.Executions))
+	return strings.Join(l, ", ")
+}
+
+// scalarLabels is Scalars を LinkType の順に "LinkType: 式" にして返す
+func (n *QueryPlanNode) scalarLabels() []string {
+	keys := make([]string, 0, len(n.Scalars))
+	for k := range n.Scalars {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	ret := make([]string, len(keys))
+	for i, k := range keys {
+		ret[i] = fmt.Sprintf("%s: %s", k, n.Scalars[k])
+	}
+	return ret
+}
+
+// ASCII is QueryPlan を Indent した ASCII の木にする
+// Index は出力しないので、 Optimizer Version ごとの Plan の diff を取るのに使える
+func (p *QueryPlan) ASCII() string {
+	var b strings.Builder
+	var write func(node *QueryPlanNode, prefix string, childPrefix string)
+	write = func(node *QueryPlanNode, prefix string, childPrefix string) {
+		b.WriteString(prefix)
+		if len(node.LinkType) > 0 {
+			fmt.Fprintf(&b, "[%s] ", node.LinkType)
+		}
+		b.WriteString(node.Label())
+		if s := node.statsLabel(); len(s) > 0 {
+			fmt.Fprintf(&b, " {%s}", s)
+		}
+		b.WriteString("\n")
+
+		next := childPrefix + "|  "
+		if len(node.Children) < 1 {
+			next = childPrefix + "   "
+		}
+		for _, s := range node.scalarLabels() {
+			fmt.Fprintf(&b, "%s%s\n", next, s)
+		}
+		for i, c := range node.Children {
+			if i == len(node.Children)-1 {
+				write(c, childPrefix+"+- ", childPrefix+"   ")
+			} else {
+				write(c, childPrefix+"+- ", childPrefix+"|  ")
+			}
+		}
+	}
+	write(p.Root, "", "")
+	return b.String()
+}
+
+// DOT is QueryPlan を Graphviz の DOT にする
+func (p *QueryPlan) DOT() string {
+	var b strings.Builder
+	b.WriteString("digraph QueryPlan {\n")
+	b.WriteString("  node [shape=box, fontname=\"monospace\"];\n")
+	p.Walk(func(node *QueryPlanNode, depth int) {
+		lines := []string{node.Label()}
+		lines = append(lines, node.scalarLabels()...)
+		if s := node.statsLabel(); len(s) > 0 {
+			lines = append(lines, s)
+		}
+		for i, l := range lines {
+			lines[i] = dotEscape(l)
+		}
+		fmt.Fprintf(&b, "  n%d [label=\"%s\"];\n", node.Index, strings.Join(lines, "\\n"))
+		for _, c := range node.Children {
+			fmt.Fprintf(&b, "  n%d -> n%d [label=\"%s\"];\n", node.Index, c.Index, dotEscape(c.LinkType))
+		}
+	})
+	b.WriteString("}\n")
+	return b.String()
+}
+
+func dotEscape(s string) string {
+	return strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(s)
+}
+
+func structString(s *structpb.Struct, key string) string {
+	v, ok := s.GetFields()[key]
+	if !ok {
+		return ""
+	}
+	return v.GetStringValue()
+}
+
+// executionStatsValue is execution_stats の {key: {field: value}} の value を返す
+func executionStatsValue(es *structpb.Struct, key string, field string) string {
+	v, ok := es.GetFields()[key]
+	if !ok {
+		return ""
+	}
+	return structString(v.GetStructValue(), field)
+}
+
+// executionStatsTotal is execution_stats の {key: {total, unit}} を "total unit" にする
+func executionStatsTotal(es *structpb.Struct, key string) string {
+	total := executionStatsValue(es, key, "total")
+	if len(total) < 1 {
+		return ""
+	}
+	if unit := executionStatsValue(es, key, "unit"); len(unit) > 0 {
+		return fmt.Sprintf("%s %s", total, unit)
+	}
+	return total
+}
+
+func parseInt64(s string) int64 {
+	i, err := strconv.ParseInt(s, 10, 64)
+	if err != nil {
+		return 0
+	}
+	return i
+}
@@ -0,0 +1,164 @@
+package spanner_test
+
+import (
+	"strings"
+	"testing"
+
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/protobuf/types/known/structpb"
+)
+
+func TestNewQueryPlan(t *testing.T) {
+	plan, err := spabox.NewQueryPlan(testQueryPlan(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	root := plan.Root
+	if e, g := "Distributed Union", root.DisplayName; e != g {
+		t.Errorf("want DisplayName %s but got %s", e, g)
+	}
+	if e, g := int64(3), root.Rows; e != g {
+		t.Errorf("want Rows %d but got %d", e, g)
+	}
+	if e, g := "1.5 msecs", root.Latency; e != g {
+		t.Errorf("want Latency %s but got %s", e, g)
+	}
+	if e, g := 1, len(root.Children); e != g {
+		t.Fatalf("want Children %d but got %d", e, g)
+	}
+
+	scan := root.Children[0]
+	if e, g := "Input", scan.LinkType; e != g {
+		t.Errorf("want LinkType %s but got %s", e, g)
+	}
+	if e, g := "TableScan", scan.ScanType; e != g {
+		t.Errorf("want ScanType %s but got %s", e, g)
+	}
+	if e, g := "Singers", scan.ScanTarget; e != g {
+		t.Errorf("want ScanTarget %s but got %s", e, g)
+	}
+	if e, g := "($SingerId = 1)", scan.Scalars["Seek Condition"]; e != g {
+		t.Errorf("want Seek Condition %s but got %s", e, g)
+	}
+	if e, g := int64(2), scan.Executions; e != g {
+		t.Errorf("want Executions %d but got %d", e, g)
+	}
+	if e, g := "0.8 msecs", scan.CPUTime; e != g {
+		t.Errorf("want CPUTime %s but got %s", e, g)
+	}
+}
+
+func TestNewQueryPlan_Invalid(t *testing.T) {
+	if _, err := spabox.NewQueryPlan(&sppb.QueryPlan{}); !spabox.ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+
+	pb := &sppb.QueryPlan{
+		PlanNodes: []*sppb.PlanNode{
+			{Index: 0, Kind: sppb.PlanNode_RELATIONAL, DisplayName: "Union", ChildLinks: []*sppb.PlanNode_ChildLink{{ChildIndex: 5}}},
+		},
+	}
+	if _, err := spabox.NewQueryPlan(pb); !spabox.ErrInvalidArgument.Is(err) {
+		t.Errorf("want ErrInvalidArgument but got %v", err)
+	}
+}
+
+func TestQueryPlan_ASCII(t *testing.T) {
+	plan, err := spabox.NewQueryPlan(testQueryPlan(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := strings.Join([]string{
+		"Distributed Union {rows=3, latency=1.5 msecs, executions=1}",
+		"|  Split Range: true",
+		"+- [Input] Table Scan (Singers, TableScan) {rows=3, latency=1.2 msecs, cpu=0.8 msecs, executions=2}",
+		"      Seek Condition: ($SingerId = 1)",
+		"",
+	}, "\n")
+	if g := plan.ASCII(); want != g {
+		t.Errorf("want\n%s\nbut got\n%s", want, g)
+	}
+}
+
+func TestQueryPlan_DOT(t *testing.T) {
+	plan, err := spabox.NewQueryPlan(testQueryPlan(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got := plan.DOT()
+	for _, want := range []string{
+		"digraph QueryPlan {",
+		`n0 [label="Distributed Union\nSplit Range: true\nrows=3, latency=1.5 msecs, executions=1"];`,
+		`n0 -> n2 [label="Input"];`,
+		`n2 [label="Table Scan (Singers, TableScan)\nSeek Condition: ($SingerId = 1)\nrows=3, latency=1.2 msecs, cpu=0.8 msecs, executions=2"];`,
+	} {
+		if !strings.Contains(got, want) {
+			t.Errorf("want contains %s but got\n%s", want, got)
+		}
+	}
+}
+
+func testQueryPlan(t *testing.T) *sppb.QueryPlan {
+	t.Helper()
+
+	newStruct := func(m map[string]interface{}) *structpb.Struct {
+		s, err := structpb.NewStruct(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return s
+	}
+
+	return &sppb.QueryPlan{
+		PlanNodes: []*sppb.PlanNode{
+			{
+				Index:       0,
+				Kind:        sppb.PlanNode_RELATIONAL,
+				DisplayName: "Distributed Union",
+				ChildLinks: []*sppb.PlanNode_ChildLink{
+					{ChildIndex: 1, Type: "Split Range"},
+					{ChildIndex: 2, Type: "Input"},
+				},
+				ExecutionStats: newStruct(map[string]interface{}{
+					"latency":           map[string]interface{}{"total": "1.5", "unit": "msecs"},
+					"rows":              map[string]interface{}{"total": "3", "unit": "rows"},
+					"execution_summary": map[string]interface{}{"num_executions": "1"},
+				}),
+			},
+			{
+				Index:               1,
+				Kind:                sppb.PlanNode_SCALAR,
+				DisplayName:         "Constant",
+				ShortRepresentation: &sppb.PlanNode_ShortRepresentation{Description: "true"},
+			},
+			{
+				Index:       2,
+				Kind:        sppb.PlanNode_RELATIONAL,
+				DisplayName: "Table Scan",
+				ChildLinks: []*sppb.PlanNode_ChildLink{
+					{ChildIndex: 3, Type: "Seek Condition"},
+				},
+				Metadata: newStruct(map[string]interface{}{
+					"scan_type":   "TableScan",
+					"scan_target": "Singers",
+				}),
+				ExecutionStats: newStruct(map[string]interface{}{
+					"latency":           map[string]interface{}{"total": "1.2", "unit": "msecs"},
+					"cpu_time":          map[string]interface{}{"total": "0.8", "unit": "msecs"},
+					"rows":              map[string]interface{}{"total": "3", "unit": "rows"},
+					"execution_summary": map[string]interface{}{"num_executions": "2"},
+				}),
+			},
+			{
+				Index:               3,
+				Kind:                sppb.PlanNode_SCALAR,
+				DisplayName:         "Function",
+				ShortRepresentation: &sppb.PlanNode_ShortRepresentation{Description: "($SingerId = 1)"},
+			},
+		},
+	}
+}
@@ -0,0 +1,208 @@
+package statscopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+)
+
+// CopyIntervalFunc is 1 つの interval_end の Stats を Copy して、 Insert した件数を返す
+type CopyIntervalFunc func(ctx context.Context, intervalEnd time.Time) (int, error)
+
+// IntervalError is Backfill で Copy に失敗した interval_end と error
+type IntervalError struct {
+	IntervalEnd time.Time
+	Err         error
+}
+
+// Error is error interface
+func (e *IntervalError) Error() string {
+	return fmt.Sprintf("failed copy. intervalEnd=%s : %v", e.IntervalEnd.Format(time.RFC3339), e.Err)
+}
+
+// Unwrap is errors.Unwrap
+func (e *IntervalError) Unwrap() error {
+	return e.Err
+}
+
+// BackfillResult is Backfill の結果
+type BackfillResult struct {
+	// Copied is 1 件以上 Copy した interval_end
+	Copied []time.Time
+
+	// Empty is Stats が 0 件だった interval_end
+	Empty []time.Time
+
+	// Failed is Copy に失敗した interval_end
+	// Checkpoint は最初に失敗した interval_end より前までしか進まないので、次の Backfill では失敗した interval_end と、
+	// その後に成功した interval_end も再度 Copy される
+	Failed []*IntervalError
+
+	// InsertCount is BigQuery に Insert した件数の合計
+	InsertCount int
+
+	// Checkpoint is Backfill 後の Checkpoint. WithCheckpoint を指定していない場合は連続して成功した最後の interval_end
+	Checkpoint time.Time
+}
+
+type backfillOptions struct {
+	checkpointStore CheckpointStore
+	checkpointKey   string
+	stopOnError     bool
+}
+
+// BackfillOptions is Backfill の Options
+type BackfillOptions func(*backfillOptions)
+
+// WithCheckpoint is 最後に Copy した interval_end を store の key に保存して、次の Backfill では続きから Copy する
+// BackfillStats で key を空にした場合は Copy 先の BigQuery Table と Stats の Table から作成する
+// BackfillStatsToSink では Sink から key を作れないので、 key を指定する
+//
+// Checkpoint は連続して成功した interval_end までしか進まないので、途中で失敗した場合は次の Backfill で失敗した後の interval_end も再度 Copy する
+// InserterSink の InsertID による重複排除は短時間のベストエフォートなので、再度 Copy した Row は重複することがある
+func WithCheckpoint(store CheckpointStore, key string) BackfillOptions {
+	return func(ops *backfillOptions) {
+		ops.checkpointStore = store
+		ops.checkpointKey = key
+	}
+}
+
+// WithStopOnError is Copy に失敗した時点で Backfill を止める
+// 省略した場合は失敗した interval_end を BackfillResult.Failed に入れて、最後まで Copy する
+func WithStopOnError() BackfillOptions {
+	return func(ops *backfillOptions) {
+		ops.stopOnError = true
+	}
+}
+
+// Backfill is from から to までの granularity の interval_end を古い順に copyFunc で Copy する
+//
+// SPANNER_SYS の Retention より古い interval_end と、集計が終わっていない可能性がある直近の interval_end は対象にしない
+// WithCheckpoint を指定した場合は Checkpoint の次の interval_end から Copy して、連続して成功した最後の interval_end を Checkpoint に保存する
+// 失敗した interval_end より後の interval_end は成功しても Checkpoint を進めないので、次の Backfill で再度 Copy される
+// Copy の失敗は BackfillResult.Failed に入れて、 error は Checkpoint の読み書きに失敗した場合と ctx が終了した場合に返す
+func Backfill(ctx context.Context, granularity Granularity, from time.Time, to time.Time, copyFunc CopyIntervalFunc, ops ...BackfillOptions) (result *BackfillResult, err error) {
+	opt := backfillOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	ctx = trace.StartSpan(ctx, "spanner.statscopy.Backfill")
+	defer func() {
+		if result != nil {
+			trace.SetAttributesKV(ctx, map[string]interface{}{
+				"copiedCount": len(result.Copied),
+				"emptyCount":  len(result.Empty),
+				"failedCount": len(result.Failed),
+				"insertCount": result.InsertCount,
+			})
+		}
+		trace.EndSpan(ctx, err)
+	}()
+
+	d := granularity.Duration()
+	if d == 0 {
+		return nil, fmt.Errorf("invalid granularity %s", granularity)
+	}
+	if opt.checkpointStore != nil && len(opt.checkpointKey) < 1 {
+		return nil, errors.New("checkpoint key is required")
+	}
+
+	now := time.Now().UTC()
+	if oldest := now.Add(-granularity.Retention()); from.Before(oldest) {
+		from = oldest
+	}
+	if latest := now.Truncate(d).Add(-d); to.After(latest) {
+		to = latest
+	}
+
+	result = &BackfillResult{}
+	if opt.checkpointStore != nil {
+		checkpoint, err := opt.checkpointStore.Get(ctx, opt.checkpointKey)
+		if err != nil {
+			return nil, err
+		}
+		result.Checkpoint = checkpoint
+		if !checkpoint.IsZero() && !checkpoint.Add(d).Before(from) {
+			from = checkpoint.Add(d)
+		}
+	}
+
+	var failed bool
+	for _, intervalEnd := range granularity.Intervals(from, to) {
+		if err := ctx.Err(); err != nil {
+			return result, err
+		}
+
+		n, err := copyFunc(ctx, intervalEnd)
+		result.InsertCount += n
+		if err != nil {
+			if ctx.Err() != nil {
+				return result, ctx.Err()
+			}
+			result.Failed = append(result.Failed, &IntervalError{IntervalEnd: intervalEnd, Err: err})
+			if opt.stopOnError {
+				return result, nil
+			}
+			failed = true
+			continue
+		}
+		if n == 0 {
+			result.Empty = append(result.Empty, intervalEnd)
+		} else {
+			result.Copied = append(result.Copied, intervalEnd)
+		}
+		if failed {
+			continue
+		}
+		if opt.checkpointStore != nil {
+			if err := opt.checkpointStore.Put(ctx, opt.checkpointKey, intervalEnd); err != nil {
+				return result, err
+			}
+		}
+		result.Checkpoint = intervalEnd
+	}
+	return result, nil
+}
+
+// BackfillStats is 指定したSpannerClientを利用して、 from から to までの kind の Stats を BigQuery に Copy する
+// Granularity は table の suffix から決める
+// spannerClient が nil の場合は Service の Spanner を利用する
+// BigQuery には InserterSink で Streaming Insert する. 他の書き込み先を使う場合は BackfillStatsToSink を使う
+func BackfillStats[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, dataset *bigquery.Dataset, bigQueryTable string, from time.Time, to time.Time, ops ...BackfillOptions) (*BackfillResult, error) {
+	opt := backfillOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+	if opt.checkpointStore != nil && len(opt.checkpointKey) < 1 {
+		ops = append(ops, WithCheckpoint(opt.checkpointStore, CheckpointKey(table, dataset, bigQueryTable)))
+	}
+
+	return BackfillStatsToSink(ctx, s, kind, spannerClient, table, NewInserterSink(s.BQ, dataset, bigQueryTable), from, to, ops...)
+}
+
+// BackfillStatsToSink is 指定したSpannerClientを利用して、 from から to までの kind の Stats を sink に書き込む
+// Granularity は table の suffix から決める
+// spannerClient が nil の場合は Service の Spanner を利用する
+// WithCheckpoint を指定する場合は key も指定する
+func BackfillStatsToSink[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, sink Sink, from time.Time, to time.Time, ops ...BackfillOptions) (*BackfillResult, error) {
+	granularity, err := GranularityFromTable(table)
+	if err != nil {
+		return nil, err
+	}
+
+	return Backfill(ctx, granularity, from, to, func(ctx context.Context, intervalEnd time.Time) (int, error) {
+		return CopyToSink(ctx, s, kind, spannerClient, table, sink, intervalEnd)
+	}, ops...)
+}
+
+// CheckpointKey is Stats の Table と Copy 先の BigQuery Table から Checkpoint の key を作成する
+// 複数の Spanner DB を同じ BigQuery Table に Copy する場合は、 DB ごとに別の key を WithCheckpoint に指定する
+func CheckpointKey(table string, dataset *bigquery.Dataset, bigQueryTable string) string {
+	return fmt.Sprintf("%s:%s.%s.%s", table, dataset.ProjectID, dataset.DatasetID, bigQueryTable)
+}
@@ -0,0 +1,209 @@
+package statscopy_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestGranularityFromTable(t *testing.T) {
+	cases := []struct {
+		table string
+		want  statscopy.Granularity
+	}{
+		{string(statscopy.QueryStatsTopMinuteTable), statscopy.GranularityMinute},
+		{string(statscopy.QueryStatsTop10MinuteTable), statscopy.Granularity10Minute},
+		{string(statscopy.QueryStatsTopHourTable), statscopy.GranularityHour},
+		{string(statscopy.TableSizesStats1HourTable), statscopy.GranularityHour},
+		{string(statscopy.SplitStatsTopMinuteTable), statscopy.GranularityMinute},
+	}
+	for _, tt := range cases {
+		t.Run(tt.table, func(t *testing.T) {
+			got, err := statscopy.GranularityFromTable(tt.table)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.want {
+				t.Errorf("want %s but got %s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := statscopy.GranularityFromTable("spanner_sys.query_stats_top"); err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestGranularity_Intervals(t *testing.T) {
+	from := time.Date(2021, 1, 13, 15, 5, 30, 0, time.UTC)
+	to := time.Date(2021, 1, 13, 15, 40, 0, 0, time.UTC)
+
+	got := statscopy.Granularity10Minute.Intervals(from, to)
+	want := []time.Time{
+		time.Date(2021, 1, 13, 15, 10, 0, 0, time.UTC),
+		time.Date(2021, 1, 13, 15, 20, 0, 0, time.UTC),
+		time.Date(2021, 1, 13, 15, 30, 0, 0, time.UTC),
+		time.Date(2021, 1, 13, 15, 40, 0, 0, time.UTC),
+	}
+	if e, g := len(want), len(got); e != g {
+		t.Fatalf("want len %d but got %d. %v", e, g, got)
+	}
+	for i := range want {
+		if !want[i].Equal(got[i]) {
+			t.Errorf("%d: want %s but got %s", i, want[i], got[i])
+		}
+	}
+}
+
+func TestBackfill(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	from := now.Add(-10 * time.Minute)
+	to := now.Add(-5 * time.Minute)
+	failAt := now.Add(-7 * time.Minute)
+	emptyAt := now.Add(-9 * time.Minute)
+
+	store := statscopy.NewMemoryCheckpointStore()
+	var called []time.Time
+	copyFunc := func(ctx context.Context, intervalEnd time.Time) (int, error) {
+		called = append(called, intervalEnd)
+		if intervalEnd.Equal(failAt) {
+			return 0, errors.New("failed")
+		}
+		if intervalEnd.Equal(emptyAt) {
+			return 0, nil
+		}
+		return 2, nil
+	}
+
+	result, err := statscopy.Backfill(ctx, statscopy.GranularityMinute, from, to, copyFunc, statscopy.WithCheckpoint(store, "query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 6, len(called); e != g {
+		t.Fatalf("want copy count %d but got %d", e, g)
+	}
+	if e, g := 4, len(result.Copied); e != g {
+		t.Errorf("want copied %d but got %d", e, g)
+	}
+	if e, g := 1, len(result.Empty); e != g {
+		t.Fatalf("want empty %d but got %d", e, g)
+	}
+	if !result.Empty[0].Equal(emptyAt) {
+		t.Errorf("want empty %s but got %s", emptyAt, result.Empty[0])
+	}
+	if e, g := 1, len(result.Failed); e != g {
+		t.Fatalf("want failed %d but got %d", e, g)
+	}
+	if !result.Failed[0].IntervalEnd.Equal(failAt) {
+		t.Errorf("want failed %s but got %s", failAt, result.Failed[0].IntervalEnd)
+	}
+	if e, g := 8, result.InsertCount; e != g {
+		t.Errorf("want insert count %d but got %d", e, g)
+	}
+
+	// Checkpoint は失敗した interval_end の手前までしか進まない
+	checkpoint, err := store.Get(ctx, "query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := failAt.Add(-time.Minute); !e.Equal(checkpoint) {
+		t.Errorf("want checkpoint %s but got %s", e, checkpoint)
+	}
+
+	// 次の Backfill は Checkpoint の次から Copy する
+	called = nil
+	failAt = time.Time{}
+	result, err = statscopy.Backfill(ctx, statscopy.GranularityMinute, from, to, copyFunc, statscopy.WithCheckpoint(store, "query"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 3, len(called); e != g {
+		t.Fatalf("want copy count %d but got %d", e, g)
+	}
+	if !called[0].Equal(now.Add(-7 * time.Minute)) {
+		t.Errorf("want resume from %s but got %s", now.Add(-7*time.Minute), called[0])
+	}
+	if !result.Checkpoint.Equal(to) {
+		t.Errorf("want checkpoint %s but got %s", to, result.Checkpoint)
+	}
+}
+
+func TestBackfill_Retention(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC()
+	var called []time.Time
+	_, err := statscopy.Backfill(ctx, statscopy.GranularityMinute, now.Add(-24*time.Hour), now.Add(time.Hour), func(ctx context.Context, intervalEnd time.Time) (int, error) {
+		called = append(called, intervalEnd)
+		return 0, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(called) < 1 {
+		t.Fatal("want called but not called")
+	}
+	if oldest := now.Add(-statscopy.GranularityMinute.Retention()); called[0].Before(oldest) {
+		t.Errorf("want after %s but got %s", oldest, called[0])
+	}
+	if latest := called[len(called)-1]; !latest.Before(now.Truncate(time.Minute)) {
+		t.Errorf("want before %s but got %s", now.Truncate(time.Minute), latest)
+	}
+}
+
+func TestBackfill_StopOnError(t *testing.T) {
+	ctx := context.Background()
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	var count int
+	result, err := statscopy.Backfill(ctx, statscopy.GranularityMinute, now.Add(-10*time.Minute), now.Add(-5*time.Minute), func(ctx context.Context, intervalEnd time.Time) (int, error) {
+		count++
+		return 0, errors.New("failed")
+	}, statscopy.WithStopOnError())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, count; e != g {
+		t.Errorf("want copy count %d but got %d", e, g)
+	}
+	if e, g := 1, len(result.Failed); e != g {
+		t.Errorf("want failed %d but got %d", e, g)
+	}
+}
+
+func TestBackfillStatsToSink(t *testing.T) {
+	ctx := context.Background()
+
+	// Spanner Client が無いので Copy は失敗して、 Sink には何も書き込まれない
+	s := &statscopy.Service{}
+	var buf bytes.Buffer
+	sink := statscopy.NewWriterSink(&buf)
+
+	now := time.Now().UTC().Truncate(time.Minute)
+	result, err := statscopy.BackfillStatsToSink(ctx, s, statscopy.QueryStatsKind, nil, string(statscopy.QueryStatsTopMinuteTable), sink, now.Add(-3*time.Minute), now.Add(-3*time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(result.Failed); e != g {
+		t.Fatalf("want failed %d but got %d", e, g)
+	}
+	if !errors.Is(result.Failed[0], statscopy.ErrRequiredSpannerClient) {
+		t.Errorf("want ErrRequiredSpannerClient but got %v", result.Failed[0])
+	}
+	if buf.Len() > 0 {
+		t.Errorf("want empty sink but got %s", buf.String())
+	}
+
+	// Sink からは Checkpoint の key を作れない
+	_, err = statscopy.BackfillStatsToSink(ctx, s, statscopy.QueryStatsKind, nil, string(statscopy.QueryStatsTopMinuteTable), sink, now.Add(-3*time.Minute), now.Add(-3*time.Minute),
+		statscopy.WithCheckpoint(statscopy.NewMemoryCheckpointStore(), ""))
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+}
@@ -0,0 +1,187 @@
+package statscopy
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+// CheckpointStore is Backfill で最後に Copy した interval_end を保存する
+type CheckpointStore interface {
+	// Get is key の最後に Copy した interval_end を返す
+	// まだ保存されていない場合は zero value を返す
+	Get(ctx context.Context, key string) (time.Time, error)
+
+	// Put is key の最後に Copy した interval_end を保存する
+	Put(ctx context.Context, key string, intervalEnd time.Time) error
+}
+
+var _ CheckpointStore = &MemoryCheckpointStore{}
+
+// MemoryCheckpointStore is Memory 上に保存する CheckpointStore
+// 1 つの Process の中で完結する場合や UnitTest で使う
+type MemoryCheckpointStore struct {
+	mutex       sync.Mutex
+	checkpoints map[string]time.Time
+}
+
+// NewMemoryCheckpointStore is MemoryCheckpointStore を返す
+func NewMemoryCheckpointStore() *MemoryCheckpointStore {
+	return &MemoryCheckpointStore{
+		checkpoints: map[string]time.Time{},
+	}
+}
+
+// Get is CheckpointStore interface
+func (s *MemoryCheckpointStore) Get(ctx context.Context, key string) (time.Time, error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return s.checkpoints[key], nil
+}
+
+// Put is CheckpointStore interface
+func (s *MemoryCheckpointStore) Put(ctx context.Context, key string, intervalEnd time.Time) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	s.checkpoints[key] = intervalEnd
+	return nil
+}
+
+// DefaultSpannerCheckpointTable is SpannerCheckpointStore の Table Name の default
+const DefaultSpannerCheckpointTable = "StatsCopyCheckpoints"
+
+// CreateSpannerCheckpointTableStatement is SpannerCheckpointStore の DDL を返す
+func CreateSpannerCheckpointTableStatement(table string) string {
+	return fmt.Sprintf(`
+CREATE TABLE %s (
+    CheckpointKey STRING(MAX) NOT NULL,
+    IntervalEnd TIMESTAMP NOT NULL,
+    UpdatedAt TIMESTAMP NOT NULL OPTIONS (allow_commit_timestamp=true),
+) PRIMARY KEY (CheckpointKey)`, table)
+}
+
+var _ CheckpointStore = &SpannerCheckpointStore{}
+
+// SpannerCheckpointStore is Spanner に保存する CheckpointStore
+// Stats を取得する Spanner DB とは別の DB に作成してもよい
+type SpannerCheckpointStore struct {
+	spannerClient *spanner.Client
+	table         string
+}
+
+// NewSpannerCheckpointStore is SpannerCheckpointStore を返す
+// table は CreateSpannerCheckpointTableStatement で作成した Table の Name
+func NewSpannerCheckpointStore(spannerClient *spanner.Client, table string) *SpannerCheckpointStore {
+	return &SpannerCheckpointStore{
+		spannerClient: spannerClient,
+		table:         table,
+	}
+}
+
+// Get is CheckpointStore interface
+func (s *SpannerCheckpointStore) Get(ctx context.Context, key string) (time.Time, error) {
+	row, err := s.spannerClient.Single().ReadRow(ctx, s.table, spanner.Key{key}, []string{"IntervalEnd"})
+	if err != nil {
+		if spanner.ErrCode(err) == codes.NotFound {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed read checkpoint. key=%s : %w", key, err)
+	}
+	var intervalEnd time.Time
+	if err := row.Columns(&intervalEnd); err != nil {
+		return time.Time{}, fmt.Errorf("failed read checkpoint. key=%s : %w", key, err)
+	}
+	return intervalEnd, nil
+}
+
+// Put is CheckpointStore interface
+func (s *SpannerCheckpointStore) Put(ctx context.Context, key string, intervalEnd time.Time) error {
+	_, err := s.spannerClient.Apply(ctx, []*spanner.Mutation{
+		spanner.InsertOrUpdate(s.table, []string{"CheckpointKey", "IntervalEnd", "UpdatedAt"}, []interface{}{key, intervalEnd, spanner.CommitTimestamp}),
+	})
+	if err != nil {
+		return fmt.Errorf("failed put checkpoint. key=%s : %w", key, err)
+	}
+	return nil
+}
+
+// CheckpointBigQueryTableSchema is BigQueryCheckpointStore の BigQuery Table Schema
+var CheckpointBigQueryTableSchema = bigquery.Schema{
+	{Name: "checkpoint_key", Required: true, Type: bigquery.StringFieldType},
+	{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+	{Name: "updated_at", Required: true, Type: bigquery.TimestampFieldType},
+}
+
+var _ CheckpointStore = &BigQueryCheckpointStore{}
+
+// BigQueryCheckpointStore is BigQuery に保存する CheckpointStore
+// Put のたびに Row を追加して、 Get では key の最大の interval_end を返す
+type BigQueryCheckpointStore struct {
+	bq      *bigquery.Client
+	dataset *bigquery.Dataset
+	table   string
+}
+
+// NewBigQueryCheckpointStore is BigQueryCheckpointStore を返す
+// table は CreateTable で作成した Table の Name
+func NewBigQueryCheckpointStore(bq *bigquery.Client, dataset *bigquery.Dataset, table string) *BigQueryCheckpointStore {
+	return &BigQueryCheckpointStore{
+		bq:      bq,
+		dataset: dataset,
+		table:   table,
+	}
+}
+
+// CreateTable is Checkpoint を保存する Table を BigQuery に作成する
+func (s *BigQueryCheckpointStore) CreateTable(ctx context.Context) error {
+	return s.bq.DatasetInProject(s.dataset.ProjectID, s.dataset.DatasetID).Table(s.table).Create(ctx, &bigquery.TableMetadata{
+		Name:   s.table,
+		Schema: CheckpointBigQueryTableSchema,
+	})
+}
+
+// Get is CheckpointStore interface
+func (s *BigQueryCheckpointStore) Get(ctx context.Context, key string) (time.Time, error) {
+	q := s.bq.Query(fmt.Sprintf("SELECT MAX(interval_end) AS interval_end FROM `%s.%s.%s` WHERE checkpoint_key = @key", s.dataset.ProjectID, s.dataset.DatasetID, s.table))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "key", Value: key},
+	}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("failed read checkpoint. key=%s : %w", key, err)
+	}
+	var row struct {
+		IntervalEnd bigquery.NullTimestamp `bigquery:"interval_end"`
+	}
+	if err := it.Next(&row); err != nil {
+		if err == iterator.Done {
+			return time.Time{}, nil
+		}
+		return time.Time{}, fmt.Errorf("failed read checkpoint. key=%s : %w", key, err)
+	}
+	if !row.IntervalEnd.Valid {
+		return time.Time{}, nil
+	}
+	return row.IntervalEnd.Timestamp, nil
+}
+
+// Put is CheckpointStore interface
+func (s *BigQueryCheckpointStore) Put(ctx context.Context, key string, intervalEnd time.Time) error {
+	row := &bigquery.ValuesSaver{
+		Schema:   CheckpointBigQueryTableSchema,
+		InsertID: fmt.Sprintf("GCPBOX_StatsCopyCheckpoint-_-%s-_-%v", key, intervalEnd.Unix()),
+		Row:      []bigquery.Value{key, intervalEnd, time.Now()},
+	}
+	if err := s.bq.DatasetInProject(s.dataset.ProjectID, s.dataset.DatasetID).Table(s.table).Inserter().Put(ctx, row); err != nil {
+		return fmt.Errorf("failed put checkpoint. key=%s : %w", key, err)
+	}
+	return nil
+}
@@ -0,0 +1,55 @@
+package statscopy_test
+
+import (
+	"context"
+	"fmt"
+	"math/rand"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestSpannerCheckpointStore(t *testing.T) {
+	ctx := context.Background()
+
+	const project = "hoge"
+	const instance = "fuga"
+	database := fmt.Sprintf("test%d", rand.Intn(10000000))
+
+	newSpannerDatabase(t, project, instance, fmt.Sprintf("CREATE DATABASE %s", database), []string{
+		statscopy.CreateSpannerCheckpointTableStatement(statscopy.DefaultSpannerCheckpointTable),
+	})
+	sc, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", project, instance, database))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	store := statscopy.NewSpannerCheckpointStore(sc, statscopy.DefaultSpannerCheckpointTable)
+	got, err := store.Get(ctx, "query")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !got.IsZero() {
+		t.Errorf("want zero but got %s", got)
+	}
+
+	for _, intervalEnd := range []time.Time{
+		time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC),
+		time.Date(2021, 1, 13, 15, 1, 0, 0, time.UTC),
+	} {
+		if err := store.Put(ctx, "query", intervalEnd); err != nil {
+			t.Fatal(err)
+		}
+		got, err := store.Get(ctx, "query")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !got.Equal(intervalEnd) {
+			t.Errorf("want %s but got %s", intervalEnd, got)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package statscopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/option"
+)
+
+// ErrClientPoolClosed is Close した ClientPool から Client を取得しようとした
+var ErrClientPoolClosed = errors.New("client pool is closed")
+
+// ClientPool is 複数の Spanner DB の Client を最大 maxOpen 個まで保持する
+//
+// maxOpen 個の Client を開いている時に別の DB の Client を Acquire すると、使われていない Client を古い順に Close する
+// 全ての Client が使われている場合は Release されるまで待つ
+type ClientPool struct {
+	maxOpen int
+	config  spanner.ClientConfig
+	ops     []option.ClientOption
+
+	mutex   sync.Mutex
+	clients map[string]*pooledClient
+	changed chan struct{}
+	closed  bool
+}
+
+type pooledClient struct {
+	client   *spanner.Client
+	inUse    int
+	lastUsed time.Time
+}
+
+// NewClientPool is ClientPool を返す
+// config, ops は spanner.NewClientWithConfig に渡す
+func NewClientPool(maxOpen int, config spanner.ClientConfig, ops ...option.ClientOption) *ClientPool {
+	if maxOpen < 1 {
+		maxOpen = 1
+	}
+	return &ClientPool{
+		maxOpen: maxOpen,
+		config:  config,
+		ops:     ops,
+		clients: map[string]*pooledClient{},
+		changed: make(chan struct{}),
+	}
+}
+
+// Acquire is database (projects/{PROJECT_ID}/instances/{INSTANCE}/databases/{DB}) の Client を返す
+// 使い終わったら Release を呼ぶ
+func (p *ClientPool) Acquire(ctx context.Context, database string) (*spanner.Client, error) {
+	for {
+		p.mutex.Lock()
+		if p.closed {
+			p.mutex.Unlock()
+			return nil, ErrClientPoolClosed
+		}
+		if c, ok := p.clients[database]; ok {
+			if c.client != nil {
+				c.inUse++
+				c.lastUsed = time.Now()
+				p.mutex.Unlock()
+				return c.client, nil
+			}
+			// 他の goroutine が Client を作成中なので待つ
+		} else if len(p.clients) < p.maxOpen {
+			p.clients[database] = &pooledClient{inUse: 1}
+			p.mutex.Unlock()
+			return p.open(ctx, database)
+		} else if evicted := p.evict(); evicted != nil {
+			p.mutex.Unlock()
+			evicted.Close()
+			continue
+		}
+		ch := p.changed
+		p.mutex.Unlock()
+
+		select {
+		case <-ch:
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		}
+	}
+}
+
+// Release is Acquire で取得した database の Client を返却する
+func (p *ClientPool) Release(database string) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	c, ok := p.clients[database]
+	if !ok {
+		return
+	}
+	c.inUse--
+	c.lastUsed = time.Now()
+	p.notify()
+}
+
+// Close is 全ての Client を Close する
+func (p *ClientPool) Close() {
+	p.mutex.Lock()
+	clients := p.clients
+	p.clients = map[string]*pooledClient{}
+	p.closed = true
+	p.notify()
+	p.mutex.Unlock()
+
+	for _, c := range clients {
+		if c.client != nil {
+			c.client.Close()
+		}
+	}
+}
+
+// open is database の Client を作成する
+// Acquire で p.clients に場所を確保してから呼ぶ
+func (p *ClientPool) open(ctx context.Context, database string) (*spanner.Client, error) {
+	client, err := spanner.NewClientWithConfig(ctx, database, p.config, p.ops...)
+
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+	defer p.notify()
+
+	if err != nil {
+		delete(p.clients, database)
+		return nil, fmt.Errorf("failed spanner.NewClient. database=%s : %w", database, err)
+	}
+	if p.closed {
+		client.Close()
+		return nil, ErrClientPoolClosed
+	}
+	c := p.clients[database]
+	c.client = client
+	c.lastUsed = time.Now()
+	return client, nil
+}
+
+// evict is 使われていない Client の中で最後に使われたのが一番古いものを p.clients から取り除いて返す
+// mutex を取得してから呼ぶ
+func (p *ClientPool) evict() *spanner.Client {
+	var oldest string
+	for name, c := range p.clients {
+		if c.client == nil || c.inUse > 0 {
+			continue
+		}
+		if len(oldest) < 1 || c.lastUsed.Before(p.clients[oldest].lastUsed) {
+			oldest = name
+		}
+	}
+	if len(oldest) < 1 {
+		return nil
+	}
+	client := p.clients[oldest].client
+	delete(p.clients, oldest)
+	return client
+}
+
+// notify is Acquire で待っている goroutine を起こす
+// mutex を取得してから呼ぶ
+func (p *ClientPool) notify() {
+	close(p.changed)
+	p.changed = make(chan struct{})
+}
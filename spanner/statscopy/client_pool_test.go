@@ -0,0 +1,73 @@
+package statscopy_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestClientPool(t *testing.T) {
+	ctx := context.Background()
+
+	// Client を作るだけで Query は投げないので、繋がらない Endpoint にしておく
+	pool := statscopy.NewClientPool(1, spanner.ClientConfig{DisableNativeMetrics: true},
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	defer pool.Close()
+
+	const db1 = "projects/hoge/instances/fuga/databases/db1"
+	const db2 = "projects/hoge/instances/fuga/databases/db2"
+
+	c1, err := pool.Acquire(ctx, db1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := pool.Acquire(ctx, db1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c1 != again {
+		t.Error("want same client")
+	}
+	pool.Release(db1)
+
+	// db1 が使われている間は db2 の Client は作れない
+	waitCtx, cancel := context.WithTimeout(ctx, 100*time.Millisecond)
+	defer cancel()
+	if _, err := pool.Acquire(waitCtx, db2); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("want context.DeadlineExceeded but got %v", err)
+	}
+
+	// db1 が Release されると db1 を Close して db2 の Client を作る
+	done := make(chan error)
+	go func() {
+		_, err := pool.Acquire(ctx, db2)
+		done <- err
+	}()
+	time.Sleep(50 * time.Millisecond)
+	pool.Release(db1)
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timeout")
+	}
+	pool.Release(db2)
+
+	pool.Close()
+	if _, err := pool.Acquire(ctx, db1); !errors.Is(err, statscopy.ErrClientPoolClosed) {
+		t.Errorf("want ErrClientPoolClosed but got %v", err)
+	}
+}
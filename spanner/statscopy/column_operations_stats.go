@@ -0,0 +1,98 @@
+package statscopy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const columnOperationsStats = `
+SELECT
+  interval_end,
+  table_name,
+  column_name,
+  read_query_count,
+  where_clause_count,
+  groupby_count,
+  join_count,
+FROM {{.Table}}
+WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
+`
+
+// pgColumnOperationsStats is PostgreSQL Dialect の DB 用の columnOperationsStats
+const pgColumnOperationsStats = `
+SELECT
+  interval_end,
+  table_name,
+  column_name,
+  read_query_count,
+  where_clause_count,
+  groupby_count,
+  join_count
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
+type ColumnOperationsStatsTable string
+
+const (
+	ColumnOperationsStatsMinuteTable   ColumnOperationsStatsTable = "spanner_sys.column_operations_stats_minute"
+	ColumnOperationsStats10MinuteTable ColumnOperationsStatsTable = "spanner_sys.column_operations_stats_10minute"
+	ColumnOperationsStatsHourTable     ColumnOperationsStatsTable = "spanner_sys.column_operations_stats_hour"
+)
+
+var _ bigquery.ValueSaver = &ColumnOperationsStat{}
+
+type ColumnOperationsStat struct {
+	IntervalEnd      time.Time `spanner:"interval_end"`       // End of the time interval that the included column operations occurred in.
+	TableName        string    `spanner:"table_name"`         // Name of the table.
+	ColumnName       string    `spanner:"column_name"`        // Name of the column.
+	ReadQueryCount   int64     `spanner:"read_query_count"`   // Number of queries or reads reading from the column.
+	WhereClauseCount int64     `spanner:"where_clause_count"` // Number of queries using the column as a filter in their WHERE clause.
+	GroupByCount     int64     `spanner:"groupby_count"`      // Number of queries using the column in their GROUP BY clause.
+	JoinCount        int64     `spanner:"join_count"`         // Number of queries using the column in their JOIN clause.
+}
+
+// Save is bigquery.ValueSaver interface
+func (s *ColumnOperationsStat) Save() (map[string]bigquery.Value, string, error) {
+	insertID, err := s.InsertID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed InsertID() : %w", err)
+	}
+	return map[string]bigquery.Value{
+		"interval_end":       s.IntervalEnd,
+		"table_name":         s.TableName,
+		"column_name":        s.ColumnName,
+		"read_query_count":   s.ReadQueryCount,
+		"where_clause_count": s.WhereClauseCount,
+		"groupby_count":      s.GroupByCount,
+		"join_count":         s.JoinCount,
+	}, insertID, nil
+}
+
+// InsertID is 同じデータをBigQueryになるべく入れないようにデータからInsertIDを作成する
+func (s *ColumnOperationsStat) InsertID() (string, error) {
+	if s.IntervalEnd.IsZero() {
+		return "", errors.New("IntervalEnd is required")
+	}
+	if len(s.TableName) < 1 {
+		return "", errors.New("TableName is required")
+	}
+	if len(s.ColumnName) < 1 {
+		return "", errors.New("ColumnName is required")
+	}
+	return fmt.Sprintf("GCPBOX_SpannerColumnOperationsStat-_-%v-_-%v-_-%v", s.IntervalEnd.Unix(), s.TableName, s.ColumnName), nil
+}
+
+// ColumnOperationsStatsBigQueryTableSchema is BigQuery Table Schema
+var ColumnOperationsStatsBigQueryTableSchema = bigquery.Schema{
+	{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+	{Name: "table_name", Required: true, Type: bigquery.StringFieldType},
+	{Name: "column_name", Required: true, Type: bigquery.StringFieldType},
+	{Name: "read_query_count", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "where_clause_count", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "groupby_count", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "join_count", Required: true, Type: bigquery.IntegerFieldType},
+}
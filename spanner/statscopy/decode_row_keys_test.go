@@ -0,0 +1,99 @@
+package statscopy
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"github.com/google/go-cmp/cmp"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials/insecure"
+)
+
+func TestDecodeRowKeys(t *testing.T) {
+	ctx := context.Background()
+
+	// INFORMATION_SCHEMA の Query が失敗するように、繋がらない Endpoint にしておく
+	const database = "projects/hoge/instances/fuga/databases/db1"
+	sc, err := spanner.NewClientWithConfig(ctx, database, spanner.ClientConfig{DisableNativeMetrics: true},
+		option.WithEndpoint("localhost:1"),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	newRows := func() []*LockStat {
+		return []*LockStat{
+			{RowRangeStartKey: []byte("Albums(1,2)+")},
+			{RowRangeStartKey: []byte("invalid")},
+		}
+	}
+
+	t.Run("primary key columns error", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+
+		s := &Service{}
+		rows := newRows()
+		decodeRowKeys[LockStat](ctx, s, sc, rows)
+
+		want := &LockStatRowKey{
+			Table: "Albums",
+			Keys:  []*LockStatRowKeyPart{{Value: "1"}, {Value: "2"}},
+			Range: true,
+		}
+		if !cmp.Equal(want, rows[0].RowKey) {
+			t.Errorf("diff %s", cmp.Diff(want, rows[0].RowKey))
+		}
+		if rows[1].RowKey != nil {
+			t.Errorf("want nil but got %+v", rows[1].RowKey)
+		}
+		// 失敗した結果は保持しない
+		if _, ok := s.primaryKeys.Load(database); ok {
+			t.Error("want not cached")
+		}
+	})
+
+	t.Run("cached primary key columns", func(t *testing.T) {
+		ctx, cancel := context.WithTimeout(ctx, 500*time.Millisecond)
+		defer cancel()
+
+		s := &Service{}
+		s.primaryKeys.Store(database, map[string][]string{"Albums": {"SingerId", "AlbumId"}})
+		rows := newRows()
+		decodeRowKeys[LockStat](ctx, s, sc, rows)
+
+		want := []*LockStatRowKeyPart{{Column: "SingerId", Value: "1"}, {Column: "AlbumId", Value: "2"}}
+		if !cmp.Equal(want, rows[0].RowKey.Keys) {
+			t.Errorf("diff %s", cmp.Diff(want, rows[0].RowKey.Keys))
+		}
+	})
+}
+
+func TestPrimaryKeyColumnsQueryWithDialect(t *testing.T) {
+	got, err := primaryKeyColumnsQueryWithDialect(databasepb.DatabaseDialect_POSTGRESQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "table_schema = 'public'") {
+		t.Errorf("want public schema but got %s", got)
+	}
+	got, err = primaryKeyColumnsQueryWithDialect(databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "TABLE_SCHEMA = ''") {
+		t.Errorf("want default schema but got %s", got)
+	}
+	if _, err := primaryKeyColumnsQueryWithDialect(databasepb.DatabaseDialect(100)); !errors.Is(err, ErrUnsupportedDialect) {
+		t.Errorf("want ErrUnsupportedDialect but got %v", err)
+	}
+}
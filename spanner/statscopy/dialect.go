@@ -0,0 +1,67 @@
+package statscopy
+
+import (
+	"context"
+	"errors"
+	"fmt"
+
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+// ErrUnsupportedDialect is StatsKind が DB の Dialect の Query を持っていない
+var ErrUnsupportedDialect = errors.New("unsupported database dialect")
+
+const detectDialectQuery = `SELECT option_value FROM information_schema.database_options WHERE option_name = 'database_dialect'`
+
+// DetectDialect is spannerClient の DB の Dialect を information_schema.database_options から取得する
+// database_dialect が無い場合は GOOGLE_STANDARD_SQL を返す
+// Project, Instance, Database が存在しない場合は spabox.ErrNotFound を返す
+func DetectDialect(ctx context.Context, spannerClient *spanner.Client) (databasepb.DatabaseDialect, error) {
+	iter := spannerClient.Single().Query(ctx, spanner.NewStatement(detectDialectQuery))
+	defer iter.Stop()
+
+	row, err := iter.Next()
+	if err == iterator.Done {
+		return databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL, nil
+	}
+	if err != nil {
+		if spanner.ErrCode(err) == codes.NotFound {
+			return databasepb.DatabaseDialect_DATABASE_DIALECT_UNSPECIFIED, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
+		}
+		return databasepb.DatabaseDialect_DATABASE_DIALECT_UNSPECIFIED, fmt.Errorf("failed detect dialect. database=%s : %w", spannerClient.DatabaseName(), err)
+	}
+	var v string
+	if err := row.Columns(&v); err != nil {
+		return databasepb.DatabaseDialect_DATABASE_DIALECT_UNSPECIFIED, fmt.Errorf("failed detect dialect. database=%s : %w", spannerClient.DatabaseName(), err)
+	}
+	dialect, ok := databasepb.DatabaseDialect_value[v]
+	if !ok {
+		return databasepb.DatabaseDialect_DATABASE_DIALECT_UNSPECIFIED, fmt.Errorf("unknown dialect %s. database=%s : %w", v, spannerClient.DatabaseName(), ErrUnsupportedDialect)
+	}
+	return databasepb.DatabaseDialect(dialect), nil
+}
+
+// SetDialect is database (projects/{PROJECT_ID}/instances/{INSTANCE}/databases/{DB}) の Dialect を設定する
+// Admin API などで Dialect が分かっている場合に設定しておくと、 DetectDialect を実行しない
+func (s *Service) SetDialect(database string, dialect databasepb.DatabaseDialect) {
+	s.dialects.Store(database, dialect)
+}
+
+// dialect is spannerClient の DB の Dialect を返す
+// 一度取得した Dialect は DB ごとに保持しておく
+func (s *Service) dialect(ctx context.Context, spannerClient *spanner.Client) (databasepb.DatabaseDialect, error) {
+	name := spannerClient.DatabaseName()
+	if v, ok := s.dialects.Load(name); ok {
+		return v.(databasepb.DatabaseDialect), nil
+	}
+	dialect, err := DetectDialect(ctx, spannerClient)
+	if err != nil {
+		return dialect, err
+	}
+	s.dialects.Store(name, dialect)
+	return dialect, nil
+}
@@ -0,0 +1,136 @@
+package statscopy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sppb "cloud.google.com/go/spanner/apiv1/spannerpb"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestStatsKind_StatementWithDialect(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+
+	st, err := statscopy.QueryStatsKind.StatementWithDialect(databasepb.DatabaseDialect_POSTGRESQL, string(statscopy.QueryStatsTopMinuteTable), intervalEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(st.SQL, "FROM spanner_sys.query_stats_top_minute\nWHERE interval_end = $1") {
+		t.Errorf("unexpected SQL %s", st.SQL)
+	}
+	p1, ok := st.Params["p1"].(time.Time)
+	if !ok {
+		t.Fatalf("want p1 time.Time but got %T", st.Params["p1"])
+	}
+	if !p1.Equal(intervalEnd) || p1.Location() != time.UTC {
+		t.Errorf("want p1 %s but got %s", intervalEnd.UTC(), p1)
+	}
+
+	st, err = statscopy.QueryStatsKind.StatementWithDialect(databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL, string(statscopy.QueryStatsTopMinuteTable), intervalEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := st.Params["IntervalEnd"]; !ok {
+		t.Errorf("want IntervalEnd param but got %v", st.Params)
+	}
+
+	_, err = statscopy.LockStatsKind.StatementWithDialect(databasepb.DatabaseDialect_POSTGRESQL, string(statscopy.LockStatsTopMinuteTable), intervalEnd)
+	if !errors.Is(err, statscopy.ErrUnsupportedDialect) {
+		t.Errorf("want ErrUnsupportedDialect but got %v", err)
+	}
+}
+
+func TestDetectDialect(t *testing.T) {
+	ctx := context.Background()
+
+	const project = "hoge"
+	const instance = "fuga"
+
+	cases := []struct {
+		name            string
+		createStatement func(database string) string
+		dialect         databasepb.DatabaseDialect
+	}{
+		{"googlesql", func(database string) string { return fmt.Sprintf("CREATE DATABASE %s", database) }, databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL},
+		{"postgresql", func(database string) string { return fmt.Sprintf(`CREATE DATABASE "%s"`, database) }, databasepb.DatabaseDialect_POSTGRESQL},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			database := fmt.Sprintf("test%d", rand.Intn(10000000))
+			newSpannerDatabaseWithDialect(t, project, instance, tt.createStatement(database), tt.dialect)
+
+			sc, err := spanner.NewClient(ctx, fmt.Sprintf("projects/%s/instances/%s/databases/%s", project, instance, database))
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer sc.Close()
+
+			got, err := statscopy.DetectDialect(ctx, sc)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got != tt.dialect {
+				t.Errorf("want %s but got %s", tt.dialect, got)
+			}
+		})
+	}
+}
+
+// notFoundSpannerServer is 全ての Session の作成に NotFound を返す Spanner Server
+type notFoundSpannerServer struct {
+	sppb.UnimplementedSpannerServer
+}
+
+func (s *notFoundSpannerServer) CreateSession(ctx context.Context, req *sppb.CreateSessionRequest) (*sppb.Session, error) {
+	return nil, status.Errorf(codes.NotFound, "Database not found: %s", req.GetDatabase())
+}
+
+func (s *notFoundSpannerServer) BatchCreateSessions(ctx context.Context, req *sppb.BatchCreateSessionsRequest) (*sppb.BatchCreateSessionsResponse, error) {
+	return nil, status.Errorf(codes.NotFound, "Database not found: %s", req.GetDatabase())
+}
+
+func TestDetectDialect_NotFound(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	sppb.RegisterSpannerServer(server, &notFoundSpannerServer{})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	sc, err := spanner.NewClientWithConfig(ctx, "projects/hoge/instances/fuga/databases/notfound", spanner.ClientConfig{DisableNativeMetrics: true},
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer sc.Close()
+
+	_, err = statscopy.DetectDialect(ctx, sc)
+	if !errors.Is(err, spabox.ErrNotFound) {
+		t.Errorf("want ErrNotFound but got %v", err)
+	}
+}
@@ -0,0 +1,395 @@
+package statscopy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	sadDatabase "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sadInstance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/api/iterator"
+)
+
+// FleetTarget is Fleet で Copy する Stats の Table と Copy 先の BigQuery Table
+type FleetTarget struct {
+	// Name is StatsKind の Name
+	Name string
+
+	// Table is Stats を取得する Spanner の Table
+	Table string
+
+	// BigQueryTable is Copy 先の BigQuery Table
+	BigQueryTable string
+
+	// Schema is Copy 先の BigQuery Table の Schema. TaggedSchema になっている
+	Schema bigquery.Schema
+
+	copy func(ctx context.Context, s *Service, spannerClient *spanner.Client, database *spabox.Database, dataset *bigquery.Dataset, intervalEnd time.Time) (int, error)
+}
+
+// NewFleetTarget is kind の table を bigQueryTable に CopyTagged する FleetTarget を返す
+func NewFleetTarget[T any, PT StatsRow[T]](kind *StatsKind[T, PT], table string, bigQueryTable string) *FleetTarget {
+	return &FleetTarget{
+		Name:          kind.Name,
+		Table:         table,
+		BigQueryTable: bigQueryTable,
+		Schema:        TaggedSchema(kind.Schema),
+		copy: func(ctx context.Context, s *Service, spannerClient *spanner.Client, database *spabox.Database, dataset *bigquery.Dataset, intervalEnd time.Time) (int, error) {
+			return CopyTagged(ctx, s, kind, spannerClient, database, table, dataset, bigQueryTable, intervalEnd)
+		},
+	}
+}
+
+// CreateTable is FleetTarget の BigQueryTable を作成する
+func (t *FleetTarget) CreateTable(ctx context.Context, s *Service, dataset *bigquery.Dataset) error {
+	return s.BQ.Dataset(dataset.DatasetID).Table(t.BigQueryTable).Create(ctx, &bigquery.TableMetadata{
+		Name:   t.BigQueryTable,
+		Schema: t.Schema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type: bigquery.DayPartitioningType,
+		},
+	})
+}
+
+// FleetTargetResult is 1 つの DB の 1 つの FleetTarget の Copy の結果
+type FleetTargetResult struct {
+	Target      *FleetTarget
+	InsertCount int
+	Err         error
+}
+
+// FleetResult is 1 つの DB の Copy の結果
+type FleetResult struct {
+	Database *spabox.Database
+
+	// Targets is FleetTarget ごとの結果. Fleet.Copy に渡した targets と同じ順番
+	Targets []*FleetTargetResult
+
+	// Err is Spanner Client の取得に失敗するなどして、 DB の Copy を始められなかった時の error
+	Err error
+}
+
+// Failed is 失敗した FleetTarget があるかどうか
+func (r *FleetResult) Failed() bool {
+	if r.Err != nil {
+		return true
+	}
+	for _, t := range r.Targets {
+		if t.Err != nil {
+			return true
+		}
+	}
+	return false
+}
+
+// InsertCount is 全ての FleetTarget で Insert した件数の合計
+func (r *FleetResult) InsertCount() int {
+	var count int
+	for _, t := range r.Targets {
+		count += t.InsertCount
+	}
+	return count
+}
+
+// FleetResults is Fleet.Copy の DB ごとの結果
+type FleetResults []*FleetResult
+
+// Succeeded is 全ての FleetTarget の Copy に成功した DB の結果を返す
+func (rs FleetResults) Succeeded() FleetResults {
+	var ret FleetResults
+	for _, r := range rs {
+		if !r.Failed() {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+// Failed is Copy に失敗した FleetTarget がある DB の結果を返す
+func (rs FleetResults) Failed() FleetResults {
+	var ret FleetResults
+	for _, r := range rs {
+		if r.Failed() {
+			ret = append(ret, r)
+		}
+	}
+	return ret
+}
+
+type fleetOptions struct {
+	maxOpenClients int
+	concurrency    int
+	clientConfig   spanner.ClientConfig
+}
+
+// FleetOptions is Fleet の Options
+type FleetOptions func(*fleetOptions)
+
+// WithMaxOpenClients is 同時に開いておく Spanner Client の最大数を指定する
+// 省略した場合は 10
+func WithMaxOpenClients(n int) FleetOptions {
+	return func(ops *fleetOptions) {
+		ops.maxOpenClients = n
+	}
+}
+
+// WithConcurrency is 同時に Copy する DB の数を指定する
+// 省略した場合は WithMaxOpenClients と同じ
+func WithConcurrency(n int) FleetOptions {
+	return func(ops *fleetOptions) {
+		ops.concurrency = n
+	}
+}
+
+// WithClientConfig is Spanner Client を作成する時の ClientConfig を指定する
+func WithClientConfig(config spanner.ClientConfig) FleetOptions {
+	return func(ops *fleetOptions) {
+		ops.clientConfig = config
+	}
+}
+
+// Fleet is 複数の Project の全ての Spanner Instance, Database の Stats を BigQuery に Copy する
+//
+// Stats の Row には TaggedRow で project_id, instance_id, database_id を追加するので、複数の DB を同じ BigQuery Table に Copy できる
+type Fleet struct {
+	s             *Service
+	instanceAdmin *sadInstance.InstanceAdminClient
+	databaseAdmin *sadDatabase.DatabaseAdminClient
+	pool          *ClientPool
+	concurrency   int
+}
+
+// NewFleet is Fleet を返す
+// s の BQ を Copy 先に利用する
+func NewFleet(s *Service, instanceAdmin *sadInstance.InstanceAdminClient, databaseAdmin *sadDatabase.DatabaseAdminClient, ops ...FleetOptions) *Fleet {
+	opt := fleetOptions{
+		maxOpenClients: 10,
+		clientConfig: spanner.ClientConfig{
+			SessionPoolConfig: spanner.SessionPoolConfig{
+				MinOpened: 1,  // DB ごとに同時に投げるのは FleetTarget の数の query
+				MaxOpened: 10, // FleetTarget の数くらいあれば足りる
+			},
+		},
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+	if opt.concurrency < 1 {
+		opt.concurrency = opt.maxOpenClients
+	}
+
+	return &Fleet{
+		s:             s,
+		instanceAdmin: instanceAdmin,
+		databaseAdmin: databaseAdmin,
+		pool:          NewClientPool(opt.maxOpenClients, opt.clientConfig),
+		concurrency:   opt.concurrency,
+	}
+}
+
+// Close is Fleet が開いた Spanner Client を Close する
+// Service, Admin Client は Close しない
+func (f *Fleet) Close() {
+	f.pool.Close()
+}
+
+// FleetDiscoverError is Discover で Instance, Database の一覧の取得に失敗した Project, Instance
+type FleetDiscoverError struct {
+	// Parent is 一覧の取得に失敗した projects/{PROJECT_ID} か projects/{PROJECT_ID}/instances/{INSTANCE}
+	Parent string
+	Err    error
+}
+
+func (e *FleetDiscoverError) Error() string {
+	return fmt.Sprintf("failed discover. parent=%s : %v", e.Parent, e.Err)
+}
+
+// Unwrap is return unwrap error
+func (e *FleetDiscoverError) Unwrap() error {
+	return e.Err
+}
+
+// FleetDiscoverErrors is Discover で一覧の取得に失敗した全ての Project, Instance
+type FleetDiscoverErrors []*FleetDiscoverError
+
+func (es FleetDiscoverErrors) Error() string {
+	msgs := make([]string, len(es))
+	for i, e := range es {
+		msgs[i] = e.Error()
+	}
+	return strings.Join(msgs, "\n")
+}
+
+// Unwrap is return unwrap errors
+func (es FleetDiscoverErrors) Unwrap() []error {
+	ret := make([]error, len(es))
+	for i, e := range es {
+		ret[i] = e
+	}
+	return ret
+}
+
+// Discover is projectIDs の全ての Spanner Instance の Database を返す
+// Organization 配下の全ての Project を対象にする場合は cloudresourcemanager.ResourceManagerService.GetRelatedProject で取得した Project を渡す
+// 権限が無いなどで一覧の取得に失敗した Project, Instance は FleetDiscoverErrors に入れて、残りの Project, Instance の Database を探し続ける
+// 失敗した Project, Instance があった場合も、見つかった Database と FleetDiscoverErrors を返す
+func (f *Fleet) Discover(ctx context.Context, projectIDs ...string) (dbs []*spabox.Database, err error) {
+	var failures FleetDiscoverErrors
+	ctx = trace.StartSpan(ctx, "spanner.statscopy.Fleet.Discover")
+	defer func() {
+		trace.SetAttributesKV(ctx, map[string]interface{}{
+			"projectCount":  len(projectIDs),
+			"databaseCount": len(dbs),
+			"failedCount":   len(failures),
+		})
+		trace.EndSpan(ctx, err)
+	}()
+
+	for _, projectID := range projectIDs {
+		parent := fmt.Sprintf("projects/%s", projectID)
+		instances, err := f.listInstances(ctx, parent)
+		if err != nil {
+			failures = append(failures, &FleetDiscoverError{Parent: parent, Err: fmt.Errorf("failed ListInstances : %w", err)})
+			continue
+		}
+		for _, instance := range instances {
+			found, err := f.listDatabases(ctx, instance)
+			if err != nil {
+				failures = append(failures, &FleetDiscoverError{Parent: instance, Err: fmt.Errorf("failed ListDatabases : %w", err)})
+				continue
+			}
+			dbs = append(dbs, found...)
+		}
+	}
+	if len(failures) > 0 {
+		return dbs, failures
+	}
+	return dbs, nil
+}
+
+// listInstances is parent (projects/{PROJECT_ID}) の Instance の Name を返す
+func (f *Fleet) listInstances(ctx context.Context, parent string) ([]string, error) {
+	var ret []string
+	iit := f.instanceAdmin.ListInstances(ctx, &instancepb.ListInstancesRequest{
+		Parent: parent,
+	})
+	for {
+		ins, err := iit.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		ret = append(ret, ins.GetName())
+	}
+	return ret, nil
+}
+
+// listDatabases is instance (projects/{PROJECT_ID}/instances/{INSTANCE}) の READY の Database を返す
+// Admin API で分かる Dialect は Service に設定しておく
+func (f *Fleet) listDatabases(ctx context.Context, instance string) ([]*spabox.Database, error) {
+	var ret []*spabox.Database
+	dit := f.databaseAdmin.ListDatabases(ctx, &databasepb.ListDatabasesRequest{
+		Parent: instance,
+	})
+	for {
+		db, err := dit.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+		if db.GetState() != databasepb.Database_READY {
+			continue
+		}
+		d, err := spabox.SplitDatabaseName(db.GetName())
+		if err != nil {
+			return nil, err
+		}
+		f.s.SetDialect(db.GetName(), db.GetDatabaseDialect())
+		ret = append(ret, d)
+	}
+	return ret, nil
+}
+
+// Copy is databases の targets の intervalEnd の Stats を BigQuery に Copy する
+// DB は WithConcurrency の数ずつ、 1 つの DB の targets は全て並行に Copy する
+// 失敗した DB, FleetTarget は FleetResults に入れて、全ての DB の Copy を試みる
+func (f *Fleet) Copy(ctx context.Context, databases []*spabox.Database, dataset *bigquery.Dataset, targets []*FleetTarget, intervalEnd time.Time) (results FleetResults, err error) {
+	ctx = trace.StartSpan(ctx, "spanner.statscopy.Fleet.Copy")
+	defer func() {
+		trace.SetAttributesKV(ctx, map[string]interface{}{
+			"databaseCount": len(databases),
+			"targetCount":   len(targets),
+			"failedCount":   len(results.Failed()),
+			"intervalEnd":   intervalEnd.Format("2006-01-02 15:04:05"),
+		})
+		trace.EndSpan(ctx, err)
+	}()
+
+	results = make(FleetResults, len(databases))
+	sem := make(chan struct{}, f.concurrency)
+	wg := &sync.WaitGroup{}
+	for i, db := range databases {
+		wg.Add(1)
+		go func(i int, db *spabox.Database) {
+			defer wg.Done()
+
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				results[i] = &FleetResult{Database: db, Err: ctx.Err()}
+				return
+			}
+			defer func() { <-sem }()
+
+			results[i] = f.copyDatabase(ctx, db, dataset, targets, intervalEnd)
+		}(i, db)
+	}
+	wg.Wait()
+
+	return results, ctx.Err()
+}
+
+// copyDatabase is 1 つの DB の targets を並行に Copy する
+func (f *Fleet) copyDatabase(ctx context.Context, db *spabox.Database, dataset *bigquery.Dataset, targets []*FleetTarget, intervalEnd time.Time) *FleetResult {
+	result := &FleetResult{
+		Database: db,
+		Targets:  make([]*FleetTargetResult, len(targets)),
+	}
+
+	name := db.ToSpannerDatabaseName()
+	spannerClient, err := f.pool.Acquire(ctx, name)
+	if err != nil {
+		result.Err = err
+		return result
+	}
+	defer f.pool.Release(name)
+
+	wg := &sync.WaitGroup{}
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target *FleetTarget) {
+			defer wg.Done()
+
+			n, err := target.copy(ctx, f.s, spannerClient, db, dataset, intervalEnd)
+			result.Targets[i] = &FleetTargetResult{
+				Target:      target,
+				InsertCount: n,
+				Err:         err,
+			}
+		}(i, target)
+	}
+	wg.Wait()
+	return result
+}
@@ -0,0 +1,197 @@
+package statscopy_test
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/rand"
+	"net"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	sadDatabase "cloud.google.com/go/spanner/admin/database/apiv1"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	sadInstance "cloud.google.com/go/spanner/admin/instance/apiv1"
+	"cloud.google.com/go/spanner/admin/instance/apiv1/instancepb"
+	"github.com/google/go-cmp/cmp"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/api/option"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestTaggedRow_Save(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	stat := &statscopy.TableSizesStat{IntervalEnd: intervalEnd, TableName: "Users", UsedBytes: 1024}
+	db1 := &spabox.Database{ProjectID: "hoge", Instance: "fuga", Database: "db1"}
+	db2 := &spabox.Database{ProjectID: "hoge", Instance: "fuga", Database: "db2"}
+
+	row, insertID1, err := (&statscopy.TaggedRow{Database: db1, Row: stat}).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for k, v := range map[string]bigquery.Value{"project_id": "hoge", "instance_id": "fuga", "database_id": "db1", "table_name": "Users"} {
+		if row[k] != v {
+			t.Errorf("%s want %v but got %v", k, v, row[k])
+		}
+	}
+	if !strings.HasPrefix(insertID1, "GCPBOX_SpannerTableSizesStat-_-") {
+		t.Errorf("unexpected insertID %s", insertID1)
+	}
+
+	_, insertID2, err := (&statscopy.TaggedRow{Database: db2, Row: stat}).Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if insertID1 == insertID2 {
+		t.Errorf("want different insertID but got same %s", insertID1)
+	}
+}
+
+func TestNewFleetTarget(t *testing.T) {
+	target := statscopy.NewFleetTarget(statscopy.QueryStatsKind, string(statscopy.QueryStatsTopMinuteTable), "query_stats_minute")
+	if e, g := "QueryStats", target.Name; e != g {
+		t.Errorf("want name %s but got %s", e, g)
+	}
+	if e, g := len(statscopy.QueryStatsBigQueryTableSchema)+len(statscopy.TaggedColumns), len(target.Schema); e != g {
+		t.Errorf("want schema len %d but got %d", e, g)
+	}
+	if e, g := "project_id", target.Schema[0].Name; e != g {
+		t.Errorf("want first column %s but got %s", e, g)
+	}
+}
+
+func TestFleet_Discover(t *testing.T) {
+	ctx := context.Background()
+
+	const project = "fleet"
+	instance := fmt.Sprintf("fleet%d", rand.Intn(10000000))
+	databases := []string{"db1", "db2"}
+	for _, database := range databases {
+		newSpannerDatabase(t, project, instance, fmt.Sprintf("CREATE DATABASE %s", database), nil)
+	}
+
+	instanceAdmin, err := sadInstance.NewInstanceAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instanceAdmin.Close()
+	databaseAdmin, err := sadDatabase.NewDatabaseAdminClient(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databaseAdmin.Close()
+
+	fleet := statscopy.NewFleet(&statscopy.Service{}, instanceAdmin, databaseAdmin)
+	defer fleet.Close()
+
+	dbs, err := fleet.Discover(ctx, project)
+	if err != nil {
+		t.Fatal(err)
+	}
+	found := map[string]bool{}
+	for _, db := range dbs {
+		if db.Instance == instance {
+			found[db.Database] = true
+		}
+	}
+	for _, database := range databases {
+		if !found[database] {
+			t.Errorf("%s is not found in %v", database, dbs)
+		}
+	}
+}
+
+// fakeInstanceAdminServer is projectID が denied の Project の ListInstances に PermissionDenied を返す Instance Admin Server
+type fakeInstanceAdminServer struct {
+	instancepb.UnimplementedInstanceAdminServer
+}
+
+func (s *fakeInstanceAdminServer) ListInstances(ctx context.Context, req *instancepb.ListInstancesRequest) (*instancepb.ListInstancesResponse, error) {
+	if req.GetParent() == "projects/denied" {
+		return nil, status.Error(codes.PermissionDenied, "permission denied")
+	}
+	return &instancepb.ListInstancesResponse{
+		Instances: []*instancepb.Instance{{Name: fmt.Sprintf("%s/instances/instance1", req.GetParent())}},
+	}, nil
+}
+
+// fakeDatabaseAdminServer is 全ての Instance に db1 を返す Database Admin Server
+type fakeDatabaseAdminServer struct {
+	databasepb.UnimplementedDatabaseAdminServer
+}
+
+func (s *fakeDatabaseAdminServer) ListDatabases(ctx context.Context, req *databasepb.ListDatabasesRequest) (*databasepb.ListDatabasesResponse, error) {
+	return &databasepb.ListDatabasesResponse{
+		Databases: []*databasepb.Database{{Name: fmt.Sprintf("%s/databases/db1", req.GetParent()), State: databasepb.Database_READY}},
+	}, nil
+}
+
+func TestFleet_Discover_PartialFailure(t *testing.T) {
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+	defer cancel()
+
+	lis, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := grpc.NewServer()
+	instancepb.RegisterInstanceAdminServer(server, &fakeInstanceAdminServer{})
+	databasepb.RegisterDatabaseAdminServer(server, &fakeDatabaseAdminServer{})
+	go func() {
+		_ = server.Serve(lis)
+	}()
+	defer server.Stop()
+
+	ops := []option.ClientOption{
+		option.WithEndpoint(lis.Addr().String()),
+		option.WithoutAuthentication(),
+		option.WithGRPCDialOption(grpc.WithTransportCredentials(insecure.NewCredentials())),
+	}
+	instanceAdmin, err := sadInstance.NewInstanceAdminClient(ctx, ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer instanceAdmin.Close()
+	databaseAdmin, err := sadDatabase.NewDatabaseAdminClient(ctx, ops...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer databaseAdmin.Close()
+
+	fleet := statscopy.NewFleet(&statscopy.Service{}, instanceAdmin, databaseAdmin)
+	defer fleet.Close()
+
+	// 権限の無い Project があっても、他の Project の Database は見つかる
+	dbs, err := fleet.Discover(ctx, "project1", "denied", "project2")
+	var failures statscopy.FleetDiscoverErrors
+	if !errors.As(err, &failures) {
+		t.Fatalf("want FleetDiscoverErrors but got %v", err)
+	}
+	if e, g := 1, len(failures); e != g {
+		t.Fatalf("want failures %d but got %d", e, g)
+	}
+	if e, g := "projects/denied", failures[0].Parent; e != g {
+		t.Errorf("want Parent %s but got %s", e, g)
+	}
+	if e, g := codes.PermissionDenied, status.Code(errors.Unwrap(failures[0].Err)); e != g {
+		t.Errorf("want code %s but got %s", e, g)
+	}
+	var got []string
+	for _, db := range dbs {
+		got = append(got, db.ToSpannerDatabaseName())
+	}
+	want := []string{
+		"projects/project1/instances/instance1/databases/db1",
+		"projects/project2/instances/instance1/databases/db1",
+	}
+	if diff := cmp.Diff(want, got); diff != "" {
+		t.Errorf("databases diff (-want +got):\n%s", diff)
+	}
+}
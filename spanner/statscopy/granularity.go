@@ -0,0 +1,100 @@
+package statscopy
+
+import (
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Granularity is SPANNER_SYS の Stats を集計している Interval の粒度
+type Granularity int
+
+const (
+	// GranularityMinute is 1分ごとの Stats. spanner_sys.*_minute
+	GranularityMinute Granularity = iota + 1
+
+	// Granularity10Minute is 10分ごとの Stats. spanner_sys.*_10minute
+	Granularity10Minute
+
+	// GranularityHour is 1時間ごとの Stats. spanner_sys.*_hour, spanner_sys.*_1hour
+	GranularityHour
+)
+
+// Duration is 1 Interval の長さを返す
+func (g Granularity) Duration() time.Duration {
+	switch g {
+	case GranularityMinute:
+		return time.Minute
+	case Granularity10Minute:
+		return 10 * time.Minute
+	case GranularityHour:
+		return time.Hour
+	default:
+		return 0
+	}
+}
+
+// Retention is SPANNER_SYS に Stats が保持されている期間を返す
+// https://cloud.google.com/spanner/docs/introspection/query-statistics#data_retention
+func (g Granularity) Retention() time.Duration {
+	switch g {
+	case GranularityMinute:
+		return 6 * time.Hour
+	case Granularity10Minute:
+		return 4 * 24 * time.Hour
+	case GranularityHour:
+		return 30 * 24 * time.Hour
+	default:
+		return 0
+	}
+}
+
+// String is fmt.Stringer
+func (g Granularity) String() string {
+	switch g {
+	case GranularityMinute:
+		return "minute"
+	case Granularity10Minute:
+		return "10minute"
+	case GranularityHour:
+		return "hour"
+	default:
+		return fmt.Sprintf("Granularity(%d)", int(g))
+	}
+}
+
+// GranularityFromTable is SPANNER_SYS の Table Name の suffix から Granularity を返す
+// spanner_sys.query_stats_top_10minute であれば Granularity10Minute になる
+func GranularityFromTable(table string) (Granularity, error) {
+	t := strings.ToLower(table)
+	switch {
+	case strings.HasSuffix(t, "_10minute"):
+		return Granularity10Minute, nil
+	case strings.HasSuffix(t, "_minute"):
+		return GranularityMinute, nil
+	case strings.HasSuffix(t, "_hour"), strings.HasSuffix(t, "_1hour"):
+		return GranularityHour, nil
+	default:
+		return 0, fmt.Errorf("unknown granularity table. table=%s", table)
+	}
+}
+
+// Intervals is from から to までの間にある interval_end を古い順に返す
+// from, to も含む. Granularity の境界にない場合は from は切り上げ、 to は切り捨てる
+func (g Granularity) Intervals(from time.Time, to time.Time) []time.Time {
+	d := g.Duration()
+	if d == 0 {
+		return nil
+	}
+	start := from.UTC().Truncate(d)
+	if start.Before(from) {
+		start = start.Add(d)
+	}
+	end := to.UTC().Truncate(d)
+
+	var ret []time.Time
+	for v := start; !v.After(end); v = v.Add(d) {
+		ret = append(ret, v)
+	}
+	return ret
+}
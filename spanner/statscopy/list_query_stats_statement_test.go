@@ -0,0 +1,42 @@
+package statscopy
+
+import (
+	"strings"
+	"testing"
+
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+)
+
+func TestListQueryStatsFromBigQueryStatement(t *testing.T) {
+	cases := []struct {
+		name          string
+		tagged        bool
+		database      *spabox.Database
+		wantPartition string
+		wantFilter    bool
+	}{
+		{"not tagged", false, nil, "PARTITION BY interval_end, text_fingerprint)", false},
+		{"tagged", true, nil, "PARTITION BY interval_end, text_fingerprint, project_id, instance_id, database_id)", false},
+		{"tagged with database", true, &spabox.Database{ProjectID: "hoge", Instance: "fuga", Database: "db1"}, "PARTITION BY interval_end, text_fingerprint, project_id, instance_id, database_id)", true},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got := listQueryStatsFromBigQueryStatement("`hoge.fuga.query_stats`", tt.tagged, tt.database)
+			if !strings.Contains(got, "QUALIFY ROW_NUMBER() OVER ("+tt.wantPartition+" = 1") {
+				t.Errorf("want dedup by %s but got %s", tt.wantPartition, got)
+			}
+			if e, g := tt.wantFilter, strings.Contains(got, "database_id = @DatabaseID"); e != g {
+				t.Errorf("want database filter %v but got %s", e, got)
+			}
+		})
+	}
+}
+
+func TestIsTaggedSchema(t *testing.T) {
+	if isTaggedSchema(QueryStatsBigQueryTableSchema) {
+		t.Error("want not tagged but got tagged")
+	}
+	if !isTaggedSchema(TaggedSchema(QueryStatsBigQueryTableSchema)) {
+		t.Error("want tagged but got not tagged")
+	}
+}
@@ -72,6 +72,22 @@ type LockStat struct {
 	            The format of this value is tablename.columnname.
 	*/
 	SampleLockRequests []*LockStatSampleLockRequest `spanner:"sample_lock_requests"`
+
+	// RowKey is RowRangeStartKey を Decode したもの
+	// Get, Copy の時に INFORMATION_SCHEMA の Primary Key の Column 名を付けて設定する. Column 名を取得できなかった場合は Column が空になる
+	// table(key-parts) 形式として Decode できなかった場合は nil
+	RowKey *LockStatRowKey `spanner:"-"`
+}
+
+// decodeRowKey is RowRangeStartKey を Decode して RowKey に設定する
+func (s *LockStat) decodeRowKey(primaryKeyColumns map[string][]string) {
+	key, err := ParseLockStatRowKey(s.RowRangeStartKey)
+	if err != nil {
+		s.RowKey = nil
+		return
+	}
+	key.LabelColumns(primaryKeyColumns[key.Table])
+	s.RowKey = key
 }
 
 // Save is bigquery.ValueSaver interface
@@ -86,12 +102,22 @@ func (s *LockStat) Save() (map[string]bigquery.Value, string, error) {
 		lockReqs = append(lockReqs, lockReq.ToBQValue())
 	}
 
-	return map[string]bigquery.Value{
+	ret := map[string]bigquery.Value{
 		"interval_end":         s.IntervalEnd,
 		"row_range_start_key":  s.RowRangeStartKey,
 		"lock_wait_seconds":    s.LockWaitSeconds,
 		"sample_lock_requests": lockReqs,
-	}, insertID, nil
+	}
+	if s.RowKey != nil {
+		var keys []map[string]bigquery.Value
+		for _, key := range s.RowKey.Keys {
+			keys = append(keys, key.ToBQValue())
+		}
+		ret["row_key_table"] = s.RowKey.Table
+		ret["row_key_is_range"] = s.RowKey.Range
+		ret["row_keys"] = keys
+	}
+	return ret, insertID, nil
 }
 
 // InsertID is 同じデータをBigQueryになるべく入れないようにデータからInsertIDを作成する
@@ -116,4 +142,14 @@ var LockStatsBigQueryTableSchema = bigquery.Schema{
 			{Name: "lock_mode", Required: true, Type: bigquery.StringFieldType},
 			{Name: "column", Required: true, Type: bigquery.StringFieldType},
 		}},
+	{Name: "row_key_table", Required: false, Type: bigquery.StringFieldType},
+	{Name: "row_key_is_range", Required: false, Type: bigquery.BooleanFieldType},
+	{Name: "row_keys",
+		Required: false,
+		Repeated: true,
+		Type:     bigquery.RecordFieldType,
+		Schema: bigquery.Schema{
+			{Name: "column", Required: false, Type: bigquery.StringFieldType},
+			{Name: "value", Required: true, Type: bigquery.StringFieldType},
+		}},
 }
@@ -0,0 +1,245 @@
+package statscopy
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	"google.golang.org/api/iterator"
+)
+
+// LockStatRowKey is LockStat.RowRangeStartKey を Decode したもの
+type LockStatRowKey struct {
+	// Table is Lock の競合が発生した Table
+	Table string
+
+	// Keys is Primary Key の値. INFORMATION_SCHEMA から Column 名が分かった場合は Column が入る
+	Keys []*LockStatRowKeyPart
+
+	// Range is Row の範囲の開始の Key の場合 true. row_range_start_key の末尾の + を表す
+	Range bool
+}
+
+// LockStatRowKeyPart is Primary Key の 1 Column の値
+type LockStatRowKeyPart struct {
+	Column string
+	Value  string
+}
+
+// ToBQValue is BigQuery に Insert する値を返す
+func (p *LockStatRowKeyPart) ToBQValue() map[string]bigquery.Value {
+	return map[string]bigquery.Value{
+		"column": p.Column,
+		"value":  p.Value,
+	}
+}
+
+// String is table(key-parts) 形式にする
+func (k *LockStatRowKey) String() string {
+	values := make([]string, len(k.Keys))
+	for i, key := range k.Keys {
+		values[i] = key.Value
+	}
+	s := fmt.Sprintf("%s(%s)", k.Table, strings.Join(values, ","))
+	if k.Range {
+		s += "+"
+	}
+	return s
+}
+
+// LabelColumns is Keys に Primary Key の Column 名を順番に設定する
+// columns の方が少ない場合、残りの Keys の Column は空のままにする
+func (k *LockStatRowKey) LabelColumns(columns []string) {
+	for i, key := range k.Keys {
+		if i >= len(columns) {
+			return
+		}
+		key.Column = columns[i]
+	}
+}
+
+// ParseLockStatRowKey is row_range_start_key の table(key-parts) 形式を Decode する
+// 例: Singers(1), Albums(1,"abc")+
+// key-parts の " で囲まれた値は " を外して \ の Escape を戻す
+func ParseLockStatRowKey(key []byte) (*LockStatRowKey, error) {
+	s := strings.TrimSpace(string(key))
+	ret := &LockStatRowKey{}
+	if strings.HasSuffix(s, "+") {
+		ret.Range = true
+		s = strings.TrimSpace(strings.TrimSuffix(s, "+"))
+	}
+
+	open := strings.Index(s, "(")
+	if open < 1 || !strings.HasSuffix(s, ")") {
+		return nil, fmt.Errorf("invalid row range start key %q", string(key))
+	}
+	ret.Table = strings.TrimSpace(s[:open])
+
+	values, err := splitLockStatKeyParts(s[open+1 : len(s)-1])
+	if err != nil {
+		return nil, fmt.Errorf("invalid row range start key %q : %w", string(key), err)
+	}
+	for _, v := range values {
+		ret.Keys = append(ret.Keys, &LockStatRowKeyPart{Value: v})
+	}
+	return ret, nil
+}
+
+// splitLockStatKeyParts is key-parts を , で分割する. " で囲まれた中の , では分割しない
+func splitLockStatKeyParts(s string) ([]string, error) {
+	if len(strings.TrimSpace(s)) < 1 {
+		return nil, nil
+	}
+
+	var values []string
+	var b strings.Builder
+	var quoted, escaped, wasQuoted bool
+	for _, r := range s {
+		switch {
+		case escaped:
+			b.WriteRune(r)
+			escaped = false
+		case quoted && r == '\\':
+			escaped = true
+		case r == '"':
+			quoted = !quoted
+			wasQuoted = true
+		case !quoted && r == ' ' && (b.Len() < 1 || wasQuoted):
+			// " で囲まれていない値の前の空白と " で囲まれた値の外側の空白は値に含めない
+		case !quoted && r == ',':
+			values = append(values, lockStatKeyPartValue(b.String(), wasQuoted))
+			b.Reset()
+			wasQuoted = false
+		default:
+			b.WriteRune(r)
+		}
+	}
+	if quoted || escaped {
+		return nil, fmt.Errorf("unterminated quoted key part")
+	}
+	return append(values, lockStatKeyPartValue(b.String(), wasQuoted)), nil
+}
+
+func lockStatKeyPartValue(v string, quoted bool) string {
+	if quoted {
+		return v
+	}
+	return strings.TrimSpace(v)
+}
+
+const primaryKeyColumnsQuery = `
+SELECT
+  TABLE_NAME,
+  COLUMN_NAME
+FROM INFORMATION_SCHEMA.INDEX_COLUMNS
+WHERE TABLE_SCHEMA = '' AND INDEX_TYPE = 'PRIMARY_KEY'
+ORDER BY TABLE_NAME, ORDINAL_POSITION
+`
+
+const primaryKeyColumnsQueryPostgreSQL = `
+SELECT
+  table_name,
+  column_name
+FROM information_schema.index_columns
+WHERE table_schema = 'public' AND index_type = 'PRIMARY_KEY'
+ORDER BY table_name, ordinal_position
+`
+
+// PrimaryKeyColumns is INFORMATION_SCHEMA から Table ごとの Primary Key の Column 名を順番に取得する
+// GoogleSQL Dialect の DB の default schema の Table だけを対象にする. PostgreSQL Dialect の DB は PrimaryKeyColumnsWithDialect を使う
+func PrimaryKeyColumns(ctx context.Context, spannerClient *spanner.Client) (map[string][]string, error) {
+	return PrimaryKeyColumnsWithDialect(ctx, spannerClient, databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL)
+}
+
+// PrimaryKeyColumnsWithDialect is dialect の INFORMATION_SCHEMA から Table ごとの Primary Key の Column 名を順番に取得する
+// GoogleSQL は default schema, PostgreSQL は public schema の Table だけを対象にする
+func PrimaryKeyColumnsWithDialect(ctx context.Context, spannerClient *spanner.Client, dialect databasepb.DatabaseDialect) (map[string][]string, error) {
+	query, err := primaryKeyColumnsQueryWithDialect(dialect)
+	if err != nil {
+		return nil, err
+	}
+	iter := spannerClient.Single().Query(ctx, spanner.NewStatement(query))
+	defer iter.Stop()
+
+	ret := map[string][]string{}
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed get primary key columns. database=%s : %w", spannerClient.DatabaseName(), err)
+		}
+		var table, column string
+		if err := row.Columns(&table, &column); err != nil {
+			return nil, fmt.Errorf("failed get primary key columns. database=%s : %w", spannerClient.DatabaseName(), err)
+		}
+		ret[table] = append(ret[table], column)
+	}
+	return ret, nil
+}
+
+func primaryKeyColumnsQueryWithDialect(dialect databasepb.DatabaseDialect) (string, error) {
+	switch dialect {
+	case databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL, databasepb.DatabaseDialect_DATABASE_DIALECT_UNSPECIFIED:
+		return primaryKeyColumnsQuery, nil
+	case databasepb.DatabaseDialect_POSTGRESQL:
+		return primaryKeyColumnsQueryPostgreSQL, nil
+	default:
+		return "", fmt.Errorf("primary key columns. dialect=%s : %w", dialect, ErrUnsupportedDialect)
+	}
+}
+
+// rowKeyDecoder is Spanner から取得した後に INFORMATION_SCHEMA の Primary Key を使って Row Key を Decode する Row
+// StatsKind の Row が実装していると Get, Copy で Decode する
+type rowKeyDecoder interface {
+	decodeRowKey(primaryKeyColumns map[string][]string)
+}
+
+// decodeRowKeys is rows が rowKeyDecoder の場合に Primary Key の Column を取得して Decode する
+// Decode した Key は付加的な情報なので、 Primary Key の Column を取得できなかった場合も Get, Copy は失敗させない
+// その場合は Column 名の無い Key を設定して、 error は Trace にだけ記録する
+func decodeRowKeys[T any, PT StatsRow[T]](ctx context.Context, s *Service, spannerClient *spanner.Client, rows []PT) {
+	if len(rows) < 1 {
+		return
+	}
+	if _, ok := any(rows[0]).(rowKeyDecoder); !ok {
+		return
+	}
+	columns, err := s.primaryKeyColumns(ctx, spannerClient)
+	if err != nil {
+		trace.TracePrintf(ctx, map[string]interface{}{
+			"database": spannerClient.DatabaseName(),
+			"error":    err.Error(),
+		}, "failed get primary key columns. row keys are not labelled")
+		columns = map[string][]string{}
+	}
+	for _, row := range rows {
+		any(row).(rowKeyDecoder).decodeRowKey(columns)
+	}
+}
+
+// primaryKeyColumns is spannerClient の DB の PrimaryKeyColumns を返す
+// 一度取得した Column は DB ごとに保持しておくので、 Backfill などで Interval ごとに INFORMATION_SCHEMA を Query しない
+// 保持した後に作成された Table の Key には Column 名が付かない. 取得に失敗した場合は保持せずに、次回に再取得する
+// Dialect は Get, Copy の Query と同じく Service が DB ごとに保持しているものを使う
+func (s *Service) primaryKeyColumns(ctx context.Context, spannerClient *spanner.Client) (map[string][]string, error) {
+	name := spannerClient.DatabaseName()
+	if v, ok := s.primaryKeys.Load(name); ok {
+		return v.(map[string][]string), nil
+	}
+	dialect, err := s.dialect(ctx, spannerClient)
+	if err != nil {
+		return nil, err
+	}
+	columns, err := PrimaryKeyColumnsWithDialect(ctx, spannerClient, dialect)
+	if err != nil {
+		return nil, err
+	}
+	s.primaryKeys.Store(name, columns)
+	return columns, nil
+}
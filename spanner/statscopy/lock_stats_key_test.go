@@ -0,0 +1,107 @@
+package statscopy_test
+
+import (
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/google/go-cmp/cmp"
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestParseLockStatRowKey(t *testing.T) {
+	cases := []struct {
+		name string
+		key  string
+		want *statscopy.LockStatRowKey
+	}{
+		{"single", "Singers(1)", &statscopy.LockStatRowKey{
+			Table: "Singers",
+			Keys:  []*statscopy.LockStatRowKeyPart{{Value: "1"}},
+		}},
+		{"composite range", "Albums(1,2)+", &statscopy.LockStatRowKey{
+			Table: "Albums",
+			Keys:  []*statscopy.LockStatRowKeyPart{{Value: "1"}, {Value: "2"}},
+			Range: true,
+		}},
+		{"quoted", `Users("a,b", "c\"d")`, &statscopy.LockStatRowKey{
+			Table: "Users",
+			Keys:  []*statscopy.LockStatRowKeyPart{{Value: "a,b"}, {Value: `c"d`}},
+		}},
+		{"table start", "Singers()+", &statscopy.LockStatRowKey{
+			Table: "Singers",
+			Range: true,
+		}},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			got, err := statscopy.ParseLockStatRowKey([]byte(tt.key))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !cmp.Equal(tt.want, got) {
+				t.Errorf("diff %s", cmp.Diff(tt.want, got))
+			}
+			if e, g := tt.key, got.String(); tt.name != "quoted" && e != g {
+				t.Errorf("want String %s but got %s", e, g)
+			}
+		})
+	}
+}
+
+func TestParseLockStatRowKey_Invalid(t *testing.T) {
+	for _, key := range []string{"", "Singers", "(1)", "Singers(1", `Singers("1)`} {
+		if _, err := statscopy.ParseLockStatRowKey([]byte(key)); err == nil {
+			t.Errorf("%q want error but got nil", key)
+		}
+	}
+}
+
+func TestLockStatRowKey_LabelColumns(t *testing.T) {
+	key, err := statscopy.ParseLockStatRowKey([]byte("Albums(1,2)"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	key.LabelColumns([]string{"SingerId"})
+
+	want := []*statscopy.LockStatRowKeyPart{{Column: "SingerId", Value: "1"}, {Value: "2"}}
+	if !cmp.Equal(want, key.Keys) {
+		t.Errorf("diff %s", cmp.Diff(want, key.Keys))
+	}
+}
+
+func TestLockStat_Save(t *testing.T) {
+	stat := &statscopy.LockStat{
+		IntervalEnd:      time.Date(2022, 1, 1, 0, 0, 0, 0, time.UTC),
+		RowRangeStartKey: []byte("Singers(1)+"),
+		RowKey: &statscopy.LockStatRowKey{
+			Table: "Singers",
+			Keys:  []*statscopy.LockStatRowKeyPart{{Column: "SingerId", Value: "1"}},
+			Range: true,
+		},
+	}
+	got, _, err := stat.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Singers", got["row_key_table"]; e != g {
+		t.Errorf("want row_key_table %v but got %v", e, g)
+	}
+	if e, g := true, got["row_key_is_range"]; e != g {
+		t.Errorf("want row_key_is_range %v but got %v", e, g)
+	}
+	want := []map[string]bigquery.Value{{"column": "SingerId", "value": "1"}}
+	if !cmp.Equal(want, got["row_keys"]) {
+		t.Errorf("diff %s", cmp.Diff(want, got["row_keys"]))
+	}
+
+	stat.RowKey = nil
+	got, _, err = stat.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := got["row_key_table"]; ok {
+		t.Errorf("want no row_key_table but got %v", got["row_key_table"])
+	}
+}
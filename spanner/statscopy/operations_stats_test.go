@@ -0,0 +1,89 @@
+package statscopy_test
+
+import (
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestTableSizesStat_Save(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	s := &statscopy.TableSizesStat{IntervalEnd: intervalEnd, TableName: "Users", UsedBytes: 1024}
+	row, insertID, err := s.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "GCPBOX_SpannerTableSizesStat-_-1610550000-_-Users", insertID; e != g {
+		t.Errorf("want insertID %s but got %s", e, g)
+	}
+	if e, g := int64(1024), row["used_bytes"]; e != g {
+		t.Errorf("want used_bytes %v but got %v", e, g)
+	}
+
+	if _, err := (&statscopy.TableSizesStat{IntervalEnd: intervalEnd}).InsertID(); err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestTableOperationsStat_InsertID(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	s := &statscopy.TableOperationsStat{IntervalEnd: intervalEnd, TableName: "Users", ReadQueryCount: 3}
+	got, err := s.InsertID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "GCPBOX_SpannerTableOperationsStat-_-1610550000-_-Users"; e != got {
+		t.Errorf("want insertID %s but got %s", e, got)
+	}
+
+	if _, err := (&statscopy.TableOperationsStat{TableName: "Users"}).InsertID(); err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestColumnOperationsStat_InsertID(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	s := &statscopy.ColumnOperationsStat{IntervalEnd: intervalEnd, TableName: "Users", ColumnName: "Name"}
+	got, err := s.InsertID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e := "GCPBOX_SpannerColumnOperationsStat-_-1610550000-_-Users-_-Name"; e != got {
+		t.Errorf("want insertID %s but got %s", e, got)
+	}
+
+	if _, err := (&statscopy.ColumnOperationsStat{IntervalEnd: intervalEnd, TableName: "Users"}).InsertID(); err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestSplitStat_InsertID(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	a := &statscopy.SplitStat{IntervalEnd: intervalEnd, SplitStart: "Users(1)", SplitLimit: "Users(2)", CPUUsageScore: 100, AffectedTables: []string{"Users"}}
+	b := &statscopy.SplitStat{IntervalEnd: intervalEnd, SplitStart: "Users(1", SplitLimit: ")Users(2)"}
+
+	idA, err := a.InsertID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	idB, err := b.InsertID()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.HasPrefix(idA, "GCPBOX_SpannerSplitStat-_-1610550000-_-") {
+		t.Errorf("unexpected insertID %s", idA)
+	}
+	if idA == idB {
+		t.Errorf("want different insertID but got same %s", idA)
+	}
+
+	row, _, err := a.Save()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(row["affected_tables"].([]string)); e != g {
+		t.Errorf("want affected_tables len %d but got %d", e, g)
+	}
+}
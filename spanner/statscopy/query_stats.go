@@ -28,6 +28,27 @@ FROM {{.Table}}
 WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
 `
 
+// pgQueryStatsTopMinute is PostgreSQL Dialect の DB 用の queryStatsTopMinute
+const pgQueryStatsTopMinute = `
+SELECT
+  text,
+  text_truncated,
+  text_fingerprint,
+  interval_end,
+  execution_count,
+  avg_latency_seconds,
+  avg_rows,
+  avg_bytes,
+  avg_rows_scanned,
+  avg_cpu_seconds,
+  all_failed_execution_count,
+  CASE WHEN all_failed_avg_latency_seconds = 'NaN'::float8 THEN 0 ELSE all_failed_avg_latency_seconds END AS all_failed_avg_latency_seconds,
+  cancelled_or_disconnected_execution_count,
+  timed_out_execution_count
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
 type QueryStatsTopTable string
 
 const (
@@ -0,0 +1,459 @@
+package statscopy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/api/iterator"
+)
+
+// QueryStatsFindingKind is QueryStatsReport で検出した問題の種類
+type QueryStatsFindingKind string
+
+const (
+	// QueryStatsNewQuery is Baseline に無く、 Current で実行された Query
+	QueryStatsNewQuery QueryStatsFindingKind = "NEW_QUERY"
+
+	// QueryStatsLatencyRegression is AvgLatencySeconds が Baseline より閾値以上悪化した Query
+	QueryStatsLatencyRegression QueryStatsFindingKind = "LATENCY_REGRESSION"
+
+	// QueryStatsCPURegression is AvgCPUSeconds が Baseline より閾値以上悪化した Query
+	QueryStatsCPURegression QueryStatsFindingKind = "CPU_REGRESSION"
+
+	// QueryStatsExecutionCountSpike is ExecuteCount が Baseline より閾値以上増えた Query
+	QueryStatsExecutionCountSpike QueryStatsFindingKind = "EXECUTION_COUNT_SPIKE"
+
+	// QueryStatsScanHeavy is 返した Row の数に比べて Scan した Row の数が多い Query
+	QueryStatsScanHeavy QueryStatsFindingKind = "SCAN_HEAVY"
+)
+
+// queryStatsFindingKinds is Report に出力する順番
+var queryStatsFindingKinds = []QueryStatsFindingKind{
+	QueryStatsLatencyRegression,
+	QueryStatsCPURegression,
+	QueryStatsNewQuery,
+	QueryStatsExecutionCountSpike,
+	QueryStatsScanHeavy,
+}
+
+// QueryStatsSummary is Window の中の 1 つの TextFingerprint の QueryStat をまとめたもの
+// Avg は ExecuteCount で重み付けした平均
+type QueryStatsSummary struct {
+	TextFingerprint   int64   `json:"textFingerprint"`
+	Text              string  `json:"text"`
+	ExecuteCount      int64   `json:"executeCount"`
+	AvgLatencySeconds float64 `json:"avgLatencySeconds"`
+	AvgCPUSeconds     float64 `json:"avgCpuSeconds"`
+	AvgRows           float64 `json:"avgRows"`
+	AvgRowsScanned    float64 `json:"avgRowsScanned"`
+}
+
+// SummarizeQueryStats is stats を TextFingerprint ごとにまとめる
+func SummarizeQueryStats(stats []*QueryStat) map[int64]*QueryStatsSummary {
+	ret := map[int64]*QueryStatsSummary{}
+	for _, stat := range stats {
+		s, ok := ret[stat.TextFingerprint]
+		if !ok {
+			s = &QueryStatsSummary{TextFingerprint: stat.TextFingerprint}
+			ret[stat.TextFingerprint] = s
+		}
+		if len(s.Text) < 1 {
+			s.Text = stat.Text
+		}
+		total := s.ExecuteCount + stat.ExecuteCount
+		if total < 1 {
+			continue
+		}
+		weighted := func(cur float64, v float64) float64 {
+			return (cur*float64(s.ExecuteCount) + v*float64(stat.ExecuteCount)) / float64(total)
+		}
+		s.AvgLatencySeconds = weighted(s.AvgLatencySeconds, stat.AvgLatencySeconds)
+		s.AvgCPUSeconds = weighted(s.AvgCPUSeconds, stat.AvgCPUSeconds)
+		s.AvgRows = weighted(s.AvgRows, stat.AvgRows)
+		s.AvgRowsScanned = weighted(s.AvgRowsScanned, stat.AvgRowsScanned)
+		s.ExecuteCount = total
+	}
+	return ret
+}
+
+// QueryStatsFinding is QueryStatsReport で検出した 1 つの問題
+type QueryStatsFinding struct {
+	Kind            QueryStatsFindingKind `json:"kind"`
+	TextFingerprint int64                 `json:"textFingerprint"`
+
+	// Baseline is Baseline の Summary. QueryStatsNewQuery の場合は nil
+	Baseline *QueryStatsSummary `json:"baseline,omitempty"`
+
+	// Current is Current の Summary
+	Current *QueryStatsSummary `json:"current"`
+
+	// Ratio is Regression, Spike の場合は Current / Baseline, ScanHeavy の場合は AvgRowsScanned / AvgRows
+	Ratio float64 `json:"ratio,omitempty"`
+}
+
+// QueryStatsReport is Baseline と Current の QueryStat を比較した結果
+type QueryStatsReport struct {
+	BaselineFingerprints int   `json:"baselineFingerprints"`
+	CurrentFingerprints  int   `json:"currentFingerprints"`
+	BaselineExecuteCount int64 `json:"baselineExecuteCount"`
+	CurrentExecuteCount  int64 `json:"currentExecuteCount"`
+
+	// Findings is 検出した問題. Kind ごとに深刻なものから順に並んでいる
+	Findings []*QueryStatsFinding `json:"findings"`
+}
+
+// FindingsByKind is kind の Finding を返す
+func (r *QueryStatsReport) FindingsByKind(kind QueryStatsFindingKind) []*QueryStatsFinding {
+	var ret []*QueryStatsFinding
+	for _, f := range r.Findings {
+		if f.Kind == kind {
+			ret = append(ret, f)
+		}
+	}
+	return ret
+}
+
+type analyzeQueryStatsOptions struct {
+	latencyRatio        float64
+	cpuRatio            float64
+	executionCountRatio float64
+	minExecuteCount     int64
+	scanHeavyMinRows    float64
+	scanHeavyRatio      float64
+}
+
+// AnalyzeQueryStatsOptions is AnalyzeQueryStats の Options
+type AnalyzeQueryStatsOptions func(*analyzeQueryStatsOptions)
+
+// WithLatencyRegressionRatio is AvgLatencySeconds が Baseline の ratio 倍以上になったら QueryStatsLatencyRegression にする
+// 省略した場合は 1.5
+func WithLatencyRegressionRatio(ratio float64) AnalyzeQueryStatsOptions {
+	return func(ops *analyzeQueryStatsOptions) {
+		ops.latencyRatio = ratio
+	}
+}
+
+// WithCPURegressionRatio is AvgCPUSeconds が Baseline の ratio 倍以上になったら QueryStatsCPURegression にする
+// 省略した場合は 1.5
+func WithCPURegressionRatio(ratio float64) AnalyzeQueryStatsOptions {
+	return func(ops *analyzeQueryStatsOptions) {
+		ops.cpuRatio = ratio
+	}
+}
+
+// WithExecutionCountSpikeRatio is ExecuteCount が Baseline の ratio 倍以上になったら QueryStatsExecutionCountSpike にする
+// Baseline と Current の Window の長さが同じであることを前提にしている
+// 省略した場合は 2
+func WithExecutionCountSpikeRatio(ratio float64) AnalyzeQueryStatsOptions {
+	return func(ops *analyzeQueryStatsOptions) {
+		ops.executionCountRatio = ratio
+	}
+}
+
+// WithMinExecuteCount is Baseline, Current の両方で ExecuteCount が n 以上の Query だけを Regression, Spike の対象にする
+// 実行回数が少ない Query の Avg はブレが大きいので、誤検知を減らすために使う
+// 省略した場合は 10
+func WithMinExecuteCount(n int64) AnalyzeQueryStatsOptions {
+	return func(ops *analyzeQueryStatsOptions) {
+		ops.minExecuteCount = n
+	}
+}
+
+// WithScanHeavyThreshold is AvgRowsScanned が minRowsScanned 以上で、 AvgRows の ratio 倍以上なら QueryStatsScanHeavy にする
+// 省略した場合は 10000 Row 以上で 100 倍以上
+func WithScanHeavyThreshold(minRowsScanned float64, ratio float64) AnalyzeQueryStatsOptions {
+	return func(ops *analyzeQueryStatsOptions) {
+		ops.scanHeavyMinRows = minRowsScanned
+		ops.scanHeavyRatio = ratio
+	}
+}
+
+// AnalyzeQueryStats is baseline と current の QueryStat を TextFingerprint ごとに比較して QueryStatsReport を返す
+// QueryStat は GetQueryStats で Spanner から取得したものでも、 ListQueryStatsFromBigQuery で BigQuery から取得したものでもよい
+func AnalyzeQueryStats(baseline []*QueryStat, current []*QueryStat, ops ...AnalyzeQueryStatsOptions) *QueryStatsReport {
+	opt := analyzeQueryStatsOptions{
+		latencyRatio:        1.5,
+		cpuRatio:            1.5,
+		executionCountRatio: 2,
+		minExecuteCount:     10,
+		scanHeavyMinRows:    10000,
+		scanHeavyRatio:      100,
+	}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	base := SummarizeQueryStats(baseline)
+	cur := SummarizeQueryStats(current)
+
+	report := &QueryStatsReport{
+		BaselineFingerprints: len(base),
+		CurrentFingerprints:  len(cur),
+		Findings:             []*QueryStatsFinding{},
+	}
+	for _, s := range base {
+		report.BaselineExecuteCount += s.ExecuteCount
+	}
+
+	for fp, c := range cur {
+		report.CurrentExecuteCount += c.ExecuteCount
+
+		b, ok := base[fp]
+		if !ok {
+			report.Findings = append(report.Findings, &QueryStatsFinding{Kind: QueryStatsNewQuery, TextFingerprint: fp, Current: c})
+		} else if b.ExecuteCount >= opt.minExecuteCount && c.ExecuteCount >= opt.minExecuteCount {
+			for _, v := range []struct {
+				kind      QueryStatsFindingKind
+				baseline  float64
+				current   float64
+				threshold float64
+			}{
+				{QueryStatsLatencyRegression, b.AvgLatencySeconds, c.AvgLatencySeconds, opt.latencyRatio},
+				{QueryStatsCPURegression, b.AvgCPUSeconds, c.AvgCPUSeconds, opt.cpuRatio},
+				{QueryStatsExecutionCountSpike, float64(b.ExecuteCount), float64(c.ExecuteCount), opt.executionCountRatio},
+			} {
+				if v.baseline <= 0 || v.threshold <= 0 {
+					continue
+				}
+				if ratio := v.current / v.baseline; ratio >= v.threshold {
+					report.Findings = append(report.Findings, &QueryStatsFinding{Kind: v.kind, TextFingerprint: fp, Baseline: b, Current: c, Ratio: ratio})
+				}
+			}
+		}
+
+		if c.AvgRowsScanned >= opt.scanHeavyMinRows {
+			ratio := c.AvgRowsScanned / maxFloat64(c.AvgRows, 1)
+			if ratio >= opt.scanHeavyRatio {
+				report.Findings = append(report.Findings, &QueryStatsFinding{Kind: QueryStatsScanHeavy, TextFingerprint: fp, Baseline: b, Current: c, Ratio: ratio})
+			}
+		}
+	}
+
+	order := map[QueryStatsFindingKind]int{}
+	for i, k := range queryStatsFindingKinds {
+		order[k] = i
+	}
+	sort.SliceStable(report.Findings, func(i, j int) bool {
+		fi, fj := report.Findings[i], report.Findings[j]
+		if fi.Kind != fj.Kind {
+			return order[fi.Kind] < order[fj.Kind]
+		}
+		if si, sj := fi.score(), fj.score(); si != sj {
+			return si > sj
+		}
+		return fi.TextFingerprint < fj.TextFingerprint
+	})
+	return report
+}
+
+// score is 同じ Kind の Finding を並べる時の深刻度
+// NewQuery は Ratio が無いので、 Current で使った CPU 時間の合計にする
+func (f *QueryStatsFinding) score() float64 {
+	if f.Kind == QueryStatsNewQuery {
+		return f.Current.AvgCPUSeconds * float64(f.Current.ExecuteCount)
+	}
+	return f.Ratio
+}
+
+func maxFloat64(a float64, b float64) float64 {
+	if a > b {
+		return a
+	}
+	return b
+}
+
+// WriteJSON is Report を JSON で w に書き込む
+func (r *QueryStatsReport) WriteJSON(w io.Writer) error {
+	enc := json.NewEncoder(w)
+	enc.SetIndent("", "  ")
+	return enc.Encode(r)
+}
+
+// WriteMarkdown is Report を Markdown で w に書き込む
+func (r *QueryStatsReport) WriteMarkdown(w io.Writer) error {
+	var b strings.Builder
+	b.WriteString("## Spanner Query Stats Report\n\n")
+	b.WriteString("| | Baseline | Current |\n|---|---:|---:|\n")
+	fmt.Fprintf(&b, "| Fingerprints | %d | %d |\n", r.BaselineFingerprints, r.CurrentFingerprints)
+	fmt.Fprintf(&b, "| Executions | %d | %d |\n", r.BaselineExecuteCount, r.CurrentExecuteCount)
+
+	titles := map[QueryStatsFindingKind]string{
+		QueryStatsLatencyRegression:   "Latency regressions",
+		QueryStatsCPURegression:       "CPU regressions",
+		QueryStatsNewQuery:            "New queries",
+		QueryStatsExecutionCountSpike: "Execution count spikes",
+		QueryStatsScanHeavy:           "Scan heavy queries",
+	}
+	for _, kind := range queryStatsFindingKinds {
+		findings := r.FindingsByKind(kind)
+		if len(findings) < 1 {
+			continue
+		}
+		fmt.Fprintf(&b, "\n### %s (%d)\n\n", titles[kind], len(findings))
+		switch kind {
+		case QueryStatsNewQuery:
+			b.WriteString("| Fingerprint | Executions | Avg Latency (s) | Avg CPU (s) | Avg Rows Scanned | Text |\n|---:|---:|---:|---:|---:|---|\n")
+			for _, f := range findings {
+				fmt.Fprintf(&b, "| %d | %d | %.4f | %.4f | %.0f | %s |\n", f.TextFingerprint, f.Current.ExecuteCount, f.Current.AvgLatencySeconds, f.Current.AvgCPUSeconds, f.Current.AvgRowsScanned, markdownQueryText(f.Current.Text))
+			}
+		case QueryStatsScanHeavy:
+			b.WriteString("| Fingerprint | Executions | Avg Rows Scanned | Avg Rows | Ratio | Text |\n|---:|---:|---:|---:|---:|---|\n")
+			for _, f := range findings {
+				fmt.Fprintf(&b, "| %d | %d | %.0f | %.1f | %.1f | %s |\n", f.TextFingerprint, f.Current.ExecuteCount, f.Current.AvgRowsScanned, f.Current.AvgRows, f.Ratio, markdownQueryText(f.Current.Text))
+			}
+		default:
+			b.WriteString("| Fingerprint | Baseline | Current | Ratio | Executions | Text |\n|---:|---:|---:|---:|---:|---|\n")
+			for _, f := range findings {
+				var bv, cv string
+				switch kind {
+				case QueryStatsLatencyRegression:
+					bv, cv = fmt.Sprintf("%.4f", f.Baseline.AvgLatencySeconds), fmt.Sprintf("%.4f", f.Current.AvgLatencySeconds)
+				case QueryStatsCPURegression:
+					bv, cv = fmt.Sprintf("%.4f", f.Baseline.AvgCPUSeconds), fmt.Sprintf("%.4f", f.Current.AvgCPUSeconds)
+				default:
+					bv, cv = fmt.Sprintf("%d", f.Baseline.ExecuteCount), fmt.Sprintf("%d", f.Current.ExecuteCount)
+				}
+				fmt.Fprintf(&b, "| %d | %s | %s | %.2fx | %d | %s |\n", f.TextFingerprint, bv, cv, f.Ratio, f.Current.ExecuteCount, markdownQueryText(f.Current.Text))
+			}
+		}
+	}
+
+	_, err := io.WriteString(w, b.String())
+	return err
+}
+
+// markdownQueryText is Query の Text を Markdown の Table の 1 Cell に収まるようにする
+func markdownQueryText(text string) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if r := []rune(text); len(r) > 100 {
+		text = string(r[:100]) + "..."
+	}
+	text = strings.ReplaceAll(text, "|", "\\|")
+	return "`" + strings.ReplaceAll(text, "`", "'") + "`"
+}
+
+const listQueryStatsFromBigQuery = `
+SELECT
+  interval_end AS IntervalEnd,
+  text AS Text,
+  text_truncated AS TextTruncated,
+  text_fingerprint AS TextFingerprint,
+  execution_count AS ExecuteCount,
+  avg_latency_seconds AS AvgLatencySeconds,
+  avg_rows AS AvgRows,
+  avg_bytes AS AvgBytes,
+  avg_rows_scanned AS AvgRowsScanned,
+  avg_cpu_seconds AS AvgCPUSeconds,
+  IFNULL(all_failed_execution_count, 0) AS AllFailedExecutionCount,
+  IFNULL(all_failed_avg_latency_seconds, 0) AS AllFailedAvgLatencySeconds,
+  IFNULL(cancelled_or_disconnected_execution_count, 0) AS CancelledOrDisconnectedExecutionCount,
+  IFNULL(timed_out_execution_count, 0) AS TimedOutExecutionCount,
+FROM %s
+WHERE interval_end > @From AND interval_end <= @To%s
+QUALIFY ROW_NUMBER() OVER (PARTITION BY %s) = 1
+`
+
+type listQueryStatsOptions struct {
+	database *spabox.Database
+}
+
+// ListQueryStatsOptions is ListQueryStatsFromBigQuery の Options
+type ListQueryStatsOptions func(*listQueryStatsOptions)
+
+// WithListQueryStatsDatabase is TaggedSchema で作成した Table から database の Row だけを取得する
+// 複数の DB の Stats を Copy した Table で指定しないと、別の DB の同じ TextFingerprint の Query がまとめて Summarize される
+func WithListQueryStatsDatabase(database *spabox.Database) ListQueryStatsOptions {
+	return func(ops *listQueryStatsOptions) {
+		ops.database = database
+	}
+}
+
+// ListQueryStatsFromBigQuery is Copy 済みの BigQuery の Table から from より後、 to 以前の interval_end の QueryStat を取得する
+//
+// InsertID による重複排除はベストエフォートで、 Backfill は失敗した Interval の後を再度 Copy するので、 Table には同じ Row が重複していることがある
+// 重複した Row で ExecuteCount が増えないように、 interval_end, text_fingerprint ごとに 1 Row だけを返す
+// TaggedSchema の Table の場合は project_id, instance_id, database_id も含めて重複を判定する
+func (s *Service) ListQueryStatsFromBigQuery(ctx context.Context, dataset *bigquery.Dataset, table string, from time.Time, to time.Time, ops ...ListQueryStatsOptions) ([]*QueryStat, error) {
+	opt := listQueryStatsOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	md, err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(table).Metadata(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed get table metadata. table=%s.%s.%s : %w", dataset.ProjectID, dataset.DatasetID, table, err)
+	}
+	tagged := isTaggedSchema(md.Schema)
+	if opt.database != nil && !tagged {
+		return nil, fmt.Errorf("database filter requires tagged table. table=%s.%s.%s", dataset.ProjectID, dataset.DatasetID, table)
+	}
+
+	q := s.BQ.Query(listQueryStatsFromBigQueryStatement(fmt.Sprintf("`%s.%s.%s`", dataset.ProjectID, dataset.DatasetID, table), tagged, opt.database))
+	q.Parameters = []bigquery.QueryParameter{
+		{Name: "From", Value: from},
+		{Name: "To", Value: to},
+	}
+	if opt.database != nil {
+		q.Parameters = append(q.Parameters,
+			bigquery.QueryParameter{Name: "ProjectID", Value: opt.database.ProjectID},
+			bigquery.QueryParameter{Name: "InstanceID", Value: opt.database.Instance},
+			bigquery.QueryParameter{Name: "DatabaseID", Value: opt.database.Database},
+		)
+	}
+	it, err := q.Read(ctx)
+	if err != nil {
+		return nil, fmt.Errorf("failed read query stats from bigquery. table=%s.%s.%s : %w", dataset.ProjectID, dataset.DatasetID, table, err)
+	}
+
+	var rets []*QueryStat
+	for {
+		var stat QueryStat
+		err := it.Next(&stat)
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf("failed read query stats from bigquery. table=%s.%s.%s : %w", dataset.ProjectID, dataset.DatasetID, table, err)
+		}
+		rets = append(rets, &stat)
+	}
+	return rets, nil
+}
+
+// listQueryStatsFromBigQueryStatement is ListQueryStatsFromBigQuery の Query を返す
+// tagged の場合は DB の Column も含めて重複を判定し、 database を指定した場合はその DB の Row だけにする
+func listQueryStatsFromBigQueryStatement(table string, tagged bool, database *spabox.Database) string {
+	var where string
+	partition := "interval_end, text_fingerprint"
+	if tagged {
+		partition += ", project_id, instance_id, database_id"
+	}
+	if database != nil {
+		where = " AND project_id = @ProjectID AND instance_id = @InstanceID AND database_id = @DatabaseID"
+	}
+	return fmt.Sprintf(listQueryStatsFromBigQuery, table, where, partition)
+}
+
+// isTaggedSchema is schema が TaggedSchema で作成した Schema かを返す
+func isTaggedSchema(schema bigquery.Schema) bool {
+	for _, c := range TaggedColumns {
+		var found bool
+		for _, f := range schema {
+			if f.Name == c.Name {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
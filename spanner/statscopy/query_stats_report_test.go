@@ -0,0 +1,120 @@
+package statscopy_test
+
+import (
+	"bytes"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestSummarizeQueryStats(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	got := statscopy.SummarizeQueryStats([]*statscopy.QueryStat{
+		{IntervalEnd: intervalEnd, TextFingerprint: 1, Text: "SELECT 1", ExecuteCount: 10, AvgLatencySeconds: 1},
+		{IntervalEnd: intervalEnd.Add(time.Minute), TextFingerprint: 1, Text: "SELECT 1", ExecuteCount: 30, AvgLatencySeconds: 3},
+	})
+	s, ok := got[1]
+	if !ok {
+		t.Fatal("fingerprint 1 is not found")
+	}
+	if e, g := int64(40), s.ExecuteCount; e != g {
+		t.Errorf("want ExecuteCount %d but got %d", e, g)
+	}
+	if e, g := 2.5, s.AvgLatencySeconds; e != g {
+		t.Errorf("want AvgLatencySeconds %v but got %v", e, g)
+	}
+}
+
+func TestAnalyzeQueryStats(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	baseline := []*statscopy.QueryStat{
+		{IntervalEnd: intervalEnd, TextFingerprint: 1, Text: "SELECT * FROM Users WHERE UserID = @id", ExecuteCount: 100, AvgLatencySeconds: 0.01, AvgCPUSeconds: 0.005, AvgRows: 1, AvgRowsScanned: 1},
+		{IntervalEnd: intervalEnd, TextFingerprint: 2, Text: "SELECT * FROM Items", ExecuteCount: 100, AvgLatencySeconds: 0.1, AvgCPUSeconds: 0.05, AvgRows: 10, AvgRowsScanned: 10},
+		{IntervalEnd: intervalEnd, TextFingerprint: 3, Text: "SELECT 1", ExecuteCount: 1, AvgLatencySeconds: 0.001, AvgCPUSeconds: 0.001},
+	}
+	current := []*statscopy.QueryStat{
+		{IntervalEnd: intervalEnd.Add(time.Hour), TextFingerprint: 1, Text: "SELECT * FROM Users WHERE UserID = @id", ExecuteCount: 100, AvgLatencySeconds: 0.05, AvgCPUSeconds: 0.005, AvgRows: 1, AvgRowsScanned: 1},
+		{IntervalEnd: intervalEnd.Add(time.Hour), TextFingerprint: 2, Text: "SELECT * FROM Items", ExecuteCount: 300, AvgLatencySeconds: 0.1, AvgCPUSeconds: 0.2, AvgRows: 10, AvgRowsScanned: 50000},
+		{IntervalEnd: intervalEnd.Add(time.Hour), TextFingerprint: 3, Text: "SELECT 1", ExecuteCount: 1, AvgLatencySeconds: 1, AvgCPUSeconds: 1},
+		{IntervalEnd: intervalEnd.Add(time.Hour), TextFingerprint: 4, Text: "SELECT * FROM Orders", ExecuteCount: 5, AvgLatencySeconds: 0.2, AvgCPUSeconds: 0.1},
+	}
+
+	report := statscopy.AnalyzeQueryStats(baseline, current)
+	if e, g := 3, report.BaselineFingerprints; e != g {
+		t.Errorf("want BaselineFingerprints %d but got %d", e, g)
+	}
+	if e, g := int64(406), report.CurrentExecuteCount; e != g {
+		t.Errorf("want CurrentExecuteCount %d but got %d", e, g)
+	}
+
+	cases := []struct {
+		kind         statscopy.QueryStatsFindingKind
+		fingerprints []int64
+	}{
+		{statscopy.QueryStatsLatencyRegression, []int64{1}},
+		{statscopy.QueryStatsCPURegression, []int64{2}},
+		{statscopy.QueryStatsNewQuery, []int64{4}},
+		{statscopy.QueryStatsExecutionCountSpike, []int64{2}},
+		{statscopy.QueryStatsScanHeavy, []int64{2}},
+	}
+	for _, tt := range cases {
+		t.Run(string(tt.kind), func(t *testing.T) {
+			findings := report.FindingsByKind(tt.kind)
+			if e, g := len(tt.fingerprints), len(findings); e != g {
+				t.Fatalf("want findings %d but got %d", e, g)
+			}
+			for i, fp := range tt.fingerprints {
+				if e, g := fp, findings[i].TextFingerprint; e != g {
+					t.Errorf("want fingerprint %d but got %d", e, g)
+				}
+			}
+		})
+	}
+
+	// 実行回数が少ない fingerprint 3 は WithMinExecuteCount を下げると Regression になる
+	report = statscopy.AnalyzeQueryStats(baseline, current, statscopy.WithMinExecuteCount(1))
+	var found bool
+	for _, f := range report.FindingsByKind(statscopy.QueryStatsLatencyRegression) {
+		if f.TextFingerprint == 3 {
+			found = true
+		}
+	}
+	if !found {
+		t.Error("want fingerprint 3 latency regression")
+	}
+}
+
+func TestQueryStatsReport_Write(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	report := statscopy.AnalyzeQueryStats(nil, []*statscopy.QueryStat{
+		{IntervalEnd: intervalEnd, TextFingerprint: 4, Text: "SELECT *\nFROM Orders | x", ExecuteCount: 5, AvgLatencySeconds: 0.2, AvgCPUSeconds: 0.1},
+	})
+
+	var md bytes.Buffer
+	if err := report.WriteMarkdown(&md); err != nil {
+		t.Fatal(err)
+	}
+	for _, want := range []string{"### New queries (1)", "| 4 | 5 |", "`SELECT * FROM Orders \\| x`"} {
+		if !strings.Contains(md.String(), want) {
+			t.Errorf("want %q in %s", want, md.String())
+		}
+	}
+
+	var js bytes.Buffer
+	if err := report.WriteJSON(&js); err != nil {
+		t.Fatal(err)
+	}
+	var got statscopy.QueryStatsReport
+	if err := json.Unmarshal(js.Bytes(), &got); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := 1, len(got.Findings); e != g {
+		t.Fatalf("want findings %d but got %d", e, g)
+	}
+	if e, g := statscopy.QueryStatsNewQuery, got.Findings[0].Kind; e != g {
+		t.Errorf("want kind %s but got %s", e, g)
+	}
+}
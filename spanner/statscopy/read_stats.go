@@ -24,6 +24,23 @@ FROM {{.Table}}
 WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
 `
 
+// pgReadStatsTopMinute is PostgreSQL Dialect の DB 用の readStatsTopMinute
+const pgReadStatsTopMinute = `
+SELECT
+  interval_end,
+  read_columns,
+  fprint,
+  execution_count,
+  avg_rows,
+  avg_bytes,
+  avg_cpu_seconds,
+  avg_locking_delay_seconds,
+  avg_client_wait_seconds,
+  avg_leader_refresh_delay_seconds
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
 type ReadStatsTopTable string
 
 const (
@@ -1,32 +1,31 @@
 package statscopy
 
 import (
-	"bytes"
 	"context"
 	"errors"
-	"fmt"
-	"text/template"
+	"sync"
 	"time"
 
 	"cloud.google.com/go/bigquery"
 	"cloud.google.com/go/spanner"
-	"github.com/sinmetalcraft/gcpbox/internal/trace"
-	spabox "github.com/sinmetalcraft/gcpbox/spanner"
-	"google.golang.org/api/iterator"
-	"google.golang.org/grpc/codes"
 )
 
 var (
 	ErrRequiredSpannerClient = errors.New("required spanner client")
 )
 
+// Service is Spanner の Stats を BigQuery に Copy する
+// Query, Read, Tx, Lock, TableSizes, TableOperations, ColumnOperations, Split 以外の Stats は StatsKind を定義して Get, Copy を使う
+// Stats を取得する DB の Dialect は DetectDialect で判別して、 GoogleSQL と PostgreSQL の Query を切り替える
 type Service struct {
-	queryStatsTopQueryTemplate *template.Template
-	readStatsTopQueryTemplate  *template.Template
-	txStatsTopQueryTemplate    *template.Template
-	lockStatsTopQueryTemplate  *template.Template
-	Spanner                    *spanner.Client
-	BQ                         *bigquery.Client
+	Spanner *spanner.Client
+	BQ      *bigquery.Client
+
+	// dialects is DB Name ごとの databasepb.DatabaseDialect
+	dialects sync.Map
+
+	// primaryKeys is DB Name ごとの PrimaryKeyColumns
+	primaryKeys sync.Map
 }
 
 // NewService is Serviceを生成する
@@ -36,30 +35,9 @@ func NewService(ctx context.Context, bq *bigquery.Client) (*Service, error) {
 
 // NewServiceWithSpannerClient is Statsを取得したいSpanner DBが1つしかないのであれば、Spanner Clientを設定して、Serviceを作成する
 func NewServiceWithSpannerClient(ctx context.Context, bq *bigquery.Client, spannerClient *spanner.Client) (*Service, error) {
-	queryStatsTmpl, err := template.New("getQueryStatsTopQuery").Parse(queryStatsTopMinute)
-	if err != nil {
-		return nil, err
-	}
-	readStatsTmpl, err := template.New("getReadStatsTopQuery").Parse(readStatsTopMinute)
-	if err != nil {
-		return nil, err
-	}
-	txStatsTmpl, err := template.New("getTxStatsTopQuery").Parse(txStatsTopMinute)
-	if err != nil {
-		return nil, err
-	}
-	lockStatsTmpl, err := template.New("getLockStatsTopQuery").Parse(lockStatsTopMinute)
-	if err != nil {
-		return nil, err
-	}
-
 	return &Service{
-		queryStatsTopQueryTemplate: queryStatsTmpl,
-		readStatsTopQueryTemplate:  readStatsTmpl,
-		txStatsTopQueryTemplate:    txStatsTmpl,
-		lockStatsTopQueryTemplate:  lockStatsTmpl,
-		Spanner:                    spannerClient,
-		BQ:                         bq,
+		Spanner: spannerClient,
+		BQ:      bq,
 	}, nil
 }
 
@@ -107,283 +85,79 @@ func (s *Service) GetLockStats(ctx context.Context, table TxStatsTopTable, inter
 }
 
 // GetQueryStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからQueryStatsを取得する
-func (s *Service) GetQueryStatsWithSpannerClient(ctx context.Context, table QueryStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (stats []*QueryStat, err error) {
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.GetQueryStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"statsCount":  len(stats),
-			"table":       table,
-			"intervalEnd": intervalEndParam,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
+func (s *Service) GetQueryStatsWithSpannerClient(ctx context.Context, table QueryStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*QueryStat, error) {
 	if spannerClient == nil {
 		return nil, ErrRequiredSpannerClient
 	}
-
-	var tpl bytes.Buffer
-	if err := s.queryStatsTopQueryTemplate.Execute(&tpl, QueryStatsParam{Table: string(table)}); err != nil {
-		return nil, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
-	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
-
-	rets := []*QueryStat{}
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-
-		var result QueryStat
-		if err := row.ToStruct(&result); err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-		rets = append(rets, &result)
-	}
-
-	return rets, nil
+	return Get(ctx, s, QueryStatsKind, spannerClient, string(table), intervalEnd)
 }
 
 // GetReadStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからQueryStatsを取得する
-func (s *Service) GetReadStatsWithSpannerClient(ctx context.Context, table ReadStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (stats []*ReadStat, err error) {
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.GetReadStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"statsCount":  len(stats),
-			"table":       table,
-			"intervalEnd": intervalEndParam,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
+func (s *Service) GetReadStatsWithSpannerClient(ctx context.Context, table ReadStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*ReadStat, error) {
 	if spannerClient == nil {
 		return nil, ErrRequiredSpannerClient
 	}
-
-	var tpl bytes.Buffer
-	if err := s.readStatsTopQueryTemplate.Execute(&tpl, ReadStatsParam{Table: string(table)}); err != nil {
-		return nil, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
-	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
-
-	rets := []*ReadStat{}
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-
-		var result ReadStat
-		if err := row.ToStruct(&result); err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-		rets = append(rets, &result)
-	}
-
-	return rets, nil
+	return Get(ctx, s, ReadStatsKind, spannerClient, string(table), intervalEnd)
 }
 
 // GetTxStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからTxStatsを取得する
-func (s *Service) GetTxStatsWithSpannerClient(ctx context.Context, table TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (stats []*TxStat, err error) {
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.GetTxStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"statsCount":  len(stats),
-			"table":       table,
-			"intervalEnd": intervalEndParam,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
+func (s *Service) GetTxStatsWithSpannerClient(ctx context.Context, table TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*TxStat, error) {
 	if spannerClient == nil {
 		return nil, ErrRequiredSpannerClient
 	}
-
-	var tpl bytes.Buffer
-	if err := s.txStatsTopQueryTemplate.Execute(&tpl, TxStatsParam{Table: string(table)}); err != nil {
-		return nil, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
-	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
-
-	rets := []*TxStat{}
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-
-		var result TxStat
-		if err := row.ToStruct(&result); err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-		rets = append(rets, &result)
-	}
-
-	return rets, nil
+	return Get(ctx, s, TxStatsKind, spannerClient, string(table), intervalEnd)
 }
 
 // GetLockStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからLockStatsを取得する
-func (s *Service) GetLockStatsWithSpannerClient(ctx context.Context, table TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (stats []*LockStat, err error) {
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.GetLockStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"statsCount":  len(stats),
-			"table":       table,
-			"intervalEnd": intervalEndParam,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
+func (s *Service) GetLockStatsWithSpannerClient(ctx context.Context, table TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*LockStat, error) {
 	if spannerClient == nil {
 		return nil, ErrRequiredSpannerClient
 	}
-
-	var tpl bytes.Buffer
-	if err := s.lockStatsTopQueryTemplate.Execute(&tpl, TxStatsParam{Table: string(table)}); err != nil {
-		return nil, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
-	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
-
-	rets := []*LockStat{}
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-
-		var result LockStat
-		if err := row.ToStruct(&result); err != nil {
-			return nil, fmt.Errorf(": %w", err)
-		}
-		rets = append(rets, &result)
-	}
-
-	return rets, nil
+	return Get(ctx, s, LockStatsKind, spannerClient, string(table), intervalEnd)
 }
 
 // CreateQueryStatsTable is QueryStatsをCopyするTableをBigQueryに作成する
 func (s *Service) CreateQueryStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
-		Name:   table,
-		Schema: QueryStatsBigQueryTableSchema,
-		TimePartitioning: &bigquery.TimePartitioning{
-			Type: bigquery.DayPartitioningType,
-		},
-	})
+	return CreateTable(ctx, s, QueryStatsKind, dataset, table)
 }
 
 // UpdateQueryStatsTable is BigQuery上にあるQueryStats TableのSchemaをUpdateする
 // 途中でColumnが追加されたときに使う
 func (s *Service) UpdateQueryStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Update(ctx, bigquery.TableMetadataToUpdate{
-		Schema: QueryStatsBigQueryTableSchema,
-	}, "")
+	return UpdateTable(ctx, s, QueryStatsKind, dataset, table)
 }
 
 // CreateReadStatsTable is ReadStatsをCopyするTableをBigQueryに作成する
 func (s *Service) CreateReadStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
-		Name:   table,
-		Schema: ReadStatsBigQueryTableSchema,
-		TimePartitioning: &bigquery.TimePartitioning{
-			Type: bigquery.DayPartitioningType,
-		},
-	})
+	return CreateTable(ctx, s, ReadStatsKind, dataset, table)
 }
 
 // UpdateReadStatsTable is BigQuery上にあるReadStats TableのSchemaをUpdateする
 // 途中でColumnが追加された時に使う
 func (s *Service) UpdateReadStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Update(ctx, bigquery.TableMetadataToUpdate{
-		Schema: ReadStatsBigQueryTableSchema,
-	}, "")
+	return UpdateTable(ctx, s, ReadStatsKind, dataset, table)
 }
 
 // CreateTxStatsTable is TxStatsをCopyするTableをBigQueryに作成する
 func (s *Service) CreateTxStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
-		Name:   table,
-		Schema: TxStatsBigQueryTableSchema,
-		TimePartitioning: &bigquery.TimePartitioning{
-			Type: bigquery.DayPartitioningType,
-		},
-	})
+	return CreateTable(ctx, s, TxStatsKind, dataset, table)
 }
 
 // UpdateTxStatsTable is BigQuery上にあるTxStats TableのSchemaをUpdateする
 // 途中でColumnが追加された時に使う
 func (s *Service) UpdateTxStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Update(ctx, bigquery.TableMetadataToUpdate{
-		Schema: TxStatsBigQueryTableSchema,
-	}, "")
+	return UpdateTable(ctx, s, TxStatsKind, dataset, table)
 }
 
 // CreateLockStatsTable is LockStatsをCopyするTableをBigQueryに作成する
 func (s *Service) CreateLockStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
-		Name:   table,
-		Schema: LockStatsBigQueryTableSchema,
-		TimePartitioning: &bigquery.TimePartitioning{
-			Type: bigquery.DayPartitioningType,
-		},
-	})
+	return CreateTable(ctx, s, LockStatsKind, dataset, table)
 }
 
-// UpdateLockStatsTable is BigQuery上にあるTxStats TableのSchemaをUpdateする
+// UpdateLockStatsTable is BigQuery上にあるLockStats TableのSchemaをUpdateする
 // 途中でColumnが追加された時に使う
 func (s *Service) UpdateLockStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
-
-	return s.BQ.Dataset(dataset.DatasetID).Table(table).Update(ctx, bigquery.TableMetadataToUpdate{
-		Schema: LockStatsBigQueryTableSchema,
-	}, "")
+	return UpdateTable(ctx, s, LockStatsKind, dataset, table)
 }
 
 // CopyQueryStats is SpannerからQuery Statsを引っ張ってきて、BigQueryにCopyしていく
@@ -419,301 +193,205 @@ func (s *Service) CopyLockStats(ctx context.Context, dataset *bigquery.Dataset,
 }
 
 // CopyQueryStatsWithSpannerClient is SpannerからQuery Statsを引っ張ってきて、BigQueryにCopyしていく
-func (s *Service) CopyQueryStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, queryStatsTable QueryStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (insertCount int, err error) {
-	var readRowCount int
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.CopyQueryStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"insertCount":  insertCount,
-			"readRowCount": readRowCount,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-	trace.SetAttributesKV(ctx, map[string]interface{}{
-		"dstDatasetProjectID": dataset.ProjectID,
-		"dstDatasetID":        dataset.DatasetID,
-		"dstTable":            bigQueryTable,
-		"queryStatsTable":     queryStatsTable,
-		"intervalEnd":         intervalEndParam,
-	})
-
+func (s *Service) CopyQueryStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, queryStatsTable QueryStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
 	if spannerClient == nil {
 		return 0, ErrRequiredSpannerClient
 	}
+	return Copy(ctx, s, QueryStatsKind, spannerClient, string(queryStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-	var tpl bytes.Buffer
-	if err := s.queryStatsTopQueryTemplate.Execute(&tpl, QueryStatsParam{Table: string(queryStatsTable)}); err != nil {
-		return 0, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEnd.Format("2006-01-02 15:04:05"),
+// CopyReadStatsWithSpannerClient is SpannerからRead Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyReadStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, readStatsTable ReadStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
 	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
+	return Copy(ctx, s, ReadStatsKind, spannerClient, string(readStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-	var statsList []*QueryStat
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			if spanner.ErrCode(err) == codes.NotFound {
-				return insertCount, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
-			}
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		readRowCount++
+// CopyTxStatsWithSpannerClient is SpannerからTx Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyTxStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, txStatsTable TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+	return Copy(ctx, s, TxStatsKind, spannerClient, string(txStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-		var stats QueryStat
-		if err := row.ToStruct(&stats); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
+// CopyLockStatsWithSpannerClient is SpannerからLock Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyLockStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, lockStatsTable LockStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+	return Copy(ctx, s, LockStatsKind, spannerClient, string(lockStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-		statsList = append(statsList, &stats)
-		if len(statsList) > 99 {
-			if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-				return insertCount, fmt.Errorf(": %w", err)
-			}
-			insertCount += len(statsList)
-			statsList = []*QueryStat{}
-		}
+// GetTableSizesStats is SpannerからTableSizesStatsを取得する
+func (s *Service) GetTableSizesStats(ctx context.Context, table TableSizesStatsTable, intervalEnd time.Time) ([]*TableSizesStat, error) {
+	if s.Spanner == nil {
+		return nil, ErrRequiredSpannerClient
 	}
-	if len(statsList) > 0 {
-		if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		insertCount += len(statsList)
+	return s.GetTableSizesStatsWithSpannerClient(ctx, table, s.Spanner, intervalEnd)
+}
+
+// GetTableSizesStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからTableSizesStatsを取得する
+func (s *Service) GetTableSizesStatsWithSpannerClient(ctx context.Context, table TableSizesStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*TableSizesStat, error) {
+	if spannerClient == nil {
+		return nil, ErrRequiredSpannerClient
 	}
+	return Get(ctx, s, TableSizesStatsKind, spannerClient, string(table), intervalEnd)
+}
 
-	return insertCount, nil
+// CreateTableSizesStatsTable is TableSizesStatsをCopyするTableをBigQueryに作成する
+func (s *Service) CreateTableSizesStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
+	return CreateTable(ctx, s, TableSizesStatsKind, dataset, table)
 }
 
-// CopyReadStatsWithSpannerClient is SpannerからRead Statsを引っ張ってきて、BigQueryにCopyしていく
-func (s *Service) CopyReadStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, readStatsTable ReadStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (insertCount int, err error) {
-	var readRowCount int
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.CopyReadStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"insertCount":  insertCount,
-			"readRowCount": readRowCount,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-	trace.SetAttributesKV(ctx, map[string]interface{}{
-		"dstDatasetProjectID": dataset.ProjectID,
-		"dstDatasetID":        dataset.DatasetID,
-		"dstTable":            bigQueryTable,
-		"readStatsTable":      readStatsTable,
-		"intervalEnd":         intervalEndParam,
-	})
+// UpdateTableSizesStatsTable is BigQuery上にあるTableSizesStats TableのSchemaをUpdateする
+// 途中でColumnが追加された時に使う
+func (s *Service) UpdateTableSizesStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
+	return UpdateTable(ctx, s, TableSizesStatsKind, dataset, table)
+}
 
+// CopyTableSizesStats is SpannerからTable Sizes Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyTableSizesStats(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, tableSizesStatsTable TableSizesStatsTable, intervalEnd time.Time) (int, error) {
+	if s.Spanner == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+	return s.CopyTableSizesStatsWithSpannerClient(ctx, dataset, bigQueryTable, tableSizesStatsTable, s.Spanner, intervalEnd)
+}
+
+// CopyTableSizesStatsWithSpannerClient is SpannerからTable Sizes Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyTableSizesStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, tableSizesStatsTable TableSizesStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
 	if spannerClient == nil {
 		return 0, ErrRequiredSpannerClient
 	}
+	return Copy(ctx, s, TableSizesStatsKind, spannerClient, string(tableSizesStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-	var tpl bytes.Buffer
-	if err := s.readStatsTopQueryTemplate.Execute(&tpl, ReadStatsParam{Table: string(readStatsTable)}); err != nil {
-		return 0, err
+// GetTableOperationsStats is SpannerからTableOperationsStatsを取得する
+func (s *Service) GetTableOperationsStats(ctx context.Context, table TableOperationsStatsTable, intervalEnd time.Time) ([]*TableOperationsStat, error) {
+	if s.Spanner == nil {
+		return nil, ErrRequiredSpannerClient
 	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
+	return s.GetTableOperationsStatsWithSpannerClient(ctx, table, s.Spanner, intervalEnd)
+}
+
+// GetTableOperationsStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからTableOperationsStatsを取得する
+func (s *Service) GetTableOperationsStatsWithSpannerClient(ctx context.Context, table TableOperationsStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*TableOperationsStat, error) {
+	if spannerClient == nil {
+		return nil, ErrRequiredSpannerClient
 	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
+	return Get(ctx, s, TableOperationsStatsKind, spannerClient, string(table), intervalEnd)
+}
 
-	var statsList []*ReadStat
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			if spanner.ErrCode(err) == codes.NotFound {
-				return insertCount, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
-			}
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		readRowCount++
+// CreateTableOperationsStatsTable is TableOperationsStatsをCopyするTableをBigQueryに作成する
+func (s *Service) CreateTableOperationsStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
+	return CreateTable(ctx, s, TableOperationsStatsKind, dataset, table)
+}
 
-		var stats ReadStat
-		if err := row.ToStruct(&stats); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
+// UpdateTableOperationsStatsTable is BigQuery上にあるTableOperationsStats TableのSchemaをUpdateする
+// 途中でColumnが追加された時に使う
+func (s *Service) UpdateTableOperationsStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
+	return UpdateTable(ctx, s, TableOperationsStatsKind, dataset, table)
+}
 
-		statsList = append(statsList, &stats)
-		if len(statsList) > 99 {
-			if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-				return insertCount, fmt.Errorf(": %w", err)
-			}
-			insertCount += len(statsList)
-			statsList = []*ReadStat{}
-		}
-	}
-	if len(statsList) > 0 {
-		if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		insertCount += len(statsList)
+// CopyTableOperationsStats is SpannerからTable Operations Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyTableOperationsStats(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, tableOperationsStatsTable TableOperationsStatsTable, intervalEnd time.Time) (int, error) {
+	if s.Spanner == nil {
+		return 0, ErrRequiredSpannerClient
 	}
-
-	return insertCount, nil
+	return s.CopyTableOperationsStatsWithSpannerClient(ctx, dataset, bigQueryTable, tableOperationsStatsTable, s.Spanner, intervalEnd)
 }
 
-// CopyTxStatsWithSpannerClient is SpannerからTx Statsを引っ張ってきて、BigQueryにCopyしていく
-func (s *Service) CopyTxStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, txStatsTable TxStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (insertCount int, err error) {
-	var readRowCount int
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.CopyTxStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"insertCount":  insertCount,
-			"readRowCount": readRowCount,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-	trace.SetAttributesKV(ctx, map[string]interface{}{
-		"dstDatasetProjectID": dataset.ProjectID,
-		"dstDatasetID":        dataset.DatasetID,
-		"dstTable":            bigQueryTable,
-		"txStatsTable":        txStatsTable,
-		"intervalEnd":         intervalEndParam,
-	})
-
+// CopyTableOperationsStatsWithSpannerClient is SpannerからTable Operations Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyTableOperationsStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, tableOperationsStatsTable TableOperationsStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
 	if spannerClient == nil {
 		return 0, ErrRequiredSpannerClient
 	}
+	return Copy(ctx, s, TableOperationsStatsKind, spannerClient, string(tableOperationsStatsTable), dataset, bigQueryTable, intervalEnd)
+}
 
-	var tpl bytes.Buffer
-	if err := s.txStatsTopQueryTemplate.Execute(&tpl, TxStatsParam{Table: string(txStatsTable)}); err != nil {
-		return 0, err
+// GetColumnOperationsStats is SpannerからColumnOperationsStatsを取得する
+func (s *Service) GetColumnOperationsStats(ctx context.Context, table ColumnOperationsStatsTable, intervalEnd time.Time) ([]*ColumnOperationsStat, error) {
+	if s.Spanner == nil {
+		return nil, ErrRequiredSpannerClient
 	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
+	return s.GetColumnOperationsStatsWithSpannerClient(ctx, table, s.Spanner, intervalEnd)
+}
+
+// GetColumnOperationsStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからColumnOperationsStatsを取得する
+func (s *Service) GetColumnOperationsStatsWithSpannerClient(ctx context.Context, table ColumnOperationsStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*ColumnOperationsStat, error) {
+	if spannerClient == nil {
+		return nil, ErrRequiredSpannerClient
 	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
+	return Get(ctx, s, ColumnOperationsStatsKind, spannerClient, string(table), intervalEnd)
+}
 
-	var statsList []*TxStat
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			if spanner.ErrCode(err) == codes.NotFound {
-				return insertCount, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
-			}
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		readRowCount++
+// CreateColumnOperationsStatsTable is ColumnOperationsStatsをCopyするTableをBigQueryに作成する
+func (s *Service) CreateColumnOperationsStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
+	return CreateTable(ctx, s, ColumnOperationsStatsKind, dataset, table)
+}
 
-		var stats TxStat
-		if err := row.ToStruct(&stats); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
+// UpdateColumnOperationsStatsTable is BigQuery上にあるColumnOperationsStats TableのSchemaをUpdateする
+// 途中でColumnが追加された時に使う
+func (s *Service) UpdateColumnOperationsStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
+	return UpdateTable(ctx, s, ColumnOperationsStatsKind, dataset, table)
+}
 
-		statsList = append(statsList, &stats)
-		if len(statsList) > 99 {
-			if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-				return insertCount, fmt.Errorf(": %w", err)
-			}
-			insertCount += len(statsList)
-			statsList = []*TxStat{}
-		}
-	}
-	if len(statsList) > 0 {
-		if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		insertCount += len(statsList)
+// CopyColumnOperationsStats is SpannerからColumn Operations Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyColumnOperationsStats(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, columnOperationsStatsTable ColumnOperationsStatsTable, intervalEnd time.Time) (int, error) {
+	if s.Spanner == nil {
+		return 0, ErrRequiredSpannerClient
 	}
+	return s.CopyColumnOperationsStatsWithSpannerClient(ctx, dataset, bigQueryTable, columnOperationsStatsTable, s.Spanner, intervalEnd)
+}
 
-	return insertCount, nil
+// CopyColumnOperationsStatsWithSpannerClient is SpannerからColumn Operations Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopyColumnOperationsStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, columnOperationsStatsTable ColumnOperationsStatsTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+	return Copy(ctx, s, ColumnOperationsStatsKind, spannerClient, string(columnOperationsStatsTable), dataset, bigQueryTable, intervalEnd)
 }
 
-// CopyLockStatsWithSpannerClient is SpannerからLock Statsを引っ張ってきて、BigQueryにCopyしていく
-func (s *Service) CopyLockStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, lockStatsTable LockStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (insertCount int, err error) {
-	var readRowCount int
-
-	ctx = trace.StartSpan(ctx, "spanner.statscopy.CopyLockStatsWithSpannerClient")
-	defer func() {
-		trace.SetAttributesKV(ctx, map[string]interface{}{
-			"insertCount":  insertCount,
-			"readRowCount": readRowCount,
-		})
-		trace.EndSpan(ctx, err)
-	}()
-
-	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
-	trace.SetAttributesKV(ctx, map[string]interface{}{
-		"dstDatasetProjectID": dataset.ProjectID,
-		"dstDatasetID":        dataset.DatasetID,
-		"dstTable":            bigQueryTable,
-		"lockStatsTable":      lockStatsTable,
-		"intervalEnd":         intervalEndParam,
-	})
+// GetSplitStats is SpannerからSplitStatsを取得する
+func (s *Service) GetSplitStats(ctx context.Context, table SplitStatsTopTable, intervalEnd time.Time) ([]*SplitStat, error) {
+	if s.Spanner == nil {
+		return nil, ErrRequiredSpannerClient
+	}
+	return s.GetSplitStatsWithSpannerClient(ctx, table, s.Spanner, intervalEnd)
+}
 
+// GetSplitStatsWithSpannerClient is 指定したSpannerClientを利用して、SpannerからSplitStatsを取得する
+func (s *Service) GetSplitStatsWithSpannerClient(ctx context.Context, table SplitStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) ([]*SplitStat, error) {
 	if spannerClient == nil {
+		return nil, ErrRequiredSpannerClient
+	}
+	return Get(ctx, s, SplitStatsKind, spannerClient, string(table), intervalEnd)
+}
+
+// CreateSplitStatsTable is SplitStatsをCopyするTableをBigQueryに作成する
+func (s *Service) CreateSplitStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) error {
+	return CreateTable(ctx, s, SplitStatsKind, dataset, table)
+}
+
+// UpdateSplitStatsTable is BigQuery上にあるSplitStats TableのSchemaをUpdateする
+// 途中でColumnが追加された時に使う
+func (s *Service) UpdateSplitStatsTable(ctx context.Context, dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
+	return UpdateTable(ctx, s, SplitStatsKind, dataset, table)
+}
+
+// CopySplitStats is SpannerからSplit Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopySplitStats(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, splitStatsTable SplitStatsTopTable, intervalEnd time.Time) (int, error) {
+	if s.Spanner == nil {
 		return 0, ErrRequiredSpannerClient
 	}
+	return s.CopySplitStatsWithSpannerClient(ctx, dataset, bigQueryTable, splitStatsTable, s.Spanner, intervalEnd)
+}
 
-	var tpl bytes.Buffer
-	if err := s.lockStatsTopQueryTemplate.Execute(&tpl, LockStatsParam{Table: string(lockStatsTable)}); err != nil {
-		return 0, err
-	}
-	statement := spanner.NewStatement(tpl.String())
-	statement.Params = map[string]interface{}{
-		"IntervalEnd": intervalEndParam,
-	}
-	iter := spannerClient.Single().Query(ctx, statement)
-	defer iter.Stop()
-
-	var statsList []*LockStat
-	for {
-		row, err := iter.Next()
-		if err == iterator.Done {
-			break
-		}
-		if err != nil {
-			if spanner.ErrCode(err) == codes.NotFound {
-				return insertCount, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
-			}
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		readRowCount++
-
-		var stats LockStat
-		if err := row.ToStruct(&stats); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-
-		statsList = append(statsList, &stats)
-		if len(statsList) > 99 {
-			if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-				return insertCount, fmt.Errorf(": %w", err)
-			}
-			insertCount += len(statsList)
-			statsList = []*LockStat{}
-		}
-	}
-	if len(statsList) > 0 {
-		if err := s.BQ.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(bigQueryTable).Inserter().Put(ctx, statsList); err != nil {
-			return insertCount, fmt.Errorf(": %w", err)
-		}
-		insertCount += len(statsList)
-	}
-
-	return insertCount, nil
+// CopySplitStatsWithSpannerClient is SpannerからSplit Statsを引っ張ってきて、BigQueryにCopyしていく
+func (s *Service) CopySplitStatsWithSpannerClient(ctx context.Context, dataset *bigquery.Dataset, bigQueryTable string, splitStatsTable SplitStatsTopTable, spannerClient *spanner.Client, intervalEnd time.Time) (int, error) {
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+	return Copy(ctx, s, SplitStatsKind, spannerClient, string(splitStatsTable), dataset, bigQueryTable, intervalEnd)
 }
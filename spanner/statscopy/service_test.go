@@ -965,6 +965,10 @@ func newTxStatsDummyData(t *testing.T, project string, instance string, database
 }
 
 func newSpannerDatabase(t *testing.T, project string, instance string, createStatement string, extraStatements []string) {
+	newSpannerDatabaseWithDialect(t, project, instance, createStatement, databasepb.DatabaseDialect_GOOGLE_STANDARD_SQL, extraStatements...)
+}
+
+func newSpannerDatabaseWithDialect(t *testing.T, project string, instance string, createStatement string, dialect databasepb.DatabaseDialect, extraStatements ...string) {
 	seh := os.Getenv("SPANNER_EMULATOR_HOST")
 	if len(seh) < 1 {
 		t.Fatal("Required $SPANNER_EMULATOR_HOST")
@@ -1011,6 +1015,7 @@ func newSpannerDatabase(t *testing.T, project string, instance string, createSta
 		Parent:          fmt.Sprintf("projects/%s/instances/%s", project, instance),
 		CreateStatement: createStatement,
 		ExtraStatements: extraStatements,
+		DatabaseDialect: dialect,
 	})
 	if err != nil {
 		if status.Code(err) == codes.AlreadyExists {
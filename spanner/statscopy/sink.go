@@ -0,0 +1,139 @@
+package statscopy
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"path"
+	"strings"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/storage"
+)
+
+// Sink is Spanner から取得した Stats の Row の書き込み先
+type Sink interface {
+	// Write is kind の table の intervalEnd の Stats の rows を書き込む
+	// kind には StatsKind の Name, table には Stats を読み込んだ SPANNER_SYS の Table Name が入る
+	// 同じ kind でも Granularity ごとに table が異なり、 intervalEnd は重なることがある
+	Write(ctx context.Context, kind string, table string, intervalEnd time.Time, rows []bigquery.ValueSaver) error
+}
+
+var _ Sink = &InserterSink{}
+
+// InserterSink is BigQuery の Streaming Insert (Inserter().Put) で書き込む Sink
+// InsertID による重複排除はベストエフォート
+type InserterSink struct {
+	inserter *bigquery.Inserter
+}
+
+// NewInserterSink is InserterSink を返す
+func NewInserterSink(bq *bigquery.Client, dataset *bigquery.Dataset, table string) *InserterSink {
+	return &InserterSink{
+		inserter: bq.DatasetInProject(dataset.ProjectID, dataset.DatasetID).Table(table).Inserter(),
+	}
+}
+
+// Write is Sink interface
+// 100 件ずつ Put する
+func (s *InserterSink) Write(ctx context.Context, kind string, table string, intervalEnd time.Time, rows []bigquery.ValueSaver) error {
+	for len(rows) > 0 {
+		n := len(rows)
+		if n > 100 {
+			n = 100
+		}
+		if err := s.inserter.Put(ctx, rows[:n]); err != nil {
+			return fmt.Errorf(": %w", err)
+		}
+		rows = rows[n:]
+	}
+	return nil
+}
+
+var _ Sink = &WriterSink{}
+
+// WriterSink is io.Writer に 1 Row 1 行の JSON で書き込む Sink
+// Local で Debug する時に使う
+type WriterSink struct {
+	mutex sync.Mutex
+	w     io.Writer
+}
+
+// NewWriterSink is WriterSink を返す
+func NewWriterSink(w io.Writer) *WriterSink {
+	return &WriterSink{
+		w: w,
+	}
+}
+
+// Write is Sink interface
+func (s *WriterSink) Write(ctx context.Context, kind string, table string, intervalEnd time.Time, rows []bigquery.ValueSaver) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	return writeNDJSON(s.w, rows)
+}
+
+var _ Sink = &GCSSink{}
+
+// GCSSink is Cloud Storage に newline-delimited JSON の Object として書き込む Sink
+//
+// Object は {prefix}/{kind}/table={table}/dt={YYYY-MM-DD}/{HHMMSS}.ndjson に 1 interval_end ずつ作成する
+// table は SPANNER_SYS の Table Name の schema を除いた部分 (query_stats_top_minute など) なので、 Granularity ごとに Object が分かれる
+// table, dt は Hive Partitioning の形式なので、 BigQuery の Hive Partitioning の External Table として読み込める
+// 同じ kind, table, interval_end を再度 Write すると Object を上書きする
+// 複数の Spanner DB の Stats を書き込む場合は DB ごとに prefix を分ける
+type GCSSink struct {
+	gcs    *storage.Client
+	bucket string
+	prefix string
+}
+
+// NewGCSSink is GCSSink を返す
+func NewGCSSink(gcs *storage.Client, bucket string, prefix string) *GCSSink {
+	return &GCSSink{
+		gcs:    gcs,
+		bucket: bucket,
+		prefix: prefix,
+	}
+}
+
+// ObjectName is kind の table の intervalEnd の Object の Name を返す
+func (s *GCSSink) ObjectName(kind string, table string, intervalEnd time.Time) string {
+	utc := intervalEnd.UTC()
+	table = strings.ToLower(table[strings.LastIndex(table, ".")+1:])
+	return path.Join(s.prefix, kind, fmt.Sprintf("table=%s", table), fmt.Sprintf("dt=%s", utc.Format("2006-01-02")), fmt.Sprintf("%s.ndjson", utc.Format("150405")))
+}
+
+// Write is Sink interface
+func (s *GCSSink) Write(ctx context.Context, kind string, table string, intervalEnd time.Time, rows []bigquery.ValueSaver) error {
+	name := s.ObjectName(kind, table, intervalEnd)
+	w := s.gcs.Bucket(s.bucket).Object(name).NewWriter(ctx)
+	w.ContentType = "application/x-ndjson"
+	if err := writeNDJSON(w, rows); err != nil {
+		_ = w.Close()
+		return fmt.Errorf("failed write gs://%s/%s : %w", s.bucket, name, err)
+	}
+	if err := w.Close(); err != nil {
+		return fmt.Errorf("failed write gs://%s/%s : %w", s.bucket, name, err)
+	}
+	return nil
+}
+
+// writeNDJSON is rows を 1 Row 1 行の JSON で w に書き込む
+func writeNDJSON(w io.Writer, rows []bigquery.ValueSaver) error {
+	enc := json.NewEncoder(w)
+	for _, row := range rows {
+		v, _, err := row.Save()
+		if err != nil {
+			return err
+		}
+		if err := enc.Encode(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,83 @@
+package statscopy_test
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+func TestWriterSink_Write(t *testing.T) {
+	ctx := context.Background()
+
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	var buf bytes.Buffer
+	sink := statscopy.NewWriterSink(&buf)
+	err := sink.Write(ctx, statscopy.TableSizesStatsKind.Name, string(statscopy.TableSizesStats1HourTable), intervalEnd, []bigquery.ValueSaver{
+		&statscopy.TableSizesStat{IntervalEnd: intervalEnd, TableName: "Users", UsedBytes: 1024},
+		&statscopy.TableSizesStat{IntervalEnd: intervalEnd, TableName: "Items", UsedBytes: 2048},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buf.String()), "\n")
+	if e, g := 2, len(lines); e != g {
+		t.Fatalf("want lines %d but got %d", e, g)
+	}
+	var row struct {
+		IntervalEnd time.Time `json:"interval_end"`
+		TableName   string    `json:"table_name"`
+		UsedBytes   int64     `json:"used_bytes"`
+	}
+	if err := json.Unmarshal([]byte(lines[1]), &row); err != nil {
+		t.Fatal(err)
+	}
+	if e, g := "Items", row.TableName; e != g {
+		t.Errorf("want table_name %s but got %s", e, g)
+	}
+	if !row.IntervalEnd.Equal(intervalEnd) {
+		t.Errorf("want interval_end %s but got %s", intervalEnd, row.IntervalEnd)
+	}
+}
+
+func TestGCSSink_ObjectName(t *testing.T) {
+	sink := statscopy.NewGCSSink(nil, "bucket", "spanner/db1")
+	intervalEnd := time.Date(2021, 1, 13, 15, 10, 0, 0, time.FixedZone("Asia/Tokyo", 9*60*60))
+	if e, g := "spanner/db1/QueryStats/table=query_stats_top_10minute/dt=2021-01-13/061000.ndjson", sink.ObjectName(statscopy.QueryStatsKind.Name, string(statscopy.QueryStatsTop10MinuteTable), intervalEnd); e != g {
+		t.Errorf("want %s but got %s", e, g)
+	}
+
+	// 同じ kind, interval_end でも Granularity が異なれば別の Object になる
+	hourIntervalEnd := time.Date(2021, 1, 13, 10, 0, 0, 0, time.UTC)
+	names := map[string]bool{}
+	for _, table := range []statscopy.QueryStatsTopTable{statscopy.QueryStatsTopMinuteTable, statscopy.QueryStatsTop10MinuteTable, statscopy.QueryStatsTopHourTable} {
+		name := sink.ObjectName(statscopy.QueryStatsKind.Name, string(table), hourIntervalEnd)
+		if names[name] {
+			t.Errorf("duplicate object name %s", name)
+		}
+		names[name] = true
+	}
+}
+
+func TestNewStorageWriteSink(t *testing.T) {
+	dataset := &bigquery.Dataset{ProjectID: "hoge", DatasetID: "fuga"}
+	for _, schema := range []bigquery.Schema{
+		statscopy.QueryStatsBigQueryTableSchema,
+		statscopy.ReadStatsBigQueryTableSchema,
+		statscopy.TxStatsBigQueryTableSchema,
+		statscopy.LockStatsBigQueryTableSchema,
+		statscopy.TaggedSchema(statscopy.SplitStatsBigQueryTableSchema),
+	} {
+		if _, err := statscopy.NewStorageWriteSink(&managedwriter.Client{}, dataset, "stats", schema); err != nil {
+			t.Error(err)
+		}
+	}
+}
@@ -0,0 +1,83 @@
+package statscopy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/dgryski/go-farm"
+)
+
+const splitStatsTopMinute = `
+SELECT
+  interval_end,
+  split_start,
+  split_limit,
+  cpu_usage_score,
+  affected_tables,
+FROM {{.Table}}
+WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
+`
+
+// pgSplitStatsTopMinute is PostgreSQL Dialect の DB 用の splitStatsTopMinute
+const pgSplitStatsTopMinute = `
+SELECT
+  interval_end,
+  split_start,
+  split_limit,
+  cpu_usage_score,
+  affected_tables
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
+type SplitStatsTopTable string
+
+const (
+	SplitStatsTopMinuteTable SplitStatsTopTable = "spanner_sys.split_stats_top_minute"
+)
+
+var _ bigquery.ValueSaver = &SplitStat{}
+
+type SplitStat struct {
+	IntervalEnd    time.Time `spanner:"interval_end"`    // End of the time interval for which the statistics were collected.
+	SplitStart     string    `spanner:"split_start"`     // The starting key of the range of rows in the split.
+	SplitLimit     string    `spanner:"split_limit"`     // The limit key for the range of rows in the split.
+	CPUUsageScore  int64     `spanner:"cpu_usage_score"` // The CPU usage score of the split. 100 means the split is fully hot.
+	AffectedTables []string  `spanner:"affected_tables"` // The tables whose rows might be in the split.
+}
+
+// Save is bigquery.ValueSaver interface
+func (s *SplitStat) Save() (map[string]bigquery.Value, string, error) {
+	insertID, err := s.InsertID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed InsertID() : %w", err)
+	}
+	return map[string]bigquery.Value{
+		"interval_end":    s.IntervalEnd,
+		"split_start":     s.SplitStart,
+		"split_limit":     s.SplitLimit,
+		"cpu_usage_score": s.CPUUsageScore,
+		"affected_tables": s.AffectedTables,
+	}, insertID, nil
+}
+
+// InsertID is 同じデータをBigQueryになるべく入れないようにデータからInsertIDを作成する
+// SplitStart, SplitLimit は Key が入っていて長くなるので Fingerprint にする
+func (s *SplitStat) InsertID() (string, error) {
+	if s.IntervalEnd.IsZero() {
+		return "", errors.New("IntervalEnd is required")
+	}
+	fp := farm.Fingerprint64([]byte(fmt.Sprintf("%s\x00%s", s.SplitStart, s.SplitLimit)))
+	return fmt.Sprintf("GCPBOX_SpannerSplitStat-_-%v-_-%v", s.IntervalEnd.Unix(), fp), nil
+}
+
+// SplitStatsBigQueryTableSchema is BigQuery Table Schema
+var SplitStatsBigQueryTableSchema = bigquery.Schema{
+	{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+	{Name: "split_start", Required: true, Type: bigquery.StringFieldType},
+	{Name: "split_limit", Required: true, Type: bigquery.StringFieldType},
+	{Name: "cpu_usage_score", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "affected_tables", Required: true, Repeated: true, Type: bigquery.StringFieldType},
+}
@@ -0,0 +1,340 @@
+package statscopy
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"text/template"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	"cloud.google.com/go/spanner/admin/database/apiv1/databasepb"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+	"google.golang.org/api/iterator"
+	"google.golang.org/grpc/codes"
+)
+
+// StatsParam is StatsKind の SQL Template に渡す値
+type StatsParam struct {
+	Table string
+}
+
+// StatsRow is StatsKind の Row の制約
+// PT は Row の struct T の Pointer で、 BigQuery に Insert する時の値と InsertID を Save で返す
+type StatsRow[T any] interface {
+	*T
+	bigquery.ValueSaver
+}
+
+// StatsKind is Spanner から取得して BigQuery に Copy する Stats の種類
+//
+// Spanner から Query する SQL Template, Row の struct, BigQuery の Schema をまとめたもの
+// InsertID は Row の Save で返す
+// SPANNER_SYS や INFORMATION_SCHEMA の任意の Table を NewStatsKind で定義すれば、 Get, Copy で扱える
+type StatsKind[T any, PT StatsRow[T]] struct {
+	// Name is Trace の Span 名などに使う名前
+	Name string
+
+	// Schema is Copy 先の BigQuery Table の Schema
+	Schema bigquery.Schema
+
+	tmpl   *template.Template
+	pgTmpl *template.Template
+}
+
+type statsKindOptions struct {
+	pgQuery string
+}
+
+// StatsKindOptions is NewStatsKind の Options
+type StatsKindOptions func(*statsKindOptions)
+
+// WithPostgreSQLQuery is PostgreSQL Dialect の DB から取得する時の SQL Template を指定する
+// query は {{.Table}} に Spanner の Table Name が入る text/template で、 $1 に interval_end の timestamptz が入る
+// 省略した場合は PostgreSQL Dialect の DB では ErrUnsupportedDialect になる
+func WithPostgreSQLQuery(query string) StatsKindOptions {
+	return func(ops *statsKindOptions) {
+		ops.pgQuery = query
+	}
+}
+
+// NewStatsKind is StatsKind を返す
+// query は {{.Table}} に Spanner の Table Name が入る text/template で、 @IntervalEnd に "2006-01-02 15:04:05" 形式の UTC の時刻が入る
+// Row の struct T には spanner tag で Column を対応させる
+func NewStatsKind[T any, PT StatsRow[T]](name string, query string, schema bigquery.Schema, ops ...StatsKindOptions) (*StatsKind[T, PT], error) {
+	opt := statsKindOptions{}
+	for _, o := range ops {
+		o(&opt)
+	}
+
+	tmpl, err := template.New(name).Parse(query)
+	if err != nil {
+		return nil, fmt.Errorf("failed parse query template. name=%s : %w", name, err)
+	}
+	var pgTmpl *template.Template
+	if len(opt.pgQuery) > 0 {
+		pgTmpl, err = template.New(name).Parse(opt.pgQuery)
+		if err != nil {
+			return nil, fmt.Errorf("failed parse postgresql query template. name=%s : %w", name, err)
+		}
+	}
+	return &StatsKind[T, PT]{
+		Name:   name,
+		Schema: schema,
+		tmpl:   tmpl,
+		pgTmpl: pgTmpl,
+	}, nil
+}
+
+// MustNewStatsKind is NewStatsKind の error を panic にする
+func MustNewStatsKind[T any, PT StatsRow[T]](name string, query string, schema bigquery.Schema, ops ...StatsKindOptions) *StatsKind[T, PT] {
+	kind, err := NewStatsKind[T, PT](name, query, schema, ops...)
+	if err != nil {
+		panic(err)
+	}
+	return kind
+}
+
+// Statement is GoogleSQL Dialect の DB から table の intervalEnd の Stats を取得する Statement を返す
+func (k *StatsKind[T, PT]) Statement(table string, intervalEnd time.Time) (spanner.Statement, error) {
+	var tpl bytes.Buffer
+	if err := k.tmpl.Execute(&tpl, StatsParam{Table: table}); err != nil {
+		return spanner.Statement{}, err
+	}
+	statement := spanner.NewStatement(tpl.String())
+	statement.Params = map[string]interface{}{
+		"IntervalEnd": intervalEnd.Format("2006-01-02 15:04:05"),
+	}
+	return statement, nil
+}
+
+// StatementWithDialect is dialect の DB から table の intervalEnd の Stats を取得する Statement を返す
+func (k *StatsKind[T, PT]) StatementWithDialect(dialect databasepb.DatabaseDialect, table string, intervalEnd time.Time) (spanner.Statement, error) {
+	if dialect != databasepb.DatabaseDialect_POSTGRESQL {
+		return k.Statement(table, intervalEnd)
+	}
+	if k.pgTmpl == nil {
+		return spanner.Statement{}, fmt.Errorf("%s does not support %s : %w", k.Name, dialect, ErrUnsupportedDialect)
+	}
+
+	var tpl bytes.Buffer
+	if err := k.pgTmpl.Execute(&tpl, StatsParam{Table: table}); err != nil {
+		return spanner.Statement{}, err
+	}
+	statement := spanner.NewStatement(tpl.String())
+	statement.Params = map[string]interface{}{
+		"p1": intervalEnd.UTC(),
+	}
+	return statement, nil
+}
+
+var (
+	// QueryStatsKind is spanner_sys.query_stats_top_*
+	QueryStatsKind = MustNewStatsKind[QueryStat]("QueryStats", queryStatsTopMinute, QueryStatsBigQueryTableSchema, WithPostgreSQLQuery(pgQueryStatsTopMinute))
+
+	// ReadStatsKind is spanner_sys.read_stats_top_*
+	ReadStatsKind = MustNewStatsKind[ReadStat]("ReadStats", readStatsTopMinute, ReadStatsBigQueryTableSchema, WithPostgreSQLQuery(pgReadStatsTopMinute))
+
+	// TxStatsKind is spanner_sys.txn_stats_top_*
+	TxStatsKind = MustNewStatsKind[TxStat]("TxStats", txStatsTopMinute, TxStatsBigQueryTableSchema, WithPostgreSQLQuery(pgTxStatsTopMinute))
+
+	// LockStatsKind is spanner_sys.lock_stats_top_*
+	// sample_lock_requests が ARRAY<STRUCT> なので PostgreSQL Dialect の DB には対応していない
+	LockStatsKind = MustNewStatsKind[LockStat]("LockStats", lockStatsTopMinute, LockStatsBigQueryTableSchema)
+
+	// TableSizesStatsKind is spanner_sys.table_sizes_stats_1hour
+	TableSizesStatsKind = MustNewStatsKind[TableSizesStat]("TableSizesStats", tableSizesStats1Hour, TableSizesStatsBigQueryTableSchema, WithPostgreSQLQuery(pgTableSizesStats1Hour))
+
+	// TableOperationsStatsKind is spanner_sys.table_operations_stats_*
+	TableOperationsStatsKind = MustNewStatsKind[TableOperationsStat]("TableOperationsStats", tableOperationsStats, TableOperationsStatsBigQueryTableSchema, WithPostgreSQLQuery(pgTableOperationsStats))
+
+	// ColumnOperationsStatsKind is spanner_sys.column_operations_stats_*
+	ColumnOperationsStatsKind = MustNewStatsKind[ColumnOperationsStat]("ColumnOperationsStats", columnOperationsStats, ColumnOperationsStatsBigQueryTableSchema, WithPostgreSQLQuery(pgColumnOperationsStats))
+
+	// SplitStatsKind is spanner_sys.split_stats_top_minute
+	SplitStatsKind = MustNewStatsKind[SplitStat]("SplitStats", splitStatsTopMinute, SplitStatsBigQueryTableSchema, WithPostgreSQLQuery(pgSplitStatsTopMinute))
+)
+
+// Get is 指定したSpannerClientを利用して、Spannerから kind の Stats を取得する
+// spannerClient が nil の場合は Service の Spanner を利用する
+func Get[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, intervalEnd time.Time) (stats []*T, err error) {
+	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
+
+	ctx = trace.StartSpan(ctx, fmt.Sprintf("spanner.statscopy.Get%sWithSpannerClient", kind.Name))
+	defer func() {
+		trace.SetAttributesKV(ctx, map[string]interface{}{
+			"statsCount":  len(stats),
+			"table":       table,
+			"intervalEnd": intervalEndParam,
+		})
+		trace.EndSpan(ctx, err)
+	}()
+
+	if spannerClient == nil {
+		spannerClient = s.Spanner
+	}
+	if spannerClient == nil {
+		return nil, ErrRequiredSpannerClient
+	}
+
+	dialect, err := s.dialect(ctx, spannerClient)
+	if err != nil {
+		return nil, err
+	}
+	statement, err := kind.StatementWithDialect(dialect, table, intervalEnd)
+	if err != nil {
+		return nil, err
+	}
+	iter := spannerClient.Single().Query(ctx, statement)
+	defer iter.Stop()
+
+	rets := []*T{}
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			return nil, fmt.Errorf(": %w", err)
+		}
+
+		var result T
+		if err := row.ToStruct(&result); err != nil {
+			return nil, fmt.Errorf(": %w", err)
+		}
+		rets = append(rets, &result)
+	}
+
+	decodeRowKeys[T, PT](ctx, s, spannerClient, toStatsRows[T, PT](rets))
+	return rets, nil
+}
+
+// Copy is 指定したSpannerClientを利用して、Spannerから kind の Stats を引っ張ってきて、BigQueryにCopyしていく
+// spannerClient が nil の場合は Service の Spanner を利用する
+// BigQuery には InserterSink で Streaming Insert する. 他の書き込み先を使う場合は CopyToSink を使う
+func Copy[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, dataset *bigquery.Dataset, bigQueryTable string, intervalEnd time.Time) (insertCount int, err error) {
+	ctx = trace.StartSpan(ctx, fmt.Sprintf("spanner.statscopy.Copy%sWithSpannerClient", kind.Name))
+	defer func() {
+		trace.EndSpan(ctx, err)
+	}()
+
+	trace.SetAttributesKV(ctx, map[string]interface{}{
+		"dstDatasetProjectID": dataset.ProjectID,
+		"dstDatasetID":        dataset.DatasetID,
+		"dstTable":            bigQueryTable,
+	})
+	return CopyToSink(ctx, s, kind, spannerClient, table, NewInserterSink(s.BQ, dataset, bigQueryTable), intervalEnd)
+}
+
+// CopyToSink is 指定したSpannerClientを利用して、Spannerから kind の Stats を引っ張ってきて、 sink に書き込む
+// spannerClient が nil の場合は Service の Spanner を利用する
+// 1 interval_end の Stats を全て取得してから 1 回 Write する. Stats が 0 件の場合は Write しない
+func CopyToSink[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, sink Sink, intervalEnd time.Time) (insertCount int, err error) {
+	return copyStats(ctx, s, kind, spannerClient, table, sink, intervalEnd, func(row PT) bigquery.ValueSaver {
+		return row
+	})
+}
+
+// copyStats is CopyToSink の実装
+// saver で sink に書き込む Row を変換する
+func copyStats[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, table string, sink Sink, intervalEnd time.Time, saver func(row PT) bigquery.ValueSaver) (insertCount int, err error) {
+	var readRowCount int
+
+	ctx = trace.StartSpan(ctx, fmt.Sprintf("spanner.statscopy.Copy%sToSink", kind.Name))
+	defer func() {
+		trace.SetAttributesKV(ctx, map[string]interface{}{
+			"insertCount":  insertCount,
+			"readRowCount": readRowCount,
+		})
+		trace.EndSpan(ctx, err)
+	}()
+
+	intervalEndParam := intervalEnd.Format("2006-01-02 15:04:05")
+	trace.SetAttributesKV(ctx, map[string]interface{}{
+		"sink":        fmt.Sprintf("%T", sink),
+		"statsKind":   kind.Name,
+		"statsTable":  table,
+		"intervalEnd": intervalEndParam,
+	})
+
+	if spannerClient == nil {
+		spannerClient = s.Spanner
+	}
+	if spannerClient == nil {
+		return 0, ErrRequiredSpannerClient
+	}
+
+	dialect, err := s.dialect(ctx, spannerClient)
+	if err != nil {
+		return 0, err
+	}
+	statement, err := kind.StatementWithDialect(dialect, table, intervalEnd)
+	if err != nil {
+		return 0, err
+	}
+	iter := spannerClient.Single().Query(ctx, statement)
+	defer iter.Stop()
+
+	var rows []PT
+	for {
+		row, err := iter.Next()
+		if err == iterator.Done {
+			break
+		}
+		if err != nil {
+			if spanner.ErrCode(err) == codes.NotFound {
+				return 0, spabox.NewErrNotFound("", err) // Spanner Instanceの情報はspannerClientが保持していて分からないので、Keyが空
+			}
+			return 0, fmt.Errorf(": %w", err)
+		}
+		readRowCount++
+
+		var stats T
+		if err := row.ToStruct(&stats); err != nil {
+			return 0, fmt.Errorf(": %w", err)
+		}
+		rows = append(rows, &stats)
+	}
+	if len(rows) < 1 {
+		return 0, nil
+	}
+	decodeRowKeys[T, PT](ctx, s, spannerClient, rows)
+	statsList := make([]bigquery.ValueSaver, len(rows))
+	for i, row := range rows {
+		statsList[i] = saver(row)
+	}
+	if err := sink.Write(ctx, kind.Name, table, intervalEnd, statsList); err != nil {
+		return 0, err
+	}
+	return len(statsList), nil
+}
+
+// toStatsRows is []*T を []PT にする
+func toStatsRows[T any, PT StatsRow[T]](rows []*T) []PT {
+	ret := make([]PT, len(rows))
+	for i, row := range rows {
+		ret[i] = row
+	}
+	return ret
+}
+
+// CreateTable is kind を Copy する Table を BigQuery に作成する
+func CreateTable[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], dataset *bigquery.Dataset, table string) error {
+	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
+		Name:   table,
+		Schema: kind.Schema,
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type: bigquery.DayPartitioningType,
+		},
+	})
+}
+
+// UpdateTable is BigQuery上にある kind の Table の Schema を Update する
+// 途中でColumnが追加された時に使う
+func UpdateTable[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], dataset *bigquery.Dataset, table string) (*bigquery.TableMetadata, error) {
+	return s.BQ.Dataset(dataset.DatasetID).Table(table).Update(ctx, bigquery.TableMetadataToUpdate{
+		Schema: kind.Schema,
+	}, "")
+}
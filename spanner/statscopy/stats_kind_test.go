@@ -0,0 +1,109 @@
+package statscopy_test
+
+import (
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"github.com/sinmetalcraft/gcpbox/spanner/statscopy"
+)
+
+type tableSize struct {
+	IntervalEnd time.Time `spanner:"interval_end"`
+	TableName   string    `spanner:"table_name"`
+	UsedBytes   int64     `spanner:"used_bytes"`
+}
+
+func (s *tableSize) Save() (map[string]bigquery.Value, string, error) {
+	return map[string]bigquery.Value{
+		"interval_end": s.IntervalEnd,
+		"table_name":   s.TableName,
+		"used_bytes":   s.UsedBytes,
+	}, fmt.Sprintf("%d-%s", s.IntervalEnd.Unix(), s.TableName), nil
+}
+
+func TestNewStatsKind(t *testing.T) {
+	kind, err := statscopy.NewStatsKind[tableSize]("TableSizes", "SELECT interval_end, table_name, used_bytes FROM {{.Table}} WHERE interval_end = TIMESTAMP(@IntervalEnd, \"UTC\")", bigquery.Schema{
+		{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+		{Name: "table_name", Required: true, Type: bigquery.StringFieldType},
+		{Name: "used_bytes", Required: true, Type: bigquery.IntegerFieldType},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	statement, err := kind.Statement("spanner_sys.table_sizes_stats_1hour", intervalEnd)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(statement.SQL, "FROM spanner_sys.table_sizes_stats_1hour WHERE") {
+		t.Errorf("unexpected SQL %s", statement.SQL)
+	}
+	if e, g := "2021-01-13 15:00:00", statement.Params["IntervalEnd"]; e != g {
+		t.Errorf("want IntervalEnd %s but got %s", e, g)
+	}
+}
+
+func TestNewStatsKind_InvalidTemplate(t *testing.T) {
+	_, err := statscopy.NewStatsKind[tableSize]("Invalid", "SELECT * FROM {{.Table", bigquery.Schema{})
+	if err == nil {
+		t.Error("want error but got nil")
+	}
+}
+
+func TestStatsKind_Statement(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+
+	cases := []struct {
+		name  string
+		sql   func() (string, error)
+		table string
+	}{
+		{"query", func() (string, error) {
+			st, err := statscopy.QueryStatsKind.Statement(string(statscopy.QueryStatsTopMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.QueryStatsTopMinuteTable)},
+		{"read", func() (string, error) {
+			st, err := statscopy.ReadStatsKind.Statement(string(statscopy.ReadStatsTopMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.ReadStatsTopMinuteTable)},
+		{"tx", func() (string, error) {
+			st, err := statscopy.TxStatsKind.Statement(string(statscopy.TxStatsTopMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.TxStatsTopMinuteTable)},
+		{"lock", func() (string, error) {
+			st, err := statscopy.LockStatsKind.Statement(string(statscopy.LockStatsTopMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.LockStatsTopMinuteTable)},
+		{"table sizes", func() (string, error) {
+			st, err := statscopy.TableSizesStatsKind.Statement(string(statscopy.TableSizesStats1HourTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.TableSizesStats1HourTable)},
+		{"table operations", func() (string, error) {
+			st, err := statscopy.TableOperationsStatsKind.Statement(string(statscopy.TableOperationsStatsMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.TableOperationsStatsMinuteTable)},
+		{"column operations", func() (string, error) {
+			st, err := statscopy.ColumnOperationsStatsKind.Statement(string(statscopy.ColumnOperationsStatsHourTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.ColumnOperationsStatsHourTable)},
+		{"split", func() (string, error) {
+			st, err := statscopy.SplitStatsKind.Statement(string(statscopy.SplitStatsTopMinuteTable), intervalEnd)
+			return st.SQL, err
+		}, string(statscopy.SplitStatsTopMinuteTable)},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			sql, err := tt.sql()
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !strings.Contains(sql, fmt.Sprintf("FROM %s", tt.table)) {
+				t.Errorf("unexpected SQL %s", sql)
+			}
+		})
+	}
+}
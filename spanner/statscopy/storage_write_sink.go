@@ -0,0 +1,291 @@
+package statscopy
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"reflect"
+	"sync"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/bigquery/storage/managedwriter"
+	"cloud.google.com/go/bigquery/storage/managedwriter/adapt"
+	"github.com/sinmetalcraft/gcpbox/internal/trace"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+	"google.golang.org/protobuf/types/descriptorpb"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+var _ Sink = &StorageWriteSink{}
+
+// StorageWriteSink is BigQuery Storage Write API の Committed Stream で書き込む Sink
+//
+// Stream の Offset を指定して Append するので、同じ Stream の中での Append の Retry では Row が重複しない (Stream 単位の exactly-once)
+// Offset は この StorageWriteSink の Memory 上にしか無いので、 Copy, Backfill の再実行や Process の再起動で新しい StorageWriteSink を作ると
+// 新しい Stream に Offset 0 から書き込み、既に書き込んだ Row も再度書き込む. 再実行で重複させたくない場合は WithCheckpoint で書き込み済みの Interval を飛ばす
+// AlreadyExists が返ってきた場合は、その Offset から同じ Row が書き込み済みとみなして Offset を進める. Stream の中身は確認しないので、
+// Retry する時は呼び出し側が失敗した時と同じ rows (同じ件数, 同じ順番) で Write する必要がある. rows を変えて Retry すると Row が欠けたり Offset がずれる
+// 最初の Write で Stream を作成して、 Close で Finalize する
+type StorageWriteSink struct {
+	client *managedwriter.Client
+	table  string
+	schema bigquery.Schema
+
+	descriptor      protoreflect.MessageDescriptor
+	descriptorProto *descriptorpb.DescriptorProto
+	mutex           sync.Mutex
+	stream          *managedwriter.ManagedStream
+	offset          int64
+}
+
+// NewStorageWriteSink is StorageWriteSink を返す
+// schema は Copy 先の Table の Schema で、 StatsKind の Schema か TaggedSchema を指定する
+func NewStorageWriteSink(client *managedwriter.Client, dataset *bigquery.Dataset, table string, schema bigquery.Schema) (*StorageWriteSink, error) {
+	descriptor, err := storageWriteDescriptor(schema)
+	if err != nil {
+		return nil, err
+	}
+	dp, err := adapt.NormalizeDescriptor(descriptor)
+	if err != nil {
+		return nil, fmt.Errorf("failed NormalizeDescriptor : %w", err)
+	}
+	return &StorageWriteSink{
+		client:          client,
+		table:           managedwriter.TableParentFromParts(dataset.ProjectID, dataset.DatasetID, table),
+		schema:          schema,
+		descriptor:      descriptor,
+		descriptorProto: dp,
+	}, nil
+}
+
+// Write is Sink interface
+func (s *StorageWriteSink) Write(ctx context.Context, kind string, table string, intervalEnd time.Time, rows []bigquery.ValueSaver) error {
+	if len(rows) < 1 {
+		return nil
+	}
+	data := make([][]byte, len(rows))
+	for i, row := range rows {
+		b, err := encodeStorageWriteRow(s.descriptor, s.schema, row)
+		if err != nil {
+			return fmt.Errorf("failed encode row. kind=%s,index=%d : %w", kind, i, err)
+		}
+		data[i] = b
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stream == nil {
+		stream, err := s.client.NewManagedStream(ctx,
+			managedwriter.WithType(managedwriter.CommittedStream),
+			managedwriter.WithDestinationTable(s.table),
+			managedwriter.WithSchemaDescriptor(s.descriptorProto),
+		)
+		if err != nil {
+			return fmt.Errorf("failed NewManagedStream. table=%s : %w", s.table, err)
+		}
+		s.stream = stream
+	}
+
+	result, err := s.stream.AppendRows(ctx, data, managedwriter.WithOffset(s.offset))
+	if err != nil {
+		return fmt.Errorf("failed AppendRows. table=%s,offset=%d : %w", s.table, s.offset, err)
+	}
+	if _, err := result.GetResult(ctx); err != nil {
+		// Retry した Append が既に書き込まれていた
+		if status.Code(err) != codes.AlreadyExists {
+			return fmt.Errorf("failed AppendRows. table=%s,offset=%d : %w", s.table, s.offset, err)
+		}
+		trace.TracePrintf(ctx, map[string]interface{}{
+			"table":  s.table,
+			"kind":   kind,
+			"offset": s.offset,
+			"rows":   len(data),
+			"error":  err.Error(),
+		}, "rows are already exists at offset. skip append")
+	}
+	s.offset += int64(len(data))
+	return nil
+}
+
+// Close is Stream を Finalize して Close する
+// Client は Close しない
+func (s *StorageWriteSink) Close(ctx context.Context) error {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.stream == nil {
+		return nil
+	}
+	stream := s.stream
+	s.stream = nil
+	s.offset = 0
+	if _, err := stream.Finalize(ctx); err != nil {
+		_ = stream.Close()
+		return fmt.Errorf("failed Finalize. table=%s : %w", s.table, err)
+	}
+	return stream.Close()
+}
+
+// storageWriteDescriptor is schema から Storage Write API の Row の MessageDescriptor を作成する
+func storageWriteDescriptor(schema bigquery.Schema) (protoreflect.MessageDescriptor, error) {
+	ts, err := adapt.BQSchemaToStorageTableSchema(schema)
+	if err != nil {
+		return nil, fmt.Errorf("failed BQSchemaToStorageTableSchema : %w", err)
+	}
+	d, err := adapt.StorageSchemaToProto2Descriptor(ts, "root")
+	if err != nil {
+		return nil, fmt.Errorf("failed StorageSchemaToProto2Descriptor : %w", err)
+	}
+	md, ok := d.(protoreflect.MessageDescriptor)
+	if !ok {
+		return nil, fmt.Errorf("unexpected descriptor %T", d)
+	}
+	return md, nil
+}
+
+// encodeStorageWriteRow is row を md の Message に変換して Marshal する
+func encodeStorageWriteRow(md protoreflect.MessageDescriptor, schema bigquery.Schema, row bigquery.ValueSaver) ([]byte, error) {
+	values, _, err := row.Save()
+	if err != nil {
+		return nil, err
+	}
+	msg, err := storageWriteMessage(md, schema, values)
+	if err != nil {
+		return nil, err
+	}
+	return proto.Marshal(msg)
+}
+
+func storageWriteMessage(md protoreflect.MessageDescriptor, schema bigquery.Schema, values map[string]bigquery.Value) (*dynamicpb.Message, error) {
+	msg := dynamicpb.NewMessage(md)
+	for _, fs := range schema {
+		v, ok := values[fs.Name]
+		if !ok || v == nil {
+			continue
+		}
+		fd := md.Fields().ByName(protoreflect.Name(fs.Name))
+		if fd == nil {
+			return nil, fmt.Errorf("field %s is not found in descriptor", fs.Name)
+		}
+
+		if !fs.Repeated {
+			pv, err := storageWriteValue(fd, fs, v)
+			if err != nil {
+				return nil, err
+			}
+			msg.Set(fd, pv)
+			continue
+		}
+
+		rv := reflect.ValueOf(v)
+		if rv.Kind() != reflect.Slice {
+			return nil, fmt.Errorf("field %s is repeated but got %T", fs.Name, v)
+		}
+		list := msg.NewField(fd).List()
+		for i := 0; i < rv.Len(); i++ {
+			pv, err := storageWriteValue(fd, fs, rv.Index(i).Interface())
+			if err != nil {
+				return nil, err
+			}
+			list.Append(pv)
+		}
+		msg.Set(fd, protoreflect.ValueOfList(list))
+	}
+	return msg, nil
+}
+
+// storageWriteValue is BigQuery の Value を Storage Write API の proto の Value に変換する
+// https://cloud.google.com/bigquery/docs/write-api#data_type_conversions
+func storageWriteValue(fd protoreflect.FieldDescriptor, fs *bigquery.FieldSchema, v bigquery.Value) (protoreflect.Value, error) {
+	switch fs.Type {
+	case bigquery.TimestampFieldType:
+		t, ok := v.(time.Time)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("field %s want time.Time but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfInt64(t.UnixMicro()), nil
+	case bigquery.StringFieldType:
+		return protoreflect.ValueOfString(fmt.Sprint(v)), nil
+	case bigquery.BytesFieldType:
+		b, ok := v.([]byte)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("field %s want []byte but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfBytes(b), nil
+	case bigquery.IntegerFieldType:
+		rv := reflect.ValueOf(v)
+		if !rv.CanInt() {
+			return protoreflect.Value{}, fmt.Errorf("field %s want int but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfInt64(rv.Int()), nil
+	case bigquery.FloatFieldType:
+		rv := reflect.ValueOf(v)
+		if !rv.CanFloat() {
+			return protoreflect.Value{}, fmt.Errorf("field %s want float but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfFloat64(rv.Float()), nil
+	case bigquery.BooleanFieldType:
+		b, ok := v.(bool)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("field %s want bool but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfBool(b), nil
+	case bigquery.NumericFieldType:
+		var r *big.Rat
+		switch n := v.(type) {
+		case *big.Rat:
+			r = n
+		case float64:
+			r = new(big.Rat)
+			if r.SetFloat64(n) == nil {
+				return protoreflect.Value{}, fmt.Errorf("field %s is invalid numeric %v", fs.Name, n)
+			}
+		default:
+			return protoreflect.Value{}, fmt.Errorf("field %s want *big.Rat or float64 but got %T", fs.Name, v)
+		}
+		return protoreflect.ValueOfBytes(encodeNumeric(r)), nil
+	case bigquery.RecordFieldType:
+		m, ok := v.(map[string]bigquery.Value)
+		if !ok {
+			return protoreflect.Value{}, fmt.Errorf("field %s want map[string]bigquery.Value but got %T", fs.Name, v)
+		}
+		msg, err := storageWriteMessage(fd.Message(), fs.Schema, m)
+		if err != nil {
+			return protoreflect.Value{}, err
+		}
+		return protoreflect.ValueOfMessage(msg), nil
+	default:
+		return protoreflect.Value{}, fmt.Errorf("field %s is unsupported type %s", fs.Name, fs.Type)
+	}
+}
+
+// encodeNumeric is NUMERIC を 10^9 倍した整数の little-endian の 2 の補数にする
+func encodeNumeric(r *big.Rat) []byte {
+	scaled := new(big.Rat).Mul(r, new(big.Rat).SetInt64(1000000000))
+	n := new(big.Int).Quo(scaled.Num(), scaled.Denom())
+
+	var b []byte
+	if n.Sign() < 0 {
+		// 2^(8*size) を足して 2 の補数にする. 最上位 bit が立っていなければ 1 byte 増やす
+		size := len(new(big.Int).Neg(n).Bytes())
+		b = new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*size))).Bytes()
+		if len(b) < size || b[0]&0x80 == 0 {
+			b = new(big.Int).Add(n, new(big.Int).Lsh(big.NewInt(1), uint(8*(size+1)))).Bytes()
+		}
+	} else {
+		b = n.Bytes()
+		if len(b) == 0 || b[0]&0x80 != 0 {
+			// 最上位 bit が立っている場合は符号のために 0 を追加する
+			b = append([]byte{0}, b...)
+		}
+	}
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+	return b
+}
@@ -0,0 +1,75 @@
+package statscopy
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+	"time"
+
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/types/dynamicpb"
+)
+
+func TestEncodeStorageWriteRow(t *testing.T) {
+	intervalEnd := time.Date(2021, 1, 13, 15, 0, 0, 0, time.UTC)
+	md, err := storageWriteDescriptor(LockStatsBigQueryTableSchema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b, err := encodeStorageWriteRow(md, LockStatsBigQueryTableSchema, &LockStat{
+		IntervalEnd:      intervalEnd,
+		RowRangeStartKey: []byte("Users(1)"),
+		LockWaitSeconds:  1.5,
+		SampleLockRequests: []*LockStatSampleLockRequest{
+			{LockMode: "ReaderShared", Column: "Users.Name"},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	msg := dynamicpb.NewMessage(md)
+	if err := proto.Unmarshal(b, msg); err != nil {
+		t.Fatal(err)
+	}
+	fields := md.Fields()
+	if e, g := intervalEnd.UnixMicro(), msg.Get(fields.ByName("interval_end")).Int(); e != g {
+		t.Errorf("want interval_end %d but got %d", e, g)
+	}
+	if e, g := []byte("Users(1)"), msg.Get(fields.ByName("row_range_start_key")).Bytes(); !bytes.Equal(e, g) {
+		t.Errorf("want row_range_start_key %s but got %s", e, g)
+	}
+	if e, g := encodeNumeric(big.NewRat(3, 2)), msg.Get(fields.ByName("lock_wait_seconds")).Bytes(); !bytes.Equal(e, g) {
+		t.Errorf("want lock_wait_seconds %v but got %v", e, g)
+	}
+	list := msg.Get(fields.ByName("sample_lock_requests")).List()
+	if e, g := 1, list.Len(); e != g {
+		t.Fatalf("want sample_lock_requests len %d but got %d", e, g)
+	}
+	req := list.Get(0).Message()
+	if e, g := "Users.Name", req.Get(req.Descriptor().Fields().ByName("column")).String(); e != g {
+		t.Errorf("want column %s but got %s", e, g)
+	}
+}
+
+func TestEncodeNumeric(t *testing.T) {
+	cases := []struct {
+		name string
+		in   *big.Rat
+		want []byte
+	}{
+		{"zero", big.NewRat(0, 1), []byte{0x00}},
+		{"one", big.NewRat(1, 1), []byte{0x00, 0xca, 0x9a, 0x3b}},
+		{"minus one", big.NewRat(-1, 1), []byte{0x00, 0x36, 0x65, 0xc4}},
+		{"nano", big.NewRat(1, 1000000000), []byte{0x01}},
+		{"minus nano", big.NewRat(-1, 1000000000), []byte{0xff}},
+		{"minus 129 nano", big.NewRat(-129, 1000000000), []byte{0x7f, 0xff}},
+	}
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := encodeNumeric(tt.in); !bytes.Equal(tt.want, got) {
+				t.Errorf("want %x but got %x", tt.want, got)
+			}
+		})
+	}
+}
@@ -0,0 +1,85 @@
+package statscopy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const tableOperationsStats = `
+SELECT
+  interval_end,
+  table_name,
+  read_query_count,
+  write_count,
+  delete_count,
+FROM {{.Table}}
+WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
+`
+
+// pgTableOperationsStats is PostgreSQL Dialect の DB 用の tableOperationsStats
+const pgTableOperationsStats = `
+SELECT
+  interval_end,
+  table_name,
+  read_query_count,
+  write_count,
+  delete_count
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
+type TableOperationsStatsTable string
+
+const (
+	TableOperationsStatsMinuteTable   TableOperationsStatsTable = "spanner_sys.table_operations_stats_minute"
+	TableOperationsStats10MinuteTable TableOperationsStatsTable = "spanner_sys.table_operations_stats_10minute"
+	TableOperationsStatsHourTable     TableOperationsStatsTable = "spanner_sys.table_operations_stats_hour"
+)
+
+var _ bigquery.ValueSaver = &TableOperationsStat{}
+
+type TableOperationsStat struct {
+	IntervalEnd    time.Time `spanner:"interval_end"`     // End of the time interval that the included table operations occurred in.
+	TableName      string    `spanner:"table_name"`       // Name of the table.
+	ReadQueryCount int64     `spanner:"read_query_count"` // Number of queries or reads reading from the table.
+	WriteCount     int64     `spanner:"write_count"`      // Number of queries writing to the table.
+	DeleteCount    int64     `spanner:"delete_count"`     // Number of queries performing deletes on the table.
+}
+
+// Save is bigquery.ValueSaver interface
+func (s *TableOperationsStat) Save() (map[string]bigquery.Value, string, error) {
+	insertID, err := s.InsertID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed InsertID() : %w", err)
+	}
+	return map[string]bigquery.Value{
+		"interval_end":     s.IntervalEnd,
+		"table_name":       s.TableName,
+		"read_query_count": s.ReadQueryCount,
+		"write_count":      s.WriteCount,
+		"delete_count":     s.DeleteCount,
+	}, insertID, nil
+}
+
+// InsertID is 同じデータをBigQueryになるべく入れないようにデータからInsertIDを作成する
+func (s *TableOperationsStat) InsertID() (string, error) {
+	if s.IntervalEnd.IsZero() {
+		return "", errors.New("IntervalEnd is required")
+	}
+	if len(s.TableName) < 1 {
+		return "", errors.New("TableName is required")
+	}
+	return fmt.Sprintf("GCPBOX_SpannerTableOperationsStat-_-%v-_-%v", s.IntervalEnd.Unix(), s.TableName), nil
+}
+
+// TableOperationsStatsBigQueryTableSchema is BigQuery Table Schema
+var TableOperationsStatsBigQueryTableSchema = bigquery.Schema{
+	{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+	{Name: "table_name", Required: true, Type: bigquery.StringFieldType},
+	{Name: "read_query_count", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "write_count", Required: true, Type: bigquery.IntegerFieldType},
+	{Name: "delete_count", Required: true, Type: bigquery.IntegerFieldType},
+}
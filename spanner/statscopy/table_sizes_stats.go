@@ -0,0 +1,73 @@
+package statscopy
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+)
+
+const tableSizesStats1Hour = `
+SELECT
+  interval_end,
+  table_name,
+  used_bytes,
+FROM {{.Table}}
+WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
+`
+
+// pgTableSizesStats1Hour is PostgreSQL Dialect の DB 用の tableSizesStats1Hour
+const pgTableSizesStats1Hour = `
+SELECT
+  interval_end,
+  table_name,
+  used_bytes
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
+type TableSizesStatsTable string
+
+const (
+	TableSizesStats1HourTable TableSizesStatsTable = "spanner_sys.table_sizes_stats_1hour"
+)
+
+var _ bigquery.ValueSaver = &TableSizesStat{}
+
+type TableSizesStat struct {
+	IntervalEnd time.Time `spanner:"interval_end"` // End of time interval in which the table sizes were collected.
+	TableName   string    `spanner:"table_name"`   // Name of the table or the index.
+	UsedBytes   int64     `spanner:"used_bytes"`   // Table size in bytes.
+}
+
+// Save is bigquery.ValueSaver interface
+func (s *TableSizesStat) Save() (map[string]bigquery.Value, string, error) {
+	insertID, err := s.InsertID()
+	if err != nil {
+		return nil, "", fmt.Errorf("failed InsertID() : %w", err)
+	}
+	return map[string]bigquery.Value{
+		"interval_end": s.IntervalEnd,
+		"table_name":   s.TableName,
+		"used_bytes":   s.UsedBytes,
+	}, insertID, nil
+}
+
+// InsertID is 同じデータをBigQueryになるべく入れないようにデータからInsertIDを作成する
+func (s *TableSizesStat) InsertID() (string, error) {
+	if s.IntervalEnd.IsZero() {
+		return "", errors.New("IntervalEnd is required")
+	}
+	if len(s.TableName) < 1 {
+		return "", errors.New("TableName is required")
+	}
+	return fmt.Sprintf("GCPBOX_SpannerTableSizesStat-_-%v-_-%v", s.IntervalEnd.Unix(), s.TableName), nil
+}
+
+// TableSizesStatsBigQueryTableSchema is BigQuery Table Schema
+var TableSizesStatsBigQueryTableSchema = bigquery.Schema{
+	{Name: "interval_end", Required: true, Type: bigquery.TimestampFieldType},
+	{Name: "table_name", Required: true, Type: bigquery.StringFieldType},
+	{Name: "used_bytes", Required: true, Type: bigquery.IntegerFieldType},
+}
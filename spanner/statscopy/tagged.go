@@ -0,0 +1,75 @@
+package statscopy
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"cloud.google.com/go/bigquery"
+	"cloud.google.com/go/spanner"
+	"github.com/dgryski/go-farm"
+	spabox "github.com/sinmetalcraft/gcpbox/spanner"
+)
+
+// TaggedColumns is TaggedRow で Stats の Row に追加する Column
+var TaggedColumns = bigquery.Schema{
+	{Name: "project_id", Required: true, Type: bigquery.StringFieldType},
+	{Name: "instance_id", Required: true, Type: bigquery.StringFieldType},
+	{Name: "database_id", Required: true, Type: bigquery.StringFieldType},
+}
+
+// TaggedSchema is schema に TaggedColumns を追加した Schema を返す
+// 複数の Spanner DB の Stats を 1 つの BigQuery Table に Copy する時に使う
+func TaggedSchema(schema bigquery.Schema) bigquery.Schema {
+	ret := make(bigquery.Schema, 0, len(TaggedColumns)+len(schema))
+	ret = append(ret, TaggedColumns...)
+	ret = append(ret, schema...)
+	return ret
+}
+
+var _ bigquery.ValueSaver = &TaggedRow{}
+
+// TaggedRow is Stats の Row に取得元の Spanner DB の project_id, instance_id, database_id を追加する
+type TaggedRow struct {
+	Database *spabox.Database
+	Row      bigquery.ValueSaver
+}
+
+// Save is bigquery.ValueSaver interface
+// InsertID は Row の InsertID に Database の Fingerprint を追加する
+func (r *TaggedRow) Save() (map[string]bigquery.Value, string, error) {
+	row, insertID, err := r.Row.Save()
+	if err != nil {
+		return nil, "", err
+	}
+	row["project_id"] = r.Database.ProjectID
+	row["instance_id"] = r.Database.Instance
+	row["database_id"] = r.Database.Database
+	return row, fmt.Sprintf("%s-_-%v", insertID, farm.Fingerprint64([]byte(r.Database.ToSpannerDatabaseName()))), nil
+}
+
+// CopyTagged is Copy と同じように kind の Stats を BigQuery に Copy する
+// Row には database の project_id, instance_id, database_id を追加するので、 BigQuery の Table は TaggedSchema で作成しておく
+// spannerClient が nil の場合は Service の Spanner を利用する
+func CopyTagged[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, database *spabox.Database, table string, dataset *bigquery.Dataset, bigQueryTable string, intervalEnd time.Time) (int, error) {
+	return CopyTaggedToSink(ctx, s, kind, spannerClient, database, table, NewInserterSink(s.BQ, dataset, bigQueryTable), intervalEnd)
+}
+
+// CopyTaggedToSink is CopyToSink と同じように kind の Stats を sink に書き込む
+// Row には database の project_id, instance_id, database_id を追加する
+func CopyTaggedToSink[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], spannerClient *spanner.Client, database *spabox.Database, table string, sink Sink, intervalEnd time.Time) (int, error) {
+	return copyStats(ctx, s, kind, spannerClient, table, sink, intervalEnd, func(row PT) bigquery.ValueSaver {
+		return &TaggedRow{Database: database, Row: row}
+	})
+}
+
+// CreateTaggedTable is kind を CopyTagged する Table を BigQuery に作成する
+func CreateTaggedTable[T any, PT StatsRow[T]](ctx context.Context, s *Service, kind *StatsKind[T, PT], dataset *bigquery.Dataset, table string) error {
+	return s.BQ.Dataset(dataset.DatasetID).Table(table).Create(ctx, &bigquery.TableMetadata{
+		Name:   table,
+		Schema: TaggedSchema(kind.Schema),
+		TimePartitioning: &bigquery.TimePartitioning{
+			Type: bigquery.DayPartitioningType,
+		},
+	})
+}
@@ -27,6 +27,26 @@ FROM {{.Table}}
 WHERE interval_end = TIMESTAMP(@IntervalEnd, "UTC")
 `
 
+// pgTxStatsTopMinute is PostgreSQL Dialect の DB 用の txStatsTopMinute
+const pgTxStatsTopMinute = `
+SELECT
+  interval_end,
+  fprint,
+  read_columns,
+  write_constructive_columns,
+  write_delete_tables,
+  commit_attempt_count,
+  commit_abort_count,
+  commit_retry_count,
+  commit_failed_precondition_count,
+  avg_participants,
+  avg_total_latency_seconds,
+  avg_commit_latency_seconds,
+  avg_bytes
+FROM {{.Table}}
+WHERE interval_end = $1
+`
+
 type TxStatsTopTable string
 
 const (